@@ -163,6 +163,8 @@ func NewRootCommand() *cobra.Command {
 			}
 
 			http.HandleFunc("/healthz", wfController.Healthz)
+			http.HandleFunc("/debug/persistence/pool", wfController.PoolStats)
+			http.HandleFunc("/debug/persistence/queries", wfController.QueryFingerprints)
 
 			go func() {
 				log.Println(http.ListenAndServe(":6060", nil))
@@ -196,6 +196,64 @@ type PersistConfig struct {
 	PostgreSQL     *PostgreSQLConfig `json:"postgresql,omitempty"`
 	MySQL          *MySQLConfig      `json:"mysql,omitempty"`
 	SkipMigration  bool              `json:"skipMigration,omitempty"`
+	// ReaderConnectionPool is the pool of the reader session when reads are split from writes, defaults to ConnectionPool
+	ReaderConnectionPool *ConnectionPool `json:"readerConnectionPool,omitempty"`
+	// CheckPrivileges fails startup unless the database user can select, insert, update and delete on the tables
+	CheckPrivileges bool `json:"checkPrivileges,omitempty"`
+	// PrimaryCheckInterval is how often to check whether the database is a writable primary, for the argo_workflows_db_primary metric,
+	// disabled if not set
+	PrimaryCheckInterval TTL `json:"primaryCheckInterval,omitempty"`
+	// MaintenanceInterval is how often to vacuum and analyze (or, for MySQL, optimize and analyze) the archive tables, on one controller replica
+	// at a time. Disabled if not set.
+	MaintenanceInterval TTL `json:"maintenanceInterval,omitempty"`
+	// PreferredBackend is the backend to use when both postgresql and mysql are configured, either "postgresql" or "mysql"
+	PreferredBackend string `json:"preferredBackend,omitempty"`
+	// ConnectionRetry retries connecting to the database while it is unavailable, e.g. still starting, rather than failing straight away
+	ConnectionRetry *ConnectionRetry `json:"connectionRetry,omitempty"`
+	// LightweightMode disables the session's instrumentation (connection error metrics, the last success metric, the server version check,
+	// query fingerprints and any middleware) and quiescing its writes, so its connections are not wrapped, for resource-constrained deployments
+	LightweightMode bool `json:"lightweightMode,omitempty"`
+	// Fallback is connected to if this config cannot connect, e.g. the passive database of an active-passive pair, and may itself have a fallback.
+	// It is a full config, with its own host, credentials and TLS, but the table name is always taken from this config, its own is not used.
+	Fallback *PersistConfig `json:"fallback,omitempty"`
+	// ArchiveEncryption encrypts the archived workflows
+	ArchiveEncryption *ArchiveEncryption `json:"archiveEncryption,omitempty"`
+}
+
+// ArchiveEncryption encrypts each archived workflow with its own data key, which is stored with it, encrypted with the key KeyID.
+// To rotate the key, add a new key and change KeyID to it. The old keys must be kept to read the workflows they encrypted.
+type ArchiveEncryption struct {
+	// KeyID is the ID of the key that workflows are encrypted with
+	KeyID string `json:"keyID"`
+	// Keys are the keys that workflows are encrypted or decrypted with
+	Keys []ArchiveEncryptionKey `json:"keys"`
+	// UnencryptedFields are the fields, any of "labels", "annotations" and "progress", that are also stored unencrypted for workflows archived
+	// once it is set, so that listing the archived workflows does not decrypt them
+	UnencryptedFields []string `json:"unencryptedFields,omitempty"`
+}
+
+// ArchiveEncryptionKey is a key from a secret, which must be at least 32 bytes
+type ArchiveEncryptionKey struct {
+	// ID identifies the key and is stored with each workflow that it encrypts, so must not be changed, and may only contain letters, digits,
+	// '_', '.' and '-'
+	ID string `json:"id"`
+	// Secret is the secret that the key is derived from
+	Secret apiv1.SecretKeySelector `json:"secret"`
+}
+
+// ConnectionRetry retries connecting until either MaxRetries or MaxElapsedTime is reached, whichever is first. At least one must be set.
+type ConnectionRetry struct {
+	// MaxRetries is the maximum number of retries, 0 means the number is not limited
+	MaxRetries int `json:"maxRetries,omitempty"`
+	// MaxElapsedTime is how long to retry for in total, 0 means the time is not limited
+	MaxElapsedTime TTL `json:"maxElapsedTime,omitempty"`
+	// InitialBackoff is the first delay between retries, which doubles on every retry, defaults to 1s
+	InitialBackoff TTL `json:"initialBackoff,omitempty"`
+	// MaxBackoff caps the delay between retries, defaults to 30s
+	MaxBackoff TTL `json:"maxBackoff,omitempty"`
+	// RetryableErrors are Postgres SQLSTATE codes (e.g. 53300) and MySQL error numbers (e.g. 1040) of errors that are also retried,
+	// as well as network errors and the server starting up (57P03)
+	RetryableErrors []string `json:"retryableErrors,omitempty"`
 }
 
 func (c PersistConfig) GetArchiveLabelSelector() (labels.Selector, error) {
@@ -216,6 +274,49 @@ type ConnectionPool struct {
 	MaxIdleConns    int `json:"maxIdleConns,omitempty"`
 	MaxOpenConns    int `json:"maxOpenConns,omitempty"`
 	ConnMaxLifetime TTL `json:"connMaxLifetime,omitempty"`
+	// MaxConnectionsFraction enables a startup check that warns if filling this pool would take the database server
+	// over this fraction (e.g. 0.8) of its max_connections
+	MaxConnectionsFraction float64 `json:"maxConnectionsFraction,omitempty"`
+	// MaxConnectionsStrict makes the max_connections check fail rather than warn
+	MaxConnectionsStrict bool `json:"maxConnectionsStrict,omitempty"`
+	// RecycleInterval enables replacing a fraction of the pool's connections on this (jittered) interval, regardless of
+	// ConnMaxLifetime, so that connections are rebalanced across the servers behind a load balancer
+	RecycleInterval TTL `json:"recycleInterval,omitempty"`
+	// RecycleFraction is the fraction of connections to replace each RecycleInterval, defaults to 0.1
+	RecycleFraction float64 `json:"recycleFraction,omitempty"`
+	// SchemaDriftCheckInterval enables checking the schema version (in schema_history) on this interval, and replacing every
+	// connection opened before it changed, so that an out-of-band migration does not break their prepared statements
+	SchemaDriftCheckInterval TTL `json:"schemaDriftCheckInterval,omitempty"`
+	// AutoTune adjusts the pool's max open connections from its stats, rather than fixing it at MaxOpenConns, which is
+	// then only the initial size. It is only read at startup, so changes to it need a restart.
+	AutoTune *ConnectionPoolAutoTune `json:"autoTune,omitempty"`
+	// MaxConcurrentQueries limits how many queries may run at once, however large the pool, e.g. to protect the database
+	// during a backfill. Unlimited if not set.
+	MaxConcurrentQueries int `json:"maxConcurrentQueries,omitempty"`
+	// ConcurrencyLimitMode is what a query does once MaxConcurrentQueries are running, one of: queue (wait for one to
+	// finish), failFast (fail straight away). Defaults to queue.
+	ConcurrencyLimitMode string `json:"concurrencyLimitMode,omitempty"`
+	// MaxConnectionOpenRate limits how many new connections are opened a second, so that the pool grows at a steady rate
+	// rather than opening connections all at once, e.g. while a recovering database is overwhelmed. Unlimited if not set.
+	MaxConnectionOpenRate float64 `json:"maxConnectionOpenRate,omitempty"`
+	// MaxConnectionOpenBurst is how many connections may be opened at once before MaxConnectionOpenRate applies,
+	// defaults to 1
+	MaxConnectionOpenBurst int `json:"maxConnectionOpenBurst,omitempty"`
+}
+
+// ConnectionPoolAutoTune grows the pool while checkouts wait too long for a connection, and shrinks it while too few of
+// its connections are in use, within the bounds
+type ConnectionPoolAutoTune struct {
+	// MinOpenConns is the smallest the pool is shrunk to, defaults to 1
+	MinOpenConns int `json:"minOpenConns,omitempty"`
+	// MaxOpenConns is the largest the pool is grown to, it is required
+	MaxOpenConns int `json:"maxOpenConns"`
+	// TargetWaitDuration is the mean time that checkouts may wait for a connection before the pool is grown, defaults to 10ms
+	TargetWaitDuration TTL `json:"targetWaitDuration,omitempty"`
+	// TargetUtilization is the fraction of the connections in use, below which the pool is shrunk, defaults to 0.5
+	TargetUtilization float64 `json:"targetUtilization,omitempty"`
+	// Interval is how often the pool is resized, defaults to 30s
+	Interval TTL `json:"interval,omitempty"`
 }
 
 type DatabaseConfig struct {
@@ -225,24 +326,195 @@ type DatabaseConfig struct {
 	TableName      string                  `json:"tableName,omitempty"`
 	UsernameSecret apiv1.SecretKeySelector `json:"userNameSecret,omitempty"`
 	PasswordSecret apiv1.SecretKeySelector `json:"passwordSecret,omitempty"`
+	// AuroraWriterEndpoint is the Amazon Aurora cluster endpoint, it is used instead of Host and always resolves to the current writer
+	AuroraWriterEndpoint string `json:"auroraWriterEndpoint,omitempty"`
+	// AuroraReaderEndpoint is the Amazon Aurora reader endpoint that reads are sent to by a split session
+	AuroraReaderEndpoint string `json:"auroraReaderEndpoint,omitempty"`
+	// SessionVars are session variables (run-time parameters for Postgres) that are set on every new connection
+	SessionVars map[string]string `json:"sessionVars,omitempty"`
+	// RequireTLS fails the connection unless it is actually encrypted, rather than relying on the driver not to fall back to plaintext
+	RequireTLS bool `json:"requireTLS,omitempty"`
+	// SecretFetchRetries is how many times to retry fetching the username and password secrets, defaults to 3, negative disables retries
+	SecretFetchRetries int `json:"secretFetchRetries,omitempty"`
+	// MigrationUsernameSecret and MigrationPasswordSecret are the credentials of a user that runs the migrations, defaults to the runtime user
+	MigrationUsernameSecret apiv1.SecretKeySelector `json:"migrationUserNameSecret,omitempty"`
+	MigrationPasswordSecret apiv1.SecretKeySelector `json:"migrationPasswordSecret,omitempty"`
+	// DefaultIsolationLevel is the transaction isolation level of every connection, one of: read uncommitted, read committed, repeatable read,
+	// serializable
+	DefaultIsolationLevel string `json:"defaultIsolationLevel,omitempty"`
+	// IAMAuth authenticates using a short-lived token from the cloud provider instead of the password secret, one of: aws, gcp, azure
+	IAMAuth string `json:"iamAuth,omitempty"`
+	// ExecCredential runs a command to get the password instead of reading the password secret
+	ExecCredential *ExecCredential `json:"execCredential,omitempty"`
+	// DisablePreparedStatements stops the driver preparing statements on the server, which some proxies (e.g. RDS Proxy, ProxySQL) mishandle.
+	// MySQL interpolates the parameters into the statement instead, and Postgres uses the simple query protocol.
+	DisablePreparedStatements bool `json:"disablePreparedStatements,omitempty"`
+	// StatementTimeout is the statement timeout of every connection, which for MySQL only applies to SELECT statements
+	StatementTimeout TTL `json:"statementTimeout,omitempty"`
+	// ReaderStatementTimeout is the StatementTimeout of the reader session when reads are split from writes, defaults to StatementTimeout
+	ReaderStatementTimeout TTL `json:"readerStatementTimeout,omitempty"`
+	// ReadAfterWriteTimeout is how long a read after a write waits for the reader to replay the write when reads are split from writes,
+	// before it reads from the writer instead. Defaults to 1s.
+	ReadAfterWriteTimeout TTL `json:"readAfterWriteTimeout,omitempty"`
+	// IdleInTransactionTimeout closes a connection that has been idle in a transaction for longer than this, so a leaked transaction does
+	// not hold its locks forever. For MySQL, it sets wait_timeout, which closes a connection that has been idle for this long whether or
+	// not it is in a transaction, so connMaxLifetime should be shorter. Defaults to the server's default.
+	IdleInTransactionTimeout TTL `json:"idleInTransactionTimeout,omitempty"`
+	// CancelGracePeriod is how long a statement may continue for once its context is cancelled, so that near-complete work is not thrown
+	// away, before it is cancelled. By default, it is cancelled straight away.
+	CancelGracePeriod TTL `json:"cancelGracePeriod,omitempty"`
+	// LockTimeout fails a statement that waits longer than this for a lock, rather than it blocking, e.g. behind a long-running maintenance operation.
+	// For MySQL, it sets innodb_lock_wait_timeout, which is in whole seconds and only applies to row locks. Defaults to the server's default.
+	LockTimeout TTL `json:"lockTimeout,omitempty"`
+	// ServiceRef resolves the host and port from a Kubernetes Service rather than Host and Port, which it takes precedence over
+	ServiceRef *DatabaseServiceRef `json:"serviceRef,omitempty"`
+	// Socks5Proxy connects to the database via a SOCKS5 proxy
+	Socks5Proxy *Socks5Proxy `json:"socks5Proxy,omitempty"`
+	// MaxRows fails a query that returns more than this many rows, so that a query that is missing a limit cannot read, e.g., the whole archive
+	// into memory. Unlimited if not set.
+	MaxRows int `json:"maxRows,omitempty"`
+	// TopQueryFingerprints counts the queries by their fingerprint, i.e. the query with its literals stripped, keeping this many of the most
+	// frequent, which the controller serves at /debug/persistence/queries on its admin port. Disabled if not set.
+	TopQueryFingerprints int `json:"topQueryFingerprints,omitempty"`
+	// LocalAddr is the local IP address that connections, or with Socks5Proxy the connections to the proxy, are made from
+	LocalAddr string `json:"localAddr,omitempty"`
+	// TLSHandshakeTimeout fails a connection whose TLS handshake takes longer than this, separately from connecting, e.g. when a
+	// load balancer accepts connections but the handshake stalls. By default, the handshake is only bounded by the connect timeout, if any.
+	TLSHandshakeTimeout TTL `json:"tlsHandshakeTimeout,omitempty"`
+	// CaCertSecret or CaCertConfigMap, only one of which may be set, is the PEM CA bundle that the server's certificate is verified with
+	CaCertSecret    *apiv1.SecretKeySelector    `json:"caCertSecret,omitempty"`
+	CaCertConfigMap *apiv1.ConfigMapKeySelector `json:"caCertConfigMap,omitempty"`
+	// CRLSecret or CRLFile is a PEM or DER certificate revocation list, and a server certificate that it revokes is rejected. Only one may be set.
+	// For MySQL, it enables TLS, for Postgres, whether TLS is used still depends on the sslMode. The list is only read when the session is created.
+	CRLSecret *apiv1.SecretKeySelector `json:"crlSecret,omitempty"`
+	CRLFile   string                   `json:"crlFile,omitempty"`
+	// PKCS12Secret is a PKCS#12 (.p12/.pfx) bundle of the client certificate and its key, and optionally its chain, that the client authenticates
+	// to the server with. It is decrypted with the passphrase in PKCS12PassphraseSecret, if set. For MySQL, it enables TLS, for Postgres, whether
+	// TLS is used still depends on the sslMode.
+	PKCS12Secret           *apiv1.SecretKeySelector `json:"pkcs12Secret,omitempty"`
+	PKCS12PassphraseSecret *apiv1.SecretKeySelector `json:"pkcs12PassphraseSecret,omitempty"`
+	// ValidateConnection fails to connect unless ValidationQuery succeeds, e.g. to confirm that a proxy routes to the right database
+	ValidateConnection bool `json:"validateConnection,omitempty"`
+	// ValidationQuery is the query that validates the connection, defaults to SELECT 1
+	ValidationQuery string `json:"validationQuery,omitempty"`
+	// ExpectedResult is the value that the first column of the first row of ValidationQuery must be, any value is accepted if it is not set
+	ExpectedResult string `json:"expectedResult,omitempty"`
+	// WarmupQuery is run on every new connection once it is initialized, e.g. to prime a cache or touch a partition. Unlike ValidationQuery, it
+	// is not a health check, and its result is discarded.
+	WarmupQuery string `json:"warmupQuery,omitempty"`
+	// TolerateWarmupFailure logs a WarmupQuery that fails rather than failing to connect
+	TolerateWarmupFailure bool `json:"tolerateWarmupFailure,omitempty"`
+}
+
+// ExecCredential is a command that writes the password to stdout as JSON, e.g. {"password": "...", "expiry": "2024-01-02T03:04:05Z"}.
+// The command is run again for the next new connection once the optional expiry (RFC 3339) has passed.
+type ExecCredential struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+	// Timeout is how long the command may run for, defaults to 30s
+	Timeout TTL `json:"timeout,omitempty"`
+}
+
+// DatabaseServiceRef is a Kubernetes Service that the database is connected to: its DNS name, or its external name
+type DatabaseServiceRef struct {
+	// Namespace defaults to the namespace of the controller or server
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+	// PortName is the name of the port, which is only required if the service has more than one
+	PortName string `json:"portName,omitempty"`
+}
+
+// Socks5Proxy is a SOCKS5 proxy, with optional username and password authentication
+type Socks5Proxy struct {
+	// Address is the host:port of the proxy
+	Address        string                   `json:"address"`
+	UsernameSecret *apiv1.SecretKeySelector `json:"userNameSecret,omitempty"`
+	PasswordSecret *apiv1.SecretKeySelector `json:"passwordSecret,omitempty"`
 }
 
 func (c DatabaseConfig) GetHostname() string {
-	if c.Port == 0 {
-		return c.Host
+	host := c.Host
+	if c.AuroraWriterEndpoint != "" {
+		host = c.AuroraWriterEndpoint
+	}
+	return hostname(host, c.Port)
+}
+
+// GetReaderHostname returns the host that reads should be sent to, which is the writer unless a reader endpoint
+// is configured
+func (c DatabaseConfig) GetReaderHostname() string {
+	if c.AuroraReaderEndpoint != "" {
+		return hostname(c.AuroraReaderEndpoint, c.Port)
+	}
+	return c.GetHostname()
+}
+
+func hostname(host string, port int) string {
+	if port == 0 {
+		return host
 	}
-	return fmt.Sprintf("%s:%v", c.Host, c.Port)
+	return fmt.Sprintf("%s:%v", host, port)
 }
 
 type PostgreSQLConfig struct {
 	DatabaseConfig
 	SSL     bool   `json:"ssl,omitempty"`
 	SSLMode string `json:"sslMode,omitempty"`
+	// GSSEncMode is whether the connection is encrypted using GSSAPI, one of: disable (the default), prefer or require. Like libpq, GSSAPI encryption
+	// would be tried before TLS, falling back to the sslMode, but the driver does not support it, so prefer always falls back, and require is rejected.
+	GSSEncMode string `json:"gssEncMode,omitempty"`
+	// StatementCacheCapacity is the number of prepared statements cached per connection, 0 (the default) disables the cache
+	StatementCacheCapacity int `json:"statementCacheCapacity,omitempty"`
+	// StatementCacheMode is either "prepare" (the default) or "describe", which does not create named statements on the server
+	StatementCacheMode string `json:"statementCacheMode,omitempty"`
+	// StatementNamePrefix names the prepared statements <prefix>_<n>_<n> rather than the driver's default, so that the statements of instances
+	// that share a server session behind a pooler cannot collide. Set it to a token that is unique to the instance. It enables the statement
+	// cache, with a capacity of 512 unless StatementCacheCapacity is set.
+	StatementNamePrefix string `json:"statementNamePrefix,omitempty"`
+	// Schema is the schema that the tables are in, it is set as the search_path of every connection, defaults to the server's search_path
+	Schema string `json:"schema,omitempty"`
+	// AuthMode is either "password" (the default) or "gssapi", which authenticates as the user using Kerberos rather than the password secret.
+	// The driver does not support GSSAPI encryption (gssencmode), so use ssl to encrypt the connection.
+	AuthMode string `json:"authMode,omitempty"`
+	// KrbRealm is the Kerberos realm of the user, required for gssapi
+	KrbRealm string `json:"krbRealm,omitempty"`
+	// KrbKeytabSecret is the secret selector for the user's Kerberos keytab, required for gssapi
+	KrbKeytabSecret *apiv1.SecretKeySelector `json:"krbKeytabSecret,omitempty"`
+	// KrbConfigSecret is the secret selector for the Kerberos config (krb5.conf), if not set the KDCs are looked up using DNS
+	KrbConfigSecret *apiv1.SecretKeySelector `json:"krbConfigSecret,omitempty"`
+	// KrbServiceName is the Kerberos service name of the server, defaults to postgres
+	KrbServiceName string `json:"krbServiceName,omitempty"`
+	// KrbServicePrincipalName is the principal name of the server, which takes precedence over KrbServiceName and the host
+	KrbServicePrincipalName string `json:"krbServicePrincipalName,omitempty"`
+	// PgPassFile is the path of a .pgpass file that the password is read from if there is no password secret, using the line that
+	// matches the host, port, database and user. It is an error if there is no matching line, or, like libpq, the file has group or world access.
+	PgPassFile string `json:"pgPassFile,omitempty"`
+	// PerPodAppName sets the application_name of each connection to argo-workflows/<component>/<pod name>, so that connections in pg_stat_activity
+	// can be traced to their pod. The pod name is read from the POD_NAME environment variable, and omitted if it is not set.
+	// Without it, the application_name is argo-workflows/<component>, where the component is controller or server.
+	PerPodAppName bool `json:"perPodAppName,omitempty"`
+	// DisableJIT turns JIT compilation off (jit = off) for every connection, as compiling a query can make its latency unpredictable. It is a
+	// startup parameter, so behind a proxy that rejects unknown startup parameters (e.g. PgBouncer) set jit in sessionVars instead.
+	DisableJIT bool `json:"disableJIT,omitempty"`
+	// SetRole is the role that every connection assumes using SET ROLE, e.g. a group role that owns the tables, so that the tables
+	// and rows that the user creates are owned by it
+	SetRole string `json:"setRole,omitempty"`
+	// ReplicationSafeMode avoids maintenance that breaks logical replication from the database, e.g. the migration gives schema_history
+	// a replica identity, as it has no primary key. Use it when the tables are published.
+	ReplicationSafeMode bool `json:"replicationSafeMode,omitempty"`
 }
 
 type MySQLConfig struct {
 	DatabaseConfig
 	Options map[string]string `json:"options,omitempty"`
+	// Charset is the character set of the connection, one of: utf8mb4 (the default), utf8mb3 or utf8. utf8mb3 cannot store every
+	// character, so only use it for legacy servers that do not support utf8mb4.
+	Charset string `json:"charset,omitempty"`
+	// CollationConnection sets collation_connection after the character set, so must be a collation of the charset
+	CollationConnection string `json:"collationConnection,omitempty"`
+	// CharsetInitMode is what to do if setting the character set fails, e.g. behind a proxy that already sets it upstream,
+	// one of: strict (fail to connect, the default), warn (log a warning and continue) or skip (do not set it)
+	CharsetInitMode string `json:"charsetInitMode,omitempty"`
 }
 
 // MetricsConfig defines a config for a metrics server
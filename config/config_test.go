@@ -11,6 +11,10 @@ import (
 func TestDatabaseConfig(t *testing.T) {
 	assert.Equal(t, "my-host", DatabaseConfig{Host: "my-host"}.GetHostname())
 	assert.Equal(t, "my-host:1234", DatabaseConfig{Host: "my-host", Port: 1234}.GetHostname())
+	aurora := DatabaseConfig{Host: "my-host", Port: 1234, AuroraWriterEndpoint: "my-cluster", AuroraReaderEndpoint: "my-cluster-ro"}
+	assert.Equal(t, "my-cluster:1234", aurora.GetHostname())
+	assert.Equal(t, "my-cluster-ro:1234", aurora.GetReaderHostname())
+	assert.Equal(t, "my-host", DatabaseConfig{Host: "my-host"}.GetReaderHostname())
 }
 
 func TestSanitize(t *testing.T) {
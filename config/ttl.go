@@ -10,6 +10,9 @@ import (
 
 // time.Duration forces you to specify in millis, and does not support days
 // see https://stackoverflow.com/questions/48050945/how-to-unmarshal-json-into-durations
+//
+// A TTL is a Go duration string (e.g. "30s", "1h30m", "500ms"), or a number of days (e.g. "7d"). For backwards
+// compatibility with fields that used to be integers, a bare number is a number of seconds.
 type TTL time.Duration
 
 func (l TTL) MarshalJSON() ([]byte, error) {
@@ -21,6 +24,24 @@ func (l *TTL) UnmarshalJSON(b []byte) error {
 	if err := json.Unmarshal(b, &v); err != nil {
 		return err
 	}
+	return l.unmarshal(v)
+}
+
+func (l TTL) MarshalYAML() (interface{}, error) {
+	return time.Duration(l).String(), nil
+}
+
+// UnmarshalYAML is for decoding with gopkg.in/yaml directly, sigs.k8s.io/yaml (which the config is loaded with)
+// converts the YAML to JSON and uses UnmarshalJSON
+func (l *TTL) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var v interface{}
+	if err := unmarshal(&v); err != nil {
+		return err
+	}
+	return l.unmarshal(v)
+}
+
+func (l *TTL) unmarshal(v interface{}) error {
 	switch value := v.(type) {
 	case string:
 		if value == "" {
@@ -32,27 +53,24 @@ func (l *TTL) UnmarshalJSON(b []byte) error {
 			*l = TTL(time.Duration(days) * 24 * time.Hour)
 			return err
 		}
-		if strings.HasSuffix(value, "h") {
-			hours, err := strconv.Atoi(strings.TrimSuffix(value, "h"))
-			*l = TTL(time.Duration(hours) * time.Hour)
-			return err
-		}
-		if strings.HasSuffix(value, "m") {
-			minutes, err := strconv.Atoi(strings.TrimSuffix(value, "m"))
-			*l = TTL(time.Duration(minutes) * time.Minute)
-			return err
-		}
-		if strings.HasSuffix(value, "s") {
-			seconds, err := strconv.Atoi(strings.TrimSuffix(value, "s"))
-			*l = TTL(time.Duration(seconds) * time.Second)
-			return err
-		}
 		d, err := time.ParseDuration(value)
 		if err != nil {
 			return err
 		}
 		*l = TTL(d)
 		return nil
+	case float64:
+		*l = TTL(value * float64(time.Second))
+		return nil
+	case int:
+		*l = TTL(time.Duration(value) * time.Second)
+		return nil
+	case int64:
+		*l = TTL(time.Duration(value) * time.Second)
+		return nil
+	case uint64:
+		*l = TTL(time.Duration(value) * time.Second)
+		return nil
 	default:
 		return errors.New("invalid TTL")
 	}
@@ -1,10 +1,13 @@
 package config
 
 import (
+	"encoding/json"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/yaml"
 )
 
 func TestTTL(t *testing.T) {
@@ -30,3 +33,66 @@ func TestTTL(t *testing.T) {
 		}
 	})
 }
+
+func TestTTL_Forms(t *testing.T) {
+	for _, tt := range []struct {
+		json string
+		want TTL
+	}{
+		{`"30s"`, TTL(30 * time.Second)},
+		{`"5m"`, TTL(5 * time.Minute)},
+		{`"1h30m"`, TTL(90 * time.Minute)},
+		{`"500ms"`, TTL(500 * time.Millisecond)},
+		{`"7d"`, TTL(7 * 24 * time.Hour)},
+		{`"1h0m0s"`, TTL(time.Hour)},
+		{`30`, TTL(30 * time.Second)},
+		{`0`, TTL(0)},
+		{`1.5`, TTL(1500 * time.Millisecond)},
+	} {
+		t.Run(tt.json, func(t *testing.T) {
+			ttl := TTL(-1)
+			require.NoError(t, ttl.UnmarshalJSON([]byte(tt.json)))
+			assert.Equal(t, tt.want, ttl)
+		})
+	}
+	t.Run("Invalid", func(t *testing.T) {
+		ttl := TTL(-1)
+		assert.Error(t, ttl.UnmarshalJSON([]byte(`"30x"`)))
+		assert.EqualError(t, ttl.UnmarshalJSON([]byte(`true`)), "invalid TTL")
+	})
+	t.Run("RoundTrip", func(t *testing.T) {
+		data, err := json.Marshal(TTL(90 * time.Minute))
+		require.NoError(t, err)
+		var ttl TTL
+		require.NoError(t, json.Unmarshal(data, &ttl))
+		assert.Equal(t, TTL(90*time.Minute), ttl)
+	})
+}
+
+func TestTTL_YAML(t *testing.T) {
+	t.Run("ConnectionPool", func(t *testing.T) {
+		var pool ConnectionPool
+		require.NoError(t, yaml.Unmarshal([]byte("connMaxLifetime: 5m\nrecycleInterval: 3600\n"), &pool))
+		assert.Equal(t, TTL(5*time.Minute), pool.ConnMaxLifetime)
+		assert.Equal(t, TTL(time.Hour), pool.RecycleInterval, "a bare integer is a number of seconds")
+	})
+	t.Run("DatabaseConfig", func(t *testing.T) {
+		var cfg PostgreSQLConfig
+		require.NoError(t, yaml.Unmarshal([]byte("statementTimeout: 30s\nlockTimeout: 10\n"), &cfg))
+		assert.Equal(t, TTL(30*time.Second), cfg.StatementTimeout)
+		assert.Equal(t, TTL(10*time.Second), cfg.LockTimeout)
+	})
+	t.Run("UnmarshalYAML", func(t *testing.T) {
+		for _, v := range []interface{}{"2m", 120, int64(120), uint64(120), 120.0} {
+			ttl := TTL(-1)
+			require.NoError(t, ttl.UnmarshalYAML(func(out interface{}) error {
+				*out.(*interface{}) = v
+				return nil
+			}))
+			assert.Equal(t, TTL(2*time.Minute), ttl, "%T", v)
+		}
+		v, err := TTL(2 * time.Minute).MarshalYAML()
+		require.NoError(t, err)
+		assert.Equal(t, "2m0s", v)
+	})
+}
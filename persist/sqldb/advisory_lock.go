@@ -0,0 +1,114 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"hash/fnv"
+
+	"github.com/upper/db/v4"
+)
+
+// AcquireAdvisoryLock blocks until it holds the advisory lock on the key, or ctx is done, so that maintenance (e.g.
+// archive GC) is only run by one controller replica at a time. The lock is held by a connection that is taken out of
+// the pool until the returned function releases it. For MySQL, the key must be at most 64 characters.
+func AcquireAdvisoryLock(ctx context.Context, session db.Session, key string, t dbType) (func() error, error) {
+	conn, release, err := acquireAdvisoryLock(ctx, session, key, t, true)
+	if err != nil {
+		return nil, err
+	}
+	if conn == nil {
+		return nil, fmt.Errorf("timed out waiting for advisory lock %q", key)
+	}
+	return release, nil
+}
+
+// TryAcquireAdvisoryLock acquires the advisory lock on the key if it is free, rather than waiting for it. The release
+// function is nil unless the lock was acquired.
+func TryAcquireAdvisoryLock(ctx context.Context, session db.Session, key string, t dbType) (func() error, bool, error) {
+	conn, release, err := acquireAdvisoryLock(ctx, session, key, t, false)
+	return release, conn != nil, err
+}
+
+// tryWithAdvisoryLock runs fn using the connection that holds the advisory lock on the key, if the lock is free,
+// returning whether it did. fn must only use that connection, as the pool may have no other, e.g. with one max open
+// connection, which fn would wait for forever.
+func tryWithAdvisoryLock(ctx context.Context, session db.Session, key string, t dbType, fn func(conn *sql.Conn) error) (bool, error) {
+	conn, release, err := acquireAdvisoryLock(ctx, session, key, t, false)
+	if err != nil || conn == nil {
+		return false, err
+	}
+	defer func() {
+		if err := release(); err != nil {
+			logger().WithError(err).WithField("key", key).Warn("Failed to release advisory lock")
+		}
+	}()
+	return true, fn(conn)
+}
+
+// acquireAdvisoryLock returns the connection that holds the lock, and the function that releases it, or a nil
+// connection if the lock was not acquired
+func acquireAdvisoryLock(ctx context.Context, session db.Session, key string, t dbType, wait bool) (*sql.Conn, func() error, error) {
+	sqlDB, ok := session.Driver().(*sql.DB)
+	if !ok {
+		return nil, nil, fmt.Errorf("cannot lock %T, it is not a connection pool", session.Driver())
+	}
+	// advisory locks belong to the connection, so the lock and unlock must use the same one
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	var lockID interface{}
+	var lock, unlock string
+	if t == MySQL {
+		lockID = key
+		lock, unlock = "select GET_LOCK(?, 0)", "select RELEASE_LOCK(?)"
+		if wait {
+			lock = "select GET_LOCK(?, -1)"
+		}
+	} else {
+		// Postgres locks are identified by a bigint
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(key))
+		lockID = int64(h.Sum64())
+		lock, unlock = "select pg_try_advisory_lock($1)", "select pg_advisory_unlock($1)"
+		if wait {
+			lock = "select pg_advisory_lock($1)"
+		}
+	}
+	// GET_LOCK returns null on error
+	var acquired sql.NullBool
+	if t != MySQL && wait {
+		// pg_advisory_lock returns void, rather than whether the lock was acquired
+		_, err = conn.ExecContext(ctx, lock, lockID)
+		acquired.Bool = true
+	} else {
+		err = conn.QueryRowContext(ctx, lock, lockID).Scan(&acquired)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, fmt.Errorf("failed to acquire advisory lock %q: %w", key, err)
+	}
+	if !acquired.Bool {
+		_ = conn.Close()
+		return nil, nil, nil
+	}
+	logger().WithField("key", key).Debug("Acquired advisory lock")
+	release := func() error {
+		// the lock is released when the connection is closed, so the connection is discarded rather than returned to
+		// the pool if the unlock fails
+		var released sql.NullBool
+		if err := conn.QueryRowContext(context.Background(), unlock, lockID).Scan(&released); err != nil || !released.Bool {
+			_ = conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+			_ = conn.Close()
+			if err == nil {
+				err = fmt.Errorf("advisory lock %q was not held", key)
+			}
+			return fmt.Errorf("failed to release advisory lock %q: %w", key, err)
+		}
+		logger().WithField("key", key).Debug("Released advisory lock")
+		return conn.Close()
+	}
+	return conn, release, nil
+}
@@ -0,0 +1,132 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/upper/db/v4"
+)
+
+// fakeLockServer holds advisory locks on behalf of the sessions that share it, and records their other statements
+type fakeLockServer struct {
+	mu         sync.Mutex
+	cond       *sync.Cond
+	holders    map[interface{}]string
+	statements []string
+}
+
+func newFakeLockServer() *fakeLockServer {
+	s := &fakeLockServer{holders: make(map[interface{}]string)}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// newSession returns a session of the type whose locks are held as the owner
+func (s *fakeLockServer) newSession(t *testing.T, dbType dbType, owner string) db.Session {
+	result := func(ok bool) *fakeResult {
+		if dbType == MySQL {
+			value := int64(0)
+			if ok {
+				value = 1
+			}
+			return &fakeResult{columns: []string{"lock"}, rows: [][]driver.Value{{value}}}
+		}
+		return &fakeResult{columns: []string{"lock"}, rows: [][]driver.Value{{ok}}}
+	}
+	return newFakeSession(t, &fakeConnector{dbType: dbType, handler: func(query string, args []driver.NamedValue) (*fakeResult, error) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		switch query {
+		case "select pg_advisory_lock($1)", "select GET_LOCK(?, -1)":
+			for s.holders[args[0].Value] != "" && s.holders[args[0].Value] != owner {
+				s.cond.Wait()
+			}
+			s.holders[args[0].Value] = owner
+			if strings.Contains(query, "GET_LOCK") {
+				return result(true), nil
+			}
+			return &fakeResult{}, nil
+		case "select pg_try_advisory_lock($1)", "select GET_LOCK(?, 0)":
+			holder := s.holders[args[0].Value]
+			if holder != "" && holder != owner {
+				return result(false), nil
+			}
+			s.holders[args[0].Value] = owner
+			return result(true), nil
+		case "select pg_advisory_unlock($1)", "select RELEASE_LOCK(?)":
+			if s.holders[args[0].Value] != owner {
+				return result(false), nil
+			}
+			delete(s.holders, args[0].Value)
+			s.cond.Broadcast()
+			return result(true), nil
+		}
+		s.statements = append(s.statements, owner+": "+query)
+		return &fakeResult{}, nil
+	}})
+}
+
+func (s *fakeLockServer) Statements() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.statements...)
+}
+
+func TestAdvisoryLock(t *testing.T) {
+	ctx := context.Background()
+	for _, dbType := range []dbType{Postgres, MySQL} {
+		t.Run(string(dbType), func(t *testing.T) {
+			server := newFakeLockServer()
+			a := server.newSession(t, dbType, "a")
+			b := server.newSession(t, dbType, "b")
+
+			releaseA, err := AcquireAdvisoryLock(ctx, a, "archive-gc", dbType)
+			require.NoError(t, err)
+			release, acquired, err := TryAcquireAdvisoryLock(ctx, b, "archive-gc", dbType)
+			require.NoError(t, err)
+			assert.False(t, acquired, "the lock is held by the other session")
+			assert.Nil(t, release)
+			releaseOther, acquired, err := TryAcquireAdvisoryLock(ctx, b, "other", dbType)
+			require.NoError(t, err)
+			assert.True(t, acquired, "other keys are not locked")
+			require.NoError(t, releaseOther())
+
+			acquiredB := make(chan func() error)
+			go func() {
+				release, err := AcquireAdvisoryLock(ctx, b, "archive-gc", dbType)
+				assert.NoError(t, err)
+				acquiredB <- release
+			}()
+			select {
+			case <-acquiredB:
+				t.Fatal("acquired the lock while it was held by the other session")
+			case <-time.After(50 * time.Millisecond):
+			}
+			require.NoError(t, releaseA())
+			var releaseB func() error
+			select {
+			case releaseB = <-acquiredB:
+			case <-time.After(5 * time.Second):
+				t.Fatal("the release did not free the lock")
+			}
+			require.NoError(t, releaseB())
+			assert.Empty(t, server.holders)
+		})
+	}
+	t.Run("ReleaseNotHeld", func(t *testing.T) {
+		server := newFakeLockServer()
+		a := server.newSession(t, Postgres, "a")
+		release, err := AcquireAdvisoryLock(ctx, a, "archive-gc", Postgres)
+		require.NoError(t, err)
+		server.mu.Lock()
+		server.holders = make(map[interface{}]string)
+		server.mu.Unlock()
+		assert.EqualError(t, release(), `failed to release advisory lock "archive-gc": advisory lock "archive-gc" was not held`)
+	})
+}
@@ -0,0 +1,283 @@
+package sqldb
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+	wfv1 "github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
+)
+
+// archiveEncryptionPrefix marks a workflow column that is encrypted, rather than the workflow's JSON
+const archiveEncryptionPrefix = "argo-encrypted:v1:"
+
+// minArchiveKeyLength is the minimum length of a key secret, so that the derived key is as strong as the cipher
+const minArchiveKeyLength = 32
+
+var archiveKeyIDRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// archiveListFields are the fields of a workflow that the list of archived workflows shows, which may be kept
+// unencrypted (unencryptedFields)
+var archiveListFields = []string{"labels", "annotations", "progress"}
+
+// archivedWorkflowEnvelope is the workflow column of an encrypted workflow that keeps some of its fields unencrypted,
+// in the same place as in the workflow, so that they are read by the same queries. A field that is not kept is not
+// set. The encrypted workflow is first, so that the column is recognized by its prefix.
+type archivedWorkflowEnvelope struct {
+	Encrypted string `json:"argoEncrypted"`
+	Metadata  struct {
+		Labels      json.RawMessage `json:"labels,omitempty"`
+		Annotations json.RawMessage `json:"annotations,omitempty"`
+	} `json:"metadata"`
+	Status struct {
+		Progress json.RawMessage `json:"progress,omitempty"`
+	} `json:"status"`
+}
+
+// ArchiveEncryptor encrypts the workflow column of archived workflows, which is stored in the database in plaintext
+// otherwise, using envelope encryption. Each workflow is encrypted with its own random data key, which is stored with
+// it, encrypted by a key encryption key. Key encryption keys have IDs, so that the key is rotated by encrypting with
+// a new key while keeping the old ones to decrypt the workflows that were encrypted with them.
+//
+// The labels, annotations and progress that the list of archived workflows shows may be kept unencrypted, so that the
+// list does not decrypt each workflow.
+type ArchiveEncryptor struct {
+	keyID             string
+	keys              map[string]cipher.AEAD
+	unencryptedFields []string
+}
+
+// NewArchiveEncryptor returns the encryptor for the config, with keys derived from the secrets, or nil if the archive
+// is not encrypted
+func NewArchiveEncryptor(ctx context.Context, kubectlConfig kubernetes.Interface, namespace string, cfg *config.ArchiveEncryption) (*ArchiveEncryptor, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	secrets := make(map[string][]byte, len(cfg.Keys))
+	for _, key := range cfg.Keys {
+		value, err := getSecret(ctx, kubectlConfig, namespace, key.Secret, 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get archive encryption key %q: %w", key.ID, err)
+		}
+		secrets[key.ID] = value
+	}
+	return newArchiveEncryptor(cfg.KeyID, secrets, cfg.UnencryptedFields)
+}
+
+func newArchiveEncryptor(keyID string, secrets map[string][]byte, unencryptedFields []string) (*ArchiveEncryptor, error) {
+	if _, ok := secrets[keyID]; !ok {
+		return nil, fmt.Errorf("archiveEncryption.keyID %q is not one of the keys", keyID)
+	}
+	for _, field := range unencryptedFields {
+		if !slices.Contains(archiveListFields, field) {
+			return nil, fmt.Errorf("archiveEncryption.unencryptedFields %q is not one of %s", field, strings.Join(archiveListFields, ", "))
+		}
+	}
+	e := &ArchiveEncryptor{keyID: keyID, keys: make(map[string]cipher.AEAD, len(secrets)), unencryptedFields: unencryptedFields}
+	for id, secret := range secrets {
+		if !archiveKeyIDRegexp.MatchString(id) {
+			return nil, fmt.Errorf("invalid archive encryption key ID %q", id)
+		}
+		if len(secret) < minArchiveKeyLength {
+			return nil, fmt.Errorf("archive encryption key %q must be at least %d bytes", id, minArchiveKeyLength)
+		}
+		// the secret may not be uniformly random, e.g. a passphrase, so the key is derived from it
+		key := make([]byte, 32)
+		if _, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, []byte("argo-workflows archive key encryption key")), key); err != nil {
+			return nil, err
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		e.keys[id] = aead
+	}
+	return e, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts the plaintext, prefixing it with the random nonce
+func seal(aead cipher.AEAD, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(aead cipher.AEAD, sealed, additionalData []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext is too short")
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additionalData)
+}
+
+// encrypt returns the column value for the workflow and its JSON. The column is JSON, so the value is a JSON string,
+// or the envelope if any fields are kept unencrypted.
+func (e *ArchiveEncryptor) encrypt(wf *wfv1.Workflow, workflow []byte) (string, error) {
+	dataKey := make([]byte, 32)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(aead, workflow, nil)
+	if err != nil {
+		return "", err
+	}
+	// the key ID is authenticated, so the data key cannot be decrypted with a different key
+	wrappedKey, err := seal(e.keys[e.keyID], dataKey, []byte(e.keyID))
+	if err != nil {
+		return "", err
+	}
+	encrypted := archiveEncryptionPrefix + e.keyID + ":" + base64.StdEncoding.EncodeToString(wrappedKey) + ":" + base64.StdEncoding.EncodeToString(ciphertext)
+	if len(e.unencryptedFields) == 0 {
+		value, err := json.Marshal(encrypted)
+		return string(value), err
+	}
+	envelope := archivedWorkflowEnvelope{Encrypted: encrypted}
+	for _, field := range e.unencryptedFields {
+		switch field {
+		case "labels":
+			envelope.Metadata.Labels, err = json.Marshal(nonNilMap(wf.Labels))
+		case "annotations":
+			envelope.Metadata.Annotations, err = json.Marshal(nonNilMap(wf.Annotations))
+		case "progress":
+			envelope.Status.Progress, err = json.Marshal(wf.Status.Progress)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	value, err := json.Marshal(envelope)
+	return string(value), err
+}
+
+// nonNilMap returns the map, or an empty map if it is nil, so that it is stored as an object rather than null
+func nonNilMap(m map[string]string) map[string]string {
+	if m == nil {
+		return map[string]string{}
+	}
+	return m
+}
+
+// parseEncryptedColumn returns the envelope of the column value, and whether it is encrypted. The envelope of a
+// workflow that keeps no fields unencrypted only has the encrypted workflow.
+func parseEncryptedColumn(column string) (archivedWorkflowEnvelope, bool, error) {
+	var envelope archivedWorkflowEnvelope
+	switch {
+	case strings.HasPrefix(column, `"`+archiveEncryptionPrefix):
+		err := json.Unmarshal([]byte(column), &envelope.Encrypted)
+		return envelope, true, err
+	case strings.HasPrefix(column, `{"argoEncrypted":"`+archiveEncryptionPrefix):
+		err := json.Unmarshal([]byte(column), &envelope)
+		return envelope, true, err
+	}
+	return envelope, false, nil
+}
+
+// decryptArchivedWorkflow returns the workflow's JSON from the column value, which is only decrypted if it is
+// encrypted, as workflows that were archived before encryption was enabled are not
+func decryptArchivedWorkflow(e *ArchiveEncryptor, column string) ([]byte, error) {
+	envelope, encrypted, err := parseEncryptedColumn(column)
+	if err != nil || !encrypted {
+		return []byte(column), err
+	}
+	return decryptEnvelope(e, envelope)
+}
+
+func decryptEnvelope(e *ArchiveEncryptor, envelope archivedWorkflowEnvelope) ([]byte, error) {
+	if e == nil {
+		return nil, fmt.Errorf("the archived workflow is encrypted, but archiveEncryption is not configured")
+	}
+	parts := strings.Split(strings.TrimPrefix(envelope.Encrypted, archiveEncryptionPrefix), ":")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("the archived workflow is not encrypted correctly")
+	}
+	keyID := parts[0]
+	kek, ok := e.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("the archived workflow is encrypted with key %q, which is not configured", keyID)
+	}
+	wrappedKey, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := open(kek, wrappedKey, []byte(keyID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt the data key of the archived workflow: %w", err)
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	workflow, err := open(aead, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt the archived workflow: %w", err)
+	}
+	return workflow, nil
+}
+
+// decryptListFields sets the labels, annotations and progress of the listed workflow from the workflow column, if it
+// is encrypted and does not keep them unencrypted, as they are then not read by the list's query
+func decryptListFields(e *ArchiveEncryptor, record *archivedWorkflowRecord) error {
+	envelope, encrypted, err := parseEncryptedColumn(record.Workflow)
+	if err != nil || !encrypted {
+		return err
+	}
+	if envelope.Metadata.Labels != nil && envelope.Metadata.Annotations != nil && envelope.Status.Progress != nil {
+		return nil
+	}
+	workflow, err := decryptEnvelope(e, envelope)
+	if err != nil {
+		return err
+	}
+	var wf wfv1.Workflow
+	if err := json.Unmarshal(workflow, &wf); err != nil {
+		return err
+	}
+	if envelope.Metadata.Labels == nil {
+		labels, err := json.Marshal(nonNilMap(wf.Labels))
+		if err != nil {
+			return err
+		}
+		record.Labels = string(labels)
+	}
+	if envelope.Metadata.Annotations == nil {
+		annotations, err := json.Marshal(nonNilMap(wf.Annotations))
+		if err != nil {
+			return err
+		}
+		record.Annotations = string(annotations)
+	}
+	if envelope.Status.Progress == nil {
+		record.Progress = string(wf.Status.Progress)
+	}
+	return nil
+}
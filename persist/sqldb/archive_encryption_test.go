@@ -0,0 +1,293 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+	wfv1 "github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
+	sutils "github.com/argoproj/argo-workflows/v3/server/utils"
+	"github.com/argoproj/argo-workflows/v3/util/instanceid"
+	"github.com/argoproj/argo-workflows/v3/workflow/common"
+)
+
+// fakeArchiveTable stores the workflow column of the archived workflows, as it was written
+type fakeArchiveTable struct {
+	mu       sync.Mutex
+	workflow string
+}
+
+func (f *fakeArchiveTable) handler(query string, args []driver.NamedValue) (*fakeResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case strings.HasPrefix(query, `INSERT INTO "argo_archived_workflows"`):
+		for _, arg := range args {
+			if value, ok := arg.Value.(string); ok && (strings.HasPrefix(value, "{") || strings.HasPrefix(value, `"`)) {
+				f.workflow = value
+			}
+		}
+	case strings.HasPrefix(query, `SELECT "workflow" FROM "argo_archived_workflows"`):
+		return &fakeResult{columns: []string{"workflow"}, rows: [][]driver.Value{{f.workflow}}}, nil
+	case strings.HasPrefix(query, `SELECT name, namespace, uid`):
+		return f.list()
+	}
+	return &fakeResult{}, nil
+}
+
+// list returns the list's row, with the fields that the list's query reads from the workflow column, as the database
+// would: only those that are kept unencrypted can be read from an encrypted workflow
+func (f *fakeArchiveTable) list() (*fakeResult, error) {
+	labels, annotations, progress := "{}", "{}", ""
+	envelope, encrypted, err := parseEncryptedColumn(f.workflow)
+	if err != nil {
+		return nil, err
+	}
+	if !encrypted {
+		var wf wfv1.Workflow
+		if err := json.Unmarshal([]byte(f.workflow), &wf); err != nil {
+			return nil, err
+		}
+		envelope.Metadata.Labels, _ = json.Marshal(wf.Labels)
+		envelope.Metadata.Annotations, _ = json.Marshal(wf.Annotations)
+		envelope.Status.Progress, _ = json.Marshal(wf.Status.Progress)
+	}
+	if envelope.Metadata.Labels != nil {
+		labels = string(envelope.Metadata.Labels)
+	}
+	if envelope.Metadata.Annotations != nil {
+		annotations = string(envelope.Metadata.Annotations)
+	}
+	if envelope.Status.Progress != nil {
+		if err := json.Unmarshal(envelope.Status.Progress, &progress); err != nil {
+			return nil, err
+		}
+	}
+	return &fakeResult{
+		columns: []string{"name", "namespace", "uid", "phase", "startedat", "finishedat", "labels", "annotations", "progress", "workflow"},
+		rows:    [][]driver.Value{{"my-wf", "argo", "my-uid", "Succeeded", time.Time{}, time.Time{}, labels, annotations, progress, f.workflow}},
+	}, nil
+}
+
+func (f *fakeArchiveTable) Workflow() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.workflow
+}
+
+func testArchiveEncryptor(t *testing.T, keyID string, keyIDs ...string) *ArchiveEncryptor {
+	t.Helper()
+	secrets := map[string][]byte{}
+	for _, id := range keyIDs {
+		secrets[id] = []byte("a secret that is at least 32 bytes long: " + id)
+	}
+	e, err := newArchiveEncryptor(keyID, secrets, nil)
+	require.NoError(t, err)
+	return e
+}
+
+func TestArchiveEncryption_ListWorkflows(t *testing.T) {
+	wf := &wfv1.Workflow{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-wf", Namespace: "argo", UID: "my-uid", Labels: map[string]string{"team": "payments"}, Annotations: map[string]string{"owner": "alice"}},
+		Spec:       wfv1.WorkflowSpec{Arguments: wfv1.Arguments{Parameters: []wfv1.Parameter{{Name: "card", Value: wfv1.AnyStringPtr("4111-1111-1111-1111")}}}},
+		Status:     wfv1.WorkflowStatus{Progress: "1/2"},
+	}
+	secrets := map[string][]byte{"key-1": []byte("a secret that is at least 32 bytes long: key-1")}
+	encryptor := func(t *testing.T, unencryptedFields ...string) *ArchiveEncryptor {
+		e, err := newArchiveEncryptor("key-1", secrets, unencryptedFields)
+		require.NoError(t, err)
+		return e
+	}
+	list := func(t *testing.T, archiveEncryptor, listEncryptor *ArchiveEncryptor) (*fakeArchiveTable, wfv1.Workflows, error) {
+		t.Helper()
+		table := &fakeArchiveTable{}
+		session := newFakeSession(t, &fakeConnector{dbType: Postgres, handler: table.handler})
+		require.NoError(t, NewWorkflowArchive(session, "default", "", instanceid.NewService(""), WithArchiveEncryption(archiveEncryptor)).ArchiveWorkflow(wf.DeepCopy()))
+		wfs, err := NewWorkflowArchive(session, "default", "", instanceid.NewService(""), WithArchiveEncryption(listEncryptor)).ListWorkflows(sutils.ListOptions{})
+		return table, wfs, err
+	}
+	assertListed := func(t *testing.T, wfs wfv1.Workflows) {
+		t.Helper()
+		require.Len(t, wfs, 1)
+		assert.Equal(t, map[string]string{"team": "payments", common.LabelKeyWorkflowArchivingStatus: "Persisted"}, wfs[0].Labels)
+		assert.Equal(t, map[string]string{"owner": "alice"}, wfs[0].Annotations)
+		assert.Equal(t, wfv1.Progress("1/2"), wfs[0].Status.Progress)
+	}
+
+	t.Run("Decrypted", func(t *testing.T) {
+		e := encryptor(t)
+		table, wfs, err := list(t, e, e)
+		require.NoError(t, err)
+		assert.NotContains(t, table.Workflow(), "payments")
+		assertListed(t, wfs)
+	})
+	t.Run("SomeFieldsUnencrypted", func(t *testing.T) {
+		e := encryptor(t, "labels", "progress")
+		table, wfs, err := list(t, e, e)
+		require.NoError(t, err)
+		stored := table.Workflow()
+		require.True(t, strings.HasPrefix(stored, `{"argoEncrypted":"`+archiveEncryptionPrefix+"key-1:"), stored)
+		assert.Contains(t, stored, "payments")
+		assert.Contains(t, stored, "1/2")
+		for _, plaintext := range []string{"alice", "4111-1111-1111-1111"} {
+			assert.NotContains(t, stored, plaintext)
+		}
+		assertListed(t, wfs)
+	})
+	t.Run("AllFieldsUnencrypted", func(t *testing.T) {
+		wrong, err := newArchiveEncryptor("key-1", map[string][]byte{"key-1": []byte("a different secret that is also 32 bytes long")}, nil)
+		require.NoError(t, err)
+		_, wfs, err := list(t, encryptor(t, "labels", "annotations", "progress"), wrong)
+		require.NoError(t, err, "the workflow is not decrypted")
+		assertListed(t, wfs)
+	})
+	t.Run("Plaintext", func(t *testing.T) {
+		_, wfs, err := list(t, nil, encryptor(t))
+		require.NoError(t, err)
+		assertListed(t, wfs)
+	})
+	t.Run("Get", func(t *testing.T) {
+		e := encryptor(t, "labels")
+		table, _, err := list(t, e, e)
+		require.NoError(t, err)
+		session := newFakeSession(t, &fakeConnector{dbType: Postgres, handler: table.handler})
+		got, err := NewWorkflowArchive(session, "default", "", instanceid.NewService(""), WithArchiveEncryption(e)).GetWorkflow("my-uid", "", "")
+		require.NoError(t, err)
+		assert.Equal(t, "4111-1111-1111-1111", got.Spec.Arguments.Parameters[0].Value.String())
+	})
+}
+
+func TestArchiveEncryption(t *testing.T) {
+	wf := &wfv1.Workflow{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-wf", Namespace: "argo", UID: "my-uid", Labels: map[string]string{"team": "payments"}},
+		Spec:       wfv1.WorkflowSpec{Arguments: wfv1.Arguments{Parameters: []wfv1.Parameter{{Name: "card", Value: wfv1.AnyStringPtr("4111-1111-1111-1111")}}}},
+	}
+	archive := func(t *testing.T, encryptor *ArchiveEncryptor) *fakeArchiveTable {
+		t.Helper()
+		table := &fakeArchiveTable{}
+		session := newFakeSession(t, &fakeConnector{dbType: Postgres, handler: table.handler})
+		repo := NewWorkflowArchive(session, "default", "", instanceid.NewService(""), WithArchiveEncryption(encryptor))
+		require.NoError(t, repo.ArchiveWorkflow(wf.DeepCopy()))
+		return table
+	}
+	read := func(table *fakeArchiveTable, encryptor *ArchiveEncryptor) (*wfv1.Workflow, error) {
+		session := newFakeSession(t, &fakeConnector{dbType: Postgres, handler: table.handler})
+		return NewWorkflowArchive(session, "default", "", instanceid.NewService(""), WithArchiveEncryption(encryptor)).GetWorkflow("my-uid", "", "")
+	}
+
+	t.Run("Encrypted", func(t *testing.T) {
+		encryptor := testArchiveEncryptor(t, "key-1", "key-1")
+		table := archive(t, encryptor)
+		stored := table.Workflow()
+		require.True(t, strings.HasPrefix(stored, `"`+archiveEncryptionPrefix+"key-1:"), stored)
+		for _, plaintext := range []string{"my-wf", "payments", "4111-1111-1111-1111", "metadata"} {
+			assert.NotContains(t, stored, plaintext)
+		}
+		got, err := read(table, encryptor)
+		require.NoError(t, err)
+		assert.Equal(t, "my-wf", got.Name)
+		assert.Equal(t, "payments", got.Labels["team"])
+		assert.Equal(t, "4111-1111-1111-1111", got.Spec.Arguments.Parameters[0].Value.String())
+	})
+	t.Run("EachWorkflowHasItsOwnDataKey", func(t *testing.T) {
+		encryptor := testArchiveEncryptor(t, "key-1", "key-1")
+		first := archive(t, encryptor)
+		second := archive(t, encryptor)
+		assert.NotEqual(t, first.Workflow(), second.Workflow())
+	})
+	t.Run("Plaintext", func(t *testing.T) {
+		table := archive(t, nil)
+		assert.Contains(t, table.Workflow(), "4111-1111-1111-1111")
+		got, err := read(table, testArchiveEncryptor(t, "key-1", "key-1"))
+		require.NoError(t, err)
+		assert.Equal(t, "my-wf", got.Name)
+	})
+	t.Run("NotConfigured", func(t *testing.T) {
+		table := archive(t, testArchiveEncryptor(t, "key-1", "key-1"))
+		_, err := read(table, nil)
+		assert.EqualError(t, err, "the archived workflow is encrypted, but archiveEncryption is not configured")
+	})
+	t.Run("Rotated", func(t *testing.T) {
+		table := archive(t, testArchiveEncryptor(t, "key-1", "key-1"))
+		rotated := testArchiveEncryptor(t, "key-2", "key-1", "key-2")
+		got, err := read(table, rotated)
+		require.NoError(t, err)
+		assert.Equal(t, "my-wf", got.Name)
+
+		table = archive(t, rotated)
+		assert.True(t, strings.HasPrefix(table.Workflow(), `"`+archiveEncryptionPrefix+"key-2:"))
+		_, err = read(table, testArchiveEncryptor(t, "key-1", "key-1"))
+		assert.EqualError(t, err, `the archived workflow is encrypted with key "key-2", which is not configured`)
+	})
+	t.Run("WrongKey", func(t *testing.T) {
+		table := archive(t, testArchiveEncryptor(t, "key-1", "key-1"))
+		wrong, err := newArchiveEncryptor("key-1", map[string][]byte{"key-1": []byte("a different secret that is also 32 bytes long")}, nil)
+		require.NoError(t, err)
+		_, err = read(table, wrong)
+		assert.ErrorContains(t, err, "failed to decrypt the data key of the archived workflow")
+	})
+	t.Run("Tampered", func(t *testing.T) {
+		table := archive(t, testArchiveEncryptor(t, "key-1", "key-1"))
+		stored := table.Workflow()
+		// flip a character of the encrypted workflow, which is last
+		i := len(stored) - 10
+		c := byte('A')
+		if stored[i] == 'A' {
+			c = 'B'
+		}
+		table = &fakeArchiveTable{workflow: stored[:i] + string(c) + stored[i+1:]}
+		_, err := read(table, testArchiveEncryptor(t, "key-1", "key-1"))
+		assert.ErrorContains(t, err, "failed to decrypt the archived workflow")
+	})
+}
+
+func TestNewArchiveEncryptor(t *testing.T) {
+	ctx := context.Background()
+	kube := fake.NewSimpleClientset(&apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "argo-archive-encryption", Namespace: "argo"},
+		Data: map[string][]byte{
+			"key-1": []byte("a secret that is at least 32 bytes long"),
+			"short": []byte("too short"),
+		},
+	})
+	key := func(id, key string) config.ArchiveEncryptionKey {
+		return config.ArchiveEncryptionKey{ID: id, Secret: apiv1.SecretKeySelector{LocalObjectReference: apiv1.LocalObjectReference{Name: "argo-archive-encryption"}, Key: key}}
+	}
+	t.Run("NotConfigured", func(t *testing.T) {
+		e, err := NewArchiveEncryptor(ctx, kube, "argo", nil)
+		require.NoError(t, err)
+		assert.Nil(t, e)
+	})
+	t.Run("Configured", func(t *testing.T) {
+		e, err := NewArchiveEncryptor(ctx, kube, "argo", &config.ArchiveEncryption{KeyID: "key-1", Keys: []config.ArchiveEncryptionKey{key("key-1", "key-1")}})
+		require.NoError(t, err)
+		assert.Equal(t, "key-1", e.keyID)
+	})
+	t.Run("UnknownKeyID", func(t *testing.T) {
+		_, err := NewArchiveEncryptor(ctx, kube, "argo", &config.ArchiveEncryption{KeyID: "key-2", Keys: []config.ArchiveEncryptionKey{key("key-1", "key-1")}})
+		assert.EqualError(t, err, `archiveEncryption.keyID "key-2" is not one of the keys`)
+	})
+	t.Run("InvalidKeyID", func(t *testing.T) {
+		_, err := NewArchiveEncryptor(ctx, kube, "argo", &config.ArchiveEncryption{KeyID: "key:1", Keys: []config.ArchiveEncryptionKey{key("key:1", "key-1")}})
+		assert.EqualError(t, err, `invalid archive encryption key ID "key:1"`)
+	})
+	t.Run("UnknownUnencryptedField", func(t *testing.T) {
+		_, err := NewArchiveEncryptor(ctx, kube, "argo", &config.ArchiveEncryption{KeyID: "key-1", Keys: []config.ArchiveEncryptionKey{key("key-1", "key-1")}, UnencryptedFields: []string{"spec"}})
+		assert.EqualError(t, err, `archiveEncryption.unencryptedFields "spec" is not one of labels, annotations, progress`)
+	})
+	t.Run("ShortKey", func(t *testing.T) {
+		_, err := NewArchiveEncryptor(ctx, kube, "argo", &config.ArchiveEncryption{KeyID: "short", Keys: []config.ArchiveEncryptionKey{key("short", "short")}})
+		assert.EqualError(t, err, `archive encryption key "short" must be at least 32 bytes`)
+	})
+}
@@ -17,7 +17,7 @@ import (
 func (r *workflowArchive) ListWorkflowsLabelKeys() (*wfv1.LabelKeys, error) {
 	var archivedWfLabels []archivedWorkflowLabelRecord
 
-	err := r.session.SQL().
+	err := r.sessions.Reader().SQL().
 		Select(db.Raw("DISTINCT name")).
 		From(archiveLabelsTableName).
 		All(&archivedWfLabels)
@@ -36,7 +36,7 @@ func (r *workflowArchive) ListWorkflowsLabelKeys() (*wfv1.LabelKeys, error) {
 // SELECT DISTINCT value FROM argo_archived_workflows_labels WHERE name=labelkey
 func (r *workflowArchive) ListWorkflowsLabelValues(key string) (*wfv1.LabelValues, error) {
 	var archivedWfLabels []archivedWorkflowLabelRecord
-	err := r.session.SQL().
+	err := r.sessions.Reader().SQL().
 		Select(db.Raw("DISTINCT value")).
 		From(archiveLabelsTableName).
 		Where(db.Cond{"name": key}).
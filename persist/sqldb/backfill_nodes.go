@@ -19,7 +19,7 @@ func (s backfillNodes) String() string {
 }
 
 func (s backfillNodes) apply(session db.Session) (err error) {
-	log.Info("Backfill node status")
+	logger().Info("Backfill node status")
 	rs, err := session.SQL().SelectFrom(s.tableName).
 		Columns("workflow").
 		Where(db.Cond{"version": nil}).
@@ -53,7 +53,7 @@ func (s backfillNodes) apply(session db.Session) (err error) {
 		if err != nil {
 			return err
 		}
-		logCtx := log.WithFields(log.Fields{"name": wf.Name, "namespace": wf.Namespace, "version": version})
+		logCtx := logger().WithFields(log.Fields{"name": wf.Name, "namespace": wf.Namespace, "version": version})
 		logCtx.Info("Back-filling node status")
 		res, err := session.SQL().Update(archiveTableName).
 			Set("version", wf.ResourceVersion).
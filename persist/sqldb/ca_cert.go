@@ -0,0 +1,67 @@
+package sqldb
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+	"github.com/argoproj/argo-workflows/v3/util"
+)
+
+// rootCAs returns the pool of the configured CA bundle, which may be in either a secret or, as it is not secret, a
+// config map, or nil if there is none
+func rootCAs(ctx context.Context, kubectlConfig kubernetes.Interface, namespace string, cfg config.DatabaseConfig) (*x509.CertPool, error) {
+	var data []byte
+	var err error
+	switch {
+	case cfg.CaCertSecret != nil && cfg.CaCertConfigMap != nil:
+		return nil, fmt.Errorf("only one of caCertSecret and caCertConfigMap may be set")
+	case cfg.CaCertSecret != nil:
+		data, err = getSecret(ctx, kubectlConfig, namespace, *cfg.CaCertSecret, cfg.SecretFetchRetries)
+	case cfg.CaCertConfigMap != nil:
+		data, err = util.GetConfigMaps(ctx, kubectlConfig, namespace, cfg.CaCertConfigMap.Name, cfg.CaCertConfigMap.Key)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the CA certificate: %w", err)
+	}
+	return certPoolFromPEM(data)
+}
+
+// certPoolFromPEM returns the pool of every certificate in the PEM bundle, e.g. an intermediate and a root. Unlike
+// AppendCertsFromPEM, a certificate that cannot be parsed is logged rather than silently skipped, and it is only an
+// error if there are no certificates at all.
+func certPoolFromPEM(data []byte) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+	var parsed, failed int
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			failed++
+			logger().WithField("certificate", parsed+failed).WithError(err).Warn("Failed to parse a certificate in the CA certificate, skipping it")
+			continue
+		}
+		pool.AddCert(cert)
+		parsed++
+	}
+	switch {
+	case parsed == 0 && failed > 0:
+		return nil, fmt.Errorf("none of the %d certificates in the CA certificate could be parsed", failed)
+	case parsed == 0:
+		return nil, fmt.Errorf("the CA certificate does not contain any PEM certificates")
+	}
+	return pool, nil
+}
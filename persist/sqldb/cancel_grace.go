@@ -0,0 +1,119 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql/driver"
+	"sync"
+	"time"
+)
+
+// cancelGraceConnector wraps a connector so that, when a statement's context is cancelled, the statement has a grace
+// period to complete before the driver cancels it, rather than near-complete work being thrown away. The driver is
+// given a context that is only cancelled once the grace period has passed, and a statement that completes within it
+// succeeds. database/sql still closes a query's rows as soon as the caller's context is cancelled, so for a query it
+// is only getting the first rows that is given the grace period.
+type cancelGraceConnector struct {
+	driver.Connector
+	grace time.Duration
+}
+
+func newCancelGraceConnector(c driver.Connector, grace time.Duration) driver.Connector {
+	if grace <= 0 {
+		return c
+	}
+	return &cancelGraceConnector{Connector: c, grace: grace}
+}
+
+func (c *cancelGraceConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &cancelGraceConn{wrappedConn: wrappedConn{Conn: conn}, grace: c.grace}, nil
+}
+
+type cancelGraceConn struct {
+	wrappedConn
+	grace time.Duration
+}
+
+// graceContext returns a context that is cancelled once the grace period has passed since ctx was cancelled, and a
+// func that must be called once the statement has completed
+func (c *cancelGraceConn) graceContext(ctx context.Context) (context.Context, func()) {
+	graceCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+		case <-done:
+			return
+		}
+		timer := time.NewTimer(c.grace)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			cancel()
+		case <-done:
+		}
+	}()
+	var once sync.Once
+	return graceCtx, func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+			cancel()
+		})
+	}
+}
+
+func (c *cancelGraceConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if ctx.Done() == nil {
+		return execer.ExecContext(ctx, query, args)
+	}
+	graceCtx, stop := c.graceContext(ctx)
+	defer stop()
+	res, err := execer.ExecContext(graceCtx, query, args)
+	if err != nil && graceCtx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return res, err
+}
+
+func (c *cancelGraceConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	if ctx.Done() == nil {
+		return queryer.QueryContext(ctx, query, args)
+	}
+	// the driver may still be reading rows with the context, so it is kept until they are closed
+	graceCtx, stop := c.graceContext(ctx)
+	rows, err := queryer.QueryContext(graceCtx, query, args)
+	if err != nil {
+		cancelled := graceCtx.Err() != nil
+		stop()
+		if cancelled {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return &cancelGraceRows{Rows: rows, stop: stop}, nil
+}
+
+type cancelGraceRows struct {
+	driver.Rows
+	stop func()
+}
+
+func (r *cancelGraceRows) Close() error {
+	err := r.Rows.Close()
+	r.stop()
+	return err
+}
@@ -0,0 +1,101 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_newCancelGraceConnector(t *testing.T) {
+	fake := &fakeConnector{dbType: Postgres}
+	assert.Same(t, fake, newCancelGraceConnector(fake, 0))
+	assert.IsType(t, &cancelGraceConnector{}, newCancelGraceConnector(fake, time.Second))
+}
+
+func TestCancelGraceConnector(t *testing.T) {
+	const grace = 200 * time.Millisecond
+	// newSession returns a session whose "select sleep(1)" statement completes when finish is closed, or is
+	// interrupted when it is killed
+	newSession := func(t *testing.T, finish chan struct{}) (db *sql.DB, started chan struct{}, killedAt *atomic.Pointer[time.Time]) {
+		var ids atomic.Int64
+		started = make(chan struct{})
+		killed := make(chan struct{})
+		killedAt = &atomic.Pointer[time.Time]{}
+		connector := &fakeConnector{dbType: MySQL, handler: func(query string, _ []driver.NamedValue) (*fakeResult, error) {
+			if res := lookupNameResult(query); res != nil {
+				return res, nil
+			}
+			switch query {
+			case "select connection_id()":
+				return &fakeResult{columns: []string{"id"}, rows: [][]driver.Value{{[]byte(fmt.Sprint(ids.Add(1)))}}}, nil
+			case "select sleep(1)":
+				close(started)
+				select {
+				case <-finish:
+					return &fakeResult{}, nil
+				case <-killed:
+					return nil, &mysql.MySQLError{Number: 1317, Message: "Query execution was interrupted"}
+				}
+			case "kill query 1":
+				now := time.Now()
+				killedAt.Store(&now)
+				close(killed)
+			}
+			return &fakeResult{}, nil
+		}}
+		session, err := openSession(MySQL, newCancelGraceConnector(&killQueryConnector{Connector: connector}, grace), allSessionFeatures)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = session.Close() })
+		return session.Driver().(*sql.DB), started, killedAt
+	}
+
+	t.Run("CompletesWithinGracePeriod", func(t *testing.T) {
+		finish := make(chan struct{})
+		sqlDB, started, killedAt := newSession(t, finish)
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			<-started
+			cancel()
+			time.Sleep(grace / 4)
+			close(finish)
+		}()
+		_, err := sqlDB.ExecContext(ctx, "select sleep(1)")
+		require.NoError(t, err)
+		assert.Nil(t, killedAt.Load(), "the statement must not be cancelled")
+	})
+	t.Run("ExceedsGracePeriod", func(t *testing.T) {
+		sqlDB, started, killedAt := newSession(t, make(chan struct{}))
+		ctx, cancel := context.WithCancel(context.Background())
+		var cancelledAt time.Time
+		go func() {
+			<-started
+			cancelledAt = time.Now()
+			cancel()
+		}()
+		_, err := sqlDB.ExecContext(ctx, "select sleep(1)")
+		require.ErrorIs(t, err, context.Canceled)
+		if assert.NotNil(t, killedAt.Load(), "the statement must be cancelled") {
+			assert.GreaterOrEqual(t, killedAt.Load().Sub(cancelledAt), grace)
+		}
+	})
+	t.Run("QueryExceedsGracePeriod", func(t *testing.T) {
+		sqlDB, started, killedAt := newSession(t, make(chan struct{}))
+		ctx, cancel := context.WithCancel(context.Background())
+		go func() {
+			<-started
+			cancel()
+		}()
+		_, err := sqlDB.QueryContext(ctx, "select sleep(1)")
+		require.ErrorIs(t, err, context.Canceled)
+		assert.NotNil(t, killedAt.Load())
+		assert.Equal(t, 0, sqlDB.Stats().InUse)
+	})
+}
@@ -0,0 +1,109 @@
+package sqldb
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v4"
+	"golang.org/x/crypto/pkcs12"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+// clientCertificate returns the client certificate of the configured PKCS#12 bundle, or nil if there is none
+func clientCertificate(ctx context.Context, kubectlConfig kubernetes.Interface, namespace string, cfg config.DatabaseConfig) (*tls.Certificate, error) {
+	if cfg.PKCS12Secret == nil {
+		if cfg.PKCS12PassphraseSecret != nil {
+			return nil, fmt.Errorf("pkcs12PassphraseSecret cannot be used without pkcs12Secret")
+		}
+		return nil, nil
+	}
+	data, err := getSecret(ctx, kubectlConfig, namespace, *cfg.PKCS12Secret, cfg.SecretFetchRetries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the PKCS#12 bundle: %w", err)
+	}
+	var passphrase []byte
+	if cfg.PKCS12PassphraseSecret != nil {
+		passphrase, err = getSecret(ctx, kubectlConfig, namespace, *cfg.PKCS12PassphraseSecret, cfg.SecretFetchRetries)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the PKCS#12 passphrase: %w", err)
+		}
+	}
+	return decodePKCS12(data, string(passphrase))
+}
+
+// decodePKCS12 returns the certificate of the bundle, which must have exactly one key, and a certificate for it. Any
+// other certificates are its chain, which is sent to the server after it.
+func decodePKCS12(data []byte, passphrase string) (*tls.Certificate, error) {
+	blocks, err := pkcs12.ToPEM(data, passphrase)
+	if err != nil {
+		if errors.Is(err, pkcs12.ErrIncorrectPassword) {
+			return nil, fmt.Errorf("the PKCS#12 passphrase is incorrect")
+		}
+		return nil, fmt.Errorf("failed to decode the PKCS#12 bundle: %w", err)
+	}
+	var keyPEM []byte
+	var certs []*pem.Block
+	for _, block := range blocks {
+		switch block.Type {
+		case "CERTIFICATE":
+			certs = append(certs, block)
+		case "PRIVATE KEY":
+			if keyPEM != nil {
+				return nil, fmt.Errorf("the PKCS#12 bundle contains more than one private key")
+			}
+			keyPEM = pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: block.Bytes})
+		}
+	}
+	if keyPEM == nil {
+		return nil, fmt.Errorf("the PKCS#12 bundle does not contain a private key")
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("the PKCS#12 bundle does not contain a certificate")
+	}
+	// the bags may be in any order, so the leaf is the certificate that is for the key
+	for i := range certs {
+		chain := append([]*pem.Block(nil), certs...)
+		chain[0], chain[i] = chain[i], chain[0]
+		var certPEM []byte
+		for _, c := range chain {
+			certPEM = append(certPEM, pem.EncodeToMemory(&pem.Block{Type: c.Type, Bytes: c.Bytes})...)
+		}
+		if cert, err := tls.X509KeyPair(certPEM, keyPEM); err == nil {
+			return &cert, nil
+		}
+	}
+	return nil, fmt.Errorf("the PKCS#12 bundle does not contain a certificate for its private key")
+}
+
+// withClientCertificate authenticates to the server using the certificate, on every connection that uses TLS.
+// Whether TLS is used still depends on the sslMode.
+func withClientCertificate(cert *tls.Certificate) PostgresOption {
+	return func(connConfig *pgx.ConnConfig) {
+		if connConfig.TLSConfig != nil {
+			connConfig.TLSConfig.Certificates = []tls.Certificate{*cert}
+		}
+		for _, fallback := range connConfig.Fallbacks {
+			if fallback.TLSConfig != nil {
+				fallback.TLSConfig.Certificates = []tls.Certificate{*cert}
+			}
+		}
+	}
+}
+
+// withMySQLClientCertificate authenticates to the server using the certificate, enabling TLS if the options do not
+func withMySQLClientCertificate(cert *tls.Certificate) mySQLOption {
+	return func(mysqlConfig *mysql.Config) {
+		if mysqlConfig.TLS == nil {
+			host, _, _ := net.SplitHostPort(mysqlConfig.Addr)
+			mysqlConfig.TLS = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+		}
+		mysqlConfig.TLS.Certificates = []tls.Certificate{*cert}
+	}
+}
@@ -0,0 +1,22 @@
+package sqldb
+
+import "time"
+
+// Clock tells the time and waits, so that tests of timeouts, backoff and expiry can control time rather than wait
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	// After is Sleep for a select, e.g. to stop waiting when a context is done
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time        { return time.Now() }
+func (realClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// clock is the package's clock. Types that keep it as a field copy it when they are created, so that a test can
+// replace a single instance's clock.
+var clock Clock = realClock{}
@@ -0,0 +1,11 @@
+package sqldb
+
+// Component is the Argo component that a session is created for. Both the controller and the server create sessions
+// from the same persistence config, so it is what tells their connections apart, in application_name and in the
+// metrics' component label.
+type Component string
+
+const (
+	ComponentController Component = "controller"
+	ComponentServer     Component = "server"
+)
@@ -0,0 +1,82 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+	errorsutil "github.com/argoproj/argo-workflows/v3/util/errors"
+)
+
+const (
+	ConcurrencyLimitModeQueue    = "queue"
+	ConcurrencyLimitModeFailFast = "failFast"
+)
+
+// ErrTooManyConcurrentQueries is returned instead of running a query while maxConcurrentQueries are already running,
+// in failFast mode. It is transient, so callers that retry transient errors retry the query.
+var ErrTooManyConcurrentQueries = errorsutil.NewErrTransient("too many concurrent database queries")
+
+// concurrencyLimitConnector limits how many queries its connections run at once, independently of the size of the
+// pool. A query runs until its rows are closed, so a caller that holds rows open while it runs another query can
+// deadlock in queue mode once every query that is running is doing so.
+type concurrencyLimitConnector struct {
+	driver.Connector
+	running  chan struct{}
+	failFast bool
+	hooks    queryHooks
+}
+
+// newConcurrencyLimitConnector limits the queries of the connector, unless maxConcurrentQueries is not set
+func newConcurrencyLimitConnector(c driver.Connector, persistPool *config.ConnectionPool) (driver.Connector, error) {
+	if persistPool == nil || persistPool.MaxConcurrentQueries <= 0 {
+		return c, nil
+	}
+	var failFast bool
+	switch persistPool.ConcurrencyLimitMode {
+	case "", ConcurrencyLimitModeQueue:
+	case ConcurrencyLimitModeFailFast:
+		failFast = true
+	default:
+		return nil, fmt.Errorf("concurrencyLimitMode must be one of: queue, failFast")
+	}
+	connector := &concurrencyLimitConnector{
+		Connector: c,
+		running:   make(chan struct{}, persistPool.MaxConcurrentQueries),
+		failFast:  failFast,
+	}
+	connector.hooks.before = func(ctx context.Context, _ string) (func(), error) {
+		return connector.acquire(ctx)
+	}
+	return connector, nil
+}
+
+// acquire waits until fewer than the limit of queries are running, or fails straight away in failFast mode, and
+// returns the func that releases the query's slot
+func (c *concurrencyLimitConnector) acquire(ctx context.Context) (func(), error) {
+	if c.failFast {
+		select {
+		case c.running <- struct{}{}:
+		default:
+			return nil, ErrTooManyConcurrentQueries
+		}
+	} else {
+		select {
+		case c.running <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	var once sync.Once
+	return func() { once.Do(func() { <-c.running }) }, nil
+}
+
+func (c *concurrencyLimitConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newHookedConn(conn, &c.hooks), nil
+}
@@ -0,0 +1,132 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql/driver"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/upper/db/v4"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+	errorsutil "github.com/argoproj/argo-workflows/v3/util/errors"
+)
+
+// newConcurrencyLimitSession returns a limited session whose "select sleep" queries call sleep
+func newConcurrencyLimitSession(t *testing.T, persistPool *config.ConnectionPool, sleep func()) db.Session {
+	t.Helper()
+	connector := &fakeConnector{dbType: Postgres, handler: func(query string, _ []driver.NamedValue) (*fakeResult, error) {
+		if res := lookupNameResult(query); res != nil {
+			return res, nil
+		}
+		if query == "select sleep" {
+			sleep()
+		}
+		return &fakeResult{}, nil
+	}}
+	limited, err := newConcurrencyLimitConnector(connector, persistPool)
+	require.NoError(t, err)
+	session, err := openSession(Postgres, limited, allSessionFeatures)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = session.Close() })
+	return session
+}
+
+func Test_newConcurrencyLimitConnector(t *testing.T) {
+	connector := &fakeConnector{}
+	t.Run("Disabled", func(t *testing.T) {
+		c, err := newConcurrencyLimitConnector(connector, &config.ConnectionPool{})
+		require.NoError(t, err)
+		assert.Same(t, connector, c)
+	})
+	t.Run("InvalidMode", func(t *testing.T) {
+		_, err := newConcurrencyLimitConnector(connector, &config.ConnectionPool{MaxConcurrentQueries: 1, ConcurrencyLimitMode: "drop"})
+		assert.EqualError(t, err, "concurrencyLimitMode must be one of: queue, failFast")
+	})
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	t.Run("Queue", func(t *testing.T) {
+		var running, maxRunning atomic.Int32
+		session := newConcurrencyLimitSession(t, &config.ConnectionPool{MaxConcurrentQueries: 3}, func() {
+			n := running.Add(1)
+			for {
+				m := maxRunning.Load()
+				if n <= m || maxRunning.CompareAndSwap(m, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			running.Add(-1)
+		})
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := session.SQL().Exec("select sleep")
+				assert.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int32(3), maxRunning.Load(), "the queries queue rather than exceed the limit")
+	})
+	t.Run("QueueContextDone", func(t *testing.T) {
+		unblock := make(chan struct{})
+		started := make(chan struct{})
+		session := newConcurrencyLimitSession(t, &config.ConnectionPool{MaxConcurrentQueries: 1}, func() {
+			close(started)
+			<-unblock
+		})
+		done := make(chan error)
+		go func() {
+			_, err := session.SQL().Exec("select sleep")
+			done <- err
+		}()
+		<-started
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := session.SQL().ExecContext(ctx, "select 1")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		close(unblock)
+		require.NoError(t, <-done)
+	})
+	t.Run("FailFast", func(t *testing.T) {
+		unblock := make(chan struct{})
+		started := make(chan struct{})
+		session := newConcurrencyLimitSession(t, &config.ConnectionPool{MaxConcurrentQueries: 1, ConcurrencyLimitMode: ConcurrencyLimitModeFailFast}, func() {
+			close(started)
+			<-unblock
+		})
+		done := make(chan error)
+		go func() {
+			_, err := session.SQL().Exec("select sleep")
+			done <- err
+		}()
+		<-started
+		start := time.Now()
+		_, err := session.SQL().Exec("select 1")
+		assert.ErrorIs(t, err, ErrTooManyConcurrentQueries)
+		assert.True(t, errorsutil.IsTransientErr(err))
+		assert.Less(t, time.Since(start), time.Second, "the query is rejected promptly")
+		close(unblock)
+		require.NoError(t, <-done)
+
+		_, err = session.SQL().Exec("select 1")
+		assert.NoError(t, err, "the slot is released once the query completes")
+	})
+	t.Run("RowsHoldTheSlot", func(t *testing.T) {
+		session := newConcurrencyLimitSession(t, &config.ConnectionPool{MaxConcurrentQueries: 1, ConcurrencyLimitMode: ConcurrencyLimitModeFailFast}, func() {})
+		rows, err := session.SQL().Query("select 1")
+		require.NoError(t, err)
+		_, err = session.SQL().Exec("select 1")
+		assert.ErrorIs(t, err, ErrTooManyConcurrentQueries)
+		require.NoError(t, rows.Close())
+		_, err = session.SQL().Exec("select 1")
+		assert.NoError(t, err)
+	})
+}
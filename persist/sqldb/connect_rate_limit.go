@@ -0,0 +1,56 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+
+	"golang.org/x/time/rate"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+// connectRateLimitConnector limits how fast new connections are opened, so that a pool that grows all at once, e.g.
+// once a recovering database accepts connections again, opens them at a steady rate rather than in a storm. It only
+// delays opening connections, the pool's existing connections are not affected.
+type connectRateLimitConnector struct {
+	driver.Connector
+	limiter *rate.Limiter
+	clock   Clock
+}
+
+// newConnectRateLimitConnector limits the connections that the connector opens, unless maxConnectionOpenRate is not set
+func newConnectRateLimitConnector(c driver.Connector, persistPool *config.ConnectionPool) (driver.Connector, error) {
+	if persistPool == nil || persistPool.MaxConnectionOpenRate <= 0 {
+		return c, nil
+	}
+	burst := persistPool.MaxConnectionOpenBurst
+	if burst == 0 {
+		burst = 1
+	}
+	if burst < 0 {
+		return nil, fmt.Errorf("maxConnectionOpenBurst must not be negative")
+	}
+	return &connectRateLimitConnector{
+		Connector: c,
+		limiter:   rate.NewLimiter(rate.Limit(persistPool.MaxConnectionOpenRate), burst),
+		clock:     clock,
+	}, nil
+}
+
+// Connect waits for the limiter before opening the connection. The wait is measured with the connector's clock rather
+// than using Limiter.Wait, so that it can be tested.
+func (c *connectRateLimitConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	reservation := c.limiter.ReserveN(c.clock.Now(), 1)
+	if delay := reservation.DelayFrom(c.clock.Now()); delay > 0 {
+		logger().WithField("delay", delay).Debug("Waiting to open a database connection, maxConnectionOpenRate reached")
+		select {
+		case <-ctx.Done():
+			// give the slot back, so that the wait of a cancelled connect does not delay the next one
+			reservation.CancelAt(c.clock.Now())
+			return nil, ctx.Err()
+		case <-c.clock.After(delay):
+		}
+	}
+	return c.Connector.Connect(ctx)
+}
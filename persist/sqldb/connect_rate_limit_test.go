@@ -0,0 +1,104 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql/driver"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+func Test_newConnectRateLimitConnector(t *testing.T) {
+	connector := &fakeConnector{}
+	t.Run("Disabled", func(t *testing.T) {
+		c, err := newConnectRateLimitConnector(connector, &config.ConnectionPool{})
+		require.NoError(t, err)
+		assert.Same(t, connector, c)
+	})
+	t.Run("NegativeBurst", func(t *testing.T) {
+		_, err := newConnectRateLimitConnector(connector, &config.ConnectionPool{MaxConnectionOpenRate: 1, MaxConnectionOpenBurst: -1})
+		assert.EqualError(t, err, "maxConnectionOpenBurst must not be negative")
+	})
+}
+
+func TestConnectRateLimit(t *testing.T) {
+	ctx := context.Background()
+	t.Run("Throttled", func(t *testing.T) {
+		clock := useFakeClock(t)
+		connector := &fakeConnector{dbType: Postgres}
+		limited, err := newConnectRateLimitConnector(connector, &config.ConnectionPool{MaxConnectionOpenRate: 10, MaxConnectionOpenBurst: 2})
+		require.NoError(t, err)
+		start := clock.Now()
+		for i := 0; i < 5; i++ {
+			_, err := limited.Connect(ctx)
+			require.NoError(t, err)
+		}
+		assert.Len(t, connector.Conns(), 5)
+		assert.Equal(t, []time.Duration{100 * time.Millisecond, 100 * time.Millisecond, 100 * time.Millisecond}, clock.Sleeps(), "the burst opens straight away, then one every 100ms")
+		assert.Equal(t, 300*time.Millisecond, clock.Now().Sub(start))
+	})
+	t.Run("DefaultBurst", func(t *testing.T) {
+		clock := useFakeClock(t)
+		limited, err := newConnectRateLimitConnector(&fakeConnector{dbType: Postgres}, &config.ConnectionPool{MaxConnectionOpenRate: 2})
+		require.NoError(t, err)
+		for i := 0; i < 2; i++ {
+			_, err := limited.Connect(ctx)
+			require.NoError(t, err)
+		}
+		assert.Equal(t, []time.Duration{500 * time.Millisecond}, clock.Sleeps())
+	})
+	t.Run("Cancelled", func(t *testing.T) {
+		connector := &fakeConnector{dbType: Postgres}
+		limited, err := newConnectRateLimitConnector(connector, &config.ConnectionPool{MaxConnectionOpenRate: 1})
+		require.NoError(t, err)
+		_, err = limited.Connect(ctx)
+		require.NoError(t, err)
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		start := time.Now()
+		_, err = limited.Connect(cancelled)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Len(t, connector.Conns(), 1, "the connection is not opened")
+		assert.Less(t, time.Since(start), time.Second, "the connect does not wait for the limiter")
+	})
+	t.Run("BurstOfDemand", func(t *testing.T) {
+		const conns = 6
+		// every query waits until all of them are running, so each needs its own connection
+		var running sync.WaitGroup
+		running.Add(conns)
+		connector := &fakeConnector{dbType: Postgres, handler: func(query string, _ []driver.NamedValue) (*fakeResult, error) {
+			if res := lookupNameResult(query); res != nil {
+				return res, nil
+			}
+			if query == "select wait" {
+				running.Done()
+				running.Wait()
+			}
+			return &fakeResult{}, nil
+		}}
+		limited, err := newConnectRateLimitConnector(connector, &config.ConnectionPool{MaxConnectionOpenRate: 100, MaxConnectionOpenBurst: 2})
+		require.NoError(t, err)
+		session, err := openSession(Postgres, limited, allSessionFeatures)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = session.Close() })
+		// opening the session used one connection of the burst
+		start := time.Now()
+		var wg sync.WaitGroup
+		for i := 0; i < conns; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := session.SQL().Exec("select wait")
+				assert.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+		assert.Len(t, connector.Conns(), conns)
+		assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond, "the connections after the burst are opened 10ms apart")
+	})
+}
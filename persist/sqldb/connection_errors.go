@@ -0,0 +1,103 @@
+package sqldb
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgconn"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/argoproj/argo-workflows/v3/workflow/metrics"
+)
+
+// the reasons a connection can fail, used as the metric label
+const (
+	connectionErrorTLS     = "tls"
+	connectionErrorAuth    = "auth"
+	connectionErrorNetwork = "network"
+	connectionErrorUnknown = "unknown"
+)
+
+// classifyConnectionError returns why connecting failed. TLS is checked first, as handshake failures are also
+// network errors.
+func classifyConnectionError(err error) string {
+	if isTLSError(err) {
+		return connectionErrorTLS
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) && (mysqlErr.Number == 1045 || mysqlErr.Number == 1044) {
+		return connectionErrorAuth
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && strings.HasPrefix(pgErr.Code, "28") {
+		// class 28 is "invalid authorization specification"
+		return connectionErrorAuth
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return connectionErrorNetwork
+	}
+	return connectionErrorUnknown
+}
+
+func isTLSError(err error) bool {
+	var verificationErr *tls.CertificateVerificationError
+	var recordHeaderErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var invalidErr x509.CertificateInvalidError
+	var hostnameErr x509.HostnameError
+	return errors.As(err, &verificationErr) ||
+		errors.As(err, &recordHeaderErr) ||
+		errors.As(err, &alertErr) ||
+		errors.As(err, &unknownAuthorityErr) ||
+		errors.As(err, &invalidErr) ||
+		errors.As(err, &hostnameErr) ||
+		// errors such as a protocol version mismatch are not typed
+		strings.Contains(err.Error(), "tls: ")
+}
+
+// tlsErrorCertificate returns the certificate the server presented, if the error has it
+func tlsErrorCertificate(err error) *x509.Certificate {
+	var verificationErr *tls.CertificateVerificationError
+	if errors.As(err, &verificationErr) && len(verificationErr.UnverifiedCertificates) > 0 {
+		return verificationErr.UnverifiedCertificates[0]
+	}
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	if errors.As(err, &unknownAuthorityErr) {
+		return unknownAuthorityErr.Cert
+	}
+	var invalidErr x509.CertificateInvalidError
+	if errors.As(err, &invalidErr) {
+		return invalidErr.Cert
+	}
+	var hostnameErr x509.HostnameError
+	if errors.As(err, &hostnameErr) {
+		return hostnameErr.Certificate
+	}
+	return nil
+}
+
+// recordConnectionError counts the failure to connect and, as TLS failures are otherwise hard to diagnose, logs the
+// (public) details of the certificate the server presented
+func recordConnectionError(t dbType, component Component, err error) {
+	reason := classifyConnectionError(err)
+	metrics.DBConnectionErrorsMetric.WithLabelValues(string(t), string(component), reason).Inc()
+	if reason != connectionErrorTLS {
+		return
+	}
+	logCtx := logger().WithField("backend", t).WithError(err)
+	if cert := tlsErrorCertificate(err); cert != nil {
+		logCtx = logCtx.WithFields(log.Fields{
+			"subject":   cert.Subject.String(),
+			"issuer":    cert.Issuer.String(),
+			"notBefore": cert.NotBefore,
+			"notAfter":  cert.NotAfter,
+		})
+	}
+	logCtx.Warn("TLS handshake with the database failed")
+}
@@ -0,0 +1,93 @@
+package sqldb
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	tlsutil "github.com/argoproj/argo-workflows/v3/util/tls"
+	"github.com/argoproj/argo-workflows/v3/workflow/metrics"
+)
+
+// fakeTLSPostgres accepts Postgres SSL requests and then presents the certificate, closing the connection after the
+// handshake
+func fakeTLSPostgres(t *testing.T, cert *tls.Certificate) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				// the SSLRequest message is 8 bytes, and the server answers 'S' to start the handshake
+				if _, err := io.ReadFull(conn, make([]byte, 8)); err != nil {
+					return
+				}
+				if _, err := conn.Write([]byte("S")); err != nil {
+					return
+				}
+				_ = tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{*cert}}).Handshake()
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func Test_recordConnectionError(t *testing.T) {
+	hook := test.NewGlobal()
+	defer log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+
+	// the certificate is self-signed, so is untrusted
+	cert, err := tlsutil.GenerateX509KeyPair()
+	require.NoError(t, err)
+	connConfig, err := pgx.ParseConfig(fmt.Sprintf("postgres://argo:password@%s/argo?sslmode=verify-full", fakeTLSPostgres(t, cert)))
+	require.NoError(t, err)
+	// the certificate is for localhost, so verification fails because it is untrusted rather than the name
+	connConfig.TLSConfig.ServerName = "localhost"
+	_, err = stdlib.GetConnector(*connConfig).Connect(context.Background())
+	require.Error(t, err)
+	assert.Equal(t, connectionErrorTLS, classifyConnectionError(err))
+
+	before := testutil.ToFloat64(metrics.DBConnectionErrorsMetric.WithLabelValues("postgres", "server", "tls"))
+	controllerBefore := testutil.ToFloat64(metrics.DBConnectionErrorsMetric.WithLabelValues("postgres", "controller", "tls"))
+	recordConnectionError(Postgres, ComponentServer, err)
+	assert.Equal(t, before+1, testutil.ToFloat64(metrics.DBConnectionErrorsMetric.WithLabelValues("postgres", "server", "tls")))
+	assert.Equal(t, controllerBefore, testutil.ToFloat64(metrics.DBConnectionErrorsMetric.WithLabelValues("postgres", "controller", "tls")), "the components are counted separately")
+
+	entry := hook.LastEntry()
+	require.NotNil(t, entry)
+	assert.Equal(t, log.WarnLevel, entry.Level)
+	assert.Equal(t, "TLS handshake with the database failed", entry.Message)
+	assert.Equal(t, "O=ArgoProj", entry.Data["subject"])
+	assert.Equal(t, "O=ArgoProj", entry.Data["issuer"])
+	assert.Contains(t, entry.Data, "notAfter")
+	assert.Contains(t, fmt.Sprint(entry.Data["error"]), "certificate signed by unknown authority")
+	for _, v := range entry.Data {
+		assert.NotContains(t, fmt.Sprint(v), "PRIVATE KEY")
+		assert.NotContains(t, fmt.Sprint(v), "password")
+	}
+}
+
+func Test_classifyConnectionError(t *testing.T) {
+	assert.Equal(t, connectionErrorAuth, classifyConnectionError(&mysql.MySQLError{Number: 1045, Message: "Access denied for user 'argo'"}))
+	assert.Equal(t, connectionErrorAuth, classifyConnectionError(fmt.Errorf("failed to connect: %w", &pgconn.PgError{Code: "28P01"})))
+	assert.Equal(t, connectionErrorNetwork, classifyConnectionError(&net.OpError{Op: "dial", Net: "tcp", Err: fmt.Errorf("connection refused")}))
+	assert.Equal(t, connectionErrorUnknown, classifyConnectionError(fmt.Errorf("tableName is empty")))
+}
@@ -0,0 +1,66 @@
+package sqldb
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v4"
+	log "github.com/sirupsen/logrus"
+)
+
+// redacted replaces a credential in the logged connection parameters, so that whether it is set is still shown
+const redacted = "[REDACTED]"
+
+func redact(credential string) string {
+	if credential == "" {
+		return ""
+	}
+	return redacted
+}
+
+// logConnectionParameters logs the parameters that the driver connects with at debug level, once per session, as
+// they are only known once the config, its options and the driver's defaults are merged
+func logConnectionParameters(parameters func() log.Fields) {
+	l := logger()
+	if !l.Logger.IsLevelEnabled(log.DebugLevel) {
+		return
+	}
+	l.WithFields(parameters()).Debug("Resolved database connection parameters")
+}
+
+func postgresConnectionParameters(connConfig *pgx.ConnConfig) log.Fields {
+	var fallbacks []string
+	for _, fallback := range connConfig.Fallbacks {
+		// e.g. sslmode=prefer falls back to the same host without TLS
+		fallbacks = append(fallbacks, fmt.Sprintf("%s tls=%t", net.JoinHostPort(fallback.Host, strconv.Itoa(int(fallback.Port))), fallback.TLSConfig != nil))
+	}
+	fields := log.Fields{
+		"backend":              Postgres,
+		"host":                 net.JoinHostPort(connConfig.Host, strconv.Itoa(int(connConfig.Port))),
+		"database":             connConfig.Database,
+		"user":                 connConfig.User,
+		"password":             redact(connConfig.Password),
+		"runtimeParams":        connConfig.RuntimeParams,
+		"fallbacks":            fallbacks,
+		"tls":                  connConfig.TLSConfig != nil,
+		"connectTimeout":       connConfig.ConnectTimeout.String(),
+		"preferSimpleProtocol": connConfig.PreferSimpleProtocol,
+	}
+	if connConfig.TLSConfig != nil {
+		fields["tlsServerName"] = connConfig.TLSConfig.ServerName
+		fields["tlsInsecureSkipVerify"] = connConfig.TLSConfig.InsecureSkipVerify
+	}
+	return fields
+}
+
+func mySQLConnectionParameters(mysqlConfig *mysql.Config) log.Fields {
+	c := mysqlConfig.Clone()
+	c.Passwd = redact(c.Passwd)
+	return log.Fields{
+		"backend": MySQL,
+		// the DSN has every parameter that differs from the driver's default
+		"dsn": c.FormatDSN(),
+	}
+}
@@ -0,0 +1,96 @@
+package sqldb
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+func TestLogConnectionParameters(t *testing.T) {
+	hook := test.NewGlobal()
+	defer log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+	defer ResetLogLevel()
+	// nothing is listening on the port once the listener is closed, so the session fails to open after the log
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	refusing := listener.Addr().(*net.TCPAddr)
+	require.NoError(t, listener.Close())
+	kube := fake.NewSimpleClientset(&apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "argo-db-config", Namespace: "argo"},
+		Data:       map[string][]byte{"username": []byte("argo"), "password": []byte("my-password")},
+	})
+	databaseConfig := config.DatabaseConfig{
+		Host:           refusing.IP.String(),
+		Port:           refusing.Port,
+		Database:       "argo",
+		TableName:      "argo_workflows",
+		UsernameSecret: apiv1.SecretKeySelector{LocalObjectReference: apiv1.LocalObjectReference{Name: "argo-db-config"}, Key: "username"},
+		PasswordSecret: apiv1.SecretKeySelector{LocalObjectReference: apiv1.LocalObjectReference{Name: "argo-db-config"}, Key: "password"},
+	}
+	parametersEntry := func(t *testing.T) *log.Entry {
+		t.Helper()
+		for _, entry := range hook.AllEntries() {
+			assert.NotContains(t, fmt.Sprint(entry.Data), "my-password")
+		}
+		for _, entry := range hook.AllEntries() {
+			if entry.Message == "Resolved database connection parameters" {
+				return entry
+			}
+		}
+		require.Fail(t, "the connection parameters were not logged")
+		return nil
+	}
+
+	t.Run("Postgres", func(t *testing.T) {
+		hook.Reset()
+		SetLogLevel(log.DebugLevel)
+		_, err := CreatePostGresDBSession(kube, "argo", &config.PostgreSQLConfig{DatabaseConfig: databaseConfig, PerPodAppName: true, StatementCacheCapacity: 10}, nil)
+		assert.Error(t, err)
+		entry := parametersEntry(t)
+		assert.Equal(t, log.DebugLevel, entry.Level)
+		assert.Equal(t, Postgres, entry.Data["backend"])
+		assert.Equal(t, refusing.String(), entry.Data["host"])
+		assert.Equal(t, "argo", entry.Data["database"])
+		assert.Equal(t, "argo", entry.Data["user"])
+		assert.Equal(t, redacted, entry.Data["password"])
+		// the options are merged into the runtime parameters
+		assert.Contains(t, entry.Data["runtimeParams"], "application_name")
+		// the default sslmode is prefer
+		assert.Equal(t, true, entry.Data["tls"])
+		assert.Equal(t, []string{refusing.String() + " tls=false"}, entry.Data["fallbacks"])
+	})
+	t.Run("MySQL", func(t *testing.T) {
+		hook.Reset()
+		SetLogLevel(log.DebugLevel)
+		_, err := CreateMySQLDBSession(kube, "argo", &config.MySQLConfig{DatabaseConfig: databaseConfig, Options: map[string]string{"readTimeout": "30s"}}, nil)
+		assert.Error(t, err)
+		entry := parametersEntry(t)
+		assert.Equal(t, MySQL, entry.Data["backend"])
+		dsn := entry.Data["dsn"].(string)
+		assert.Contains(t, dsn, "argo:"+redacted+"@tcp("+refusing.String()+")/argo")
+		assert.Contains(t, dsn, "readTimeout=30s")
+		assert.Contains(t, dsn, "parseTime=true")
+	})
+	t.Run("NotDebug", func(t *testing.T) {
+		hook.Reset()
+		SetLogLevel(log.InfoLevel)
+		_, err := CreatePostGresDBSession(kube, "argo", &config.PostgreSQLConfig{DatabaseConfig: databaseConfig}, nil)
+		assert.Error(t, err)
+		for _, entry := range hook.AllEntries() {
+			assert.NotEqual(t, "Resolved database connection parameters", entry.Message)
+		}
+	})
+	t.Run("NoPassword", func(t *testing.T) {
+		assert.Equal(t, "", redact(""))
+	})
+}
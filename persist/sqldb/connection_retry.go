@@ -0,0 +1,86 @@
+package sqldb
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgconn"
+	"github.com/upper/db/v4"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+const (
+	defaultRetryInitialBackoff = time.Second
+	defaultRetryMaxBackoff     = 30 * time.Second
+)
+
+// retryConnect calls connect until it succeeds, it fails with an error that is not transient, or the retry config's
+// retry count or elapsed time is exhausted, whichever is first, returning the last error. Without a retry config,
+// connect is only called once.
+func retryConnect(retry *config.ConnectionRetry, connect func() (db.Session, error)) (db.Session, error) {
+	session, err := connect()
+	if err == nil || retry == nil || (retry.MaxRetries <= 0 && retry.MaxElapsedTime <= 0) {
+		return session, err
+	}
+	backoff := time.Duration(retry.InitialBackoff)
+	if backoff <= 0 {
+		backoff = defaultRetryInitialBackoff
+	}
+	maxBackoff := time.Duration(retry.MaxBackoff)
+	if maxBackoff <= 0 {
+		maxBackoff = defaultRetryMaxBackoff
+	}
+	backoff = min(backoff, maxBackoff)
+	start := clock.Now()
+	for retries := 0; err != nil && isTransientConnectionError(err, retry.RetryableErrors); retries++ {
+		if retry.MaxRetries > 0 && retries >= retry.MaxRetries {
+			break
+		}
+		delay := backoff
+		if retry.MaxElapsedTime > 0 {
+			remaining := time.Duration(retry.MaxElapsedTime) - clock.Now().Sub(start)
+			if remaining <= 0 {
+				break
+			}
+			delay = min(delay, remaining)
+		}
+		logger().WithError(err).WithField("delay", delay).Warn("Failed to connect to the database, retrying")
+		clock.Sleep(delay)
+		// capped as it grows, so it cannot overflow
+		backoff = min(backoff*2, maxBackoff)
+		session, err = connect()
+	}
+	return session, err
+}
+
+// defaultRetryableErrors are the codes of the errors that are always retried
+var defaultRetryableErrors = []string{
+	// cannot_connect_now, the server is starting up or shutting down
+	"57P03",
+}
+
+// isTransientConnectionError returns whether connecting may succeed if retried, e.g. the database is still starting.
+// retryableErrors are the codes of more errors to retry, as well as the defaults.
+func isTransientConnectionError(err error, retryableErrors []string) bool {
+	if code := errorCode(err); code != "" && (slices.Contains(defaultRetryableErrors, code) || slices.Contains(retryableErrors, code)) {
+		return true
+	}
+	return classifyConnectionError(err) == connectionErrorNetwork
+}
+
+// errorCode returns the SQLSTATE code of a Postgres error, or the number of a MySQL error, or "" for any other error
+func errorCode(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return strconv.Itoa(int(mysqlErr.Number))
+	}
+	return ""
+}
@@ -0,0 +1,100 @@
+package sqldb
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/upper/db/v4"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+func Test_retryConnect(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	// connect returns a session once it has failed the given number of times
+	connect := func(failures int, err error) (func() (db.Session, error), *int) {
+		calls := 0
+		return func() (db.Session, error) {
+			calls++
+			if calls <= failures {
+				return nil, err
+			}
+			return newFakeSession(t, &fakeConnector{dbType: Postgres}), nil
+		}, &calls
+	}
+
+	t.Run("NoRetryConfig", func(t *testing.T) {
+		c := useFakeClock(t)
+		fn, calls := connect(1, refused)
+		_, err := retryConnect(nil, fn)
+		assert.Equal(t, refused, err)
+		assert.Equal(t, 1, *calls)
+		assert.Empty(t, c.Sleeps())
+	})
+	t.Run("Succeeds", func(t *testing.T) {
+		c := useFakeClock(t)
+		fn, calls := connect(2, refused)
+		session, err := retryConnect(&config.ConnectionRetry{MaxRetries: 5}, fn)
+		assert.NoError(t, err)
+		assert.NotNil(t, session)
+		assert.Equal(t, 3, *calls)
+		assert.Equal(t, []time.Duration{time.Second, 2 * time.Second}, c.Sleeps())
+	})
+	t.Run("MaxRetries", func(t *testing.T) {
+		c := useFakeClock(t)
+		fn, calls := connect(100, refused)
+		_, err := retryConnect(&config.ConnectionRetry{MaxRetries: 3, MaxElapsedTime: config.TTL(time.Hour)}, fn)
+		assert.Equal(t, refused, err)
+		assert.Equal(t, 4, *calls)
+		assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}, c.Sleeps())
+	})
+	t.Run("MaxElapsedTime", func(t *testing.T) {
+		c := useFakeClock(t)
+		fn, calls := connect(100, refused)
+		_, err := retryConnect(&config.ConnectionRetry{MaxRetries: 100, MaxElapsedTime: config.TTL(time.Minute), MaxBackoff: config.TTL(20 * time.Second)}, fn)
+		assert.Equal(t, refused, err)
+		// the last delay is cut short by the deadline
+		assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 16 * time.Second, 20 * time.Second, 9 * time.Second}, c.Sleeps())
+		assert.Equal(t, 8, *calls)
+	})
+	t.Run("ServerStarting", func(t *testing.T) {
+		c := useFakeClock(t)
+		fn, calls := connect(1, &pgconn.PgError{Code: "57P03", Message: "the database system is starting up"})
+		_, err := retryConnect(&config.ConnectionRetry{MaxRetries: 1}, fn)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, *calls)
+		assert.Equal(t, []time.Duration{time.Second}, c.Sleeps())
+	})
+	t.Run("NotTransient", func(t *testing.T) {
+		c := useFakeClock(t)
+		fn, calls := connect(1, &pgconn.PgError{Code: "28P01", Message: "password authentication failed"})
+		_, err := retryConnect(&config.ConnectionRetry{MaxRetries: 5}, fn)
+		assert.Error(t, err)
+		assert.Equal(t, 1, *calls)
+		assert.Empty(t, c.Sleeps())
+	})
+	t.Run("RetryableErrors", func(t *testing.T) {
+		for _, err := range []error{
+			&pgconn.PgError{Code: "53300", Message: "sorry, too many clients already"},
+			&mysql.MySQLError{Number: 1040, Message: "Too many connections"},
+		} {
+			useFakeClock(t)
+			fn, calls := connect(1, err)
+			_, got := retryConnect(&config.ConnectionRetry{MaxRetries: 5}, fn)
+			assert.Equal(t, err, got, "not retried by default")
+			assert.Equal(t, 1, *calls)
+
+			c := useFakeClock(t)
+			fn, calls = connect(1, err)
+			_, got = retryConnect(&config.ConnectionRetry{MaxRetries: 5, RetryableErrors: []string{"53300", "1040"}}, fn)
+			assert.NoError(t, got)
+			assert.Equal(t, 2, *calls)
+			assert.Equal(t, []time.Duration{time.Second}, c.Sleeps())
+		}
+	})
+}
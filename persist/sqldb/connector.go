@@ -0,0 +1,513 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/upper/db/v4"
+	mysqladp "github.com/upper/db/v4/adapter/mysql"
+	postgresqladp "github.com/upper/db/v4/adapter/postgresql"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+// sessionFeatures are the features of a session that wrap its connections, so that a session without them, e.g. in
+// lightweight mode, does not pay for the wrappers
+type sessionFeatures struct {
+	// quiesce allows the session's writes to be paused by Quiesce
+	quiesce bool
+	// lastSuccess reports when the session's queries last succeeded
+	lastSuccess bool
+	// fingerprints counts the fingerprints of the session's queries
+	fingerprints bool
+	// drain allows DrainPool to close the connections that are still in use at its deadline
+	drain bool
+}
+
+// sessionFeaturesFor returns the features of a session for the config, which are all disabled in lightweight mode
+func sessionFeaturesFor(cfg config.DatabaseConfig, lightweightMode bool) sessionFeatures {
+	if lightweightMode {
+		return sessionFeatures{}
+	}
+	return sessionFeatures{quiesce: true, lastSuccess: true, fingerprints: cfg.TopQueryFingerprints > 0, drain: true}
+}
+
+// openSession opens a session of the given type on top of the connector, wrapping its connections for the features
+func openSession(t dbType, c driver.Connector, features sessionFeatures) (db.Session, error) {
+	var fingerprints *fingerprintConnector
+	if features.fingerprints {
+		fingerprints = newFingerprintConnector(c)
+		c = fingerprints
+	}
+	var lastSuccess *lastSuccessConnector
+	if features.lastSuccess {
+		lastSuccess = newLastSuccessConnector(c)
+		c = lastSuccess
+	}
+	var drain *drainConnector
+	if features.drain {
+		drain = newDrainConnector(c)
+		c = drain
+	}
+	var sqlDB *sql.DB
+	if features.quiesce {
+		sqlDB = openQuiescable(c)
+	} else {
+		sqlDB = sql.OpenDB(c)
+	}
+	if lastSuccess != nil {
+		lastSuccess.register(sqlDB)
+	}
+	if fingerprints != nil {
+		fingerprints.register(sqlDB)
+	}
+	if drain != nil {
+		drain.register(sqlDB)
+	}
+	var session db.Session
+	var err error
+	if t == MySQL {
+		session, err = mysqladp.New(sqlDB)
+	} else {
+		session, err = postgresqladp.New(sqlDB)
+	}
+	if err != nil {
+		_ = sqlDB.Close()
+		return nil, err
+	}
+	return session, nil
+}
+
+// connInits returns the per-connection initialization for the config
+func connInits(t dbType, cfg config.DatabaseConfig) ([]connInitFunc, error) {
+	statements, err := connInitStatements(t, cfg)
+	if err != nil || len(statements) == 0 {
+		return nil, err
+	}
+	return []connInitFunc{execStatements(statements...)}, nil
+}
+
+// connInitStatements returns the statements that initialize each connection for the config
+func connInitStatements(t dbType, cfg config.DatabaseConfig) ([]string, error) {
+	var statements []string
+	if len(cfg.SessionVars) > 0 {
+		vars, err := sessionVarStatements(t, cfg.SessionVars)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, vars...)
+	}
+	if cfg.DefaultIsolationLevel != "" {
+		statement, err := isolationLevelStatement(t, cfg.DefaultIsolationLevel)
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, statement)
+	}
+	if cfg.StatementTimeout > 0 {
+		statements = append(statements, statementTimeoutStatement(t, time.Duration(cfg.StatementTimeout)))
+	}
+	if cfg.IdleInTransactionTimeout > 0 {
+		statements = append(statements, idleInTransactionTimeoutStatement(t, time.Duration(cfg.IdleInTransactionTimeout)))
+	}
+	if cfg.LockTimeout > 0 {
+		statements = append(statements, lockTimeoutStatement(t, time.Duration(cfg.LockTimeout)))
+	}
+	return statements, nil
+}
+
+// statementTimeoutStatement returns the statement that sets the statement timeout of the session, MySQL only
+// supports a timeout for SELECT statements
+func statementTimeoutStatement(t dbType, timeout time.Duration) string {
+	name := "statement_timeout"
+	if t == MySQL {
+		name = "max_execution_time"
+	}
+	return setStatement(t, name, strconv.FormatInt(timeout.Milliseconds(), 10))
+}
+
+// idleInTransactionTimeoutStatement returns the statement that sets how long the session may be idle in a
+// transaction before the server closes it, rolling the transaction back. MySQL has no equivalent, so its wait_timeout
+// is used, which closes the session once it has been idle for that long whether or not it is in a transaction, and
+// is in whole seconds.
+func idleInTransactionTimeoutStatement(t dbType, timeout time.Duration) string {
+	if t == MySQL {
+		seconds := int64(max((timeout+time.Second-1)/time.Second, 1))
+		return setStatement(t, "wait_timeout", strconv.FormatInt(seconds, 10))
+	}
+	return setStatement(t, "idle_in_transaction_session_timeout", strconv.FormatInt(timeout.Milliseconds(), 10))
+}
+
+// lockTimeoutStatement returns the statement that sets how long a statement in the session may wait for a lock.
+// MySQL's innodb_lock_wait_timeout is in whole seconds, and only applies to row locks, not e.g. metadata locks.
+func lockTimeoutStatement(t dbType, timeout time.Duration) string {
+	if t == MySQL {
+		seconds := int64(max((timeout+time.Second-1)/time.Second, 1))
+		return setStatement(t, "innodb_lock_wait_timeout", strconv.FormatInt(seconds, 10))
+	}
+	return setStatement(t, "lock_timeout", strconv.FormatInt(timeout.Milliseconds(), 10))
+}
+
+var isolationLevels = map[string]bool{
+	"read uncommitted": true,
+	"read committed":   true,
+	"repeatable read":  true,
+	"serializable":     true,
+}
+
+// isolationLevelStatement returns the statement that sets the default transaction isolation level of the session
+func isolationLevelStatement(t dbType, level string) (string, error) {
+	level = strings.ToLower(strings.Join(strings.Fields(level), " "))
+	if !isolationLevels[level] {
+		return "", fmt.Errorf("defaultIsolationLevel must be one of: read uncommitted, read committed, repeatable read, serializable")
+	}
+	if t == MySQL {
+		return "set session transaction isolation level " + level, nil
+	}
+	return "set default_transaction_isolation = '" + level + "'", nil
+}
+
+// warmupInits returns the per-connection initialization that runs the warm-up query, if there is one. It must be
+// after the rest of the initialization, so that the query runs with, e.g., the session variables set.
+func warmupInits(cfg config.DatabaseConfig) []connInitFunc {
+	if cfg.WarmupQuery == "" {
+		return nil
+	}
+	warmup := execStatements(cfg.WarmupQuery)
+	return []connInitFunc{func(ctx context.Context, conn driver.Conn) error {
+		err := warmup(ctx, conn)
+		if err != nil && cfg.TolerateWarmupFailure {
+			logger().WithField("query", cfg.WarmupQuery).WithError(err).Warn("Failed to warm up connection, continuing as tolerateWarmupFailure is set")
+			return nil
+		}
+		return err
+	}}
+}
+
+// connInitFunc initializes a new physical connection before the pool hands it out
+type connInitFunc func(ctx context.Context, conn driver.Conn) error
+
+// initConnector wraps a driver.Connector so that every new physical connection is initialized. The pool opens
+// connections lazily, so anything that is per-connection (e.g. session variables) must be applied here rather than
+// once when the session is created.
+type initConnector struct {
+	driver.Connector
+	init []connInitFunc
+}
+
+func newInitConnector(c driver.Connector, init ...connInitFunc) driver.Connector {
+	if len(init) == 0 {
+		return c
+	}
+	return &initConnector{Connector: c, init: init}
+}
+
+func (c *initConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, init := range c.init {
+		if err := init(ctx, conn); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// execStatements returns an init func that executes the statements in order
+func execStatements(statements ...string) connInitFunc {
+	return func(ctx context.Context, conn driver.Conn) error {
+		execer, ok := conn.(driver.ExecerContext)
+		if !ok {
+			return fmt.Errorf("driver connection %T cannot execute statements", conn)
+		}
+		for _, s := range statements {
+			if _, err := execer.ExecContext(ctx, s, nil); err != nil {
+				return fmt.Errorf("failed to initialize connection with %q: %w", s, err)
+			}
+		}
+		return nil
+	}
+}
+
+var (
+	sessionVarNameRegexp    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+	sessionVarNumericRegexp = regexp.MustCompile(`^-?[0-9]+(\.[0-9]+)?$`)
+)
+
+// sessionVarStatements returns the statements that set the session variables, sorted by name so they are
+// deterministic
+func sessionVarStatements(t dbType, vars map[string]string) ([]string, error) {
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		if !sessionVarNameRegexp.MatchString(name) {
+			return nil, fmt.Errorf("invalid session variable name %q", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	statements := make([]string, len(names))
+	for i, name := range names {
+		statements[i] = setStatement(t, name, vars[name])
+	}
+	return statements, nil
+}
+
+// setStatement returns the statement to set a session variable. Numbers are not quoted, as MySQL rejects strings
+// for numeric variables.
+func setStatement(t dbType, name, value string) string {
+	if !sessionVarNumericRegexp.MatchString(value) {
+		value = quoteLiteral(t, value)
+	}
+	if t == MySQL {
+		return fmt.Sprintf("set session %s = %s", name, value)
+	}
+	return fmt.Sprintf("set %s = %s", name, value)
+}
+
+// quoteLiteral quotes a string literal, MySQL treats backslashes as escapes by default, but Postgres does not
+func quoteLiteral(t dbType, value string) string {
+	if t == MySQL {
+		value = strings.ReplaceAll(value, `\`, `\\`)
+	}
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// wrappedConn delegates the optional driver interfaces to the wrapped connection, so that wrappers can embed it and
+// only override what they change
+type wrappedConn struct {
+	driver.Conn
+}
+
+func (c *wrappedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if execer, ok := c.Conn.(driver.ExecerContext); ok {
+		return execer.ExecContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *wrappedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if queryer, ok := c.Conn.(driver.QueryerContext); ok {
+		return queryer.QueryContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *wrappedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return preparer.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *wrappedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin() //nolint:staticcheck
+}
+
+func (c *wrappedConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *wrappedConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *wrappedConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+func (c *wrappedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+// queryHooks are called around each query that a hookedConn runs, whether directly or as a prepared statement, so that
+// a wrapper only implements what it does around a query rather than each way of running one. Any of them may be nil.
+type queryHooks struct {
+	// before is called before the query runs, and fails it if it returns an error. The func that it returns, if any,
+	// is called once the query is complete: when an exec returns, or when a query fails or its rows are closed.
+	before func(ctx context.Context, query string) (func(), error)
+	// after is called once the query returns, with its error, which is driver.ErrSkip if the driver cannot run the
+	// query without preparing it, in which case the query is then run as a prepared statement, calling the hooks again
+	after func(ctx context.Context, query string, err error)
+	// rows wraps the rows of a query that succeeded
+	rows func(ctx context.Context, rows driver.Rows) driver.Rows
+	// ping is called once a ping returns, with its error
+	ping func(ctx context.Context, err error)
+}
+
+func (h *queryHooks) exec(ctx context.Context, query string, exec func() (driver.Result, error)) (driver.Result, error) {
+	var done func()
+	if h.before != nil {
+		var err error
+		if done, err = h.before(ctx, query); err != nil {
+			return nil, err
+		}
+	}
+	res, err := exec()
+	if h.after != nil {
+		h.after(ctx, query, err)
+	}
+	if done != nil {
+		done()
+	}
+	return res, err
+}
+
+func (h *queryHooks) query(ctx context.Context, query string, run func() (driver.Rows, error)) (driver.Rows, error) {
+	var done func()
+	if h.before != nil {
+		var err error
+		if done, err = h.before(ctx, query); err != nil {
+			return nil, err
+		}
+	}
+	rows, err := run()
+	if h.after != nil {
+		h.after(ctx, query, err)
+	}
+	if err != nil {
+		if done != nil {
+			done()
+		}
+		return nil, err
+	}
+	if h.rows != nil {
+		rows = h.rows(ctx, rows)
+	}
+	if done != nil {
+		rows = &hookedRows{Rows: rows, done: done}
+	}
+	return rows, nil
+}
+
+// hookedConn calls its hooks around its queries, and those of its prepared statements
+type hookedConn struct {
+	wrappedConn
+	hooks *queryHooks
+}
+
+func newHookedConn(conn driver.Conn, hooks *queryHooks) *hookedConn {
+	return &hookedConn{wrappedConn: wrappedConn{Conn: conn}, hooks: hooks}
+}
+
+func (c *hookedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.hooks.exec(ctx, query, func() (driver.Result, error) {
+		return c.wrappedConn.ExecContext(ctx, query, args)
+	})
+}
+
+func (c *hookedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.hooks.query(ctx, query, func() (driver.Rows, error) {
+		return c.wrappedConn.QueryContext(ctx, query, args)
+	})
+}
+
+func (c *hookedConn) Ping(ctx context.Context) error {
+	err := c.wrappedConn.Ping(ctx)
+	if c.hooks.ping != nil {
+		c.hooks.ping(ctx, err)
+	}
+	return err
+}
+
+// PrepareContext wraps the statement, as prepared statements are cached, so the hooks are called each time that it
+// runs rather than when it is prepared
+func (c *hookedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	stmt, err := c.wrappedConn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return &hookedStmt{Stmt: stmt, conn: c, query: query}, nil
+}
+
+func (c *hookedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+type hookedStmt struct {
+	driver.Stmt
+	conn  *hookedConn
+	query string
+}
+
+func (s *hookedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.hooks.exec(ctx, s.query, func() (driver.Result, error) {
+		if execer, ok := s.Stmt.(driver.StmtExecContext); ok {
+			return execer.ExecContext(ctx, args)
+		}
+		values, err := namedValuesToValues(args)
+		if err != nil {
+			return nil, err
+		}
+		return s.Stmt.Exec(values) //nolint:staticcheck
+	})
+}
+
+func (s *hookedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.hooks.query(ctx, s.query, func() (driver.Rows, error) {
+		if queryer, ok := s.Stmt.(driver.StmtQueryContext); ok {
+			return queryer.QueryContext(ctx, args)
+		}
+		values, err := namedValuesToValues(args)
+		if err != nil {
+			return nil, err
+		}
+		return s.Stmt.Query(values) //nolint:staticcheck
+	})
+}
+
+func (s *hookedStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	// the connection's checker is only used if the statement does not have one
+	return s.conn.CheckNamedValue(nv)
+}
+
+// hookedRows calls done once the rows are closed
+type hookedRows struct {
+	driver.Rows
+	done func()
+}
+
+func (r *hookedRows) Close() error {
+	defer r.done()
+	return r.Rows.Close()
+}
+
+// namedValuesToValues converts the arguments for a statement that does not support contexts, which cannot have
+// named arguments
+func namedValuesToValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, fmt.Errorf("the driver does not support named arguments")
+		}
+		values[i] = arg.Value
+	}
+	return values, nil
+}
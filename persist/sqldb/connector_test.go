@@ -0,0 +1,274 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/upper/db/v4"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+func Test_sessionVarStatements(t *testing.T) {
+	vars := map[string]string{"lock_timeout": "5s", "idle_in_transaction_session_timeout": "10000", "application_name": `it's\`}
+	t.Run("Postgres", func(t *testing.T) {
+		statements, err := sessionVarStatements(Postgres, vars)
+		require.NoError(t, err)
+		assert.Equal(t, []string{
+			`set application_name = 'it''s\'`,
+			"set idle_in_transaction_session_timeout = 10000",
+			"set lock_timeout = '5s'",
+		}, statements)
+	})
+	t.Run("MySQL", func(t *testing.T) {
+		statements, err := sessionVarStatements(MySQL, map[string]string{"wait_timeout": "60", "sql_mode": `it's\`})
+		require.NoError(t, err)
+		assert.Equal(t, []string{`set session sql_mode = 'it''s\\'`, "set session wait_timeout = 60"}, statements)
+	})
+	t.Run("InvalidName", func(t *testing.T) {
+		_, err := sessionVarStatements(Postgres, map[string]string{"a = 1; drop table x; --": "1"})
+		assert.EqualError(t, err, `invalid session variable name "a = 1; drop table x; --"`)
+	})
+}
+
+func Test_isolationLevelStatement(t *testing.T) {
+	t.Run("Postgres", func(t *testing.T) {
+		statement, err := isolationLevelStatement(Postgres, "READ COMMITTED")
+		require.NoError(t, err)
+		assert.Equal(t, "set default_transaction_isolation = 'read committed'", statement)
+	})
+	t.Run("MySQL", func(t *testing.T) {
+		statement, err := isolationLevelStatement(MySQL, "repeatable  read")
+		require.NoError(t, err)
+		assert.Equal(t, "set session transaction isolation level repeatable read", statement)
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		for _, level := range []string{"snapshot", "read committed; drop table argo_workflows"} {
+			_, err := isolationLevelStatement(Postgres, level)
+			assert.EqualError(t, err, "defaultIsolationLevel must be one of: read uncommitted, read committed, repeatable read, serializable")
+		}
+	})
+	t.Run("ConnInit", func(t *testing.T) {
+		init, err := connInits(MySQL, config.DatabaseConfig{DefaultIsolationLevel: "serializable"})
+		require.NoError(t, err)
+		fake := &fakeConnector{dbType: MySQL}
+		sqlDB := sql.OpenDB(newInitConnector(fake, init...))
+		defer func() { _ = sqlDB.Close() }()
+		require.NoError(t, sqlDB.Ping())
+		assert.Equal(t, []string{"set session transaction isolation level serializable"}, fake.Statements())
+	})
+}
+
+func Test_statementTimeoutStatement(t *testing.T) {
+	assert.Equal(t, "set statement_timeout = 30000", statementTimeoutStatement(Postgres, 30*time.Second))
+	assert.Equal(t, "set session max_execution_time = 1500", statementTimeoutStatement(MySQL, 1500*time.Millisecond))
+	init, err := connInits(Postgres, config.DatabaseConfig{StatementTimeout: config.TTL(time.Second)})
+	require.NoError(t, err)
+	assert.Len(t, init, 1)
+}
+
+func Test_idleInTransactionTimeoutStatement(t *testing.T) {
+	assert.Equal(t, "set idle_in_transaction_session_timeout = 300000", idleInTransactionTimeoutStatement(Postgres, 5*time.Minute))
+	assert.Equal(t, "set session wait_timeout = 300", idleInTransactionTimeoutStatement(MySQL, 5*time.Minute))
+	assert.Equal(t, "set session wait_timeout = 2", idleInTransactionTimeoutStatement(MySQL, 1500*time.Millisecond), "rounded up to whole seconds")
+	assert.Equal(t, "set session wait_timeout = 1", idleInTransactionTimeoutStatement(MySQL, time.Millisecond))
+	for _, tt := range []struct {
+		dbType    dbType
+		statement string
+	}{
+		{Postgres, "set idle_in_transaction_session_timeout = 60000"},
+		{MySQL, "set session wait_timeout = 60"},
+	} {
+		t.Run(string(tt.dbType), func(t *testing.T) {
+			init, err := connInits(tt.dbType, config.DatabaseConfig{IdleInTransactionTimeout: config.TTL(time.Minute)})
+			require.NoError(t, err)
+			fake := &fakeConnector{}
+			sqlDB := sql.OpenDB(newInitConnector(fake, init...))
+			defer func() { _ = sqlDB.Close() }()
+			require.NoError(t, sqlDB.Ping())
+			assert.Equal(t, []string{tt.statement}, fake.Statements())
+		})
+	}
+	t.Run("ServerDefault", func(t *testing.T) {
+		init, err := connInits(Postgres, config.DatabaseConfig{})
+		require.NoError(t, err)
+		assert.Empty(t, init)
+	})
+}
+
+func Test_lockTimeoutStatement(t *testing.T) {
+	assert.Equal(t, "set lock_timeout = 10000", lockTimeoutStatement(Postgres, 10*time.Second))
+	assert.Equal(t, "set session innodb_lock_wait_timeout = 10", lockTimeoutStatement(MySQL, 10*time.Second))
+	assert.Equal(t, "set session innodb_lock_wait_timeout = 2", lockTimeoutStatement(MySQL, 1500*time.Millisecond), "rounded up to whole seconds")
+	for _, tt := range []struct {
+		dbType    dbType
+		statement string
+	}{
+		{Postgres, "set lock_timeout = 5000"},
+		{MySQL, "set session innodb_lock_wait_timeout = 5"},
+	} {
+		t.Run(string(tt.dbType), func(t *testing.T) {
+			init, err := connInits(tt.dbType, config.DatabaseConfig{LockTimeout: config.TTL(5 * time.Second)})
+			require.NoError(t, err)
+			fake := &fakeConnector{}
+			sqlDB := sql.OpenDB(newInitConnector(fake, init...))
+			defer func() { _ = sqlDB.Close() }()
+			require.NoError(t, sqlDB.Ping())
+			assert.Equal(t, []string{tt.statement}, fake.Statements())
+		})
+	}
+	t.Run("ServerDefault", func(t *testing.T) {
+		init, err := connInits(MySQL, config.DatabaseConfig{})
+		require.NoError(t, err)
+		assert.Empty(t, init)
+	})
+}
+
+func Test_openSession(t *testing.T) {
+	registered := func(session db.Session) []string {
+		sqlDB := session.Driver().(*sql.DB)
+		var features []string
+		for name, registry := range map[string]*sync.Map{"quiesce": &quiesceGates, "lastSuccess": &lastSuccessTrackers, "fingerprints": &fingerprintConnectors, "drain": &drainConnectors} {
+			if _, ok := registry.Load(sqlDB); ok {
+				features = append(features, name)
+			}
+		}
+		return features
+	}
+	t.Run("AllFeatures", func(t *testing.T) {
+		session := newFakeSession(t, &fakeConnector{dbType: Postgres})
+		assert.ElementsMatch(t, []string{"quiesce", "lastSuccess", "fingerprints", "drain"}, registered(session))
+	})
+	t.Run("LightweightMode", func(t *testing.T) {
+		session, err := openSession(Postgres, &fakeConnector{dbType: Postgres}, sessionFeaturesFor(config.DatabaseConfig{TopQueryFingerprints: 10}, true))
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = session.Close() })
+		assert.Empty(t, registered(session))
+		assert.EqualError(t, Quiesce(session), "cannot quiesce *sql.DB, it was not opened by this package or is in lightweight mode")
+	})
+}
+
+func Test_sessionFeaturesFor(t *testing.T) {
+	assert.Equal(t, sessionFeatures{quiesce: true, lastSuccess: true, drain: true}, sessionFeaturesFor(config.DatabaseConfig{}, false))
+	assert.Equal(t, sessionFeatures{quiesce: true, lastSuccess: true, fingerprints: true, drain: true}, sessionFeaturesFor(config.DatabaseConfig{TopQueryFingerprints: 10}, false))
+	assert.Equal(t, sessionFeatures{}, sessionFeaturesFor(config.DatabaseConfig{TopQueryFingerprints: 10}, true))
+}
+
+func Test_hookedConn(t *testing.T) {
+	ctx := context.Background()
+	var events []string
+	conn, err := (&fakeConnector{}).Connect(ctx)
+	require.NoError(t, err)
+	hooked := newHookedConn(conn, &queryHooks{
+		before: func(_ context.Context, query string) (func(), error) {
+			if query == "select blocked" {
+				return nil, fmt.Errorf("blocked")
+			}
+			events = append(events, "before "+query)
+			return func() { events = append(events, "done "+query) }, nil
+		},
+		after: func(_ context.Context, query string, err error) {
+			events = append(events, fmt.Sprintf("after %s: %v", query, err))
+		},
+	})
+	t.Run("Exec", func(t *testing.T) {
+		events = nil
+		_, err := hooked.ExecContext(ctx, "delete", nil)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"before delete", "after delete: <nil>", "done delete"}, events)
+	})
+	t.Run("Query", func(t *testing.T) {
+		events = nil
+		rows, err := hooked.QueryContext(ctx, "select 1", nil)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"before select 1", "after select 1: <nil>"}, events)
+		require.NoError(t, rows.Close())
+		assert.Equal(t, []string{"before select 1", "after select 1: <nil>", "done select 1"}, events, "the query is done once its rows are closed")
+	})
+	t.Run("PreparedStatement", func(t *testing.T) {
+		events = nil
+		stmt, err := hooked.PrepareContext(ctx, "update")
+		require.NoError(t, err)
+		assert.Empty(t, events, "the hooks are called when the statement runs")
+		_, err = stmt.(driver.StmtExecContext).ExecContext(ctx, nil)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"before update", "after update: <nil>", "done update"}, events)
+	})
+	t.Run("BeforeFails", func(t *testing.T) {
+		events = nil
+		_, err := hooked.ExecContext(ctx, "select blocked", nil)
+		assert.EqualError(t, err, "blocked")
+		assert.Empty(t, events, "the query does not run")
+	})
+}
+
+func Test_initConnector(t *testing.T) {
+	fake := &fakeConnector{}
+	sqlDB := sql.OpenDB(newInitConnector(fake, execStatements("set lock_timeout = '5s'")))
+	defer func() { _ = sqlDB.Close() }()
+	ctx := context.Background()
+	// hold the connections open, so that the pool has to open new ones
+	for i := 0; i < 3; i++ {
+		conn, err := sqlDB.Conn(ctx)
+		require.NoError(t, err)
+		defer func() { _ = conn.Close() }()
+	}
+	conns := fake.Conns()
+	assert.Len(t, conns, 3)
+	for _, conn := range conns {
+		assert.Equal(t, []string{"set lock_timeout = '5s'"}, conn.Statements())
+	}
+}
+
+func Test_warmupInits(t *testing.T) {
+	assert.Nil(t, warmupInits(config.DatabaseConfig{}))
+	const warmup = "select count(*) from argo_archived_workflows where startedat > now() - interval '1 day'"
+	newDB := func(t *testing.T, cfg config.DatabaseConfig, fail bool) (*sql.DB, *fakeConnector) {
+		fake := &fakeConnector{handler: func(query string, _ []driver.NamedValue) (*fakeResult, error) {
+			if fail && query == warmup {
+				return nil, fmt.Errorf(`relation "argo_archived_workflows" does not exist`)
+			}
+			return &fakeResult{}, nil
+		}}
+		init := append([]connInitFunc{execStatements("set lock_timeout = '5s'")}, warmupInits(cfg)...)
+		sqlDB := sql.OpenDB(newInitConnector(fake, init...))
+		t.Cleanup(func() { _ = sqlDB.Close() })
+		return sqlDB, fake
+	}
+	ctx := context.Background()
+
+	t.Run("EveryNewConnection", func(t *testing.T) {
+		sqlDB, fake := newDB(t, config.DatabaseConfig{WarmupQuery: warmup}, false)
+		// hold the connections open, so that the pool has to open new ones
+		for i := 0; i < 3; i++ {
+			conn, err := sqlDB.Conn(ctx)
+			require.NoError(t, err)
+			defer func() { _ = conn.Close() }()
+		}
+		conns := fake.Conns()
+		assert.Len(t, conns, 3)
+		for _, conn := range conns {
+			assert.Equal(t, []string{"set lock_timeout = '5s'", warmup}, conn.Statements(), "the warm-up runs after the rest of the initialization")
+		}
+	})
+	t.Run("Failed", func(t *testing.T) {
+		sqlDB, _ := newDB(t, config.DatabaseConfig{WarmupQuery: warmup}, true)
+		_, err := sqlDB.Conn(ctx)
+		assert.ErrorContains(t, err, `relation "argo_archived_workflows" does not exist`)
+	})
+	t.Run("TolerateFailure", func(t *testing.T) {
+		sqlDB, fake := newDB(t, config.DatabaseConfig{WarmupQuery: warmup, TolerateWarmupFailure: true}, true)
+		conn, err := sqlDB.Conn(ctx)
+		require.NoError(t, err)
+		defer func() { _ = conn.Close() }()
+		require.NoError(t, conn.PingContext(ctx))
+		assert.Len(t, fake.Conns(), 1)
+	})
+}
@@ -0,0 +1,212 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+	"github.com/argoproj/argo-workflows/v3/workflow/metrics"
+)
+
+// Credentials are the username and password used to open a physical connection
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// CredentialProvider provides credentials that are only valid for a short time, such as IAM auth tokens.
+// BeforeConnect is called before each new physical connection is opened, with the credentials from the secrets,
+// and may change them.
+type CredentialProvider interface {
+	BeforeConnect(ctx context.Context, creds *Credentials) error
+}
+
+// newCredentialProvider returns the provider for the config, which records its refreshes, or nil if the credentials
+// from the secrets are used as they are
+func newCredentialProvider(ctx context.Context, cfg config.DatabaseConfig) (CredentialProvider, error) {
+	if cfg.ExecCredential != nil {
+		if cfg.IAMAuth != "" {
+			return nil, fmt.Errorf("only one of iamAuth and execCredential may be set")
+		}
+		provider, err := newExecCredentialProvider(cfg.ExecCredential)
+		return withRefreshMetrics(provider, authModeExec), err
+	}
+	var provider CredentialProvider
+	var err error
+	switch cfg.IAMAuth {
+	case "":
+		return nil, nil
+	case "aws":
+		provider, err = newAWSCredentialProvider(ctx, cfg.GetHostname())
+	case "gcp":
+		provider, err = newGCPCredentialProvider(ctx)
+	case "azure":
+		provider, err = newAzureCredentialProvider()
+	default:
+		return nil, fmt.Errorf("iamAuth must be one of: aws, gcp, azure")
+	}
+	return withRefreshMetrics(provider, cfg.IAMAuth), err
+}
+
+// authModeExec is the auth_mode label of the refreshes of an exec credential, the other modes are the iamAuth
+const authModeExec = "exec"
+
+// refreshMetricsProvider counts the provider's refreshes, i.e. the credentials of each new connection, and their
+// failures, by auth mode, as a broken auth integration otherwise only shows as failures to connect
+type refreshMetricsProvider struct {
+	CredentialProvider
+	authMode string
+}
+
+func withRefreshMetrics(provider CredentialProvider, authMode string) CredentialProvider {
+	if provider == nil {
+		return nil
+	}
+	return &refreshMetricsProvider{CredentialProvider: provider, authMode: authMode}
+}
+
+func (p *refreshMetricsProvider) BeforeConnect(ctx context.Context, creds *Credentials) error {
+	metrics.DBCredentialRefreshesMetric.WithLabelValues(p.authMode).Inc()
+	err := p.CredentialProvider.BeforeConnect(ctx, creds)
+	if err != nil {
+		metrics.DBCredentialRefreshFailuresMetric.WithLabelValues(p.authMode).Inc()
+	}
+	return err
+}
+
+// credentialConnector opens each physical connection with fresh credentials, as a connector is otherwise
+// configured once, with credentials that expire
+type credentialConnector struct {
+	driver.Connector
+	provider     CredentialProvider
+	creds        Credentials
+	newConnector func(creds Credentials) (driver.Connector, error)
+}
+
+// newCredentialConnector returns a connector that calls the provider before every connection. The connector is only
+// used for its Driver, newConnector builds the connector for each connection.
+func newCredentialConnector(c driver.Connector, provider CredentialProvider, creds Credentials, newConnector func(creds Credentials) (driver.Connector, error)) driver.Connector {
+	if provider == nil {
+		return c
+	}
+	return &credentialConnector{Connector: c, provider: provider, creds: creds, newConnector: newConnector}
+}
+
+func (c *credentialConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	creds := c.creds
+	if err := c.provider.BeforeConnect(ctx, &creds); err != nil {
+		return nil, fmt.Errorf("failed to get database credentials: %w", err)
+	}
+	connector, err := c.newConnector(creds)
+	if err != nil {
+		return nil, err
+	}
+	return connector.Connect(ctx)
+}
+
+// awsCredentialProvider generates RDS IAM auth tokens, which are valid for 15 minutes
+type awsCredentialProvider struct {
+	endpoint string
+	region   string
+	creds    aws.CredentialsProvider
+}
+
+func newAWSCredentialProvider(ctx context.Context, endpoint string) (CredentialProvider, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	if cfg.Region == "" {
+		return nil, fmt.Errorf("the AWS region is not configured, set AWS_REGION")
+	}
+	return &awsCredentialProvider{endpoint: endpoint, region: cfg.Region, creds: cfg.Credentials}, nil
+}
+
+func (p *awsCredentialProvider) BeforeConnect(ctx context.Context, creds *Credentials) error {
+	awsCreds, err := p.creds.Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+	token, err := rdsAuthToken(ctx, p.endpoint, p.region, creds.Username, awsCreds, clock.Now())
+	if err != nil {
+		return err
+	}
+	creds.Password = token
+	return nil
+}
+
+// emptyPayloadHash is the SHA-256 of an empty payload, as the token is a presigned GET
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// rdsAuthToken builds the token the same way as the feature/rds/auth module, a presigned "connect" request
+func rdsAuthToken(ctx context.Context, endpoint, region, username string, creds aws.Credentials, now time.Time) (string, error) {
+	query := url.Values{"Action": {"connect"}, "DBUser": {username}, "X-Amz-Expires": {"900"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://"+endpoint+"/?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	signed, _, err := v4.NewSigner().PresignHTTP(ctx, creds, req, emptyPayloadHash, "rds-db", region, now)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign RDS auth token: %w", err)
+	}
+	return strings.TrimPrefix(signed, "https://"), nil
+}
+
+// gcpCredentialProvider uses an OAuth2 access token for Cloud SQL IAM database authentication
+type gcpCredentialProvider struct {
+	tokens oauth2.TokenSource
+}
+
+func newGCPCredentialProvider(ctx context.Context) (CredentialProvider, error) {
+	tokens, err := google.DefaultTokenSource(ctx, "https://www.googleapis.com/auth/sqlservice.login")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get GCP credentials: %w", err)
+	}
+	return &gcpCredentialProvider{tokens: tokens}, nil
+}
+
+func (p *gcpCredentialProvider) BeforeConnect(_ context.Context, creds *Credentials) error {
+	// the token source caches the token until it expires
+	token, err := p.tokens.Token()
+	if err != nil {
+		return err
+	}
+	creds.Password = token.AccessToken
+	return nil
+}
+
+// azureCredentialProvider uses a Microsoft Entra ID access token for Azure Database for PostgreSQL/MySQL
+type azureCredentialProvider struct {
+	creds azcore.TokenCredential
+}
+
+func newAzureCredentialProvider() (CredentialProvider, error) {
+	creds, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Azure credentials: %w", err)
+	}
+	return &azureCredentialProvider{creds: creds}, nil
+}
+
+func (p *azureCredentialProvider) BeforeConnect(ctx context.Context, creds *Credentials) error {
+	token, err := p.creds.GetToken(ctx, policy.TokenRequestOptions{Scopes: []string{"https://ossrdbms-aad.database.windows.net/.default"}})
+	if err != nil {
+		return err
+	}
+	creds.Password = token.Token
+	return nil
+}
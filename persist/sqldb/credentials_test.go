@@ -0,0 +1,116 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+	"github.com/argoproj/argo-workflows/v3/workflow/metrics"
+)
+
+type fakeCredentialProvider struct {
+	mu    sync.Mutex
+	calls int
+	err   error
+}
+
+func (p *fakeCredentialProvider) BeforeConnect(_ context.Context, creds *Credentials) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	if p.err != nil {
+		return p.err
+	}
+	creds.Password = fmt.Sprintf("token-%d", p.calls)
+	return nil
+}
+
+func Test_credentialConnector(t *testing.T) {
+	provider := &fakeCredentialProvider{}
+	fake := &fakeConnector{}
+	var used []Credentials
+	connector := newCredentialConnector(fake, provider, Credentials{Username: "argo"}, func(creds Credentials) (driver.Connector, error) {
+		used = append(used, creds)
+		return fake, nil
+	})
+	sqlDB := sql.OpenDB(connector)
+	defer func() { _ = sqlDB.Close() }()
+	ctx := context.Background()
+	// hold the connections open, so that the pool has to open new ones
+	for i := 0; i < 3; i++ {
+		conn, err := sqlDB.Conn(ctx)
+		require.NoError(t, err)
+		defer func() { _ = conn.Close() }()
+	}
+	assert.Equal(t, 3, provider.calls)
+	assert.Equal(t, []Credentials{{"argo", "token-1"}, {"argo", "token-2"}, {"argo", "token-3"}}, used)
+	assert.Len(t, fake.Conns(), 3)
+	t.Run("NoProvider", func(t *testing.T) {
+		assert.Same(t, fake, newCredentialConnector(fake, nil, Credentials{}, nil))
+	})
+}
+
+func Test_newCredentialProvider(t *testing.T) {
+	provider, err := newCredentialProvider(context.Background(), config.DatabaseConfig{})
+	require.NoError(t, err)
+	assert.Nil(t, provider)
+	_, err = newCredentialProvider(context.Background(), config.DatabaseConfig{IAMAuth: "oracle"})
+	assert.EqualError(t, err, "iamAuth must be one of: aws, gcp, azure")
+}
+
+func Test_withRefreshMetrics(t *testing.T) {
+	ctx := context.Background()
+	refreshes := metrics.DBCredentialRefreshesMetric.WithLabelValues("aws")
+	failures := metrics.DBCredentialRefreshFailuresMetric.WithLabelValues("aws")
+	fake := &fakeCredentialProvider{}
+	provider := withRefreshMetrics(fake, "aws")
+	refreshesBefore, failuresBefore := testutil.ToFloat64(refreshes), testutil.ToFloat64(failures)
+
+	t.Run("Refreshed", func(t *testing.T) {
+		creds := Credentials{Username: "argo"}
+		require.NoError(t, provider.BeforeConnect(ctx, &creds))
+		require.NoError(t, provider.BeforeConnect(ctx, &creds))
+		assert.Equal(t, "token-2", creds.Password)
+		assert.Equal(t, refreshesBefore+2, testutil.ToFloat64(refreshes))
+		assert.Equal(t, failuresBefore, testutil.ToFloat64(failures))
+	})
+	t.Run("Failed", func(t *testing.T) {
+		fake.err = errors.New("token expired")
+		assert.EqualError(t, provider.BeforeConnect(ctx, &Credentials{}), "token expired")
+		assert.Equal(t, refreshesBefore+3, testutil.ToFloat64(refreshes))
+		assert.Equal(t, failuresBefore+1, testutil.ToFloat64(failures))
+	})
+	t.Run("ByAuthMode", func(t *testing.T) {
+		exec := metrics.DBCredentialRefreshesMetric.WithLabelValues(authModeExec)
+		before := testutil.ToFloat64(exec)
+		require.NoError(t, withRefreshMetrics(&fakeCredentialProvider{}, authModeExec).BeforeConnect(ctx, &Credentials{}))
+		assert.Equal(t, before+1, testutil.ToFloat64(exec))
+		assert.Equal(t, refreshesBefore+3, testutil.ToFloat64(refreshes))
+	})
+	t.Run("NoProvider", func(t *testing.T) {
+		assert.Nil(t, withRefreshMetrics(nil, "aws"))
+	})
+}
+
+func Test_rdsAuthToken(t *testing.T) {
+	creds := aws.Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"}
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	token, err := rdsAuthToken(context.Background(), "my-db.cluster-abc.us-east-1.rds.amazonaws.com:5432", "us-east-1", "argo", creds, now)
+	require.NoError(t, err)
+	assert.Regexp(t, `^my-db\.cluster-abc\.us-east-1\.rds\.amazonaws\.com:5432/\?Action=connect&DBUser=argo&`, token)
+	assert.Contains(t, token, "X-Amz-Credential=AKIDEXAMPLE%2F20240102%2Fus-east-1%2Frds-db%2Faws4_request")
+	assert.Contains(t, token, "X-Amz-Expires=900")
+	assert.Contains(t, token, "X-Amz-Signature=")
+	assert.NotContains(t, token, "secret")
+}
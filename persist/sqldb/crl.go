@@ -0,0 +1,131 @@
+package sqldb
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v4"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+// peerCertificateVerifier is a tls.Config VerifyPeerCertificate callback
+type peerCertificateVerifier func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error
+
+// revocationLists returns the configured certificate revocation lists, which may be PEM (with any number of lists)
+// or a single DER list, or nil if there are none
+func revocationLists(ctx context.Context, kubectlConfig kubernetes.Interface, namespace string, cfg config.DatabaseConfig) ([]*x509.RevocationList, error) {
+	var data []byte
+	var err error
+	switch {
+	case cfg.CRLSecret != nil && cfg.CRLFile != "":
+		return nil, fmt.Errorf("only one of crlSecret and crlFile may be set")
+	case cfg.CRLSecret != nil:
+		data, err = getSecret(ctx, kubectlConfig, namespace, *cfg.CRLSecret, cfg.SecretFetchRetries)
+	case cfg.CRLFile != "":
+		data, err = os.ReadFile(cfg.CRLFile)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the CRL: %w", err)
+	}
+	var crls []*x509.RevocationList
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "X509 CRL" {
+			continue
+		}
+		crl, err := x509.ParseRevocationList(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse the CRL: %w", err)
+		}
+		crls = append(crls, crl)
+	}
+	if len(crls) == 0 {
+		crl, err := x509.ParseRevocationList(data)
+		if err != nil {
+			return nil, fmt.Errorf("the CRL is neither PEM nor DER: %w", err)
+		}
+		crls = append(crls, crl)
+	}
+	return crls, nil
+}
+
+// verifyNotRevoked returns a callback that rejects the server's certificates if any of them, e.g. an intermediate, is
+// revoked by one of the lists. The lists' signatures are not checked, as, like the CA bundle, they are trusted
+// because they are configured.
+func verifyNotRevoked(crls []*x509.RevocationList) peerCertificateVerifier {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		for _, rawCert := range rawCerts {
+			cert, err := x509.ParseCertificate(rawCert)
+			if err != nil {
+				return err
+			}
+			for _, crl := range crls {
+				if !bytes.Equal(crl.RawIssuer, cert.RawIssuer) {
+					continue
+				}
+				for _, entry := range crl.RevokedCertificateEntries {
+					if entry.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+						return fmt.Errorf("the database server's certificate %q (serial %s) was revoked at %s", cert.Subject, cert.SerialNumber, entry.RevocationTime)
+					}
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// chainVerifier runs verify after the config's own callback, if it has one, e.g. the one that pgx uses to verify
+// the chain for sslmode=verify-ca
+func chainVerifier(tlsConfig *tls.Config, verify peerCertificateVerifier) {
+	if previous := tlsConfig.VerifyPeerCertificate; previous != nil {
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+			if err := previous(rawCerts, verifiedChains); err != nil {
+				return err
+			}
+			return verify(rawCerts, verifiedChains)
+		}
+		return
+	}
+	tlsConfig.VerifyPeerCertificate = verify
+}
+
+// withCRL rejects a server certificate that is revoked by the lists, on every connection that uses TLS. Whether TLS
+// is used still depends on the sslMode.
+func withCRL(crls []*x509.RevocationList) PostgresOption {
+	return func(connConfig *pgx.ConnConfig) {
+		if connConfig.TLSConfig != nil {
+			chainVerifier(connConfig.TLSConfig, verifyNotRevoked(crls))
+		}
+		for _, fallback := range connConfig.Fallbacks {
+			if fallback.TLSConfig != nil {
+				chainVerifier(fallback.TLSConfig, verifyNotRevoked(crls))
+			}
+		}
+	}
+}
+
+// withMySQLCRL rejects a server certificate that is revoked by the lists, enabling TLS if the options do not
+func withMySQLCRL(crls []*x509.RevocationList) mySQLOption {
+	return func(mysqlConfig *mysql.Config) {
+		if mysqlConfig.TLS == nil {
+			host, _, _ := net.SplitHostPort(mysqlConfig.Addr)
+			mysqlConfig.TLS = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+		}
+		chainVerifier(mysqlConfig.TLS, verifyNotRevoked(crls))
+	}
+}
@@ -0,0 +1,184 @@
+package sqldb
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+// testPKI is a CA, a valid and a revoked server certificate for 127.0.0.1, and a CRL that revokes the latter
+type testPKI struct {
+	pool           *x509.CertPool
+	valid, revoked *tls.Certificate
+	crlDER         []byte
+}
+
+func newTestPKI(t *testing.T) *testPKI {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "argo-test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, caKey.Public(), caKey)
+	require.NoError(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+	serverCert := func(serial int64) *tls.Certificate {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "db"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}, ca, key.Public(), caKey)
+		require.NoError(t, err)
+		return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	}
+	crlDER, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(1),
+		ThisUpdate:                time.Now().Add(-time.Hour),
+		NextUpdate:                time.Now().Add(time.Hour),
+		RevokedCertificateEntries: []x509.RevocationListEntry{{SerialNumber: big.NewInt(3), RevocationTime: time.Now().Add(-time.Minute)}},
+	}, ca, caKey)
+	require.NoError(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return &testPKI{pool: pool, valid: serverCert(2), revoked: serverCert(3), crlDER: crlDER}
+}
+
+func Test_revocationLists(t *testing.T) {
+	pki := newTestPKI(t)
+	crlPEM := pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: pki.crlDER})
+	kube := fake.NewSimpleClientset(&apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "argo-db-crl", Namespace: "argo"},
+		Data:       map[string][]byte{"ca.crl": crlPEM, "bad.crl": []byte("not a CRL")},
+	})
+	secret := func(key string) *apiv1.SecretKeySelector {
+		return &apiv1.SecretKeySelector{LocalObjectReference: apiv1.LocalObjectReference{Name: "argo-db-crl"}, Key: key}
+	}
+	derFile := filepath.Join(t.TempDir(), "ca.crl")
+	require.NoError(t, os.WriteFile(derFile, pki.crlDER, 0o600))
+	ctx := context.Background()
+
+	t.Run("None", func(t *testing.T) {
+		crls, err := revocationLists(ctx, kube, "argo", config.DatabaseConfig{})
+		require.NoError(t, err)
+		assert.Nil(t, crls)
+	})
+	t.Run("PEMSecret", func(t *testing.T) {
+		crls, err := revocationLists(ctx, kube, "argo", config.DatabaseConfig{CRLSecret: secret("ca.crl")})
+		require.NoError(t, err)
+		require.Len(t, crls, 1)
+		assert.Len(t, crls[0].RevokedCertificateEntries, 1)
+	})
+	t.Run("DERFile", func(t *testing.T) {
+		crls, err := revocationLists(ctx, kube, "argo", config.DatabaseConfig{CRLFile: derFile})
+		require.NoError(t, err)
+		assert.Len(t, crls, 1)
+	})
+	t.Run("OnlyOneSource", func(t *testing.T) {
+		_, err := revocationLists(ctx, kube, "argo", config.DatabaseConfig{CRLSecret: secret("ca.crl"), CRLFile: derFile})
+		assert.EqualError(t, err, "only one of crlSecret and crlFile may be set")
+	})
+	t.Run("NotCRL", func(t *testing.T) {
+		_, err := revocationLists(ctx, kube, "argo", config.DatabaseConfig{CRLSecret: secret("bad.crl")})
+		assert.ErrorContains(t, err, "the CRL is neither PEM nor DER")
+	})
+	t.Run("MissingFile", func(t *testing.T) {
+		_, err := revocationLists(ctx, kube, "argo", config.DatabaseConfig{CRLFile: filepath.Join(t.TempDir(), "missing.crl")})
+		assert.ErrorContains(t, err, "failed to get the CRL")
+	})
+}
+
+func Test_withCRL(t *testing.T) {
+	pki := newTestPKI(t)
+	crl, err := x509.ParseRevocationList(pki.crlDER)
+	require.NoError(t, err)
+	crls := []*x509.RevocationList{crl}
+
+	t.Run("Postgres", func(t *testing.T) {
+		connect := func(cert *tls.Certificate) error {
+			// verify-ca verifies the chain in pgx's own callback, which the CRL check runs after
+			connConfig, err := pgx.ParseConfig(fmt.Sprintf("postgres://argo:password@%s/argo?sslmode=verify-ca", fakeTLSPostgres(t, cert)))
+			require.NoError(t, err)
+			WithRootCAs(pki.pool)(connConfig)
+			withCRL(crls)(connConfig)
+			_, err = stdlib.GetConnector(*connConfig).Connect(context.Background())
+			return err
+		}
+		err := connect(pki.revoked)
+		assert.ErrorContains(t, err, `the database server's certificate "CN=db" (serial 3) was revoked`)
+		// the fake server closes the connection after the handshake, so connecting still fails, but not verification
+		err = connect(pki.valid)
+		require.Error(t, err)
+		assert.NotContains(t, err.Error(), "certificate")
+	})
+	t.Run("MySQL", func(t *testing.T) {
+		mysqlConfig := mysql.NewConfig()
+		mysqlConfig.Addr = "127.0.0.1:3306"
+		withMySQLRootCAs(pki.pool)(mysqlConfig)
+		withMySQLCRL(crls)(mysqlConfig)
+		handshake := func(cert *tls.Certificate) error {
+			// a pipe is unbuffered, so the handshake deadlocks if both ends write at once, e.g. the client's alert
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			require.NoError(t, err)
+			defer func() { _ = l.Close() }()
+			go func() {
+				server, err := l.Accept()
+				if err != nil {
+					return
+				}
+				defer func() { _ = server.Close() }()
+				_ = tls.Server(server, &tls.Config{Certificates: []tls.Certificate{*cert}}).Handshake()
+			}()
+			client, err := net.Dial("tcp", l.Addr().String())
+			require.NoError(t, err)
+			defer func() { _ = client.Close() }()
+			return tls.Client(client, mysqlConfig.TLS).Handshake()
+		}
+		assert.ErrorContains(t, handshake(pki.revoked), "was revoked")
+		assert.NoError(t, handshake(pki.valid))
+	})
+	t.Run("MySQLEnablesTLS", func(t *testing.T) {
+		mysqlConfig := mysql.NewConfig()
+		mysqlConfig.Addr = "db.example.com:3306"
+		withMySQLCRL(crls)(mysqlConfig)
+		if assert.NotNil(t, mysqlConfig.TLS) {
+			assert.Equal(t, "db.example.com", mysqlConfig.TLS.ServerName)
+			assert.NotNil(t, mysqlConfig.TLS.VerifyPeerCertificate)
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package sqldb
+
+import (
+	"time"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+// PersistenceDescription describes the persistence config, e.g. for an admin UI. It must never include credentials,
+// so it only has fields for settings that are not secret, rather than copying the config.
+type PersistenceDescription struct {
+	// Backend is the backend in use, "postgres" or "mysql", or empty if persistence is not configured
+	Backend           string `json:"backend,omitempty"`
+	Host              string `json:"host,omitempty"`
+	ReaderHost        string `json:"readerHost,omitempty"`
+	Database          string `json:"database,omitempty"`
+	Schema            string `json:"schema,omitempty"`
+	TableName         string `json:"tableName,omitempty"`
+	TLS               bool   `json:"tls"`
+	SSLMode           string `json:"sslMode,omitempty"`
+	IAMAuth           string `json:"iamAuth,omitempty"`
+	ClusterName       string `json:"clusterName,omitempty"`
+	Archive           bool   `json:"archive"`
+	NodeStatusOffload bool   `json:"nodeStatusOffload"`
+	MaxOpenConns      int    `json:"maxOpenConns,omitempty"`
+	MaxIdleConns      int    `json:"maxIdleConns,omitempty"`
+	ConnMaxLifetime   string `json:"connMaxLifetime,omitempty"`
+	// Error is why the config cannot be used, e.g. which backend to use is ambiguous, in which case the backend is not
+	// described
+	Error string `json:"error,omitempty"`
+}
+
+// DescribePersistence returns a description of the persistence config that is safe to show to users
+func DescribePersistence(persistConfig *config.PersistConfig) PersistenceDescription {
+	if persistConfig == nil {
+		return PersistenceDescription{}
+	}
+	d := PersistenceDescription{
+		ClusterName:       persistConfig.GetClusterName(),
+		Archive:           persistConfig.Archive,
+		NodeStatusOffload: persistConfig.NodeStatusOffload,
+	}
+	persistConfig, err := ResolveBackend(persistConfig)
+	if err != nil {
+		d.Error = err.Error()
+		return d
+	}
+	describeDatabase := func(cfg config.DatabaseConfig) {
+		d.Host = cfg.GetHostname()
+		if cfg.AuroraReaderEndpoint != "" {
+			d.ReaderHost = cfg.GetReaderHostname()
+		}
+		d.Database = cfg.Database
+		d.TableName = cfg.TableName
+		d.IAMAuth = cfg.IAMAuth
+	}
+	if cfg := persistConfig.PostgreSQL; cfg != nil {
+		d.Backend = string(Postgres)
+		describeDatabase(cfg.DatabaseConfig)
+		d.Schema = cfg.Schema
+		d.TLS = cfg.SSL
+		d.SSLMode = cfg.SSLMode
+	} else if cfg := persistConfig.MySQL; cfg != nil {
+		d.Backend = string(MySQL)
+		describeDatabase(cfg.DatabaseConfig)
+		d.TLS = cfg.Options["tls"] != "" && cfg.Options["tls"] != "false"
+	}
+	if pool := persistConfig.ConnectionPool; pool != nil {
+		d.MaxOpenConns = pool.MaxOpenConns
+		d.MaxIdleConns = pool.MaxIdleConns
+		d.ConnMaxLifetime = time.Duration(pool.ConnMaxLifetime).String()
+	}
+	return d
+}
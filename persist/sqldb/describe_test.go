@@ -0,0 +1,95 @@
+package sqldb
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+func TestDescribePersistence(t *testing.T) {
+	secret := func(key string) apiv1.SecretKeySelector {
+		return apiv1.SecretKeySelector{LocalObjectReference: apiv1.LocalObjectReference{Name: "my-db-secret"}, Key: key}
+	}
+	databaseConfig := config.DatabaseConfig{
+		Host:                    "my-host",
+		Port:                    1234,
+		Database:                "my-db",
+		TableName:               "argo_workflows",
+		UsernameSecret:          secret("my-username-key"),
+		PasswordSecret:          secret("my-password-key"),
+		MigrationUsernameSecret: secret("my-migration-username-key"),
+		MigrationPasswordSecret: secret("my-migration-password-key"),
+	}
+	t.Run("Postgres", func(t *testing.T) {
+		cfg := databaseConfig
+		cfg.IAMAuth = "aws"
+		cfg.AuroraReaderEndpoint = "my-reader"
+		d := DescribePersistence(&config.PersistConfig{
+			Archive:        true,
+			ClusterName:    "my-cluster",
+			PostgreSQL:     &config.PostgreSQLConfig{DatabaseConfig: cfg, SSL: true, SSLMode: "verify-full", Schema: "argo"},
+			ConnectionPool: &config.ConnectionPool{MaxOpenConns: 10},
+		})
+		assert.Equal(t, PersistenceDescription{
+			Backend:         "postgres",
+			Host:            "my-host:1234",
+			ReaderHost:      "my-reader:1234",
+			Database:        "my-db",
+			Schema:          "argo",
+			TableName:       "argo_workflows",
+			TLS:             true,
+			SSLMode:         "verify-full",
+			IAMAuth:         "aws",
+			ClusterName:     "my-cluster",
+			Archive:         true,
+			MaxOpenConns:    10,
+			ConnMaxLifetime: "0s",
+		}, d)
+		assertNoSecrets(t, d)
+	})
+	t.Run("MySQL", func(t *testing.T) {
+		d := DescribePersistence(&config.PersistConfig{
+			NodeStatusOffload: true,
+			MySQL:             &config.MySQLConfig{DatabaseConfig: databaseConfig, Options: map[string]string{"tls": "skip-verify"}},
+		})
+		assert.Equal(t, PersistenceDescription{
+			Backend:           "mysql",
+			Host:              "my-host:1234",
+			Database:          "my-db",
+			TableName:         "argo_workflows",
+			TLS:               true,
+			ClusterName:       "default",
+			NodeStatusOffload: true,
+		}, d)
+		assertNoSecrets(t, d)
+	})
+	t.Run("AmbiguousBackend", func(t *testing.T) {
+		d := DescribePersistence(&config.PersistConfig{
+			Archive:    true,
+			PostgreSQL: &config.PostgreSQLConfig{DatabaseConfig: databaseConfig},
+			MySQL:      &config.MySQLConfig{DatabaseConfig: databaseConfig},
+		})
+		assert.Equal(t, PersistenceDescription{
+			ClusterName: "default",
+			Archive:     true,
+			Error:       "both postgresql and mysql are configured, set preferredBackend to choose which to use",
+		}, d)
+	})
+	t.Run("NotConfigured", func(t *testing.T) {
+		assert.Equal(t, PersistenceDescription{}, DescribePersistence(nil))
+	})
+}
+
+func assertNoSecrets(t *testing.T, d PersistenceDescription) {
+	t.Helper()
+	data, err := json.Marshal(d)
+	require.NoError(t, err)
+	for _, s := range []string{"my-db-secret", "username-key", "password-key"} {
+		assert.NotContains(t, string(data), s)
+	}
+}
@@ -0,0 +1,82 @@
+package sqldb
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v4"
+	"golang.org/x/net/proxy"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+// dbDialer connects from the local address, via a SOCKS5 proxy, or both, and bounds the TLS handshake
+type dbDialer struct {
+	proxy.ContextDialer
+	// name identifies the local address, proxy and user, and is the network name that the dialer is registered with
+	// the MySQL driver as, so a session for the same dialer replaces the registration rather than adding another
+	name string
+	// proxied is whether the host is resolved by the proxy
+	proxied bool
+}
+
+// newDBDialer returns the dialer for the config, or nil if the drivers' own dialers can be used
+func newDBDialer(ctx context.Context, kubectlConfig kubernetes.Interface, namespace string, cfg config.DatabaseConfig) (*dbDialer, error) {
+	if cfg.LocalAddr == "" && cfg.Socks5Proxy == nil && cfg.TLSHandshakeTimeout <= 0 {
+		return nil, nil
+	}
+	// the keep-alive is the same as the one that pgx's dialer uses
+	forward := &net.Dialer{KeepAlive: 5 * time.Minute}
+	var name string
+	if cfg.LocalAddr != "" {
+		ip := net.ParseIP(cfg.LocalAddr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid localAddr %q, it must be an IP address", cfg.LocalAddr)
+		}
+		forward.LocalAddr = &net.TCPAddr{IP: ip}
+		name = "local:" + ip.String()
+	}
+	dialer := &dbDialer{ContextDialer: forward, name: name}
+	if cfg.Socks5Proxy != nil {
+		socks5, proxyName, err := newSOCKS5Dialer(ctx, kubectlConfig, namespace, cfg, forward)
+		if err != nil {
+			return nil, err
+		}
+		if name != "" {
+			proxyName = name + "/" + proxyName
+		}
+		dialer = &dbDialer{ContextDialer: socks5, name: proxyName, proxied: true}
+	}
+	if timeout := time.Duration(cfg.TLSHandshakeTimeout); timeout > 0 {
+		dialer.ContextDialer = &tlsHandshakeTimeoutDialer{ContextDialer: dialer.ContextDialer, timeout: timeout}
+		dialer.name = strings.TrimPrefix(dialer.name+"/tlsHandshakeTimeout:"+timeout.String(), "/")
+	}
+	return dialer, nil
+}
+
+// withDialer connects using the dialer. If it is proxied, the host is resolved by the proxy, as it may not be
+// resolvable from here.
+func withDialer(dialer *dbDialer) PostgresOption {
+	return func(connConfig *pgx.ConnConfig) {
+		connConfig.DialFunc = dialer.DialContext
+		if dialer.proxied {
+			connConfig.LookupFunc = func(_ context.Context, host string) ([]string, error) {
+				return []string{host}, nil
+			}
+		}
+	}
+}
+
+// registerMySQLDialer registers the dialer with the MySQL driver, which only supports custom dialers by network
+// name, and returns the network name to use
+func registerMySQLDialer(dialer *dbDialer) string {
+	mysql.RegisterDialContext(dialer.name, func(ctx context.Context, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, "tcp", addr)
+	})
+	return dialer.name
+}
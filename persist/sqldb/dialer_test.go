@@ -0,0 +1,110 @@
+package sqldb
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	postgresqladp "github.com/upper/db/v4/adapter/postgresql"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+// loopbackAlias is a loopback address other than 127.0.0.1, which Linux routes to the loopback interface, so that a
+// test can tell which local address a connection is from
+const loopbackAlias = "127.0.0.2"
+
+// newRemoteAddrRecorder returns the address of a server that records the remote IP of every connection and closes it
+func newRemoteAddrRecorder(t *testing.T) (string, func() []string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+	var mu sync.Mutex
+	var remotes []string
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			remotes = append(remotes, conn.RemoteAddr().(*net.TCPAddr).IP.String())
+			mu.Unlock()
+			_ = conn.Close()
+		}
+	}()
+	return listener.Addr().String(), func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), remotes...)
+	}
+}
+
+// requireLoopbackAlias skips the test if the alias cannot be bound, e.g. on macOS, where only 127.0.0.1 is configured
+func requireLoopbackAlias(t *testing.T) {
+	listener, err := net.Listen("tcp", net.JoinHostPort(loopbackAlias, "0"))
+	if err != nil {
+		t.Skipf("loopback alias %s is not available: %v", loopbackAlias, err)
+	}
+	_ = listener.Close()
+}
+
+func TestLocalAddr(t *testing.T) {
+	ctx := context.Background()
+	t.Run("NotSet", func(t *testing.T) {
+		dialer, err := newDBDialer(ctx, nil, "argo", config.DatabaseConfig{})
+		require.NoError(t, err)
+		assert.Nil(t, dialer)
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := newDBDialer(ctx, nil, "argo", config.DatabaseConfig{LocalAddr: "10.0.0.300"})
+		assert.EqualError(t, err, `invalid localAddr "10.0.0.300", it must be an IP address`)
+		_, err = newDBDialer(ctx, nil, "argo", config.DatabaseConfig{LocalAddr: "10.0.0.1:5432"})
+		assert.EqualError(t, err, `invalid localAddr "10.0.0.1:5432", it must be an IP address`)
+	})
+	t.Run("Postgres", func(t *testing.T) {
+		requireLoopbackAlias(t)
+		database, remotes := newRemoteAddrRecorder(t)
+		dialer, err := newDBDialer(ctx, nil, "argo", config.DatabaseConfig{LocalAddr: loopbackAlias})
+		require.NoError(t, err)
+		connConfig, err := pgxConnConfig(postgresqladp.ConnectionURL{User: "argo", Host: database, Options: map[string]string{"sslmode": "disable"}}, &config.PostgreSQLConfig{}, withDialer(dialer))
+		require.NoError(t, err)
+		_, err = pgconn.ConnectConfig(ctx, &connConfig.Config)
+		assert.Error(t, err, "the fake database closes the connection")
+		assert.Equal(t, []string{loopbackAlias}, remotes())
+	})
+	t.Run("MySQL", func(t *testing.T) {
+		requireLoopbackAlias(t)
+		database, remotes := newRemoteAddrRecorder(t)
+		dialer, err := newDBDialer(ctx, nil, "argo", config.DatabaseConfig{LocalAddr: loopbackAlias})
+		require.NoError(t, err)
+		assert.Equal(t, "local:"+loopbackAlias, dialer.name)
+		mysqlConfig := mysql.NewConfig()
+		mysqlConfig.Addr = database
+		mysqlConfig.Net = registerMySQLDialer(dialer)
+		connector, err := mysql.NewConnector(mysqlConfig)
+		require.NoError(t, err)
+		_, err = connector.Connect(ctx)
+		assert.Error(t, err, "the fake database closes the connection")
+		assert.Equal(t, []string{loopbackAlias}, remotes())
+	})
+	t.Run("Socks5Proxy", func(t *testing.T) {
+		requireLoopbackAlias(t)
+		database, accepted := newFakeDatabase(t)
+		proxy, address := newFakeSOCKS5(t, database, "", "")
+		dialer, err := newDBDialer(ctx, nil, "argo", config.DatabaseConfig{LocalAddr: loopbackAlias, Socks5Proxy: &config.Socks5Proxy{Address: address}})
+		require.NoError(t, err)
+		assert.Equal(t, "local:"+loopbackAlias+"/socks5:"+address, dialer.name)
+		conn, err := dialer.DialContext(ctx, "tcp", "db.internal:5432")
+		require.NoError(t, err)
+		_ = conn.Close()
+		assert.Equal(t, []string{"db.internal:5432"}, proxy.Connects())
+		assert.Eventually(t, func() bool { return accepted() == 1 }, time.Second, 10*time.Millisecond)
+	})
+}
@@ -0,0 +1,130 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/upper/db/v4"
+)
+
+// drainPollInterval is how often DrainPool checks whether connections are still in use
+const drainPollInterval = 50 * time.Millisecond
+
+// DrainPool closes the session gracefully, e.g. on SIGTERM. New queries fail immediately and idle connections are
+// closed, then DrainPool waits for the queries in flight to finish until ctx is done. At the deadline it force-closes
+// the connections that are still in use, which fails their queries, and returns how many it closed. A session that was
+// not opened by this package, or is in lightweight mode, does not track its connections, so they are abandoned instead,
+// and closed as soon as their query returns. SplitSession.Drain drains both of a split session's pools.
+func DrainPool(ctx context.Context, session db.Session) (int, error) {
+	sqlDB, ok := session.Driver().(*sql.DB)
+	if !ok {
+		return 0, fmt.Errorf("cannot drain %T, it is not a connection pool", session.Driver())
+	}
+	// the connector is forgotten once the pool is closed
+	connector, tracked := drainConnectors.Load(sqlDB)
+	// closing the pool stops it handing out connections, but does not interrupt connections in use
+	if err := session.Close(); err != nil {
+		return 0, err
+	}
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		inUse := sqlDB.Stats().OpenConnections
+		if inUse == 0 {
+			return 0, nil
+		}
+		select {
+		case <-ctx.Done():
+			if !tracked {
+				logger().WithField("inUse", inUse).Warn("Connection pool did not drain before the deadline, abandoning connections")
+				return inUse, nil
+			}
+			closed := connector.(*drainConnector).closeConns()
+			logger().WithField("closed", closed).Warn("Connection pool did not drain before the deadline, closed the connections in use")
+			return closed, nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// drainConnectors are the connectors of the open sessions, by their pool
+var drainConnectors sync.Map
+
+// drainConnector tracks its open connections, so that DrainPool can close the ones that are still in use at its
+// deadline, which database/sql cannot
+type drainConnector struct {
+	driver.Connector
+	sqlDB  *sql.DB
+	closed sync.Once
+
+	mu    sync.Mutex
+	conns map[*drainConn]struct{}
+}
+
+func newDrainConnector(c driver.Connector) *drainConnector {
+	return &drainConnector{Connector: c, conns: map[*drainConn]struct{}{}}
+}
+
+// register registers the connector for the pool until the pool is closed
+func (c *drainConnector) register(sqlDB *sql.DB) {
+	c.sqlDB = sqlDB
+	drainConnectors.Store(sqlDB, c)
+}
+
+func (c *drainConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tracked := &drainConn{wrappedConn: wrappedConn{Conn: conn}, connector: c}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.conns[tracked] = struct{}{}
+	return tracked, nil
+}
+
+// closeConns closes the open connections, returning how many it closed
+func (c *drainConnector) closeConns() int {
+	c.mu.Lock()
+	conns := make([]*drainConn, 0, len(c.conns))
+	for conn := range c.conns {
+		conns = append(conns, conn)
+	}
+	c.mu.Unlock()
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+	return len(conns)
+}
+
+// Close is called when sql.DB is closed
+func (c *drainConnector) Close() error {
+	c.closed.Do(func() { drainConnectors.Delete(c.sqlDB) })
+	if closer, ok := c.Connector.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// drainConn is closed once, whether by the pool or by DrainPool, as the pool closes it again once its query fails
+type drainConn struct {
+	wrappedConn
+	connector *drainConnector
+	closeOnce sync.Once
+	closeErr  error
+}
+
+func (c *drainConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.connector.mu.Lock()
+		delete(c.connector.conns, c)
+		c.connector.mu.Unlock()
+		c.closeErr = c.Conn.Close()
+	})
+	return c.closeErr
+}
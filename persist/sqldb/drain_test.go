@@ -0,0 +1,60 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrainPool(t *testing.T) {
+	ctx := context.Background()
+	t.Run("DrainBeforeDeadline", func(t *testing.T) {
+		session := newFakeSession(t, &fakeConnector{dbType: Postgres})
+		conn, err := session.Driver().(*sql.DB).Conn(ctx)
+		require.NoError(t, err)
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			_ = conn.Close()
+		}()
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		closed, err := DrainPool(ctx, session)
+		require.NoError(t, err)
+		assert.Zero(t, closed)
+	})
+	t.Run("ForceCloseAfterDeadline", func(t *testing.T) {
+		connector := &fakeConnector{dbType: Postgres}
+		session := newFakeSession(t, connector)
+		sqlDB := session.Driver().(*sql.DB)
+		// simulate a query in flight by holding a connection
+		conn, err := sqlDB.Conn(ctx)
+		require.NoError(t, err)
+		defer func() { _ = conn.Close() }()
+		ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		closed, err := DrainPool(ctx, session)
+		require.NoError(t, err)
+		assert.Equal(t, 1, closed)
+		conns := connector.Conns()
+		require.Len(t, conns, 1)
+		assert.True(t, conns[0].Closed(), "the connection in use is closed")
+		_, err = sqlDB.Exec("select 1")
+		assert.EqualError(t, err, "sql: database is closed")
+	})
+	t.Run("NotTracked", func(t *testing.T) {
+		session, err := openSession(Postgres, &fakeConnector{dbType: Postgres}, sessionFeatures{})
+		require.NoError(t, err)
+		conn, err := session.Driver().(*sql.DB).Conn(ctx)
+		require.NoError(t, err)
+		defer func() { _ = conn.Close() }()
+		ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		abandoned, err := DrainPool(ctx, session)
+		require.NoError(t, err)
+		assert.Equal(t, 1, abandoned, "the connection in use cannot be closed, so it is abandoned")
+	})
+}
@@ -0,0 +1,52 @@
+package sqldb
+
+import (
+	"time"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+	"github.com/argoproj/argo-workflows/v3/util/env"
+)
+
+// environment variables that override the persistence config, these take precedence over the config map
+const (
+	envHost            = "ARGO_PERSISTENCE_HOST"
+	envPort            = "ARGO_PERSISTENCE_PORT"
+	envDatabase        = "ARGO_PERSISTENCE_DATABASE"
+	envSSLMode         = "ARGO_PERSISTENCE_SSL_MODE"
+	envMaxOpenConns    = "ARGO_PERSISTENCE_MAX_OPEN_CONNS"
+	envMaxIdleConns    = "ARGO_PERSISTENCE_MAX_IDLE_CONNS"
+	envConnMaxLifetime = "ARGO_PERSISTENCE_CONN_MAX_LIFETIME"
+)
+
+// WithEnvOverrides returns a copy of the config with any ARGO_PERSISTENCE_* environment variables applied. The
+// original config is not modified, as it is shared (and logged) by the caller.
+func WithEnvOverrides(persistConfig *config.PersistConfig) *config.PersistConfig {
+	c := *persistConfig
+	overrideDatabaseConfig := func(cfg *config.DatabaseConfig) {
+		cfg.Host = env.LookupEnvStringOr(envHost, cfg.Host)
+		cfg.Port = env.LookupEnvIntOr(envPort, cfg.Port)
+		cfg.Database = env.LookupEnvStringOr(envDatabase, cfg.Database)
+	}
+	if c.PostgreSQL != nil {
+		postgreSQL := *c.PostgreSQL
+		overrideDatabaseConfig(&postgreSQL.DatabaseConfig)
+		postgreSQL.SSLMode = env.LookupEnvStringOr(envSSLMode, postgreSQL.SSLMode)
+		c.PostgreSQL = &postgreSQL
+	}
+	if c.MySQL != nil {
+		mySQL := *c.MySQL
+		overrideDatabaseConfig(&mySQL.DatabaseConfig)
+		c.MySQL = &mySQL
+	}
+	pool := config.ConnectionPool{}
+	if c.ConnectionPool != nil {
+		pool = *c.ConnectionPool
+	}
+	pool.MaxOpenConns = env.LookupEnvIntOr(envMaxOpenConns, pool.MaxOpenConns)
+	pool.MaxIdleConns = env.LookupEnvIntOr(envMaxIdleConns, pool.MaxIdleConns)
+	pool.ConnMaxLifetime = config.TTL(env.LookupEnvDurationOr(envConnMaxLifetime, time.Duration(pool.ConnMaxLifetime)))
+	if c.ConnectionPool != nil || pool != (config.ConnectionPool{}) {
+		c.ConnectionPool = &pool
+	}
+	return &c
+}
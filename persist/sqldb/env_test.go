@@ -0,0 +1,40 @@
+package sqldb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+func TestWithEnvOverrides(t *testing.T) {
+	t.Run("NoOverrides", func(t *testing.T) {
+		persistConfig := &config.PersistConfig{MySQL: &config.MySQLConfig{DatabaseConfig: config.DatabaseConfig{Host: "my-host"}}}
+		assert.Equal(t, persistConfig, WithEnvOverrides(persistConfig))
+	})
+	t.Run("Overrides", func(t *testing.T) {
+		t.Setenv("ARGO_PERSISTENCE_HOST", "env-host")
+		t.Setenv("ARGO_PERSISTENCE_PORT", "6543")
+		t.Setenv("ARGO_PERSISTENCE_DATABASE", "env-db")
+		t.Setenv("ARGO_PERSISTENCE_SSL_MODE", "verify-full")
+		t.Setenv("ARGO_PERSISTENCE_MAX_OPEN_CONNS", "20")
+		t.Setenv("ARGO_PERSISTENCE_CONN_MAX_LIFETIME", "5m")
+		persistConfig := &config.PersistConfig{
+			ConnectionPool: &config.ConnectionPool{MaxOpenConns: 10, MaxIdleConns: 5},
+			PostgreSQL: &config.PostgreSQLConfig{
+				DatabaseConfig: config.DatabaseConfig{Host: "my-host", Port: 5432, Database: "my-db", TableName: "argo_workflows"},
+				SSLMode:        "require",
+			},
+		}
+		overridden := WithEnvOverrides(persistConfig)
+		assert.Equal(t, "env-host:6543", overridden.PostgreSQL.GetHostname())
+		assert.Equal(t, "env-db", overridden.PostgreSQL.Database)
+		assert.Equal(t, "argo_workflows", overridden.PostgreSQL.TableName)
+		assert.Equal(t, "verify-full", overridden.PostgreSQL.SSLMode)
+		assert.Equal(t, config.ConnectionPool{MaxOpenConns: 20, MaxIdleConns: 5, ConnMaxLifetime: config.TTL(5 * time.Minute)}, *overridden.ConnectionPool)
+		assert.Equal(t, "my-host", persistConfig.PostgreSQL.Host, "original is not modified")
+		assert.Equal(t, 10, persistConfig.ConnectionPool.MaxOpenConns, "original is not modified")
+	})
+}
@@ -0,0 +1,83 @@
+package sqldb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+// defaultExecCredentialTimeout is how long the command may run for, unless the config sets a timeout
+const defaultExecCredentialTimeout = 30 * time.Second
+
+// execCredentialOutput is what the command writes to stdout
+type execCredentialOutput struct {
+	Password string `json:"password"`
+	// Expiry is when the password expires, the password is used until the process exits if it is not set
+	Expiry *time.Time `json:"expiry,omitempty"`
+}
+
+// execCredentialProvider runs a command to get the password, like kubectl's exec credential plugins, and caches it
+// until it expires
+type execCredentialProvider struct {
+	command string
+	args    []string
+	timeout time.Duration
+	clock   Clock
+
+	mu       sync.Mutex
+	password string
+	expiry   *time.Time
+}
+
+func newExecCredentialProvider(cfg *config.ExecCredential) (CredentialProvider, error) {
+	if cfg.Command == "" {
+		return nil, fmt.Errorf("execCredential.command is required")
+	}
+	timeout := defaultExecCredentialTimeout
+	if cfg.Timeout > 0 {
+		timeout = time.Duration(cfg.Timeout)
+	}
+	return &execCredentialProvider{command: cfg.Command, args: cfg.Args, timeout: timeout, clock: clock}, nil
+}
+
+func (p *execCredentialProvider) BeforeConnect(ctx context.Context, creds *Credentials) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.password == "" || (p.expiry != nil && !p.clock.Now().Before(*p.expiry)) {
+		out, err := p.exec(ctx)
+		if err != nil {
+			return err
+		}
+		p.password, p.expiry = out.Password, out.Expiry
+	}
+	creds.Password = p.password
+	return nil
+}
+
+func (p *execCredentialProvider) exec(ctx context.Context) (*execCredentialOutput, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.command, p.args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("execCredential command %q failed: %w: %s", p.command, err, strings.TrimSpace(stderr.String()))
+	}
+	out := &execCredentialOutput{}
+	if err := json.Unmarshal(stdout.Bytes(), out); err != nil {
+		// the output is not included, as it holds the password
+		return nil, fmt.Errorf("failed to parse the output of execCredential command %q: %w", p.command, err)
+	}
+	if out.Password == "" {
+		return nil, fmt.Errorf("execCredential command %q did not output a password", p.command)
+	}
+	return out, nil
+}
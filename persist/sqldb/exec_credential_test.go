@@ -0,0 +1,114 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+// newFakeHelper writes a script that outputs the password with a suffix of how many times it has been run, and the
+// expiry given as its first argument
+func newFakeHelper(t *testing.T) (string, func() int) {
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	script := filepath.Join(dir, "helper.sh")
+	require.NoError(t, os.WriteFile(script, []byte(`#!/bin/sh
+echo x >> "`+calls+`"
+n=$(wc -l < "`+calls+`" | tr -d ' ')
+if [ -n "$1" ]; then
+  echo "{\"password\": \"password-$n\", \"expiry\": \"$1\"}"
+else
+  echo "{\"password\": \"password-$n\"}"
+fi
+`), 0o700))
+	return script, func() int {
+		data, err := os.ReadFile(calls)
+		if os.IsNotExist(err) {
+			return 0
+		}
+		require.NoError(t, err)
+		return strings.Count(string(data), "\n")
+	}
+}
+
+func Test_execCredentialProvider(t *testing.T) {
+	ctx := context.Background()
+	clock := newFakeClock()
+	newProvider := func(t *testing.T, cfg *config.ExecCredential) *execCredentialProvider {
+		provider, err := newExecCredentialProvider(cfg)
+		require.NoError(t, err)
+		p := provider.(*execCredentialProvider)
+		p.clock = clock
+		return p
+	}
+	beforeConnect := func(t *testing.T, p CredentialProvider) string {
+		creds := Credentials{Username: "argo"}
+		require.NoError(t, p.BeforeConnect(ctx, &creds))
+		assert.Equal(t, "argo", creds.Username)
+		return creds.Password
+	}
+
+	t.Run("UsedForNewConnections", func(t *testing.T) {
+		script, calls := newFakeHelper(t)
+		provider := newProvider(t, &config.ExecCredential{Command: script})
+		fake := &fakeConnector{}
+		var used []Credentials
+		connector := newCredentialConnector(fake, provider, Credentials{Username: "argo"}, func(creds Credentials) (driver.Connector, error) {
+			used = append(used, creds)
+			return fake, nil
+		})
+		sqlDB := sql.OpenDB(connector)
+		defer func() { _ = sqlDB.Close() }()
+		for i := 0; i < 2; i++ {
+			conn, err := sqlDB.Conn(ctx)
+			require.NoError(t, err)
+			defer func() { _ = conn.Close() }()
+		}
+		assert.Equal(t, []Credentials{{"argo", "password-1"}, {"argo", "password-1"}}, used)
+		assert.Equal(t, 1, calls(), "without an expiry, the password is cached")
+	})
+	t.Run("ReExecutedOnExpiry", func(t *testing.T) {
+		script, calls := newFakeHelper(t)
+		provider := newProvider(t, &config.ExecCredential{Command: script, Args: []string{"2024-01-02T03:05:05Z"}})
+		assert.Equal(t, "password-1", beforeConnect(t, provider))
+		clock.Advance(59 * time.Second)
+		assert.Equal(t, "password-1", beforeConnect(t, provider))
+		assert.Equal(t, 1, calls())
+		clock.Advance(time.Second)
+		assert.Equal(t, "password-2", beforeConnect(t, provider))
+		assert.Equal(t, 2, calls())
+	})
+	t.Run("CommandRequired", func(t *testing.T) {
+		_, err := newExecCredentialProvider(&config.ExecCredential{})
+		assert.EqualError(t, err, "execCredential.command is required")
+	})
+	t.Run("Failed", func(t *testing.T) {
+		provider := newProvider(t, &config.ExecCredential{Command: "sh", Args: []string{"-c", "echo denied >&2; exit 1"}})
+		err := provider.BeforeConnect(ctx, &Credentials{})
+		assert.EqualError(t, err, `execCredential command "sh" failed: exit status 1: denied`)
+	})
+	t.Run("NoPassword", func(t *testing.T) {
+		provider := newProvider(t, &config.ExecCredential{Command: "echo", Args: []string{"{}"}})
+		err := provider.BeforeConnect(ctx, &Credentials{})
+		assert.EqualError(t, err, `execCredential command "echo" did not output a password`)
+	})
+	t.Run("Timeout", func(t *testing.T) {
+		provider := newProvider(t, &config.ExecCredential{Command: "sleep", Args: []string{"10"}, Timeout: config.TTL(10 * time.Millisecond)})
+		err := provider.BeforeConnect(ctx, &Credentials{})
+		assert.ErrorContains(t, err, `execCredential command "sleep" failed: signal: killed`)
+	})
+	t.Run("IAMAuth", func(t *testing.T) {
+		_, err := newCredentialProvider(ctx, config.DatabaseConfig{IAMAuth: "aws", ExecCredential: &config.ExecCredential{Command: "echo"}})
+		assert.EqualError(t, err, "only one of iamAuth and execCredential may be set")
+	})
+}
@@ -0,0 +1,215 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/require"
+	"github.com/upper/db/v4"
+)
+
+// fakeResult is the canned response for a single statement
+type fakeResult struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+// fakeConnector is a driver.Connector that records every statement and answers queries using handler,
+// so that session behaviour can be tested without a database server
+type fakeConnector struct {
+	dbType  dbType
+	handler func(query string, args []driver.NamedValue) (*fakeResult, error)
+	// prepareArgs makes statements with arguments return driver.ErrSkip, so that they are prepared, like
+	// go-sql-driver/mysql without interpolateParams
+	prepareArgs bool
+
+	mu         sync.Mutex
+	conns      []*fakeConn
+	statements []string
+}
+
+func (c *fakeConnector) Connect(context.Context) (driver.Conn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conn := &fakeConn{connector: c}
+	c.conns = append(c.conns, conn)
+	return conn, nil
+}
+
+func (c *fakeConnector) Driver() driver.Driver {
+	if c.dbType == MySQL {
+		return &mysql.MySQLDriver{}
+	}
+	return fakeDriver{}
+}
+
+func (c *fakeConnector) Statements() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.statements...)
+}
+
+func (c *fakeConnector) Conns() []*fakeConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*fakeConn(nil), c.conns...)
+}
+
+func (c *fakeConnector) exec(conn *fakeConn, query string, args []driver.NamedValue) (*fakeResult, error) {
+	// the adapters emit a lot of whitespace, so normalize it to make assertions easier
+	query = strings.Join(strings.Fields(query), " ")
+	c.mu.Lock()
+	c.statements = append(c.statements, query)
+	conn.statements = append(conn.statements, query)
+	handler := c.handler
+	c.mu.Unlock()
+	if handler != nil {
+		return handler(query, args)
+	}
+	return &fakeResult{}, nil
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, driver.ErrSkip
+}
+
+type fakeConn struct {
+	connector  *fakeConnector
+	statements []string
+	closed     atomic.Bool
+}
+
+func (c *fakeConn) Statements() []string {
+	c.connector.mu.Lock()
+	defer c.connector.mu.Unlock()
+	return append([]string(nil), c.statements...)
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	c.closed.Store(true)
+	return nil
+}
+
+// Closed returns whether the connection has been closed
+func (c *fakeConn) Closed() bool {
+	return c.closed.Load()
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+func (c *fakeConn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	return fakeTx{}, nil
+}
+
+func (c *fakeConn) ExecContext(_ context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if c.connector.prepareArgs && len(args) > 0 {
+		return nil, driver.ErrSkip
+	}
+	return c.exec(query, args)
+}
+
+func (c *fakeConn) exec(query string, args []driver.NamedValue) (driver.Result, error) {
+	res, err := c.connector.exec(c, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(len(res.rows) + 1), nil
+}
+
+func (c *fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if c.connector.prepareArgs && len(args) > 0 {
+		return nil, driver.ErrSkip
+	}
+	return c.query(query, args)
+}
+
+func (c *fakeConn) query(query string, args []driver.NamedValue) (driver.Rows, error) {
+	res, err := c.connector.exec(c, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{fakeResult: res}, nil
+}
+
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.conn.exec(s.query, namedValues(args))
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.conn.query(s.query, namedValues(args))
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+type fakeRows struct {
+	*fakeResult
+	next int
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.next])
+	r.next++
+	return nil
+}
+
+// lookupNameResult answers the database name lookup that the adapters issue when a session is created
+func lookupNameResult(query string) *fakeResult {
+	switch query {
+	case "SELECT CURRENT_DATABASE() AS name", "SELECT DATABASE() AS name":
+		return &fakeResult{columns: []string{"name"}, rows: [][]driver.Value{{"argo"}}}
+	}
+	return nil
+}
+
+// allSessionFeatures enables every feature of the session
+var allSessionFeatures = sessionFeatures{quiesce: true, lastSuccess: true, fingerprints: true, drain: true}
+
+// newFakeSession returns a session of the given type backed by connector
+func newFakeSession(t *testing.T, connector *fakeConnector) db.Session {
+	t.Helper()
+	handler := connector.handler
+	connector.handler = func(query string, args []driver.NamedValue) (*fakeResult, error) {
+		if res := lookupNameResult(query); res != nil {
+			return res, nil
+		}
+		if handler != nil {
+			return handler(query, args)
+		}
+		return &fakeResult{}, nil
+	}
+	session, err := openSession(connector.dbType, connector, allSessionFeatures)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = session.Close() })
+	return session
+}
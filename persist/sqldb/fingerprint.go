@@ -0,0 +1,40 @@
+package sqldb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+// FingerprintConfig returns a hash of the settings of the config that affect the session, i.e. the databases, their
+// pools and how they are connected to, so that two configs with the same fingerprint can share a session, and a
+// change in fingerprint warrants reconnecting. Settings of what is persisted, e.g. archive and archiveTTL, are
+// excluded. The config only has the selectors of the secrets, never their values, so neither does the hash.
+func FingerprintConfig(persistConfig *config.PersistConfig) (string, error) {
+	data, err := json.Marshal(connectionSettings(persistConfig))
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// connectionSettings returns a copy of the config with only the settings that affect the session. JSON is
+// deterministic for the copy, as struct fields are in declaration order and map keys are sorted.
+func connectionSettings(persistConfig *config.PersistConfig) *config.PersistConfig {
+	if persistConfig == nil {
+		return nil
+	}
+	return &config.PersistConfig{
+		ConnectionPool:       persistConfig.ConnectionPool,
+		PostgreSQL:           persistConfig.PostgreSQL,
+		MySQL:                persistConfig.MySQL,
+		ReaderConnectionPool: persistConfig.ReaderConnectionPool,
+		PreferredBackend:     persistConfig.PreferredBackend,
+		ConnectionRetry:      persistConfig.ConnectionRetry,
+		LightweightMode:      persistConfig.LightweightMode,
+		Fallback:             connectionSettings(persistConfig.Fallback),
+	}
+}
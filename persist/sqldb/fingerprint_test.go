@@ -0,0 +1,98 @@
+package sqldb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+func TestFingerprintConfig(t *testing.T) {
+	newConfig := func() *config.PersistConfig {
+		return &config.PersistConfig{
+			Archive:        true,
+			ClusterName:    "my-cluster",
+			ConnectionPool: &config.ConnectionPool{MaxOpenConns: 10},
+			PostgreSQL: &config.PostgreSQLConfig{
+				DatabaseConfig: config.DatabaseConfig{
+					Host:           "my-host",
+					Port:           5432,
+					Database:       "argo",
+					TableName:      "argo_workflows",
+					UsernameSecret: apiv1.SecretKeySelector{LocalObjectReference: apiv1.LocalObjectReference{Name: "my-secret"}, Key: "username"},
+					PasswordSecret: apiv1.SecretKeySelector{LocalObjectReference: apiv1.LocalObjectReference{Name: "my-secret"}, Key: "password"},
+					SessionVars:    map[string]string{"lock_timeout": "5s", "search_path": "argo", "work_mem": "4MB"},
+				},
+				SSL:     true,
+				SSLMode: "verify-full",
+			},
+			Fallback: &config.PersistConfig{PostgreSQL: &config.PostgreSQLConfig{DatabaseConfig: config.DatabaseConfig{Host: "my-fallback"}}},
+		}
+	}
+	fingerprint := func(t *testing.T, persistConfig *config.PersistConfig) string {
+		t.Helper()
+		f, err := FingerprintConfig(persistConfig)
+		require.NoError(t, err)
+		return f
+	}
+	base := fingerprint(t, newConfig())
+
+	t.Run("Identical", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			assert.Equal(t, base, fingerprint(t, newConfig()))
+		}
+	})
+	t.Run("NotConnectionAffecting", func(t *testing.T) {
+		for name, change := range map[string]func(*config.PersistConfig){
+			"archive":              func(c *config.PersistConfig) { c.Archive = false },
+			"archiveTTL":           func(c *config.PersistConfig) { c.ArchiveTTL = config.TTL(24 * time.Hour) },
+			"clusterName":          func(c *config.PersistConfig) { c.ClusterName = "other-cluster" },
+			"nodeStatusOffload":    func(c *config.PersistConfig) { c.NodeStatusOffload = true },
+			"skipMigration":        func(c *config.PersistConfig) { c.SkipMigration = true },
+			"primaryCheckInterval": func(c *config.PersistConfig) { c.PrimaryCheckInterval = config.TTL(time.Minute) },
+			"fallback.archive":     func(c *config.PersistConfig) { c.Fallback.Archive = true },
+		} {
+			t.Run(name, func(t *testing.T) {
+				c := newConfig()
+				change(c)
+				assert.Equal(t, base, fingerprint(t, c))
+			})
+		}
+	})
+	t.Run("ConnectionAffecting", func(t *testing.T) {
+		for name, change := range map[string]func(*config.PersistConfig){
+			"host":                 func(c *config.PersistConfig) { c.PostgreSQL.Host = "other-host" },
+			"port":                 func(c *config.PersistConfig) { c.PostgreSQL.Port = 5433 },
+			"database":             func(c *config.PersistConfig) { c.PostgreSQL.Database = "other" },
+			"tableName":            func(c *config.PersistConfig) { c.PostgreSQL.TableName = "other_workflows" },
+			"passwordSecret.name":  func(c *config.PersistConfig) { c.PostgreSQL.PasswordSecret.Name = "other-secret" },
+			"passwordSecret.key":   func(c *config.PersistConfig) { c.PostgreSQL.PasswordSecret.Key = "other-password" },
+			"sessionVars":          func(c *config.PersistConfig) { c.PostgreSQL.SessionVars["work_mem"] = "8MB" },
+			"sslMode":              func(c *config.PersistConfig) { c.PostgreSQL.SSLMode = "require" },
+			"connectionPool":       func(c *config.PersistConfig) { c.ConnectionPool.MaxOpenConns = 20 },
+			"readerConnectionPool": func(c *config.PersistConfig) { c.ReaderConnectionPool = &config.ConnectionPool{MaxOpenConns: 5} },
+			"connectionRetry":      func(c *config.PersistConfig) { c.ConnectionRetry = &config.ConnectionRetry{} },
+			"lightweightMode":      func(c *config.PersistConfig) { c.LightweightMode = true },
+			"preferredBackend":     func(c *config.PersistConfig) { c.PreferredBackend = "postgresql" },
+			"mysql": func(c *config.PersistConfig) {
+				c.MySQL, c.PostgreSQL = &config.MySQLConfig{DatabaseConfig: c.PostgreSQL.DatabaseConfig}, nil
+			},
+			"fallback.host": func(c *config.PersistConfig) { c.Fallback.PostgreSQL.Host = "other-fallback" },
+			"fallback":      func(c *config.PersistConfig) { c.Fallback = nil },
+		} {
+			t.Run(name, func(t *testing.T) {
+				c := newConfig()
+				change(c)
+				assert.NotEqual(t, base, fingerprint(t, c))
+			})
+		}
+	})
+	t.Run("Nil", func(t *testing.T) {
+		assert.Equal(t, fingerprint(t, nil), fingerprint(t, nil))
+		assert.NotEqual(t, base, fingerprint(t, nil))
+	})
+}
@@ -0,0 +1,133 @@
+package sqldb
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/jackc/pgconn"
+	krb "github.com/jcmturner/gokrb5/v8/client"
+	krbconfig "github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+const (
+	AuthModePassword = "password"
+	AuthModeGSSAPI   = "gssapi"
+)
+
+// newGSSProvider returns the GSSAPI provider that authenticates as the user, a variable so tests can mock it
+var newGSSProvider = newKrbGSSProvider
+
+// gssProvider is the provider registered with pgconn, which only supports one per process, so only one Kerberos
+// principal can be used at a time
+var gssProvider struct {
+	sync.Mutex
+	principal string
+	newGSS    pgconn.NewGSSFunc
+	once      sync.Once
+}
+
+// setupGSSAPI reads the keytab, and registers the provider that pgconn uses when the server asks for GSSAPI auth
+func setupGSSAPI(ctx context.Context, kubectlConfig kubernetes.Interface, namespace string, cfg *config.PostgreSQLConfig, username string) error {
+	if cfg.KrbRealm == "" {
+		return fmt.Errorf("krbRealm is required for the gssapi authMode")
+	}
+	if cfg.KrbKeytabSecret == nil || cfg.KrbKeytabSecret.Name == "" {
+		return fmt.Errorf("krbKeytabSecret is required for the gssapi authMode")
+	}
+	keytabData, err := getSecret(ctx, kubectlConfig, namespace, *cfg.KrbKeytabSecret, cfg.SecretFetchRetries)
+	if err != nil {
+		return fmt.Errorf("failed to get the Kerberos keytab: %w", err)
+	}
+	var krbConfigData []byte
+	if cfg.KrbConfigSecret != nil {
+		krbConfigData, err = getSecret(ctx, kubectlConfig, namespace, *cfg.KrbConfigSecret, cfg.SecretFetchRetries)
+		if err != nil {
+			return fmt.Errorf("failed to get the Kerberos config: %w", err)
+		}
+	}
+	newGSS, err := newGSSProvider(username, cfg.KrbRealm, keytabData, krbConfigData)
+	if err != nil {
+		return err
+	}
+	return registerGSSProvider(username+"@"+cfg.KrbRealm, newGSS)
+}
+
+// registerGSSProvider makes pgconn authenticate as the principal. Registering the same principal again replaces the
+// provider, e.g. with a new keytab.
+func registerGSSProvider(principal string, newGSS pgconn.NewGSSFunc) error {
+	gssProvider.Lock()
+	defer gssProvider.Unlock()
+	if gssProvider.principal != "" && gssProvider.principal != principal {
+		return fmt.Errorf("cannot authenticate as Kerberos principal %q, only one principal is supported and %q is already in use", principal, gssProvider.principal)
+	}
+	gssProvider.principal = principal
+	gssProvider.newGSS = newGSS
+	gssProvider.once.Do(func() { pgconn.RegisterGSSProvider(currentGSS) })
+	return nil
+}
+
+func currentGSS() (pgconn.GSS, error) {
+	gssProvider.Lock()
+	newGSS := gssProvider.newGSS
+	gssProvider.Unlock()
+	return newGSS()
+}
+
+// newKrbGSSProvider logs in using the keytab, so that misconfiguration is found when the session is created rather
+// than when the first connection is opened
+func newKrbGSSProvider(username, realm string, keytabData, krbConfigData []byte) (pgconn.NewGSSFunc, error) {
+	kt := keytab.New()
+	if err := kt.Unmarshal(keytabData); err != nil {
+		return nil, fmt.Errorf("invalid Kerberos keytab: %w", err)
+	}
+	krbConfig := krbconfig.New()
+	if len(krbConfigData) > 0 {
+		var err error
+		krbConfig, err = krbconfig.NewFromString(string(krbConfigData))
+		if err != nil {
+			return nil, fmt.Errorf("invalid Kerberos config: %w", err)
+		}
+	} else {
+		krbConfig.LibDefaults.DefaultRealm = realm
+		krbConfig.LibDefaults.DNSLookupKDC = true
+	}
+	client := krb.NewWithKeytab(username, realm, kt, krbConfig)
+	if err := client.Login(); err != nil {
+		return nil, fmt.Errorf("failed to log in to Kerberos as %s@%s: %w", username, realm, err)
+	}
+	return func() (pgconn.GSS, error) { return &krbGSS{client: client}, nil }, nil
+}
+
+// krbGSS implements pgconn.GSS using SPNEGO
+type krbGSS struct {
+	client *krb.Client
+}
+
+func (g *krbGSS) GetInitToken(host string, service string) ([]byte, error) {
+	return g.GetInitTokenFromSPN(service + "/" + host)
+}
+
+func (g *krbGSS) GetInitTokenFromSPN(spn string) ([]byte, error) {
+	token, err := spnego.SPNEGOClient(g.client, spn).InitSecContext()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get Kerberos service ticket for %s: %w", spn, err)
+	}
+	return token.Marshal()
+}
+
+func (g *krbGSS) Continue(inToken []byte) (bool, []byte, error) {
+	var token spnego.SPNEGOToken
+	if err := token.Unmarshal(inToken); err != nil {
+		return false, nil, fmt.Errorf("invalid GSSAPI response: %w", err)
+	}
+	if !token.Resp || token.NegTokenResp.State() != spnego.NegStateAcceptCompleted {
+		return false, nil, fmt.Errorf("GSSAPI authentication was not accepted by the server")
+	}
+	return true, nil, nil
+}
@@ -0,0 +1,86 @@
+package sqldb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	postgresqladp "github.com/upper/db/v4/adapter/postgresql"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+type fakeGSS struct {
+	pgconn.GSS
+	username, realm string
+	keytab          []byte
+}
+
+func Test_setupGSSAPI(t *testing.T) {
+	newProvider := newGSSProvider
+	defer func() {
+		newGSSProvider = newProvider
+		gssProvider.principal = ""
+		gssProvider.newGSS = nil
+	}()
+	newGSSProvider = func(username, realm string, keytabData, _ []byte) (pgconn.NewGSSFunc, error) {
+		return func() (pgconn.GSS, error) {
+			return &fakeGSS{username: username, realm: realm, keytab: keytabData}, nil
+		}, nil
+	}
+	kube := fake.NewSimpleClientset(&apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "argo-postgres-kerberos", Namespace: "argo"},
+		Data:       map[string][]byte{"keytab": []byte("my-keytab")},
+	})
+	newConfig := func(keytabSecret string) *config.PostgreSQLConfig {
+		return &config.PostgreSQLConfig{
+			AuthMode:                AuthModeGSSAPI,
+			KrbRealm:                "EXAMPLE.COM",
+			KrbKeytabSecret:         &apiv1.SecretKeySelector{LocalObjectReference: apiv1.LocalObjectReference{Name: keytabSecret}, Key: "keytab"},
+			KrbServiceName:          "pg",
+			KrbServicePrincipalName: "pg/db.example.com@EXAMPLE.COM",
+		}
+	}
+	ctx := context.Background()
+
+	t.Run("ConnectionOptions", func(t *testing.T) {
+		connConfig, err := pgxConnConfig(postgresqladp.ConnectionURL{User: "argo", Host: "db.example.com"}, newConfig("argo-postgres-kerberos"))
+		require.NoError(t, err)
+		assert.Equal(t, "pg", connConfig.KerberosSrvName)
+		assert.Equal(t, "pg/db.example.com@EXAMPLE.COM", connConfig.KerberosSpn)
+	})
+	t.Run("Provider", func(t *testing.T) {
+		require.NoError(t, setupGSSAPI(ctx, kube, "argo", newConfig("argo-postgres-kerberos"), "argo"))
+		gss, err := currentGSS()
+		require.NoError(t, err)
+		assert.Equal(t, &fakeGSS{username: "argo", realm: "EXAMPLE.COM", keytab: []byte("my-keytab")}, gss)
+	})
+	t.Run("OnePrincipal", func(t *testing.T) {
+		err := setupGSSAPI(ctx, kube, "argo", newConfig("argo-postgres-kerberos"), "other")
+		assert.EqualError(t, err, `cannot authenticate as Kerberos principal "other@EXAMPLE.COM", only one principal is supported and "argo@EXAMPLE.COM" is already in use`)
+	})
+	t.Run("KeytabRequired", func(t *testing.T) {
+		err := setupGSSAPI(ctx, kube, "argo", newConfig(""), "argo")
+		assert.EqualError(t, err, "krbKeytabSecret is required for the gssapi authMode")
+	})
+	t.Run("MissingKeytab", func(t *testing.T) {
+		err := setupGSSAPI(ctx, kube, "argo", newConfig("missing"), "argo")
+		assert.EqualError(t, err, `failed to get the Kerberos keytab: secrets "missing" not found`)
+	})
+	t.Run("InvalidAuthMode", func(t *testing.T) {
+		kube := fake.NewSimpleClientset(&apiv1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "argo-postgres-config", Namespace: "argo"},
+			Data:       map[string][]byte{"username": []byte("argo")},
+		})
+		cfg := &config.PostgreSQLConfig{AuthMode: "bad"}
+		cfg.TableName = "argo_workflows"
+		cfg.UsernameSecret = apiv1.SecretKeySelector{LocalObjectReference: apiv1.LocalObjectReference{Name: "argo-postgres-config"}, Key: "username"}
+		_, err := CreatePostGresDBSession(kube, "argo", cfg, nil)
+		assert.EqualError(t, err, "authMode must be one of: password, gssapi")
+	})
+}
@@ -0,0 +1,221 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// killQueryTimeout bounds how long we wait to open a connection and kill a cancelled query
+const killQueryTimeout = 5 * time.Second
+
+// killQueryConnector wraps a MySQL connector so that cancelling a query's context kills the query on the server
+// (using KILL QUERY from another connection) rather than the driver closing the connection. The connection, which
+// may have taken a while to open and initialize, is then returned to the pool rather than discarded.
+type killQueryConnector struct {
+	driver.Connector
+}
+
+func (c *killQueryConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	id, err := connectionID(ctx, conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return &killQueryConn{wrappedConn: wrappedConn{Conn: conn}, connector: c.Connector, id: id}, nil
+}
+
+// connectionID returns the server's id for the connection, which is what KILL QUERY takes
+func connectionID(ctx context.Context, conn driver.Conn) (string, error) {
+	queryer, ok := conn.(driver.QueryerContext)
+	if !ok {
+		return "", fmt.Errorf("driver connection %T cannot query", conn)
+	}
+	rows, err := queryer.QueryContext(ctx, "select connection_id()", nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to get connection id: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+	dest := make([]driver.Value, 1)
+	if err := rows.Next(dest); err != nil {
+		return "", fmt.Errorf("failed to get connection id: %w", err)
+	}
+	switch v := dest[0].(type) {
+	case []byte:
+		return string(v), nil
+	case int64, uint64:
+		return fmt.Sprint(v), nil
+	default:
+		return "", fmt.Errorf("unexpected connection id %v", v)
+	}
+}
+
+// killQueryConn runs statements without the caller's cancellation, so the driver does not close the connection, and
+// instead kills the running statement when the context is cancelled. Without interpolateParams the driver cannot run a
+// statement that has arguments directly, so database/sql prepares it, and the prepared statement is wrapped too.
+type killQueryConn struct {
+	wrappedConn
+	connector driver.Connector
+	id        string
+}
+
+// watch kills the connection's query if ctx is cancelled before the returned func is called. The returned func waits
+// for any kill to complete, so a late kill cannot interrupt the next statement on the connection.
+func (c *killQueryConn) watch(ctx context.Context) func() {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			c.kill()
+		case <-done:
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+		})
+	}
+}
+
+func (c *killQueryConn) kill() {
+	ctx, cancel := context.WithTimeout(context.Background(), killQueryTimeout)
+	defer cancel()
+	err := func() error {
+		conn, err := c.connector.Connect(ctx)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = conn.Close() }()
+		execer, ok := conn.(driver.ExecerContext)
+		if !ok {
+			return fmt.Errorf("driver connection %T cannot execute statements", conn)
+		}
+		_, err = execer.ExecContext(ctx, "kill query "+c.id, nil)
+		return err
+	}()
+	if err != nil {
+		logger().WithField("connectionId", c.id).WithError(err).Warn("Failed to kill cancelled query")
+	}
+}
+
+// exec runs fn without the caller's cancellation, killing the statement if ctx is cancelled before it completes
+func (c *killQueryConn) exec(ctx context.Context, fn func(ctx context.Context) (driver.Result, error)) (driver.Result, error) {
+	if ctx.Done() == nil {
+		return fn(ctx)
+	}
+	stop := c.watch(ctx)
+	res, err := fn(context.WithoutCancel(ctx))
+	stop()
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return res, err
+}
+
+// query runs fn without the caller's cancellation, killing the query if ctx is cancelled before its rows are closed
+func (c *killQueryConn) query(ctx context.Context, fn func(ctx context.Context) (driver.Rows, error)) (driver.Rows, error) {
+	if ctx.Done() == nil {
+		return fn(ctx)
+	}
+	// the server may still be sending rows, so keep watching until they are closed
+	stop := c.watch(ctx)
+	rows, err := fn(context.WithoutCancel(ctx))
+	if err != nil {
+		stop()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return &killQueryRows{Rows: rows, stop: stop}, nil
+}
+
+func (c *killQueryConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	return c.exec(ctx, func(ctx context.Context) (driver.Result, error) {
+		return execer.ExecContext(ctx, query, args)
+	})
+}
+
+func (c *killQueryConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	return c.query(ctx, func(ctx context.Context) (driver.Rows, error) {
+		return queryer.QueryContext(ctx, query, args)
+	})
+}
+
+// PrepareContext prepares the statement without the caller's cancellation too, as preparing it is not a query that
+// can be killed, and is quick
+func (c *killQueryConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	stmt, err := c.wrappedConn.PrepareContext(context.WithoutCancel(ctx), query)
+	if err != nil {
+		return nil, err
+	}
+	return &killQueryStmt{Stmt: stmt, conn: c}, nil
+}
+
+// killQueryStmt is a prepared statement whose executions are killed like the connection's statements
+type killQueryStmt struct {
+	driver.Stmt
+	conn *killQueryConn
+}
+
+func (s *killQueryStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.exec(ctx, func(ctx context.Context) (driver.Result, error) {
+		if execer, ok := s.Stmt.(driver.StmtExecContext); ok {
+			return execer.ExecContext(ctx, args)
+		}
+		values, err := namedValuesToValues(args)
+		if err != nil {
+			return nil, err
+		}
+		return s.Stmt.Exec(values) //nolint:staticcheck
+	})
+}
+
+func (s *killQueryStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.query(ctx, func(ctx context.Context) (driver.Rows, error) {
+		if queryer, ok := s.Stmt.(driver.StmtQueryContext); ok {
+			return queryer.QueryContext(ctx, args)
+		}
+		values, err := namedValuesToValues(args)
+		if err != nil {
+			return nil, err
+		}
+		return s.Stmt.Query(values) //nolint:staticcheck
+	})
+}
+
+func (s *killQueryStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := s.Stmt.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return s.conn.CheckNamedValue(nv)
+}
+
+type killQueryRows struct {
+	driver.Rows
+	stop func()
+}
+
+func (r *killQueryRows) Close() error {
+	err := r.Rows.Close()
+	r.stop()
+	return err
+}
@@ -0,0 +1,75 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_killQueryConnector(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		query string
+		args  []any
+	}{
+		{"Query", "select sleep(10)", nil},
+		// without interpolateParams, the driver prepares a query that has arguments
+		{"PreparedQuery", "select sleep(?)", []any{10}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var ids atomic.Int64
+			started := make(chan struct{})
+			killed := make(chan struct{})
+			connector := &fakeConnector{dbType: MySQL, prepareArgs: true, handler: func(query string, _ []driver.NamedValue) (*fakeResult, error) {
+				if res := lookupNameResult(query); res != nil {
+					return res, nil
+				}
+				switch query {
+				case "select connection_id()":
+					return &fakeResult{columns: []string{"id"}, rows: [][]driver.Value{{[]byte(fmt.Sprint(ids.Add(1)))}}}, nil
+				case tt.query:
+					close(started)
+					select {
+					case <-killed:
+					case <-time.After(5 * time.Second):
+						return nil, fmt.Errorf("the query was not killed")
+					}
+					return nil, &mysql.MySQLError{Number: 1317, Message: "Query execution was interrupted"}
+				case "kill query 1":
+					close(killed)
+				}
+				return &fakeResult{}, nil
+			}}
+			session, err := openSession(MySQL, &killQueryConnector{Connector: connector}, allSessionFeatures)
+			require.NoError(t, err)
+			defer func() { _ = session.Close() }()
+			sqlDB := session.Driver().(*sql.DB)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				<-started
+				cancel()
+			}()
+			_, err = session.SQL().QueryContext(ctx, tt.query, tt.args...)
+			require.ErrorIs(t, err, context.Canceled)
+
+			assert.Equal(t, 0, sqlDB.Stats().InUse)
+			assert.Equal(t, 1, sqlDB.Stats().OpenConnections)
+			// the first connection is reused, the second was only opened to kill the query
+			_, err = session.SQL().Exec("select 1")
+			require.NoError(t, err)
+			conns := connector.Conns()
+			require.Len(t, conns, 2)
+			assert.Contains(t, conns[0].Statements(), "select 1")
+			assert.Equal(t, []string{"kill query 1"}, conns[1].Statements())
+		})
+	}
+}
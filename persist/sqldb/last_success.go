@@ -0,0 +1,94 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/upper/db/v4"
+
+	"github.com/argoproj/argo-workflows/v3/workflow/metrics"
+)
+
+// lastSuccessTrackers are the trackers of the open sessions, by their pool
+var lastSuccessTrackers sync.Map
+
+// lastSuccessTracker sets the gauge to the time of each successful query or ping. It has no gauge until the session
+// is instrumented, so that, e.g., sessions in lightweight mode are not reported.
+type lastSuccessTracker struct {
+	gauge atomic.Pointer[prometheus.Gauge]
+}
+
+func (t *lastSuccessTracker) succeeded() {
+	if gauge := t.gauge.Load(); gauge != nil {
+		(*gauge).Set(float64(clock.Now().UnixNano()) / 1e9)
+	}
+}
+
+// trackLastSuccess reports the time that the session's queries last succeeded as the component's gauge, doing
+// nothing if the session was not opened by this package
+func trackLastSuccess(session db.Session, component Component) {
+	sqlDB, ok := session.Driver().(*sql.DB)
+	if !ok {
+		return
+	}
+	tracker, ok := lastSuccessTrackers.Load(sqlDB)
+	if !ok {
+		return
+	}
+	gauge := metrics.DBLastSuccessMetric.WithLabelValues(string(dbTypeFor(session)), string(component))
+	tracker.(*lastSuccessTracker).gauge.Store(&gauge)
+}
+
+// lastSuccessConnector tells its tracker when its connections' queries and pings succeed
+type lastSuccessConnector struct {
+	driver.Connector
+	tracker *lastSuccessTracker
+	hooks   queryHooks
+	sqlDB   *sql.DB
+	closed  sync.Once
+}
+
+func newLastSuccessConnector(c driver.Connector) *lastSuccessConnector {
+	tracker := &lastSuccessTracker{}
+	succeeded := func(err error) {
+		if err == nil {
+			tracker.succeeded()
+		}
+	}
+	return &lastSuccessConnector{
+		Connector: c,
+		tracker:   tracker,
+		hooks: queryHooks{
+			after: func(_ context.Context, _ string, err error) { succeeded(err) },
+			ping:  func(_ context.Context, err error) { succeeded(err) },
+		},
+	}
+}
+
+// register registers the tracker for the pool until the pool is closed
+func (c *lastSuccessConnector) register(sqlDB *sql.DB) {
+	c.sqlDB = sqlDB
+	lastSuccessTrackers.Store(sqlDB, c.tracker)
+}
+
+func (c *lastSuccessConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newHookedConn(conn, &c.hooks), nil
+}
+
+// Close is called when sql.DB is closed
+func (c *lastSuccessConnector) Close() error {
+	c.closed.Do(func() { lastSuccessTrackers.Delete(c.sqlDB) })
+	if closer, ok := c.Connector.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/argoproj/argo-workflows/v3/workflow/metrics"
+)
+
+func Test_trackLastSuccess(t *testing.T) {
+	fakeClock := useFakeClock(t)
+	connector := &fakeConnector{dbType: Postgres, handler: func(query string, _ []driver.NamedValue) (*fakeResult, error) {
+		if query == "select fail" {
+			return nil, errors.New("connection reset by peer")
+		}
+		return &fakeResult{}, nil
+	}}
+	session := newFakeSession(t, connector)
+	gauge := metrics.DBLastSuccessMetric.WithLabelValues("postgres", "server")
+	lastSuccess := func() time.Time {
+		return time.Unix(0, int64(testutil.ToFloat64(gauge)*1e9)).UTC()
+	}
+
+	t.Run("NotInstrumented", func(t *testing.T) {
+		before := testutil.ToFloat64(gauge)
+		_, err := session.SQL().Exec("select 1")
+		require.NoError(t, err)
+		assert.Equal(t, before, testutil.ToFloat64(gauge))
+	})
+
+	trackLastSuccess(session, ComponentServer)
+
+	t.Run("Success", func(t *testing.T) {
+		_, err := session.SQL().Exec("select 1")
+		require.NoError(t, err)
+		assert.Equal(t, fakeClock.Now(), lastSuccess())
+
+		fakeClock.Advance(time.Minute)
+		_, err = session.SQL().Query("select 1")
+		require.NoError(t, err)
+		assert.Equal(t, fakeClock.Now(), lastSuccess(), "the gauge advances")
+
+		fakeClock.Advance(time.Minute)
+		require.NoError(t, session.Ping())
+		assert.Equal(t, fakeClock.Now(), lastSuccess(), "pings count")
+	})
+	t.Run("Failure", func(t *testing.T) {
+		succeeded := lastSuccess()
+		fakeClock.Advance(time.Minute)
+		_, err := session.SQL().ExecContext(context.Background(), "select fail")
+		require.Error(t, err)
+		assert.Equal(t, succeeded, lastSuccess(), "the gauge does not advance")
+	})
+	t.Run("Closed", func(t *testing.T) {
+		require.NoError(t, session.Close())
+		_, ok := lastSuccessTrackers.Load(session.Driver())
+		assert.False(t, ok, "the tracker is unregistered")
+	})
+}
@@ -0,0 +1,40 @@
+package sqldb
+
+import (
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// logLevel overrides the standard logger's level for this package, it is the level plus one, so that zero (the
+// default) means the standard logger's level is used
+var logLevel atomic.Int32
+
+// SetLogLevel changes the log level of the persistence package at runtime, without changing the rest of the
+// process, e.g. to debug connection issues
+func SetLogLevel(level log.Level) {
+	logLevel.Store(int32(level) + 1)
+}
+
+// ResetLogLevel reverts the persistence package to the standard logger's level
+func ResetLogLevel() {
+	logLevel.Store(0)
+}
+
+// logger returns the logger for the package. It shares the standard logger's output, formatter and hooks, so
+// only the level differs.
+func logger() *log.Entry {
+	std := log.StandardLogger()
+	level := logLevel.Load()
+	if level == 0 {
+		return log.NewEntry(std)
+	}
+	return log.NewEntry(&log.Logger{
+		Out:          std.Out,
+		Hooks:        std.Hooks,
+		Formatter:    std.Formatter,
+		ReportCaller: std.ReportCaller,
+		Level:        log.Level(level - 1),
+		ExitFunc:     std.ExitFunc,
+	})
+}
@@ -0,0 +1,40 @@
+package sqldb
+
+import (
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetLogLevel(t *testing.T) {
+	hook := test.NewGlobal()
+	defer log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+	defer ResetLogLevel()
+	level := log.GetLevel()
+	defer log.SetLevel(level)
+	log.SetLevel(log.InfoLevel)
+
+	logger().Debug("hidden")
+	assert.Empty(t, hook.AllEntries())
+
+	SetLogLevel(log.DebugLevel)
+	logger().WithField("host", "postgres").Debug("shown")
+	if assert.Len(t, hook.AllEntries(), 1) {
+		assert.Equal(t, "shown", hook.LastEntry().Message)
+		assert.Equal(t, "postgres", hook.LastEntry().Data["host"])
+	}
+	// the rest of the process is unaffected
+	log.Debug("hidden")
+	assert.Len(t, hook.AllEntries(), 1)
+
+	SetLogLevel(log.ErrorLevel)
+	logger().Warn("hidden")
+	assert.Len(t, hook.AllEntries(), 1)
+
+	ResetLogLevel()
+	logger().Debug("hidden")
+	logger().Info("shown")
+	assert.Len(t, hook.AllEntries(), 2)
+}
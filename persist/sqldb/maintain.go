@@ -0,0 +1,120 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/upper/db/v4"
+)
+
+// maintenanceLockKey is the advisory lock that is held while the archive tables are maintained
+const maintenanceLockKey = "argo-archive-maintenance"
+
+// Maintain runs the routine maintenance of the table, to reclaim the space of deleted rows and update the planner's
+// statistics: VACUUM (ANALYZE) for Postgres, or OPTIMIZE TABLE and ANALYZE TABLE for MySQL. VACUUM does not lock the
+// table, but OPTIMIZE TABLE rebuilds an InnoDB table, which blocks writes while it is swapped in.
+func Maintain(ctx context.Context, session db.Session, tableName string, t dbType) error {
+	return maintain(ctx, sessionRunner{session.SQL()}, tableName, t)
+}
+
+// sqlRunner runs statements, using either a pool or a single connection
+type sqlRunner interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// sessionRunner runs statements using the session's pool
+type sessionRunner struct {
+	sql db.SQL
+}
+
+func (r sessionRunner) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return r.sql.ExecContext(ctx, query, args...)
+}
+
+func (r sessionRunner) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return r.sql.QueryContext(ctx, query, args...)
+}
+
+func maintain(ctx context.Context, runner sqlRunner, tableName string, t dbType) error {
+	for _, part := range strings.Split(tableName, ".") {
+		if !identifierRegexp.MatchString(part) {
+			return fmt.Errorf("invalid table name %q", tableName)
+		}
+	}
+	switch t {
+	case Postgres:
+		// VACUUM cannot run in a transaction, so it must not be run using a transaction's session
+		if _, err := runner.ExecContext(ctx, "vacuum (analyze) "+tableName); err != nil {
+			return fmt.Errorf("failed to vacuum table %s: %w", tableName, err)
+		}
+	case MySQL:
+		for _, statement := range []string{"optimize table ", "analyze table "} {
+			if err := execMySQLTableStatement(ctx, runner, statement+tableName); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported database type %q", t)
+	}
+	logger().WithField("tableName", tableName).Info("Maintained database table")
+	return nil
+}
+
+// execMySQLTableStatement runs a table maintenance statement, which reports its failures as rows rather than as an
+// error
+func execMySQLTableStatement(ctx context.Context, runner sqlRunner, statement string) error {
+	rows, err := runner.QueryContext(ctx, statement)
+	if err != nil {
+		return fmt.Errorf("failed to %s: %w", statement, err)
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var table, op, msgType, msgText string
+		if err := rows.Scan(&table, &op, &msgType, &msgText); err != nil {
+			return fmt.Errorf("failed to %s: %w", statement, err)
+		}
+		if strings.EqualFold(msgType, "error") {
+			return fmt.Errorf("failed to %s: %s", statement, msgText)
+		}
+	}
+	return rows.Err()
+}
+
+// maintainLocked maintains the tables while it holds the maintenance lock, returning whether it did, as they are not
+// maintained if another replica holds the lock. They are maintained using the lock's connection.
+func maintainLocked(ctx context.Context, session db.Session, t dbType, tableNames ...string) (bool, error) {
+	return tryWithAdvisoryLock(ctx, session, maintenanceLockKey, t, func(conn *sql.Conn) error {
+		for _, tableName := range tableNames {
+			if err := maintain(ctx, conn, tableName, t); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// MaintainPeriodically maintains the archive tables every interval until the context is done. Only one replica
+// maintains them at a time, the others skip that interval. The session is got every interval, so that a writer that
+// is reconnected after a failover is the one that is used.
+func MaintainPeriodically(ctx context.Context, session func() db.Session, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		current := session()
+		maintained, err := maintainLocked(ctx, current, dbTypeFor(current), archiveTableName, archiveLabelsTableName)
+		if err != nil {
+			logger().WithError(err).Error("Failed to maintain the archive tables")
+		} else if !maintained {
+			logger().Debug("Skipped maintaining the archive tables, another replica holds the lock")
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/upper/db/v4"
+)
+
+func TestMaintain(t *testing.T) {
+	ctx := context.Background()
+	mySQLResult := func(op, msgType, msgText string) *fakeResult {
+		return &fakeResult{
+			columns: []string{"Table", "Op", "Msg_type", "Msg_text"},
+			rows:    [][]driver.Value{{"argo.argo_archived_workflows", op, msgType, msgText}},
+		}
+	}
+	t.Run("Postgres", func(t *testing.T) {
+		connector := &fakeConnector{dbType: Postgres}
+		session := newFakeSession(t, connector)
+		require.NoError(t, Maintain(ctx, session, "argo_archived_workflows", Postgres))
+		assert.Contains(t, connector.Statements(), "vacuum (analyze) argo_archived_workflows")
+	})
+	t.Run("MySQL", func(t *testing.T) {
+		connector := &fakeConnector{dbType: MySQL, handler: func(query string, _ []driver.NamedValue) (*fakeResult, error) {
+			op := strings.Fields(query)[0]
+			return mySQLResult(op, "status", "OK"), nil
+		}}
+		session := newFakeSession(t, connector)
+		require.NoError(t, Maintain(ctx, session, "argo_archived_workflows", MySQL))
+		statements := connector.Statements()
+		assert.Equal(t, []string{"optimize table argo_archived_workflows", "analyze table argo_archived_workflows"}, statements[len(statements)-2:])
+	})
+	t.Run("MySQLError", func(t *testing.T) {
+		session := newFakeSession(t, &fakeConnector{dbType: MySQL, handler: func(string, []driver.NamedValue) (*fakeResult, error) {
+			return mySQLResult("optimize", "Error", "Table 'argo.argo_archived_workflows' doesn't exist"), nil
+		}})
+		err := Maintain(ctx, session, "argo_archived_workflows", MySQL)
+		assert.EqualError(t, err, "failed to optimize table argo_archived_workflows: Table 'argo.argo_archived_workflows' doesn't exist")
+	})
+	t.Run("InvalidTableName", func(t *testing.T) {
+		connector := &fakeConnector{dbType: Postgres}
+		session := newFakeSession(t, connector)
+		statements := len(connector.Statements())
+		assert.EqualError(t, Maintain(ctx, session, "argo; drop table argo", Postgres), `invalid table name "argo; drop table argo"`)
+		assert.Len(t, connector.Statements(), statements)
+	})
+	t.Run("Schema", func(t *testing.T) {
+		connector := &fakeConnector{dbType: Postgres}
+		session := newFakeSession(t, connector)
+		require.NoError(t, Maintain(ctx, session, "argo.argo_archived_workflows", Postgres))
+		assert.Contains(t, connector.Statements(), "vacuum (analyze) argo.argo_archived_workflows")
+	})
+}
+
+func Test_maintainLocked(t *testing.T) {
+	ctx := context.Background()
+	server := newFakeLockServer()
+	a := server.newSession(t, Postgres, "a")
+	b := server.newSession(t, Postgres, "b")
+
+	releaseB, err := AcquireAdvisoryLock(ctx, b, maintenanceLockKey, Postgres)
+	require.NoError(t, err)
+	maintained, err := maintainLocked(ctx, a, Postgres, "argo_archived_workflows")
+	require.NoError(t, err)
+	assert.False(t, maintained, "the other replica holds the lock")
+	assert.Empty(t, server.Statements())
+
+	require.NoError(t, releaseB())
+	maintained, err = maintainLocked(ctx, a, Postgres, "argo_archived_workflows", "argo_archived_workflows_labels")
+	require.NoError(t, err)
+	assert.True(t, maintained)
+	assert.Equal(t, []string{
+		"a: vacuum (analyze) argo_archived_workflows",
+		"a: vacuum (analyze) argo_archived_workflows_labels",
+	}, server.Statements())
+	assert.Empty(t, server.holders, "the lock is released")
+
+	t.Run("OneConnection", func(t *testing.T) {
+		c := server.newSession(t, Postgres, "c")
+		c.SetMaxOpenConns(1)
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		maintained, err := maintainLocked(ctx, c, Postgres, "argo_archived_workflows")
+		require.NoError(t, err, "the tables are maintained using the lock's connection")
+		assert.True(t, maintained)
+	})
+}
+
+func TestMaintainPeriodically(t *testing.T) {
+	server := newFakeLockServer()
+	// the writer is reconnected after the first interval
+	sessions := []db.Session{server.newSession(t, Postgres, "a"), server.newSession(t, Postgres, "b")}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	intervals := 0
+	MaintainPeriodically(ctx, func() db.Session {
+		intervals++
+		if intervals > len(sessions) {
+			cancel()
+			return sessions[len(sessions)-1]
+		}
+		return sessions[intervals-1]
+	}, time.Millisecond)
+	statements := server.Statements()
+	assert.Contains(t, statements, "a: vacuum (analyze) argo_archived_workflows")
+	assert.Contains(t, statements, "b: vacuum (analyze) argo_archived_workflows", "the new writer is used")
+}
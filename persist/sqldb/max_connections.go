@@ -0,0 +1,73 @@
+package sqldb
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/upper/db/v4"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+// serverConnections returns the server's max_connections and the number of connections currently open
+func serverConnections(session db.Session, t dbType) (int, int, error) {
+	var maxConnections, used int
+	switch t {
+	case Postgres:
+		row, err := session.SQL().QueryRow("select cast(current_setting('max_connections') as int), (select count(*) from pg_stat_activity)")
+		if err != nil {
+			return 0, 0, err
+		}
+		if err := row.Scan(&maxConnections, &used); err != nil {
+			return 0, 0, err
+		}
+	case MySQL:
+		row, err := session.SQL().QueryRow("select @@max_connections")
+		if err != nil {
+			return 0, 0, err
+		}
+		if err := row.Scan(&maxConnections); err != nil {
+			return 0, 0, err
+		}
+		row, err = session.SQL().QueryRow("show global status like 'Threads_connected'")
+		if err != nil {
+			return 0, 0, err
+		}
+		var name string
+		if err := row.Scan(&name, &used); err != nil {
+			return 0, 0, err
+		}
+	default:
+		return 0, 0, fmt.Errorf("unsupported database type %q", t)
+	}
+	return maxConnections, used, nil
+}
+
+// checkMaxConnections warns, or errors in strict mode, if filling the pool could take the server over the configured
+// fraction of its max_connections, starving other clients
+func checkMaxConnections(session db.Session, t dbType, pool *config.ConnectionPool) error {
+	if pool == nil || pool.MaxConnectionsFraction <= 0 {
+		return nil
+	}
+	logCtx := logger().WithField("maxOpenConns", pool.MaxOpenConns)
+	if pool.MaxOpenConns <= 0 {
+		logCtx.Warn("Cannot check the database server's max_connections, because the connection pool's maxOpenConns is unlimited")
+		return nil
+	}
+	maxConnections, used, err := serverConnections(session, t)
+	if err != nil {
+		return fmt.Errorf("failed to check the database server's max_connections: %w", err)
+	}
+	// one of the used connections is our own
+	projected := used - 1 + pool.MaxOpenConns
+	limit := int(pool.MaxConnectionsFraction * float64(maxConnections))
+	if projected <= limit {
+		return nil
+	}
+	msg := fmt.Sprintf("the connection pool could use %d of the database server's %d max_connections (%d already in use), which is over the %v limit", projected, maxConnections, used, pool.MaxConnectionsFraction)
+	if pool.MaxConnectionsStrict {
+		return errors.New(msg)
+	}
+	logCtx.Warn(msg)
+	return nil
+}
@@ -0,0 +1,56 @@
+package sqldb
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+func Test_checkMaxConnections(t *testing.T) {
+	// a server with max_connections=100 and 60 in use
+	postgres := &fakeConnector{dbType: Postgres, handler: func(query string, _ []driver.NamedValue) (*fakeResult, error) {
+		if strings.Contains(query, "max_connections") {
+			return &fakeResult{columns: []string{"max", "used"}, rows: [][]driver.Value{{int64(100), int64(60)}}}, nil
+		}
+		return &fakeResult{}, nil
+	}}
+	mysql := &fakeConnector{dbType: MySQL, handler: func(query string, _ []driver.NamedValue) (*fakeResult, error) {
+		switch query {
+		case "select @@max_connections":
+			return &fakeResult{columns: []string{"max"}, rows: [][]driver.Value{{int64(100)}}}, nil
+		case "show global status like 'Threads_connected'":
+			return &fakeResult{columns: []string{"Variable_name", "Value"}, rows: [][]driver.Value{{"Threads_connected", "60"}}}, nil
+		}
+		return &fakeResult{}, nil
+	}}
+	for _, connector := range []*fakeConnector{postgres, mysql} {
+		session := newFakeSession(t, connector)
+		t.Run(string(connector.dbType)+"Disabled", func(t *testing.T) {
+			assert.NoError(t, checkMaxConnections(session, connector.dbType, nil))
+			assert.NoError(t, checkMaxConnections(session, connector.dbType, &config.ConnectionPool{MaxOpenConns: 100}))
+		})
+		t.Run(string(connector.dbType)+"UnderLimit", func(t *testing.T) {
+			assert.NoError(t, checkMaxConnections(session, connector.dbType, &config.ConnectionPool{MaxOpenConns: 21, MaxConnectionsFraction: 0.8, MaxConnectionsStrict: true}))
+		})
+		t.Run(string(connector.dbType)+"Warn", func(t *testing.T) {
+			hook := &test.Hook{}
+			log.AddHook(hook)
+			defer log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+			assert.NoError(t, checkMaxConnections(session, connector.dbType, &config.ConnectionPool{MaxOpenConns: 30, MaxConnectionsFraction: 0.8}))
+			if assert.NotNil(t, hook.LastEntry()) {
+				assert.Equal(t, log.WarnLevel, hook.LastEntry().Level)
+				assert.Equal(t, "the connection pool could use 89 of the database server's 100 max_connections (60 already in use), which is over the 0.8 limit", hook.LastEntry().Message)
+			}
+		})
+		t.Run(string(connector.dbType)+"Strict", func(t *testing.T) {
+			err := checkMaxConnections(session, connector.dbType, &config.ConnectionPool{MaxOpenConns: 30, MaxConnectionsFraction: 0.8, MaxConnectionsStrict: true})
+			assert.EqualError(t, err, "the connection pool could use 89 of the database server's 100 max_connections (60 already in use), which is over the 0.8 limit")
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+)
+
+// ErrMaxRowsExceeded is returned by a query that returns more rows than the maxRows limit
+var ErrMaxRowsExceeded = errors.New("query returned too many rows")
+
+type unlimitedRowsKey struct{}
+
+// withUnlimitedRows exempts the queries that use the context from the maxRows limit, for callers that iterate over
+// large results on purpose, without holding them in memory
+func withUnlimitedRows(ctx context.Context) context.Context {
+	return context.WithValue(ctx, unlimitedRowsKey{}, true)
+}
+
+// maxRowsConnector aborts queries that return more than maxRows rows, so that a query that is missing a limit fails
+// rather than reading, e.g., the whole archive into memory
+type maxRowsConnector struct {
+	driver.Connector
+	hooks queryHooks
+}
+
+// newMaxRowsConnector limits the rows of the connector's queries, unless maxRows is not positive
+func newMaxRowsConnector(c driver.Connector, maxRows int) driver.Connector {
+	if maxRows <= 0 {
+		return c
+	}
+	return &maxRowsConnector{Connector: c, hooks: queryHooks{rows: func(ctx context.Context, rows driver.Rows) driver.Rows {
+		if ctx.Value(unlimitedRowsKey{}) != nil {
+			return rows
+		}
+		return &maxRowsRows{Rows: rows, maxRows: maxRows}
+	}}}
+}
+
+func (c *maxRowsConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newHookedConn(conn, &c.hooks), nil
+}
+
+// maxRowsRows fails once more than maxRows rows have been read. The rest of the rows are discarded when the rows are
+// closed, rather than held in memory.
+type maxRowsRows struct {
+	driver.Rows
+	maxRows int
+	read    int
+}
+
+func (r *maxRowsRows) Next(dest []driver.Value) error {
+	if err := r.Rows.Next(dest); err != nil {
+		return err
+	}
+	r.read++
+	if r.read > r.maxRows {
+		return fmt.Errorf("%w: more than the maxRows limit of %d were read, the query may be missing a limit", ErrMaxRowsExceeded, r.maxRows)
+	}
+	return nil
+}
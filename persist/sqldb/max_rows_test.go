@@ -0,0 +1,77 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/upper/db/v4"
+)
+
+// newMaxRowsSession returns a session limited to maxRows, whose queries return n rows
+func newMaxRowsSession(t *testing.T, dbType dbType, maxRows, n int) db.Session {
+	t.Helper()
+	connector := &fakeConnector{dbType: dbType, handler: func(query string, args []driver.NamedValue) (*fakeResult, error) {
+		if res := lookupNameResult(query); res != nil {
+			return res, nil
+		}
+		res := &fakeResult{columns: []string{"uid"}}
+		for i := 0; i < n; i++ {
+			res.rows = append(res.rows, []driver.Value{"uid"})
+		}
+		return res, nil
+	}}
+	session, err := openSession(dbType, newMaxRowsConnector(connector, maxRows), allSessionFeatures)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = session.Close() })
+	return session
+}
+
+func TestMaxRows(t *testing.T) {
+	ctx := context.Background()
+	type record struct {
+		UID string `db:"uid"`
+	}
+	t.Run("Disabled", func(t *testing.T) {
+		connector := &fakeConnector{}
+		assert.Same(t, connector, newMaxRowsConnector(connector, 0))
+	})
+	t.Run("Under", func(t *testing.T) {
+		session := newMaxRowsSession(t, Postgres, 10, 10)
+		var records []record
+		require.NoError(t, session.SQL().Select("uid").From("argo_archived_workflows").All(&records))
+		assert.Len(t, records, 10)
+	})
+	t.Run("Exceeded", func(t *testing.T) {
+		session := newMaxRowsSession(t, Postgres, 10, 11)
+		var records []record
+		err := session.SQL().Select("uid").From("argo_archived_workflows").All(&records)
+		assert.ErrorIs(t, err, ErrMaxRowsExceeded)
+		assert.ErrorContains(t, err, "more than the maxRows limit of 10 were read")
+	})
+	t.Run("Query", func(t *testing.T) {
+		session := newMaxRowsSession(t, MySQL, 10, 11)
+		rows, err := session.SQL().QueryContext(ctx, "select uid from argo_archived_workflows")
+		require.NoError(t, err)
+		defer func() { _ = rows.Close() }()
+		n := 0
+		for rows.Next() {
+			n++
+		}
+		assert.Equal(t, 10, n, "rows up to the limit are read")
+		assert.ErrorIs(t, rows.Err(), ErrMaxRowsExceeded)
+	})
+	t.Run("StreamRows", func(t *testing.T) {
+		session := newMaxRowsSession(t, MySQL, 10, 100)
+		n := 0
+		err := StreamRows(ctx, session, "select uid from argo_archived_workflows", func(*sql.Rows) error {
+			n++
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 100, n, "streamed rows are not limited")
+	})
+}
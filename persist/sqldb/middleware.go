@@ -0,0 +1,15 @@
+package sqldb
+
+import "github.com/upper/db/v4"
+
+// SessionMiddleware wraps the session that CreateDBSession returns, e.g. to trace or audit queries, without forking
+// this package
+type SessionMiddleware func(db.Session) db.Session
+
+// applyMiddleware wraps the session with each middleware in order, so the last is the outermost
+func applyMiddleware(session db.Session, middleware ...SessionMiddleware) db.Session {
+	for _, m := range middleware {
+		session = m(session)
+	}
+	return session
+}
@@ -0,0 +1,57 @@
+package sqldb
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/upper/db/v4"
+)
+
+// observedSession records the queries executed through SQL()
+type observedSession struct {
+	db.Session
+	name    string
+	queries *[]string
+}
+
+func (s *observedSession) SQL() db.SQL {
+	return &observedSQL{SQL: s.Session.SQL(), session: s}
+}
+
+type observedSQL struct {
+	db.SQL
+	session *observedSession
+}
+
+func (s *observedSQL) Exec(query interface{}, args ...interface{}) (sql.Result, error) {
+	*s.session.queries = append(*s.session.queries, s.session.name+": "+query.(string))
+	return s.SQL.Exec(query, args...)
+}
+
+func Test_applyMiddleware(t *testing.T) {
+	connector := &fakeConnector{dbType: Postgres}
+	session := newFakeSession(t, connector)
+	var queries []string
+	observe := func(name string) SessionMiddleware {
+		return func(session db.Session) db.Session {
+			return &observedSession{Session: session, name: name, queries: &queries}
+		}
+	}
+
+	t.Run("None", func(t *testing.T) {
+		assert.Same(t, session, applyMiddleware(session))
+	})
+	t.Run("InOrder", func(t *testing.T) {
+		wrapped := applyMiddleware(session, observe("first"), observe("second"))
+		outer, ok := wrapped.(*observedSession)
+		require.True(t, ok)
+		assert.Equal(t, "second", outer.name)
+		assert.Equal(t, "first", outer.Session.(*observedSession).name)
+		_, err := wrapped.SQL().Exec("select 1")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"second: select 1", "first: select 1"}, queries)
+		assert.Contains(t, connector.Statements(), "select 1")
+	})
+}
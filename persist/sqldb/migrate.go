@@ -11,14 +11,15 @@ type Migrate interface {
 	Exec(ctx context.Context) error
 }
 
-func NewMigrate(session db.Session, clusterName string, tableName string) Migrate {
-	return migrate{session, clusterName, tableName}
+func NewMigrate(session db.Session, clusterName string, tableName string, opts ...MaintenanceOption) Migrate {
+	return migrate{session, clusterName, tableName, newMaintenanceOptions(opts)}
 }
 
 type migrate struct {
 	session     db.Session
 	clusterName string
 	tableName   string
+	maintenanceOptions
 }
 
 type change interface {
@@ -40,6 +41,12 @@ func (m migrate) Exec(ctx context.Context) (err error) {
 		if err != nil {
 			return err
 		}
+		if m.replicationSafe && dbTypeFor(m.session) == Postgres {
+			_, err = m.session.SQL().Exec("alter table schema_history replica identity full")
+			if err != nil {
+				return err
+			}
+		}
 		rs, err := m.session.SQL().Query("select schema_version from schema_history")
 		if err != nil {
 			return err
@@ -61,7 +68,7 @@ func (m migrate) Exec(ctx context.Context) (err error) {
 	}
 	dbType := dbTypeFor(m.session)
 
-	log.WithFields(log.Fields{"clusterName": m.clusterName, "dbType": dbType}).Info("Migrating database schema")
+	logger().WithFields(log.Fields{"clusterName": m.clusterName, "dbType": dbType}).Info("Migrating database schema")
 
 	// try and make changes idempotent, as it is possible for the change to apply, but the archive update to fail
 	// and therefore try and apply again next try
@@ -280,7 +287,7 @@ func (m migrate) applyChange(changeSchemaVersion int, c change) error {
 			return err
 		}
 		if rowsAffected == 1 {
-			log.WithFields(log.Fields{"changeSchemaVersion": changeSchemaVersion, "change": c}).Info("applying database change")
+			logger().WithFields(log.Fields{"changeSchemaVersion": changeSchemaVersion, "change": c}).Info("applying database change")
 			err := c.apply(m.session)
 			if err != nil {
 				return err
@@ -0,0 +1,67 @@
+package sqldb
+
+import (
+	"github.com/upper/db/v4"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+	"github.com/argoproj/argo-workflows/v3/util"
+)
+
+// MigrationSession returns the session to run migrations with. If migration credentials are configured, this is a
+// new session using them, which is closed by the returned func, so that the privileged user is only connected for
+// as long as the migrations take. Otherwise, it is the runtime session, which is left open.
+func MigrationSession(kubectlConfig kubernetes.Interface, namespace string, persistConfig *config.PersistConfig, session db.Session) (db.Session, func(), error) {
+	persistConfig, err := ResolveBackend(WithEnvOverrides(persistConfig))
+	if err != nil {
+		return nil, nil, err
+	}
+	migrationConfig := migrationPersistConfig(persistConfig)
+	if migrationConfig == nil {
+		return session, func() {}, nil
+	}
+	// only the controller runs the migrations
+	migrationSession, err := newDBSession(kubectlConfig, namespace, ComponentController, migrationConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	return migrationSession, func() { util.Close(migrationSession) }, nil
+}
+
+// migrationPersistConfig returns a copy of the config that uses the migration credentials, or nil if there are none
+func migrationPersistConfig(persistConfig *config.PersistConfig) *config.PersistConfig {
+	withMigrationCredentials := func(cfg config.DatabaseConfig) (config.DatabaseConfig, bool) {
+		if cfg.MigrationUsernameSecret.Name == "" || cfg.MigrationPasswordSecret.Name == "" {
+			return cfg, false
+		}
+		cfg.UsernameSecret = cfg.MigrationUsernameSecret
+		cfg.PasswordSecret = cfg.MigrationPasswordSecret
+		cfg.ExecCredential = nil
+		return cfg, true
+	}
+	c := *persistConfig
+	// migrations are run one at a time, so a single connection is enough
+	c.ConnectionPool = &config.ConnectionPool{MaxOpenConns: 1, MaxIdleConns: 1}
+	if c.PostgreSQL != nil {
+		postgreSQL := *c.PostgreSQL
+		cfg, ok := withMigrationCredentials(postgreSQL.DatabaseConfig)
+		if !ok {
+			return nil
+		}
+		postgreSQL.DatabaseConfig = cfg
+		// the migration credentials are a password, even if the runtime user authenticates with Kerberos
+		postgreSQL.AuthMode = ""
+		c.PostgreSQL = &postgreSQL
+	} else if c.MySQL != nil {
+		mySQL := *c.MySQL
+		cfg, ok := withMigrationCredentials(mySQL.DatabaseConfig)
+		if !ok {
+			return nil
+		}
+		mySQL.DatabaseConfig = cfg
+		c.MySQL = &mySQL
+	} else {
+		return nil
+	}
+	return &c
+}
@@ -0,0 +1,63 @@
+package sqldb
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+func Test_migrationPersistConfig(t *testing.T) {
+	secret := func(key string) apiv1.SecretKeySelector {
+		return apiv1.SecretKeySelector{LocalObjectReference: apiv1.LocalObjectReference{Name: "argo-postgres-config"}, Key: key}
+	}
+	runtimeConfig := config.DatabaseConfig{
+		Host:                    "postgres",
+		UsernameSecret:          secret("username"),
+		PasswordSecret:          secret("password"),
+		MigrationUsernameSecret: secret("migration-username"),
+		MigrationPasswordSecret: secret("migration-password"),
+	}
+	t.Run("Postgres", func(t *testing.T) {
+		persistConfig := &config.PersistConfig{
+			PostgreSQL:     &config.PostgreSQLConfig{DatabaseConfig: runtimeConfig, SSLMode: "require"},
+			ConnectionPool: &config.ConnectionPool{MaxOpenConns: 100},
+		}
+		c := migrationPersistConfig(persistConfig)
+		require.NotNil(t, c)
+		assert.Equal(t, secret("migration-username"), c.PostgreSQL.UsernameSecret)
+		assert.Equal(t, secret("migration-password"), c.PostgreSQL.PasswordSecret)
+		assert.Equal(t, "postgres", c.PostgreSQL.Host)
+		assert.Equal(t, "require", c.PostgreSQL.SSLMode)
+		assert.Equal(t, 1, c.ConnectionPool.MaxOpenConns)
+		// the runtime config is unchanged
+		assert.Equal(t, secret("username"), persistConfig.PostgreSQL.UsernameSecret)
+		assert.Equal(t, secret("password"), persistConfig.PostgreSQL.PasswordSecret)
+		assert.Equal(t, 100, persistConfig.ConnectionPool.MaxOpenConns)
+	})
+	t.Run("MySQL", func(t *testing.T) {
+		c := migrationPersistConfig(&config.PersistConfig{MySQL: &config.MySQLConfig{DatabaseConfig: runtimeConfig}})
+		require.NotNil(t, c)
+		assert.Equal(t, secret("migration-username"), c.MySQL.UsernameSecret)
+		assert.Equal(t, secret("migration-password"), c.MySQL.PasswordSecret)
+	})
+	t.Run("NoMigrationCredentials", func(t *testing.T) {
+		cfg := runtimeConfig
+		cfg.MigrationPasswordSecret = apiv1.SecretKeySelector{}
+		assert.Nil(t, migrationPersistConfig(&config.PersistConfig{PostgreSQL: &config.PostgreSQLConfig{DatabaseConfig: cfg}}))
+	})
+}
+
+func TestMigrationSession(t *testing.T) {
+	session := newFakeSession(t, &fakeConnector{dbType: Postgres})
+	migrationSession, closeSession, err := MigrationSession(nil, "argo", &config.PersistConfig{PostgreSQL: &config.PostgreSQLConfig{}}, session)
+	require.NoError(t, err)
+	closeSession()
+	assert.Same(t, session, migrationSession)
+	// the runtime session is not closed
+	_, err = session.SQL().Exec("select 1")
+	assert.NoError(t, err)
+}
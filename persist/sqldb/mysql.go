@@ -0,0 +1,254 @@
+package sqldb
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql/driver"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	log "github.com/sirupsen/logrus"
+	"github.com/upper/db/v4"
+	mysqladp "github.com/upper/db/v4/adapter/mysql"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+// mySQLConfig builds the driver configuration for the settings
+func mySQLConfig(settings mysqladp.ConnectionURL, cfg *config.MySQLConfig) (*mysql.Config, error) {
+	mysqlConfig, err := mysql.ParseDSN(settings.String())
+	if err != nil {
+		return nil, err
+	}
+	if cfg.DisablePreparedStatements {
+		mysqlConfig.InterpolateParams = true
+	}
+	return mysqlConfig, nil
+}
+
+// mySQLOption configures the driver beyond what the settings can express
+type mySQLOption func(*mysql.Config)
+
+// withMySQLDialer connects using the dialer
+func withMySQLDialer(dialer *dbDialer) mySQLOption {
+	return func(mysqlConfig *mysql.Config) {
+		mysqlConfig.Net = registerMySQLDialer(dialer)
+	}
+}
+
+// withMySQLRootCAs verifies the server's certificate using the pool rather than the system roots, enabling TLS if the
+// options do not. It has no effect if the options skip verification.
+func withMySQLRootCAs(pool *x509.CertPool) mySQLOption {
+	return func(mysqlConfig *mysql.Config) {
+		if mysqlConfig.TLS == nil {
+			host, _, _ := net.SplitHostPort(mysqlConfig.Addr)
+			mysqlConfig.TLS = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+		}
+		mysqlConfig.TLS.RootCAs = pool
+	}
+}
+
+// mySQLConnector returns the driver's connector for the config. It is a variable so that tests can fake the database.
+var mySQLConnector = func(mysqlConfig *mysql.Config) (driver.Connector, error) {
+	return mysql.NewConnector(mysqlConfig)
+}
+
+// openMySQL opens the session using a driver connector rather than via mysqladp.Open, so that connections can be
+// initialized
+func openMySQL(settings mysqladp.ConnectionURL, cfg *config.MySQLConfig, persistPool *config.ConnectionPool, features sessionFeatures, opts ...mySQLOption) (db.Session, error) {
+	mysqlConfig, err := mySQLConfig(settings, cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, opt := range opts {
+		opt(mysqlConfig)
+	}
+	provider, err := newCredentialProvider(context.Background(), cfg.DatabaseConfig)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.IAMAuth != "" {
+		// IAM auth tokens are sent using the cleartext plugin, so the connection must use TLS
+		mysqlConfig.AllowCleartextPasswords = true
+	}
+	logConnectionParameters(func() log.Fields { return mySQLConnectionParameters(mysqlConfig) })
+	connector, err := mySQLConnector(mysqlConfig)
+	if err != nil {
+		return nil, err
+	}
+	connector = newCredentialConnector(connector, provider, Credentials{Username: mysqlConfig.User, Password: mysqlConfig.Passwd}, func(creds Credentials) (driver.Connector, error) {
+		c := mysqlConfig.Clone()
+		c.User = creds.Username
+		c.Passwd = creds.Password
+		return mySQLConnector(c)
+	})
+	connector, err = newConnectRateLimitConnector(connector, persistPool)
+	if err != nil {
+		return nil, err
+	}
+	statements, err := connInitStatements(MySQL, cfg.DatabaseConfig)
+	if err != nil {
+		return nil, err
+	}
+	connector = newCancelGraceConnector(&killQueryConnector{Connector: connector}, time.Duration(cfg.CancelGracePeriod))
+	init := append(mySQLConnInits(statements, mysqlConfig.MultiStatements), warmupInits(cfg.DatabaseConfig)...)
+	connector, err = newRecycleConnector(newSchemaDriftConnector(newInitConnector(connector, init...), persistPool), persistPool)
+	if err != nil {
+		return nil, err
+	}
+	connector, err = newConcurrencyLimitConnector(newMaxRowsConnector(connector, cfg.MaxRows), persistPool)
+	if err != nil {
+		return nil, err
+	}
+	session, err := openSession(MySQL, connector, features)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// mySQLConnInits returns the per-connection initialization that runs the statements, in a single round-trip if
+// multiStatements is enabled, as every new connection, e.g. during a reconnect storm, waits for it
+func mySQLConnInits(statements []string, multiStatements bool) []connInitFunc {
+	if len(statements) == 0 {
+		return nil
+	}
+	if multiStatements {
+		return []connInitFunc{execStatements(strings.Join(statements, "; "))}
+	}
+	return []connInitFunc{execStatements(statements...)}
+}
+
+// mySQLMultiStatements returns whether the options enable multiStatements, which the driver parses the same way
+func mySQLMultiStatements(options map[string]string) bool {
+	multiStatements, _ := strconv.ParseBool(options["multiStatements"])
+	return multiStatements
+}
+
+// defaultMySQLCharset is the character set that setMySQLCharset uses if the charset is not set
+const defaultMySQLCharset = "utf8mb4"
+
+// mySQLCharsets are the character sets that charset may be set to. utf8 (an alias of utf8mb3) cannot store every
+// character that utf8mb4 can, e.g. emoji, so is only for servers that do not support utf8mb4.
+var mySQLCharsets = map[string]bool{
+	"utf8mb4": true,
+	"utf8mb3": true,
+	"utf8":    true,
+}
+
+// mySQLCharset returns the character set of the config
+func mySQLCharset(cfg *config.MySQLConfig) string {
+	if cfg.Charset == "" {
+		return defaultMySQLCharset
+	}
+	return cfg.Charset
+}
+
+// validateCharset returns an error unless the charset is empty (i.e. utf8mb4) or allowed
+func validateCharset(charset string) error {
+	if charset != "" && !mySQLCharsets[charset] {
+		return fmt.Errorf("charset must be one of: utf8mb4, utf8mb3, utf8")
+	}
+	return nil
+}
+
+// mySQLCollations are the collations that collationConnection may be set to, they must also be for the character
+// set that setMySQLCharset uses
+var mySQLCollations = map[string]bool{
+	"utf8mb4_0900_ai_ci":     true,
+	"utf8mb4_0900_as_ci":     true,
+	"utf8mb4_0900_as_cs":     true,
+	"utf8mb4_0900_bin":       true,
+	"utf8mb4_bin":            true,
+	"utf8mb4_general_ci":     true,
+	"utf8mb4_unicode_520_ci": true,
+	"utf8mb4_unicode_ci":     true,
+	"utf8mb3_bin":            true,
+	"utf8mb3_general_ci":     true,
+	"utf8mb3_unicode_ci":     true,
+	"utf8_bin":               true,
+	"utf8_general_ci":        true,
+	"utf8_unicode_ci":        true,
+}
+
+// validateCollation returns an error unless the collation is empty (i.e. the charset's default) or allowed for the
+// charset
+func validateCollation(charset, collation string) error {
+	if collation == "" {
+		return nil
+	}
+	if !mySQLCollations[collation] {
+		return fmt.Errorf("unsupported collationConnection %q", collation)
+	}
+	if !strings.HasPrefix(collation, charset+"_") {
+		return fmt.Errorf("collationConnection %q is not for the %s character set", collation, charset)
+	}
+	return nil
+}
+
+const (
+	CharsetInitModeStrict = "strict"
+	CharsetInitModeWarn   = "warn"
+	CharsetInitModeSkip   = "skip"
+)
+
+// validateCharsetInitMode returns an error unless the mode is empty (i.e. strict) or known
+func validateCharsetInitMode(mode string) error {
+	switch mode {
+	case "", CharsetInitModeStrict, CharsetInitModeWarn, CharsetInitModeSkip:
+		return nil
+	}
+	return fmt.Errorf("charsetInitMode must be one of: strict, warn, skip")
+}
+
+// setMySQLCharset sets the character set, and optionally the collation, of the session. Some proxies reject the
+// character set statements, so depending on the charsetInitMode their failure may be logged, or they may not be run.
+func setMySQLCharset(session db.Session, cfg *config.MySQLConfig) error {
+	charset := mySQLCharset(cfg)
+	// the charset is interpolated, as SET NAMES cannot have a parameter, so it must be validated first
+	if err := validateCharset(cfg.Charset); err != nil {
+		return err
+	}
+	var statements []string
+	if cfg.CharsetInitMode != CharsetInitModeSkip {
+		// this is needed to make MySQL run in a Golang-compatible UTF-8 character set.
+		statements = []string{"SET NAMES '" + charset + "'", "SET CHARACTER SET " + charset}
+	}
+	if cfg.CollationConnection != "" {
+		if err := validateCollation(charset, cfg.CollationConnection); err != nil {
+			return err
+		}
+	}
+	// a batch fails as a whole, so the statements are only batched if any failure is fatal
+	if mySQLMultiStatements(cfg.Options) && cfg.CharsetInitMode != CharsetInitModeWarn {
+		if cfg.CollationConnection != "" {
+			// after SET NAMES, as below. It is validated, so can be interpolated, as a batch cannot have parameters
+			statements = append(statements, "SET collation_connection = '"+cfg.CollationConnection+"'")
+		}
+		if len(statements) == 0 {
+			return nil
+		}
+		_, err := session.SQL().Exec(strings.Join(statements, "; "))
+		return err
+	}
+	for _, statement := range statements {
+		if _, err := session.SQL().Exec(statement); err != nil {
+			if cfg.CharsetInitMode != CharsetInitModeWarn {
+				return err
+			}
+			logger().WithField("statement", statement).WithError(err).Warn("Failed to set the character set, continuing as the charsetInitMode is warn")
+		}
+	}
+	if cfg.CollationConnection != "" {
+		// this must be after SET NAMES, which sets the collation to the character set's default
+		if _, err := session.SQL().Exec("SET collation_connection = ?", cfg.CollationConnection); err != nil {
+			return err
+		}
+	}
+	return nil
+}
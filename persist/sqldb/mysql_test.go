@@ -0,0 +1,170 @@
+package sqldb
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	mysqladp "github.com/upper/db/v4/adapter/mysql"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+func Test_mySQLConfig(t *testing.T) {
+	settings := mysqladp.ConnectionURL{User: "my-user", Host: "my-host:3306", Database: "my-db"}
+	t.Run("PreparedStatementsByDefault", func(t *testing.T) {
+		mysqlConfig, err := mySQLConfig(settings, &config.MySQLConfig{})
+		require.NoError(t, err)
+		assert.False(t, mysqlConfig.InterpolateParams)
+	})
+	t.Run("DisablePreparedStatements", func(t *testing.T) {
+		cfg := &config.MySQLConfig{}
+		cfg.DisablePreparedStatements = true
+		mysqlConfig, err := mySQLConfig(settings, cfg)
+		require.NoError(t, err)
+		assert.True(t, mysqlConfig.InterpolateParams)
+	})
+}
+
+func Test_setMySQLCharset(t *testing.T) {
+	t.Run("Default", func(t *testing.T) {
+		connector := &fakeConnector{dbType: MySQL}
+		require.NoError(t, setMySQLCharset(newFakeSession(t, connector), &config.MySQLConfig{}))
+		assert.NotContains(t, connector.Statements(), "SET collation_connection = ?")
+		assert.Equal(t, "SET CHARACTER SET utf8mb4", connector.Statements()[len(connector.Statements())-1])
+	})
+	t.Run("CollationConnection", func(t *testing.T) {
+		var collation interface{}
+		connector := &fakeConnector{dbType: MySQL, handler: func(query string, args []driver.NamedValue) (*fakeResult, error) {
+			if query == "SET collation_connection = ?" {
+				collation = args[0].Value
+			}
+			return &fakeResult{}, nil
+		}}
+		require.NoError(t, setMySQLCharset(newFakeSession(t, connector), &config.MySQLConfig{CollationConnection: "utf8mb4_unicode_ci"}))
+		statements := connector.Statements()
+		assert.Equal(t, []string{"SET NAMES 'utf8mb4'", "SET CHARACTER SET utf8mb4", "SET collation_connection = ?"}, statements[len(statements)-3:])
+		assert.Equal(t, "utf8mb4_unicode_ci", collation)
+	})
+	t.Run("Charset", func(t *testing.T) {
+		var collation interface{}
+		connector := &fakeConnector{dbType: MySQL, handler: func(query string, args []driver.NamedValue) (*fakeResult, error) {
+			if query == "SET collation_connection = ?" {
+				collation = args[0].Value
+			}
+			return &fakeResult{}, nil
+		}}
+		require.NoError(t, setMySQLCharset(newFakeSession(t, connector), &config.MySQLConfig{Charset: "utf8", CollationConnection: "utf8_unicode_ci"}))
+		statements := connector.Statements()
+		assert.Equal(t, []string{"SET NAMES 'utf8'", "SET CHARACTER SET utf8", "SET collation_connection = ?"}, statements[len(statements)-3:])
+		assert.Equal(t, "utf8_unicode_ci", collation)
+		assert.NotContains(t, statements, "SET NAMES 'utf8mb4'")
+	})
+	t.Run("InvalidCharset", func(t *testing.T) {
+		connector := &fakeConnector{dbType: MySQL}
+		err := setMySQLCharset(newFakeSession(t, connector), &config.MySQLConfig{Charset: "latin1'; drop table argo_workflows; --"})
+		assert.EqualError(t, err, "charset must be one of: utf8mb4, utf8mb3, utf8")
+		for _, statement := range connector.Statements() {
+			assert.NotContains(t, statement, "SET NAMES")
+		}
+	})
+	t.Run("CollationOfAnotherCharset", func(t *testing.T) {
+		connector := &fakeConnector{dbType: MySQL}
+		err := setMySQLCharset(newFakeSession(t, connector), &config.MySQLConfig{Charset: "utf8mb3", CollationConnection: "utf8mb4_bin"})
+		assert.EqualError(t, err, `collationConnection "utf8mb4_bin" is not for the utf8mb3 character set`)
+		assert.NotContains(t, connector.Statements(), "SET collation_connection = ?")
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		connector := &fakeConnector{dbType: MySQL}
+		err := setMySQLCharset(newFakeSession(t, connector), &config.MySQLConfig{CollationConnection: "latin1_swedish_ci'; drop table argo_workflows; --"})
+		assert.EqualError(t, err, `unsupported collationConnection "latin1_swedish_ci'; drop table argo_workflows; --"`)
+		assert.NotContains(t, connector.Statements(), "SET collation_connection = ?")
+	})
+	t.Run("CharsetInitMode", func(t *testing.T) {
+		// a proxy that rejects the character set statements
+		rejecting := func() *fakeConnector {
+			return &fakeConnector{dbType: MySQL, handler: func(query string, _ []driver.NamedValue) (*fakeResult, error) {
+				if strings.HasPrefix(query, "SET NAMES") || strings.HasPrefix(query, "SET CHARACTER SET") {
+					return nil, errors.New("Error 1227: Access denied")
+				}
+				return &fakeResult{}, nil
+			}}
+		}
+		t.Run("Strict", func(t *testing.T) {
+			for _, mode := range []string{"", CharsetInitModeStrict} {
+				connector := rejecting()
+				err := setMySQLCharset(newFakeSession(t, connector), &config.MySQLConfig{CollationConnection: "utf8mb4_bin", CharsetInitMode: mode})
+				assert.EqualError(t, err, "Error 1227: Access denied")
+				assert.NotContains(t, connector.Statements(), "SET collation_connection = ?")
+			}
+		})
+		t.Run("Warn", func(t *testing.T) {
+			connector := rejecting()
+			require.NoError(t, setMySQLCharset(newFakeSession(t, connector), &config.MySQLConfig{CollationConnection: "utf8mb4_bin", CharsetInitMode: CharsetInitModeWarn}))
+			statements := connector.Statements()
+			assert.Equal(t, []string{"SET NAMES 'utf8mb4'", "SET CHARACTER SET utf8mb4", "SET collation_connection = ?"}, statements[len(statements)-3:])
+		})
+		t.Run("Skip", func(t *testing.T) {
+			connector := rejecting()
+			require.NoError(t, setMySQLCharset(newFakeSession(t, connector), &config.MySQLConfig{CharsetInitMode: CharsetInitModeSkip}))
+			assert.NotContains(t, connector.Statements(), "SET NAMES 'utf8mb4'")
+			assert.NotContains(t, connector.Statements(), "SET CHARACTER SET utf8mb4")
+		})
+		t.Run("Invalid", func(t *testing.T) {
+			assert.EqualError(t, validateCharsetInitMode("lenient"), "charsetInitMode must be one of: strict, warn, skip")
+		})
+	})
+	t.Run("MultiStatements", func(t *testing.T) {
+		options := map[string]string{"multiStatements": "true"}
+		t.Run("Batched", func(t *testing.T) {
+			connector := &fakeConnector{dbType: MySQL}
+			require.NoError(t, setMySQLCharset(newFakeSession(t, connector), &config.MySQLConfig{CollationConnection: "utf8mb4_bin", Options: options}))
+			statements := connector.Statements()
+			assert.Equal(t, "SET NAMES 'utf8mb4'; SET CHARACTER SET utf8mb4; SET collation_connection = 'utf8mb4_bin'", statements[len(statements)-1])
+			assert.NotContains(t, statements, "SET NAMES 'utf8mb4'")
+		})
+		t.Run("Warn", func(t *testing.T) {
+			connector := &fakeConnector{dbType: MySQL}
+			require.NoError(t, setMySQLCharset(newFakeSession(t, connector), &config.MySQLConfig{CollationConnection: "utf8mb4_bin", CharsetInitMode: CharsetInitModeWarn, Options: options}))
+			statements := connector.Statements()
+			assert.Equal(t, []string{"SET NAMES 'utf8mb4'", "SET CHARACTER SET utf8mb4", "SET collation_connection = ?"}, statements[len(statements)-3:])
+		})
+		t.Run("Disabled", func(t *testing.T) {
+			connector := &fakeConnector{dbType: MySQL}
+			require.NoError(t, setMySQLCharset(newFakeSession(t, connector), &config.MySQLConfig{Options: map[string]string{"multiStatements": "false"}}))
+			statements := connector.Statements()
+			assert.Equal(t, []string{"SET NAMES 'utf8mb4'", "SET CHARACTER SET utf8mb4"}, statements[len(statements)-2:])
+		})
+	})
+}
+
+func Test_mySQLConnInits(t *testing.T) {
+	statements := []string{"set session innodb_lock_wait_timeout = 5", "set session max_execution_time = 30000"}
+	t.Run("Batched", func(t *testing.T) {
+		connector := &fakeConnector{dbType: MySQL}
+		db := sql.OpenDB(newInitConnector(connector, mySQLConnInits(statements, true)...))
+		defer func() { _ = db.Close() }()
+		require.NoError(t, db.Ping())
+		assert.Equal(t, []string{"set session innodb_lock_wait_timeout = 5; set session max_execution_time = 30000"}, connector.Statements())
+	})
+	t.Run("Sequential", func(t *testing.T) {
+		connector := &fakeConnector{dbType: MySQL}
+		db := sql.OpenDB(newInitConnector(connector, mySQLConnInits(statements, false)...))
+		defer func() { _ = db.Close() }()
+		require.NoError(t, db.Ping())
+		assert.Equal(t, statements, connector.Statements())
+	})
+	t.Run("None", func(t *testing.T) {
+		assert.Empty(t, mySQLConnInits(nil, true))
+	})
+	t.Run("Options", func(t *testing.T) {
+		assert.True(t, mySQLMultiStatements(map[string]string{"multiStatements": "true"}))
+		assert.True(t, mySQLMultiStatements(map[string]string{"multiStatements": "1"}))
+		assert.False(t, mySQLMultiStatements(map[string]string{"multiStatements": "false"}))
+		assert.False(t, mySQLMultiStatements(nil))
+	})
+}
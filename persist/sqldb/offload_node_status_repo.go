@@ -1,10 +1,12 @@
 package sqldb
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"hash/fnv"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
@@ -31,11 +33,17 @@ type OffloadNodeStatusRepo interface {
 }
 
 func NewOffloadNodeStatusRepo(session db.Session, clusterName, tableName string) (OffloadNodeStatusRepo, error) {
+	return NewSplitOffloadNodeStatusRepo(NewSplitSession(session, session, nil), clusterName, tableName)
+}
+
+// NewSplitOffloadNodeStatusRepo returns a repo that saves and deletes offloads using the writer, and gets and lists
+// them using the reader, once it has replayed the repo's last save
+func NewSplitOffloadNodeStatusRepo(sessions *SplitSession, clusterName, tableName string) (OffloadNodeStatusRepo, error) {
 	// this environment variable allows you to make Argo Workflows delete offloaded data more or less aggressively,
 	// useful for testing
 	ttl := env.LookupEnvDurationOr("OFFLOAD_NODE_STATUS_TTL", 5*time.Minute)
-	log.WithField("ttl", ttl).Debug("Node status offloading config")
-	return &nodeOffloadRepo{session: session, clusterName: clusterName, tableName: tableName, ttl: ttl}, nil
+	logger().WithField("ttl", ttl).Debug("Node status offloading config")
+	return &nodeOffloadRepo{sessions: sessions, clusterName: clusterName, tableName: tableName, ttl: ttl}, nil
 }
 
 type nodesRecord struct {
@@ -46,11 +54,16 @@ type nodesRecord struct {
 }
 
 type nodeOffloadRepo struct {
-	session     db.Session
+	sessions    *SplitSession
 	clusterName string
 	tableName   string
 	// time to live - at what ttl an offload becomes old
 	ttl time.Duration
+	// lastSave is the writer's position after the last save, that reads wait for the reader to reach, so that a
+	// workflow's nodes can be got as soon as they are saved
+	mu       sync.Mutex
+	saved    bool
+	lastSave ConsistencyToken
 }
 
 func (wdc *nodeOffloadRepo) IsEnabled() bool {
@@ -84,41 +97,62 @@ func (wdc *nodeOffloadRepo) Save(uid, namespace string, nodes wfv1.Nodes) (strin
 		Nodes:     marshalled,
 	}
 
-	logCtx := log.WithFields(log.Fields{"uid": uid, "version": version})
+	logCtx := logger().WithFields(log.Fields{"uid": uid, "version": version})
 	logCtx.Debug("Offloading nodes")
-	_, err = wdc.session.Collection(wdc.tableName).Insert(record)
-	if err != nil {
-		// if we have a duplicate, then it must have the same clustername+uid+version, which MUST mean that we
-		// have already written this record
-		if !isDuplicateKeyError(err) {
-			return "", err
+	token, err := wdc.sessions.WriteWithToken(context.Background(), func(session db.Session) error {
+		_, err := session.Collection(wdc.tableName).Insert(record)
+		if err != nil {
+			// if we have a duplicate, then it must have the same clustername+uid+version, which MUST mean that we
+			// have already written this record
+			if !isDuplicateKeyError(err) {
+				return err
+			}
+			logCtx.WithField("err", err).Info("Ignoring duplicate key error")
 		}
-		logCtx.WithField("err", err).Info("Ignoring duplicate key error")
-	}
 
-	logCtx.Debug("Nodes offloaded, cleaning up old offloads")
-
-	// This might fail, which kind of fine (maybe a bug).
-	// It might not delete all records, which is also fine, as we always key on resource version.
-	// We also want to keep enough around so that we can service watches.
-	rs, err := wdc.session.SQL().
-		DeleteFrom(wdc.tableName).
-		Where(db.Cond{"clustername": wdc.clusterName}).
-		And(db.Cond{"uid": uid}).
-		And(db.Cond{"version <>": version}).
-		And(wdc.oldOffload()).
-		Exec()
-	if err != nil {
-		return "", err
-	}
-	rowsAffected, err := rs.RowsAffected()
+		logCtx.Debug("Nodes offloaded, cleaning up old offloads")
+
+		// This might fail, which kind of fine (maybe a bug).
+		// It might not delete all records, which is also fine, as we always key on resource version.
+		// We also want to keep enough around so that we can service watches.
+		rs, err := session.SQL().
+			DeleteFrom(wdc.tableName).
+			Where(db.Cond{"clustername": wdc.clusterName}).
+			And(db.Cond{"uid": uid}).
+			And(db.Cond{"version <>": version}).
+			And(wdc.oldOffload()).
+			Exec()
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := rs.RowsAffected()
+		if err != nil {
+			return err
+		}
+		logCtx.WithField("rowsAffected", rowsAffected).Debug("Deleted offloaded nodes")
+		return nil
+	})
 	if err != nil {
 		return "", err
 	}
-	logCtx.WithField("rowsAffected", rowsAffected).Debug("Deleted offloaded nodes")
+	wdc.mu.Lock()
+	wdc.saved, wdc.lastSave = true, token
+	wdc.mu.Unlock()
 	return version, nil
 }
 
+// read runs fn against the reader, once it has replayed the last save, if there has been one, e.g. the Argo Server
+// only reads
+func (wdc *nodeOffloadRepo) read(fn func(session db.Session) error) error {
+	wdc.mu.Lock()
+	saved, token := wdc.saved, wdc.lastSave
+	wdc.mu.Unlock()
+	if !saved {
+		return wdc.sessions.Read(fn)
+	}
+	return wdc.sessions.ReadAfter(context.Background(), token, fn)
+}
+
 func isDuplicateKeyError(err error) bool {
 	// postgres
 	if strings.Contains(err.Error(), "duplicate key") {
@@ -132,14 +166,16 @@ func isDuplicateKeyError(err error) bool {
 }
 
 func (wdc *nodeOffloadRepo) Get(uid, version string) (wfv1.Nodes, error) {
-	log.WithFields(log.Fields{"uid": uid, "version": version}).Debug("Getting offloaded nodes")
+	logger().WithFields(log.Fields{"uid": uid, "version": version}).Debug("Getting offloaded nodes")
 	r := &nodesRecord{}
-	err := wdc.session.SQL().
-		SelectFrom(wdc.tableName).
-		Where(db.Cond{"clustername": wdc.clusterName}).
-		And(db.Cond{"uid": uid}).
-		And(db.Cond{"version": version}).
-		One(r)
+	err := wdc.read(func(session db.Session) error {
+		return session.SQL().
+			SelectFrom(wdc.tableName).
+			Where(db.Cond{"clustername": wdc.clusterName}).
+			And(db.Cond{"uid": uid}).
+			And(db.Cond{"version": version}).
+			One(r)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -152,14 +188,16 @@ func (wdc *nodeOffloadRepo) Get(uid, version string) (wfv1.Nodes, error) {
 }
 
 func (wdc *nodeOffloadRepo) List(namespace string) (map[UUIDVersion]wfv1.Nodes, error) {
-	log.WithFields(log.Fields{"namespace": namespace}).Debug("Listing offloaded nodes")
+	logger().WithFields(log.Fields{"namespace": namespace}).Debug("Listing offloaded nodes")
 	var records []nodesRecord
-	err := wdc.session.SQL().
-		Select("uid", "version", "nodes").
-		From(wdc.tableName).
-		Where(db.Cond{"clustername": wdc.clusterName}).
-		And(namespaceEqual(namespace)).
-		All(&records)
+	err := wdc.read(func(session db.Session) error {
+		return session.SQL().
+			Select("uid", "version", "nodes").
+			From(wdc.tableName).
+			Where(db.Cond{"clustername": wdc.clusterName}).
+			And(namespaceEqual(namespace)).
+			All(&records)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -178,15 +216,17 @@ func (wdc *nodeOffloadRepo) List(namespace string) (map[UUIDVersion]wfv1.Nodes,
 }
 
 func (wdc *nodeOffloadRepo) ListOldOffloads(namespace string) (map[string][]string, error) {
-	log.WithFields(log.Fields{"namespace": namespace}).Debug("Listing old offloaded nodes")
+	logger().WithFields(log.Fields{"namespace": namespace}).Debug("Listing old offloaded nodes")
 	var records []UUIDVersion
-	err := wdc.session.SQL().
-		Select("uid", "version").
-		From(wdc.tableName).
-		Where(db.Cond{"clustername": wdc.clusterName}).
-		And(namespaceEqual(namespace)).
-		And(wdc.oldOffload()).
-		All(&records)
+	err := wdc.read(func(session db.Session) error {
+		return session.SQL().
+			Select("uid", "version").
+			From(wdc.tableName).
+			Where(db.Cond{"clustername": wdc.clusterName}).
+			And(namespaceEqual(namespace)).
+			And(wdc.oldOffload()).
+			All(&records)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -204,23 +244,25 @@ func (wdc *nodeOffloadRepo) Delete(uid, version string) error {
 	if version == "" {
 		return fmt.Errorf("invalid version")
 	}
-	logCtx := log.WithFields(log.Fields{"uid": uid, "version": version})
+	logCtx := logger().WithFields(log.Fields{"uid": uid, "version": version})
 	logCtx.Debug("Deleting offloaded nodes")
-	rs, err := wdc.session.SQL().
-		DeleteFrom(wdc.tableName).
-		Where(db.Cond{"clustername": wdc.clusterName}).
-		And(db.Cond{"uid": uid}).
-		And(db.Cond{"version": version}).
-		Exec()
-	if err != nil {
-		return err
-	}
-	rowsAffected, err := rs.RowsAffected()
-	if err != nil {
-		return err
-	}
-	logCtx.WithField("rowsAffected", rowsAffected).Debug("Deleted offloaded nodes")
-	return nil
+	return wdc.sessions.Write(func(session db.Session) error {
+		rs, err := session.SQL().
+			DeleteFrom(wdc.tableName).
+			Where(db.Cond{"clustername": wdc.clusterName}).
+			And(db.Cond{"uid": uid}).
+			And(db.Cond{"version": version}).
+			Exec()
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := rs.RowsAffected()
+		if err != nil {
+			return err
+		}
+		logCtx.WithField("rowsAffected", rowsAffected).Debug("Deleted offloaded nodes")
+		return nil
+	})
 }
 
 func (wdc *nodeOffloadRepo) oldOffload() string {
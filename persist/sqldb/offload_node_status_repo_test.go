@@ -1,9 +1,14 @@
 package sqldb
 
 import (
+	"database/sql/driver"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/upper/db/v4"
 
 	wfv1 "github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
 )
@@ -24,3 +29,30 @@ func Test_nodeStatusVersion(t *testing.T) {
 		}
 	})
 }
+
+func Test_nodeOffloadRepo_split(t *testing.T) {
+	clock := useFakeClock(t)
+	writer := &fakeConnector{dbType: Postgres, handler: func(query string, _ []driver.NamedValue) (*fakeResult, error) {
+		if res := lookupNameResult(query); res != nil {
+			return res, nil
+		}
+		if strings.Contains(query, "pg_current_wal_lsn") {
+			return &fakeResult{columns: []string{"lsn"}, rows: [][]driver.Value{{"0/16B3740"}}}, nil
+		}
+		return &fakeResult{}, nil
+	}}
+	reader, polls := laggingReader(1)
+	repo, err := NewSplitOffloadNodeStatusRepo(NewSplitSession(newFakeSession(t, writer), newFakeSession(t, reader), nil), "default", "argo_workflows")
+	require.NoError(t, err)
+	_, err = repo.Get("my-uid", "fnv:784127654")
+	require.ErrorIs(t, err, db.ErrNoMoreRows)
+	assert.Equal(t, int32(0), polls.Load(), "without a save, there is nothing to wait for")
+	_, err = repo.Save("my-uid", "my-ns", wfv1.Nodes{})
+	require.NoError(t, err)
+	assert.True(t, slices.ContainsFunc(writer.Statements(), func(s string) bool { return strings.HasPrefix(s, "INSERT INTO") }), "the save is written to the writer")
+	_, err = repo.List("my-ns")
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), polls.Load(), "the list waits for the reader to replay the save")
+	assert.Len(t, clock.Sleeps(), 1)
+	assert.True(t, slices.ContainsFunc(reader.Statements(), func(s string) bool { return strings.HasPrefix(s, "SELECT") }), "the list is read from the reader")
+}
@@ -0,0 +1,96 @@
+package sqldb
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+// pgPassFilePassword returns the password from the first line of the .pgpass file that matches the config and user,
+// see https://www.postgresql.org/docs/current/libpq-pgpass.html. Like libpq, the file is not used unless it is a
+// regular file that only the owner can access. Unlike libpq, which then connects without a password, it is an error
+// if the file cannot be used or has no matching line, as the password secret is not set either.
+func pgPassFilePassword(cfg *config.PostgreSQLConfig, user string) (string, error) {
+	path := cfg.PgPassFile
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read pgPassFile: %w", err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("pgPassFile %s is not a regular file", path)
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		return "", fmt.Errorf("pgPassFile %s has group or world access (%s), permissions should be u=rw (0600) or less", path, info.Mode().Perm())
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read pgPassFile: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+	host, port := pgPassHostPort(cfg.GetHostname())
+	want := []string{host, port, cfg.Database, user}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := splitPgPassLine(line)
+		if len(fields) != 5 {
+			continue
+		}
+		if pgPassMatches(fields[:4], want) {
+			logger().WithFields(log.Fields{"pgPassFile": path, "host": host, "port": port, "user": user}).Debug("Using the password from pgPassFile")
+			return fields[4], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("failed to read pgPassFile: %w", err)
+	}
+	return "", fmt.Errorf("pgPassFile %s has no line that matches host %s, port %s, database %s and user %s", path, host, port, cfg.Database, user)
+}
+
+// pgPassHostPort splits the host and port, the port defaults to 5432, as it does for the driver
+func pgPassHostPort(hostname string) (string, string) {
+	host, port, err := net.SplitHostPort(hostname)
+	if err != nil {
+		return hostname, strconv.Itoa(5432)
+	}
+	return host, port
+}
+
+// splitPgPassLine splits the line on the colons that are not escaped with a backslash, and unescapes the fields
+func splitPgPassLine(line string) []string {
+	var fields []string
+	var field strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\' && i+1 < len(line):
+			i++
+			field.WriteByte(line[i])
+		case c == ':':
+			fields = append(fields, field.String())
+			field.Reset()
+		default:
+			field.WriteByte(c)
+		}
+	}
+	return append(fields, field.String())
+}
+
+// pgPassMatches returns whether every field is either the wildcard or the wanted value
+func pgPassMatches(fields, want []string) bool {
+	for i, field := range fields {
+		if field != "*" && field != want[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,67 @@
+package sqldb
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+func Test_pgPassFilePassword(t *testing.T) {
+	writePgPass := func(t *testing.T, content string, mode os.FileMode) string {
+		path := filepath.Join(t.TempDir(), ".pgpass")
+		require.NoError(t, os.WriteFile(path, []byte(content), mode))
+		require.NoError(t, os.Chmod(path, mode))
+		return path
+	}
+	const pgPass = `# comment
+other-host:5432:argo:argo:other-host-password
+db.internal:5433:argo:argo:other-port-password
+db.internal:5432:argo:admin:admin-password
+db.internal:5432:argo:argo:p\:ss\\word
+*:*:*:argo:wildcard-password
+`
+	newConfig := func(path, host string, port int) *config.PostgreSQLConfig {
+		return &config.PostgreSQLConfig{DatabaseConfig: config.DatabaseConfig{Host: host, Port: port, Database: "argo"}, PgPassFile: path}
+	}
+
+	t.Run("Match", func(t *testing.T) {
+		path := writePgPass(t, pgPass, 0o600)
+		password, err := pgPassFilePassword(newConfig(path, "db.internal", 5432), "argo")
+		require.NoError(t, err)
+		assert.Equal(t, `p:ss\word`, password, "the first matching line is used, and is unescaped")
+		password, err = pgPassFilePassword(newConfig(path, "db.internal", 5432), "admin")
+		require.NoError(t, err)
+		assert.Equal(t, "admin-password", password)
+	})
+	t.Run("DefaultPort", func(t *testing.T) {
+		path := writePgPass(t, "db.internal:5432:argo:argo:default-port-password\n", 0o400)
+		password, err := pgPassFilePassword(newConfig(path, "db.internal", 0), "argo")
+		require.NoError(t, err)
+		assert.Equal(t, "default-port-password", password)
+	})
+	t.Run("Wildcard", func(t *testing.T) {
+		path := writePgPass(t, pgPass, 0o600)
+		password, err := pgPassFilePassword(newConfig(path, "db.internal", 6432), "argo")
+		require.NoError(t, err)
+		assert.Equal(t, "wildcard-password", password)
+	})
+	t.Run("NoMatch", func(t *testing.T) {
+		path := writePgPass(t, pgPass, 0o600)
+		_, err := pgPassFilePassword(newConfig(path, "db.internal", 5432), "reader")
+		assert.EqualError(t, err, "pgPassFile "+path+" has no line that matches host db.internal, port 5432, database argo and user reader")
+	})
+	t.Run("GroupReadable", func(t *testing.T) {
+		path := writePgPass(t, pgPass, 0o640)
+		_, err := pgPassFilePassword(newConfig(path, "db.internal", 5432), "argo")
+		assert.EqualError(t, err, "pgPassFile "+path+" has group or world access (-rw-r-----), permissions should be u=rw (0600) or less")
+	})
+	t.Run("Missing", func(t *testing.T) {
+		_, err := pgPassFilePassword(newConfig(filepath.Join(t.TempDir(), ".pgpass"), "db.internal", 5432), "argo")
+		assert.ErrorContains(t, err, "failed to read pgPassFile")
+	})
+}
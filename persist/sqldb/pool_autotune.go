@@ -0,0 +1,126 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/upper/db/v4"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+const (
+	defaultAutoTuneTargetWaitDuration = 10 * time.Millisecond
+	defaultAutoTuneTargetUtilization  = 0.5
+	defaultAutoTuneInterval           = 30 * time.Second
+)
+
+// poolTuner resizes a pool towards its targets, one interval at a time. It grows quickly, by a quarter, as waiting for
+// connections slows every query, and shrinks slowly, by a tenth, so that a lull does not empty the pool.
+type poolTuner struct {
+	minOpenConns      int
+	maxOpenConns      int
+	targetWait        time.Duration
+	targetUtilization float64
+
+	size int
+	last sql.DBStats
+}
+
+func newPoolTuner(persistPool *config.ConnectionPool) (*poolTuner, error) {
+	autoTune := persistPool.AutoTune
+	t := &poolTuner{
+		minOpenConns:      max(autoTune.MinOpenConns, 1),
+		maxOpenConns:      autoTune.MaxOpenConns,
+		targetWait:        time.Duration(autoTune.TargetWaitDuration),
+		targetUtilization: autoTune.TargetUtilization,
+	}
+	if t.maxOpenConns <= 0 {
+		return nil, fmt.Errorf("autoTune.maxOpenConns is required")
+	}
+	if t.minOpenConns > t.maxOpenConns {
+		return nil, fmt.Errorf("autoTune.minOpenConns cannot be more than autoTune.maxOpenConns")
+	}
+	if t.targetWait <= 0 {
+		t.targetWait = defaultAutoTuneTargetWaitDuration
+	}
+	if t.targetUtilization == 0 {
+		t.targetUtilization = defaultAutoTuneTargetUtilization
+	}
+	if t.targetUtilization < 0 || t.targetUtilization > 1 {
+		return nil, fmt.Errorf("autoTune.targetUtilization must be between 0 and 1")
+	}
+	t.size = autoTuneInitialSize(persistPool)
+	return t, nil
+}
+
+// autoTuneInitialSize is the pool's MaxOpenConns within the bounds, or the upper bound if it is not limited
+func autoTuneInitialSize(persistPool *config.ConnectionPool) int {
+	autoTune := persistPool.AutoTune
+	if persistPool.MaxOpenConns <= 0 {
+		return autoTune.MaxOpenConns
+	}
+	return min(max(persistPool.MaxOpenConns, autoTune.MinOpenConns, 1), autoTune.MaxOpenConns)
+}
+
+// next returns the pool size for the stats at the end of an interval. The wait counters are cumulative, so it is the
+// waits since the last interval that are compared to the target.
+func (t *poolTuner) next(stats sql.DBStats) int {
+	waitCount := stats.WaitCount - t.last.WaitCount
+	waitDuration := stats.WaitDuration - t.last.WaitDuration
+	t.last = stats
+	switch {
+	case waitCount > 0 && waitDuration/time.Duration(waitCount) > t.targetWait:
+		t.size += max(t.size/4, 1)
+	case waitCount == 0 && float64(stats.InUse) < t.targetUtilization*float64(t.size):
+		// no smaller than the pool that would meet the target with the connections that are in use
+		t.size = max(t.size-max(t.size/10, 1), int(math.Ceil(float64(stats.InUse)/t.targetUtilization)))
+	}
+	t.size = min(max(t.size, t.minOpenConns), t.maxOpenConns)
+	return t.size
+}
+
+// AutoTunePool resizes the session's pool every interval, as configured by the pool's autoTune, until the context is
+// done. It returns straight away if the pool is not auto-tuned. The session is got every interval, so that once a
+// writer is reconnected after a failover it is its new pool that is tuned, starting from the tuned size.
+func AutoTunePool(ctx context.Context, session func() db.Session, persistPool *config.ConnectionPool) error {
+	if persistPool == nil || persistPool.AutoTune == nil {
+		return nil
+	}
+	t, err := newPoolTuner(persistPool)
+	if err != nil {
+		return err
+	}
+	interval := time.Duration(persistPool.AutoTune.Interval)
+	if interval <= 0 {
+		interval = defaultAutoTuneInterval
+	}
+	var sqlDB *sql.DB
+	for {
+		current, ok := session().Driver().(*sql.DB)
+		if !ok {
+			return fmt.Errorf("cannot auto-tune the pool of %T", session().Driver())
+		}
+		if current != sqlDB {
+			// a new pool's wait counters start from zero
+			sqlDB = current
+			sqlDB.SetMaxOpenConns(t.size)
+			t.last = sqlDB.Stats()
+		} else {
+			stats := sqlDB.Stats()
+			if size := t.next(stats); size != stats.MaxOpenConnections {
+				logger().WithFields(log.Fields{"from": stats.MaxOpenConnections, "to": size, "inUse": stats.InUse}).Info("Resizing the database connection pool")
+				sqlDB.SetMaxOpenConns(size)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-clock.After(interval):
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/upper/db/v4"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+func Test_poolTuner(t *testing.T) {
+	newTuner := func(t *testing.T, maxOpenConns int) *poolTuner {
+		t.Helper()
+		tuner, err := newPoolTuner(&config.ConnectionPool{MaxOpenConns: maxOpenConns, AutoTune: &config.ConnectionPoolAutoTune{MinOpenConns: 5, MaxOpenConns: 20}})
+		require.NoError(t, err)
+		return tuner
+	}
+	// stats accumulates synthetic stats, as the wait counters are cumulative
+	type stats struct{ sql.DBStats }
+	wait := func(s *stats, count int64, mean time.Duration, inUse int) sql.DBStats {
+		s.WaitCount += count
+		s.WaitDuration += time.Duration(count) * mean
+		s.InUse = inUse
+		return s.DBStats
+	}
+
+	t.Run("Defaults", func(t *testing.T) {
+		tuner := newTuner(t, 0)
+		assert.Equal(t, 20, tuner.size, "an unlimited pool starts at the upper bound")
+		assert.Equal(t, 10*time.Millisecond, tuner.targetWait)
+		assert.InDelta(t, 0.5, tuner.targetUtilization, 0)
+	})
+	t.Run("GrowsToTheUpperBound", func(t *testing.T) {
+		tuner := newTuner(t, 10)
+		s := &stats{}
+		var sizes []int
+		for i := 0; i < 6; i++ {
+			sizes = append(sizes, tuner.next(wait(s, 100, 50*time.Millisecond, 10)))
+		}
+		assert.Equal(t, []int{12, 15, 18, 20, 20, 20}, sizes)
+	})
+	t.Run("AtTarget", func(t *testing.T) {
+		tuner := newTuner(t, 10)
+		s := &stats{}
+		assert.Equal(t, 10, tuner.next(wait(s, 100, 5*time.Millisecond, 10)), "the waits are shorter than the target")
+		assert.Equal(t, 10, tuner.next(wait(s, 0, 0, 6)), "enough connections are in use")
+	})
+	t.Run("ShrinksToTheTarget", func(t *testing.T) {
+		tuner := newTuner(t, 20)
+		s := &stats{}
+		var sizes []int
+		for i := 0; i < 6; i++ {
+			sizes = append(sizes, tuner.next(wait(s, 0, 0, 6)))
+		}
+		// 6 in use is half of 12
+		assert.Equal(t, []int{18, 17, 16, 15, 14, 13}, sizes)
+		for i := 0; i < 5; i++ {
+			tuner.next(wait(s, 0, 0, 6))
+		}
+		assert.Equal(t, 12, tuner.size)
+	})
+	t.Run("ShrinksToTheLowerBound", func(t *testing.T) {
+		tuner := newTuner(t, 8)
+		s := &stats{}
+		var sizes []int
+		for i := 0; i < 4; i++ {
+			sizes = append(sizes, tuner.next(wait(s, 0, 0, 0)))
+		}
+		assert.Equal(t, []int{7, 6, 5, 5}, sizes)
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		for autoTune, err := range map[*config.ConnectionPoolAutoTune]string{
+			{}:                                      "autoTune.maxOpenConns is required",
+			{MinOpenConns: 10, MaxOpenConns: 5}:     "autoTune.minOpenConns cannot be more than autoTune.maxOpenConns",
+			{MaxOpenConns: 5, TargetUtilization: 2}: "autoTune.targetUtilization must be between 0 and 1",
+		} {
+			_, got := newPoolTuner(&config.ConnectionPool{AutoTune: autoTune})
+			assert.EqualError(t, got, err)
+		}
+	})
+}
+
+// intervalsClock is a fake clock whose After fires for the given number of intervals, and then cancels the context
+// rather than firing, so that a loop runs exactly that many intervals
+type intervalsClock struct {
+	*fakeClock
+	intervals int
+	cancel    context.CancelFunc
+}
+
+func (c *intervalsClock) After(d time.Duration) <-chan time.Time {
+	if c.intervals == 0 {
+		c.cancel()
+		return nil
+	}
+	c.intervals--
+	return c.fakeClock.After(d)
+}
+
+func TestAutoTunePool(t *testing.T) {
+	session := newFakeSession(t, &fakeConnector{dbType: Postgres})
+	persistPool := &config.ConnectionPool{MaxOpenConns: 4, AutoTune: &config.ConnectionPoolAutoTune{MinOpenConns: 2, MaxOpenConns: 8, Interval: config.TTL(time.Minute)}}
+	ConfigureDBSession(session, persistPool)
+	sqlDB := session.Driver().(*sql.DB)
+	assert.Equal(t, 4, sqlDB.Stats().MaxOpenConnections)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := &intervalsClock{fakeClock: newFakeClock(), intervals: 3, cancel: cancel}
+	defer func(old Clock) { clock = old }(clock)
+	clock = c
+	require.NoError(t, AutoTunePool(ctx, func() db.Session { return session }, persistPool))
+	assert.Equal(t, []time.Duration{time.Minute, time.Minute, time.Minute}, c.Sleeps())
+	assert.Equal(t, 2, sqlDB.Stats().MaxOpenConnections, "the idle pool shrinks to the lower bound")
+
+	t.Run("Reconfigured", func(t *testing.T) {
+		reloaded := *persistPool
+		reloaded.MaxIdleConns = 1
+		ReconfigureDBSession(session, &reloaded)
+		assert.Equal(t, 2, sqlDB.Stats().MaxOpenConnections, "the tuned size is kept")
+
+		reloaded.AutoTune = nil
+		ReconfigureDBSession(session, &reloaded)
+		assert.Equal(t, 4, sqlDB.Stats().MaxOpenConnections)
+	})
+	t.Run("NotAutoTuned", func(t *testing.T) {
+		assert.NoError(t, AutoTunePool(context.Background(), func() db.Session { return session }, &config.ConnectionPool{MaxOpenConns: 4}))
+		assert.NoError(t, AutoTunePool(context.Background(), func() db.Session { return session }, nil))
+	})
+	t.Run("Reconnected", func(t *testing.T) {
+		reconnected := newFakeSession(t, &fakeConnector{dbType: Postgres})
+		ConfigureDBSession(reconnected, persistPool)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		c := &intervalsClock{fakeClock: newFakeClock(), intervals: 2, cancel: cancel}
+		clock = c
+		// the idle pool shrinks by one, and then the writer is reconnected
+		sessions := []db.Session{session, session, reconnected}
+		require.NoError(t, AutoTunePool(ctx, func() db.Session {
+			current := sessions[0]
+			if len(sessions) > 1 {
+				sessions = sessions[1:]
+			}
+			return current
+		}, persistPool))
+		assert.Equal(t, 3, reconnected.Driver().(*sql.DB).Stats().MaxOpenConnections, "the new pool is tuned, starting from the tuned size")
+	})
+}
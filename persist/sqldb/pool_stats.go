@@ -0,0 +1,94 @@
+package sqldb
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/upper/db/v4"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+// PoolStats is the live state of a session's connection pool, for debugging without Prometheus
+type PoolStats struct {
+	Namespace string `json:"namespace,omitempty"`
+	// Config is the same summary that is logged when the session is created, so never includes credentials
+	Config            log.Fields `json:"config"`
+	MaxOpenConns      int        `json:"maxOpenConns"`
+	OpenConns         int        `json:"openConns"`
+	InUse             int        `json:"inUse"`
+	Idle              int        `json:"idle"`
+	WaitCount         int64      `json:"waitCount"`
+	WaitDuration      string     `json:"waitDuration"`
+	MaxIdleClosed     int64      `json:"maxIdleClosed"`
+	MaxIdleTimeClosed int64      `json:"maxIdleTimeClosed"`
+	MaxLifetimeClosed int64      `json:"maxLifetimeClosed"`
+}
+
+// ConnectionPoolState is the live state of a session's connection pool, so that callers, e.g. a custom health
+// endpoint, do not need to depend on database/sql
+type ConnectionPoolState struct {
+	MaxOpen           int
+	Open              int
+	InUse             int
+	Idle              int
+	WaitCount         int64
+	WaitDuration      time.Duration
+	MaxIdleClosed     int64
+	MaxIdleTimeClosed int64
+	MaxLifetimeClosed int64
+}
+
+// PoolState returns the state of the session's connection pool, which is zero if the session has no pool
+func PoolState(session db.Session) ConnectionPoolState {
+	sqlDB, ok := session.Driver().(*sql.DB)
+	if !ok {
+		return ConnectionPoolState{}
+	}
+	s := sqlDB.Stats()
+	return ConnectionPoolState{
+		MaxOpen:           s.MaxOpenConnections,
+		Open:              s.OpenConnections,
+		InUse:             s.InUse,
+		Idle:              s.Idle,
+		WaitCount:         s.WaitCount,
+		WaitDuration:      s.WaitDuration,
+		MaxIdleClosed:     s.MaxIdleClosed,
+		MaxIdleTimeClosed: s.MaxIdleTimeClosed,
+		MaxLifetimeClosed: s.MaxLifetimeClosed,
+	}
+}
+
+// SessionPoolStats returns the pool stats for the session
+func SessionPoolStats(namespace string, persistConfig *config.PersistConfig, session db.Session) PoolStats {
+	s := PoolState(session)
+	return PoolStats{
+		Namespace:         namespace,
+		Config:            persistenceSummary(persistConfig),
+		MaxOpenConns:      s.MaxOpen,
+		OpenConns:         s.Open,
+		InUse:             s.InUse,
+		Idle:              s.Idle,
+		WaitCount:         s.WaitCount,
+		WaitDuration:      s.WaitDuration.String(),
+		MaxIdleClosed:     s.MaxIdleClosed,
+		MaxIdleTimeClosed: s.MaxIdleTimeClosed,
+		MaxLifetimeClosed: s.MaxLifetimeClosed,
+	}
+}
+
+// PoolStatsHandler serves the pool stats as JSON, it is intended for the admin (pprof) server rather than the API
+func PoolStatsHandler(stats func() []PoolStats) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := json.MarshalIndent(stats(), "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(data)
+	}
+}
@@ -0,0 +1,116 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/upper/db/v4"
+	apiv1 "k8s.io/api/core/v1"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+func TestPoolStatsHandler(t *testing.T) {
+	persistConfig := &config.PersistConfig{
+		PostgreSQL: &config.PostgreSQLConfig{DatabaseConfig: config.DatabaseConfig{
+			Host:           "postgres",
+			Port:           5432,
+			Database:       "argo",
+			TableName:      "argo_workflows",
+			UsernameSecret: apiv1.SecretKeySelector{LocalObjectReference: apiv1.LocalObjectReference{Name: "argo-postgres-config"}, Key: "username"},
+			PasswordSecret: apiv1.SecretKeySelector{LocalObjectReference: apiv1.LocalObjectReference{Name: "argo-postgres-config"}, Key: "password"},
+		}},
+		ConnectionPool: &config.ConnectionPool{MaxOpenConns: 10, MaxIdleConns: 5},
+	}
+	session := newFakeSession(t, &fakeConnector{dbType: Postgres})
+	ConfigureDBSession(session, persistConfig.ConnectionPool)
+	_, err := session.SQL().Exec("select 1")
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	PoolStatsHandler(func() []PoolStats {
+		return []PoolStats{SessionPoolStats("argo", persistConfig, session)}
+	})(rec, httptest.NewRequest(http.MethodGet, "/debug/persistence/pool", nil))
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.NotContains(t, rec.Body.String(), "argo-postgres-config")
+	var stats []map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &stats))
+	require.Len(t, stats, 1)
+	assert.Equal(t, "argo", stats[0]["namespace"])
+	assert.Equal(t, map[string]interface{}{
+		"backend":         "postgres",
+		"host":            "postgres:5432",
+		"database":        "argo",
+		"tableName":       "argo_workflows",
+		"tls":             false,
+		"sslMode":         "",
+		"maxOpenConns":    float64(10),
+		"maxIdleConns":    float64(5),
+		"connMaxLifetime": "0s",
+	}, stats[0]["config"])
+	assert.Equal(t, float64(10), stats[0]["maxOpenConns"])
+	assert.Equal(t, float64(1), stats[0]["openConns"])
+	assert.Equal(t, float64(0), stats[0]["inUse"])
+	assert.Equal(t, float64(1), stats[0]["idle"])
+	for _, field := range []string{"waitCount", "waitDuration", "maxIdleClosed", "maxIdleTimeClosed", "maxLifetimeClosed"} {
+		assert.Contains(t, stats[0], field)
+	}
+}
+
+// noPoolSession is a session that is not backed by a database/sql pool
+type noPoolSession struct {
+	db.Session
+}
+
+func (s *noPoolSession) Driver() interface{} { return nil }
+
+func TestPoolState(t *testing.T) {
+	ctx := context.Background()
+	session := newFakeSession(t, &fakeConnector{dbType: Postgres})
+	// the limits are set on the pool rather than the session, as the session's settings are shared
+	sqlDB := session.Driver().(*sql.DB)
+	sqlDB.SetMaxOpenConns(1)
+	sqlDB.SetMaxIdleConns(1)
+	// opening the session leaves the connection it pinged with idle
+	assert.Equal(t, ConnectionPoolState{MaxOpen: 1, Open: 1, Idle: 1}, PoolState(session))
+
+	conn, err := sqlDB.Conn(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, ConnectionPoolState{MaxOpen: 1, Open: 1, InUse: 1}, PoolState(session))
+
+	// the pool is exhausted, so this waits for the connection to be released
+	done := make(chan error)
+	go func() {
+		_, err := session.SQL().Exec("select 1")
+		done <- err
+	}()
+	assert.Eventually(t, func() bool { return PoolState(session).WaitCount == 1 }, 5*time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	require.NoError(t, conn.Close())
+	require.NoError(t, <-done)
+
+	state := PoolState(session)
+	assert.Equal(t, 1, state.Open)
+	assert.Equal(t, 0, state.InUse)
+	assert.Equal(t, 1, state.Idle)
+	assert.Equal(t, int64(1), state.WaitCount)
+	assert.GreaterOrEqual(t, state.WaitDuration, 10*time.Millisecond)
+
+	sqlDB.SetMaxIdleConns(0)
+	state = PoolState(session)
+	assert.Equal(t, 0, state.Open)
+	assert.Equal(t, int64(1), state.MaxIdleClosed)
+
+	t.Run("NoPool", func(t *testing.T) {
+		assert.Equal(t, ConnectionPoolState{}, PoolState(&noPoolSession{}))
+	})
+}
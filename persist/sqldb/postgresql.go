@@ -0,0 +1,251 @@
+package sqldb
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql/driver"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgconn/stmtcache"
+	"github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/stdlib"
+	log "github.com/sirupsen/logrus"
+	"github.com/upper/db/v4"
+	postgresqladp "github.com/upper/db/v4/adapter/postgresql"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+// PostgresOption configures a Postgres session programmatically, for controllers that embed Argo and build the
+// session themselves. Options take precedence over the config.
+type PostgresOption func(*pgx.ConnConfig)
+
+// WithTLSConfig connects using the TLS config rather than one built from sslMode, so the connection is always
+// encrypted. If the config has no ServerName, the host is used.
+func WithTLSConfig(tlsConfig *tls.Config) PostgresOption {
+	return func(connConfig *pgx.ConnConfig) {
+		c := tlsConfig.Clone()
+		if c.ServerName == "" {
+			c.ServerName = connConfig.Host
+		}
+		connConfig.TLSConfig = c
+		connConfig.Fallbacks = nil
+	}
+}
+
+// WithRootCAs verifies the server's certificate using the pool rather than the system roots. Whether the
+// certificate is verified at all still depends on the sslMode, i.e. it must be verify-ca or verify-full.
+func WithRootCAs(pool *x509.CertPool) PostgresOption {
+	return func(connConfig *pgx.ConnConfig) {
+		if connConfig.TLSConfig != nil {
+			connConfig.TLSConfig.RootCAs = pool
+		}
+		for _, fallback := range connConfig.Fallbacks {
+			if fallback.TLSConfig != nil {
+				fallback.TLSConfig.RootCAs = pool
+			}
+		}
+	}
+}
+
+const (
+	GSSEncModeDisable = "disable"
+	GSSEncModePrefer  = "prefer"
+	GSSEncModeRequire = "require"
+)
+
+var sslModes = map[string]bool{
+	"disable":     true,
+	"allow":       true,
+	"prefer":      true,
+	"require":     true,
+	"verify-ca":   true,
+	"verify-full": true,
+}
+
+// defaultSSLMode is the sslMode that the driver uses if it is not set
+const defaultSSLMode = "prefer"
+
+// postgresOptions returns the connection options for the sslMode, which is only used if ssl is set, validating it
+// with the gssEncMode and requireTLS. libpq negotiates GSSAPI encryption before TLS, but the driver does not support
+// GSSAPI encryption, so the gssEncMode is never sent to it: prefer falls back to the sslMode, as libpq does when GSSAPI
+// encryption is not available, and require is rejected rather than connecting without it.
+func postgresOptions(cfg *config.PostgreSQLConfig) (map[string]string, error) {
+	switch cfg.GSSEncMode {
+	case "", GSSEncModeDisable, GSSEncModePrefer:
+	case GSSEncModeRequire:
+		return nil, fmt.Errorf("gssEncMode require is not supported, as the driver cannot encrypt the connection using GSSAPI, use sslMode require or verify-full instead")
+	default:
+		return nil, fmt.Errorf("gssEncMode must be one of: disable, prefer, require")
+	}
+	sslMode := defaultSSLMode
+	var options map[string]string
+	if cfg.SSL && cfg.SSLMode != "" {
+		if !sslModes[cfg.SSLMode] {
+			return nil, fmt.Errorf("sslMode must be one of: disable, allow, prefer, require, verify-ca, verify-full")
+		}
+		// the other modes may fall back to connecting without TLS, which is what requireTLS checks for
+		if cfg.SSLMode == "disable" && cfg.RequireTLS {
+			return nil, fmt.Errorf("requireTLS cannot be used with sslMode disable, which never uses TLS")
+		}
+		sslMode = cfg.SSLMode
+		options = map[string]string{"sslmode": sslMode}
+	}
+	if cfg.GSSEncMode == GSSEncModePrefer {
+		logger().WithField("sslMode", sslMode).Debug("GSSAPI encryption is not supported by the driver, falling back to the sslMode")
+	}
+	return options, nil
+}
+
+// pgxConnConfig builds the pgx configuration for the settings, applying the options that cannot be expressed
+// through the upper/db connection URL
+func pgxConnConfig(settings postgresqladp.ConnectionURL, cfg *config.PostgreSQLConfig, opts ...PostgresOption) (*pgx.ConnConfig, error) {
+	connConfig, err := pgx.ParseConfig(settings.String())
+	if err != nil {
+		return nil, err
+	}
+	if cfg.PerPodAppName {
+		connConfig.RuntimeParams["application_name"] = applicationName("", true)
+	}
+	if cfg.DisableJIT {
+		connConfig.RuntimeParams["jit"] = "off"
+	}
+	if cfg.AuthMode == AuthModeGSSAPI {
+		connConfig.KerberosSrvName = cfg.KrbServiceName
+		connConfig.KerberosSpn = cfg.KrbServicePrincipalName
+	}
+	for _, opt := range opts {
+		opt(connConfig)
+	}
+	if cfg.DisablePreparedStatements {
+		if cfg.StatementCacheCapacity > 0 {
+			return nil, fmt.Errorf("statementCacheCapacity cannot be used with disablePreparedStatements")
+		}
+		connConfig.PreferSimpleProtocol = true
+	}
+	if prefix := cfg.StatementNamePrefix; prefix != "" {
+		if cfg.DisablePreparedStatements {
+			return nil, fmt.Errorf("statementNamePrefix cannot be used with disablePreparedStatements")
+		}
+		if err := validateStatementNamePrefix(prefix); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.StatementCacheCapacity > 0 || cfg.StatementNamePrefix != "" {
+		mode := stmtcache.ModePrepare
+		switch cfg.StatementCacheMode {
+		case "", "prepare":
+		case "describe":
+			mode = stmtcache.ModeDescribe
+		default:
+			return nil, fmt.Errorf("statementCacheMode must be one of: prepare, describe")
+		}
+		capacity := cfg.StatementCacheCapacity
+		if capacity == 0 {
+			capacity = defaultStatementCacheCapacity
+		}
+		prefix := cfg.StatementNamePrefix
+		connConfig.BuildStatementCache = func(conn *pgconn.PgConn) stmtcache.Cache {
+			if prefix != "" {
+				return newPrefixedStatementCache(pgStatementConn{conn}, mode, capacity, prefix)
+			}
+			return stmtcache.New(conn, mode, capacity)
+		}
+	}
+	return connConfig, nil
+}
+
+// defaultApplicationName is the application_name of the connections of a pod whose name is not known
+const defaultApplicationName = "argo-workflows"
+
+// applicationName returns the application_name that identifies the component and, if perPod, the pod that the
+// connections are from, using the POD_NAME environment variable that the downward API sets. Off-cluster, there is no
+// pod name, so only the component is identified.
+func applicationName(component Component, perPod bool) string {
+	name := defaultApplicationName
+	if component != "" {
+		name += "/" + string(component)
+	}
+	if podName := os.Getenv("POD_NAME"); perPod && podName != "" {
+		name += "/" + podName
+	}
+	return name
+}
+
+// withApplicationName sets the application_name of the connections
+func withApplicationName(name string) PostgresOption {
+	return func(connConfig *pgx.ConnConfig) {
+		connConfig.RuntimeParams["application_name"] = name
+	}
+}
+
+// pgxConnector returns the driver's connector for the config. It is a variable so that tests can fake the database.
+var pgxConnector = func(connConfig pgx.ConnConfig) driver.Connector {
+	return stdlib.GetConnector(connConfig)
+}
+
+// openPostgres opens the session using pgx directly rather than via postgresqladp.Open, which does not allow the
+// driver to be configured
+func openPostgres(settings postgresqladp.ConnectionURL, cfg *config.PostgreSQLConfig, persistPool *config.ConnectionPool, features sessionFeatures, opts ...PostgresOption) (db.Session, error) {
+	connConfig, err := pgxConnConfig(settings, cfg, opts...)
+	if err != nil {
+		return nil, err
+	}
+	logConnectionParameters(func() log.Fields { return postgresConnectionParameters(connConfig) })
+	init, err := connInits(Postgres, cfg.DatabaseConfig)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Schema != "" {
+		if !identifierRegexp.MatchString(cfg.Schema) {
+			return nil, fmt.Errorf("invalid schema name %q", cfg.Schema)
+		}
+		init = append(init, execStatements(setStatement(Postgres, "search_path", cfg.Schema)))
+	}
+	init = append(init, warmupInits(cfg.DatabaseConfig)...)
+	var setRole string
+	if cfg.SetRole != "" {
+		if setRole, err = setRoleStatement(cfg.SetRole); err != nil {
+			return nil, err
+		}
+	}
+	provider, err := newCredentialProvider(context.Background(), cfg.DatabaseConfig)
+	if err != nil {
+		return nil, err
+	}
+	connector := newCredentialConnector(pgxConnector(*connConfig), provider, Credentials{Username: connConfig.User, Password: connConfig.Password}, func(creds Credentials) (driver.Connector, error) {
+		c := connConfig.Copy()
+		c.User = creds.Username
+		c.Password = creds.Password
+		return pgxConnector(*c), nil
+	})
+	connector, err = newConnectRateLimitConnector(connector, persistPool)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.StatementNamePrefix != "" {
+		connector = &unpreparedConnector{Connector: connector}
+	}
+	connector = newCancelGraceConnector(connector, time.Duration(cfg.CancelGracePeriod))
+	// the role is set first, so that the rest of the initialization runs as the role
+	if setRole != "" {
+		connector = &roleConnector{Connector: connector, statement: setRole}
+	}
+	connector, err = newRecycleConnector(newSchemaDriftConnector(newInitConnector(connector, init...), persistPool), persistPool)
+	if err != nil {
+		return nil, err
+	}
+	connector, err = newConcurrencyLimitConnector(newMaxRowsConnector(connector, cfg.MaxRows), persistPool)
+	if err != nil {
+		return nil, err
+	}
+	session, err := openSession(Postgres, connector, features)
+	if err != nil {
+		return nil, err
+	}
+	return session, nil
+}
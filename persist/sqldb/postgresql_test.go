@@ -0,0 +1,199 @@
+package sqldb
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgconn/stmtcache"
+	"github.com/jackc/pgx/v4/stdlib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	postgresqladp "github.com/upper/db/v4/adapter/postgresql"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+	tlsutil "github.com/argoproj/argo-workflows/v3/util/tls"
+)
+
+func Test_pgxConnConfig(t *testing.T) {
+	settings := postgresqladp.ConnectionURL{User: "my-user", Host: "my-host:5432", Database: "my-db"}
+	t.Run("StatementCacheDisabledByDefault", func(t *testing.T) {
+		connConfig, err := pgxConnConfig(settings, &config.PostgreSQLConfig{})
+		require.NoError(t, err)
+		assert.Nil(t, connConfig.BuildStatementCache)
+	})
+	t.Run("StatementCache", func(t *testing.T) {
+		for _, tt := range []struct {
+			mode string
+			want int
+		}{
+			{"", stmtcache.ModePrepare},
+			{"prepare", stmtcache.ModePrepare},
+			{"describe", stmtcache.ModeDescribe},
+		} {
+			connConfig, err := pgxConnConfig(settings, &config.PostgreSQLConfig{StatementCacheCapacity: 64, StatementCacheMode: tt.mode})
+			require.NoError(t, err)
+			if assert.NotNil(t, connConfig.BuildStatementCache) {
+				cache := connConfig.BuildStatementCache(nil)
+				assert.Equal(t, 64, cache.Cap())
+				assert.Equal(t, tt.want, cache.Mode())
+			}
+		}
+	})
+	t.Run("InvalidStatementCacheMode", func(t *testing.T) {
+		_, err := pgxConnConfig(settings, &config.PostgreSQLConfig{StatementCacheCapacity: 64, StatementCacheMode: "bad"})
+		assert.EqualError(t, err, "statementCacheMode must be one of: prepare, describe")
+	})
+	t.Run("PreparedStatementsByDefault", func(t *testing.T) {
+		connConfig, err := pgxConnConfig(settings, &config.PostgreSQLConfig{})
+		require.NoError(t, err)
+		assert.False(t, connConfig.PreferSimpleProtocol)
+	})
+	t.Run("DisablePreparedStatements", func(t *testing.T) {
+		cfg := &config.PostgreSQLConfig{}
+		cfg.DisablePreparedStatements = true
+		connConfig, err := pgxConnConfig(settings, cfg)
+		require.NoError(t, err)
+		assert.True(t, connConfig.PreferSimpleProtocol)
+		assert.Nil(t, connConfig.BuildStatementCache)
+		cfg.StatementCacheCapacity = 64
+		_, err = pgxConnConfig(settings, cfg)
+		assert.EqualError(t, err, "statementCacheCapacity cannot be used with disablePreparedStatements")
+	})
+	t.Run("StatementNamePrefix", func(t *testing.T) {
+		connConfig, err := pgxConnConfig(settings, &config.PostgreSQLConfig{StatementNamePrefix: "argo_prod"})
+		require.NoError(t, err)
+		if assert.NotNil(t, connConfig.BuildStatementCache, "the prefix enables the cache") {
+			cache, ok := connConfig.BuildStatementCache(nil).(*prefixedStatementCache)
+			require.True(t, ok)
+			assert.Equal(t, defaultStatementCacheCapacity, cache.Cap())
+			assert.Equal(t, stmtcache.ModePrepare, cache.Mode())
+			assert.Regexp(t, `^argo_prod_\d+$`, cache.namePrefix)
+		}
+		connConfig, err = pgxConnConfig(settings, &config.PostgreSQLConfig{StatementNamePrefix: "argo_prod", StatementCacheCapacity: 64})
+		require.NoError(t, err)
+		assert.Equal(t, 64, connConfig.BuildStatementCache(nil).Cap())
+	})
+	t.Run("InvalidStatementNamePrefix", func(t *testing.T) {
+		_, err := pgxConnConfig(settings, &config.PostgreSQLConfig{StatementNamePrefix: "argo-prod"})
+		assert.EqualError(t, err, "statementNamePrefix must be at most 32 letters, digits or underscores, starting with a letter or underscore")
+		cfg := &config.PostgreSQLConfig{StatementNamePrefix: "argo_prod"}
+		cfg.DisablePreparedStatements = true
+		_, err = pgxConnConfig(settings, cfg)
+		assert.EqualError(t, err, "statementNamePrefix cannot be used with disablePreparedStatements")
+	})
+	t.Run("DisableJIT", func(t *testing.T) {
+		connConfig, err := pgxConnConfig(settings, &config.PostgreSQLConfig{DisableJIT: true})
+		require.NoError(t, err)
+		assert.Equal(t, "off", connConfig.RuntimeParams["jit"])
+	})
+	t.Run("JITByDefault", func(t *testing.T) {
+		connConfig, err := pgxConnConfig(settings, &config.PostgreSQLConfig{})
+		require.NoError(t, err)
+		assert.NotContains(t, connConfig.RuntimeParams, "jit")
+	})
+	t.Run("PerPodAppName", func(t *testing.T) {
+		t.Run("InCluster", func(t *testing.T) {
+			t.Setenv("POD_NAME", "workflow-controller-7d9f8-abcde")
+			connConfig, err := pgxConnConfig(settings, &config.PostgreSQLConfig{PerPodAppName: true})
+			require.NoError(t, err)
+			assert.Equal(t, "argo-workflows/workflow-controller-7d9f8-abcde", connConfig.RuntimeParams["application_name"])
+			assert.Equal(t, "argo-workflows/controller/workflow-controller-7d9f8-abcde", applicationName(ComponentController, true))
+			assert.Equal(t, "argo-workflows/server", applicationName(ComponentServer, false))
+		})
+		t.Run("OffCluster", func(t *testing.T) {
+			t.Setenv("POD_NAME", "")
+			connConfig, err := pgxConnConfig(settings, &config.PostgreSQLConfig{PerPodAppName: true})
+			require.NoError(t, err)
+			assert.Equal(t, "argo-workflows", connConfig.RuntimeParams["application_name"])
+			assert.Equal(t, "argo-workflows/controller", applicationName(ComponentController, true))
+		})
+		t.Run("Disabled", func(t *testing.T) {
+			t.Setenv("POD_NAME", "workflow-controller-7d9f8-abcde")
+			connConfig, err := pgxConnConfig(settings, &config.PostgreSQLConfig{})
+			require.NoError(t, err)
+			assert.NotContains(t, connConfig.RuntimeParams, "application_name")
+		})
+	})
+}
+
+func Test_postgresOptions(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		cfg  config.PostgreSQLConfig
+		want map[string]string
+		err  string
+	}{
+		{"Default", config.PostgreSQLConfig{}, nil, ""},
+		{"SSLModeWithoutSSL", config.PostgreSQLConfig{SSLMode: "verify-full"}, nil, ""},
+		{"SSLMode", config.PostgreSQLConfig{SSL: true, SSLMode: "verify-full"}, map[string]string{"sslmode": "verify-full"}, ""},
+		{"GSSEncModeDisable", config.PostgreSQLConfig{SSL: true, SSLMode: "require", GSSEncMode: GSSEncModeDisable}, map[string]string{"sslmode": "require"}, ""},
+		{"GSSEncModePreferFallsBackToSSLMode", config.PostgreSQLConfig{SSL: true, SSLMode: "verify-ca", GSSEncMode: GSSEncModePrefer}, map[string]string{"sslmode": "verify-ca"}, ""},
+		{"RequireTLSWithPrefer", config.PostgreSQLConfig{SSL: true, SSLMode: "prefer", DatabaseConfig: config.DatabaseConfig{RequireTLS: true}}, map[string]string{"sslmode": "prefer"}, ""},
+		{"GSSEncModeRequire", config.PostgreSQLConfig{SSL: true, SSLMode: "require", GSSEncMode: GSSEncModeRequire}, nil, "gssEncMode require is not supported, as the driver cannot encrypt the connection using GSSAPI, use sslMode require or verify-full instead"},
+		{"InvalidGSSEncMode", config.PostgreSQLConfig{GSSEncMode: "allow"}, nil, "gssEncMode must be one of: disable, prefer, require"},
+		{"InvalidSSLMode", config.PostgreSQLConfig{SSL: true, SSLMode: "verify"}, nil, "sslMode must be one of: disable, allow, prefer, require, verify-ca, verify-full"},
+		{"RequireTLSWithSSLDisabled", config.PostgreSQLConfig{SSL: true, SSLMode: "disable", GSSEncMode: GSSEncModeDisable, DatabaseConfig: config.DatabaseConfig{RequireTLS: true}}, nil, "requireTLS cannot be used with sslMode disable, which never uses TLS"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			options, err := postgresOptions(&tt.cfg)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, options)
+			// gssencmode is not a driver option, so it must not be sent to the server as a run-time parameter
+			connConfig, err := pgxConnConfig(postgresqladp.ConnectionURL{Host: "my-host", Options: options}, &tt.cfg)
+			require.NoError(t, err)
+			assert.NotContains(t, connConfig.RuntimeParams, "gssencmode")
+		})
+	}
+}
+
+func Test_pgxConnConfigTLSOptions(t *testing.T) {
+	pool := x509.NewCertPool()
+	t.Run("RootCAs", func(t *testing.T) {
+		settings := postgresqladp.ConnectionURL{Host: "my-host:5432", Options: map[string]string{"sslmode": "verify-full"}}
+		connConfig, err := pgxConnConfig(settings, &config.PostgreSQLConfig{}, WithRootCAs(pool))
+		require.NoError(t, err)
+		require.NotNil(t, connConfig.TLSConfig)
+		assert.Same(t, pool, connConfig.TLSConfig.RootCAs)
+		assert.Equal(t, "my-host", connConfig.TLSConfig.ServerName)
+	})
+	t.Run("TLSConfig", func(t *testing.T) {
+		settings := postgresqladp.ConnectionURL{Host: "my-host:5432", Options: map[string]string{"sslmode": "disable"}}
+		tlsConfig := &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS13}
+		connConfig, err := pgxConnConfig(settings, &config.PostgreSQLConfig{}, WithTLSConfig(tlsConfig))
+		require.NoError(t, err)
+		require.NotNil(t, connConfig.TLSConfig)
+		assert.Same(t, pool, connConfig.TLSConfig.RootCAs)
+		assert.Equal(t, uint16(tls.VersionTLS13), connConfig.TLSConfig.MinVersion)
+		assert.Equal(t, "my-host", connConfig.TLSConfig.ServerName)
+		assert.Empty(t, connConfig.Fallbacks)
+		assert.Empty(t, tlsConfig.ServerName, "the caller's config is not modified")
+	})
+	t.Run("Handshake", func(t *testing.T) {
+		cert, err := tlsutil.GenerateX509KeyPair()
+		require.NoError(t, err)
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		require.NoError(t, err)
+		pool := x509.NewCertPool()
+		pool.AddCert(leaf)
+		settings := postgresqladp.ConnectionURL{Host: fakeTLSPostgres(t, cert), Options: map[string]string{"sslmode": "verify-ca"}}
+		connect := func(opts ...PostgresOption) error {
+			connConfig, err := pgxConnConfig(settings, &config.PostgreSQLConfig{}, opts...)
+			require.NoError(t, err)
+			_, err = stdlib.GetConnector(*connConfig).Connect(context.Background())
+			return err
+		}
+		err = connect()
+		assert.Equal(t, connectionErrorTLS, classifyConnectionError(err), fmt.Sprint(err))
+		// the fake server hangs up after the handshake, so the connection fails, but the certificate was trusted
+		err = connect(WithRootCAs(pool))
+		require.Error(t, err)
+		assert.NotEqual(t, connectionErrorTLS, classifyConnectionError(err), err.Error())
+	})
+}
@@ -0,0 +1,66 @@
+package sqldb
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/upper/db/v4"
+
+	"github.com/argoproj/argo-workflows/v3/workflow/metrics"
+)
+
+// IsPrimary returns whether the session is connected to a writable primary rather than a read-only replica, e.g.
+// because a connection was left pointing at the old primary after a failover
+func IsPrimary(ctx context.Context, session db.Session, t dbType) (bool, error) {
+	if t == MySQL {
+		row, err := session.SQL().QueryRowContext(ctx, "select @@read_only, @@innodb_read_only")
+		if err != nil {
+			return false, fmt.Errorf("failed to check whether the database is read-only: %w", err)
+		}
+		var readOnly, innodbReadOnly bool
+		if err := row.Scan(&readOnly, &innodbReadOnly); err != nil {
+			return false, fmt.Errorf("failed to check whether the database is read-only: %w", err)
+		}
+		return !readOnly && !innodbReadOnly, nil
+	}
+	row, err := session.SQL().QueryRowContext(ctx, "select pg_is_in_recovery()")
+	if err != nil {
+		return false, fmt.Errorf("failed to check whether the database is in recovery: %w", err)
+	}
+	var inRecovery bool
+	if err := row.Scan(&inRecovery); err != nil {
+		return false, fmt.Errorf("failed to check whether the database is in recovery: %w", err)
+	}
+	return !inRecovery, nil
+}
+
+// recordPrimary sets the component's primary gauge from the session, leaving it unchanged if the check fails
+func recordPrimary(ctx context.Context, session db.Session, component Component) {
+	t := dbTypeFor(session)
+	primary, err := IsPrimary(ctx, session, t)
+	if err != nil {
+		logger().WithError(err).Warn("Failed to check whether the database is the primary")
+		return
+	}
+	value := 0.0
+	if primary {
+		value = 1
+	}
+	metrics.DBPrimaryMetric.WithLabelValues(string(t), string(component)).Set(value)
+}
+
+// MonitorPrimary updates the component's primary gauge every interval until the context is done. The session is got
+// on every check, so that a writer that is reconnected after a failover is the one that is checked.
+func MonitorPrimary(ctx context.Context, session func() db.Session, component Component, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		recordPrimary(ctx, session(), component)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
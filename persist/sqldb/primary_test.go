@@ -0,0 +1,81 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/upper/db/v4"
+
+	"github.com/argoproj/argo-workflows/v3/workflow/metrics"
+)
+
+func TestIsPrimary(t *testing.T) {
+	ctx := context.Background()
+	postgres := func(inRecovery bool) *fakeConnector {
+		return &fakeConnector{dbType: Postgres, handler: func(query string, _ []driver.NamedValue) (*fakeResult, error) {
+			if query != "select pg_is_in_recovery()" {
+				return &fakeResult{}, nil
+			}
+			return &fakeResult{columns: []string{"pg_is_in_recovery"}, rows: [][]driver.Value{{inRecovery}}}, nil
+		}}
+	}
+	mySQL := func(readOnly, innodbReadOnly int64) *fakeConnector {
+		return &fakeConnector{dbType: MySQL, handler: func(query string, _ []driver.NamedValue) (*fakeResult, error) {
+			if query != "select @@read_only, @@innodb_read_only" {
+				return &fakeResult{}, nil
+			}
+			return &fakeResult{columns: []string{"@@read_only", "@@innodb_read_only"}, rows: [][]driver.Value{{readOnly, innodbReadOnly}}}, nil
+		}}
+	}
+	for _, tt := range []struct {
+		name      string
+		connector *fakeConnector
+		primary   bool
+	}{
+		{"PostgresPrimary", postgres(false), true},
+		{"PostgresReplica", postgres(true), false},
+		{"MySQLPrimary", mySQL(0, 0), true},
+		{"MySQLReadOnly", mySQL(1, 0), false},
+		{"MySQLInnoDBReadOnly", mySQL(0, 1), false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			session := newFakeSession(t, tt.connector)
+			primary, err := IsPrimary(ctx, session, tt.connector.dbType)
+			require.NoError(t, err)
+			assert.Equal(t, tt.primary, primary)
+		})
+	}
+	t.Run("Metric", func(t *testing.T) {
+		gauge := metrics.DBPrimaryMetric.WithLabelValues(string(Postgres), "controller")
+		serverGauge := metrics.DBPrimaryMetric.WithLabelValues(string(Postgres), "server")
+		recordPrimary(ctx, newFakeSession(t, postgres(true)), ComponentController)
+		recordPrimary(ctx, newFakeSession(t, postgres(false)), ComponentServer)
+		assert.Equal(t, 0.0, testutil.ToFloat64(gauge))
+		assert.Equal(t, 1.0, testutil.ToFloat64(serverGauge))
+		recordPrimary(ctx, newFakeSession(t, postgres(false)), ComponentController)
+		assert.Equal(t, 1.0, testutil.ToFloat64(gauge))
+	})
+	t.Run("Reconnected", func(t *testing.T) {
+		gauge := metrics.DBPrimaryMetric.WithLabelValues(string(Postgres), "controller")
+		// the writer is reconnected to the new primary after the first check
+		sessions := []db.Session{newFakeSession(t, postgres(true)), newFakeSession(t, postgres(false))}
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		checks := 0
+		MonitorPrimary(ctx, func() db.Session {
+			checks++
+			if checks > len(sessions) {
+				// the check fails, leaving the gauge unchanged
+				cancel()
+				return sessions[len(sessions)-1]
+			}
+			return sessions[checks-1]
+		}, ComponentController, time.Millisecond)
+		assert.Equal(t, 1.0, testutil.ToFloat64(gauge), "the new writer is checked")
+	})
+}
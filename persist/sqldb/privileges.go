@@ -0,0 +1,116 @@
+package sqldb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/upper/db/v4"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+// tablePrivileges are the privileges that the runtime user needs on every table
+var tablePrivileges = []string{"SELECT", "INSERT", "UPDATE", "DELETE"}
+
+// CheckPrivileges returns the privileges that the connected user is missing on the table. Misconfigured grants
+// otherwise only show up as confusing failures once the table is used.
+//
+// For MySQL, the grants are read from information_schema, so privileges granted via a role, or via a wildcard
+// database name, are not seen and are reported as missing.
+func CheckPrivileges(ctx context.Context, session db.Session, tableName string, t dbType) ([]string, error) {
+	var granted map[string]bool
+	var err error
+	if t == MySQL {
+		granted, err = mySQLPrivileges(ctx, session, tableName)
+	} else {
+		granted, err = postgresPrivileges(ctx, session, tableName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to check privileges on %s: %w", tableName, err)
+	}
+	var missing []string
+	for _, privilege := range tablePrivileges {
+		if !granted[privilege] {
+			missing = append(missing, privilege)
+		}
+	}
+	return missing, nil
+}
+
+func postgresPrivileges(ctx context.Context, session db.Session, tableName string) (map[string]bool, error) {
+	granted := make(map[string]bool)
+	for _, privilege := range tablePrivileges {
+		row, err := session.SQL().QueryRowContext(ctx, "select has_table_privilege(?, ?)", tableName, privilege)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if err := row.Scan(&ok); err != nil {
+			return nil, err
+		}
+		granted[privilege] = ok
+	}
+	return granted, nil
+}
+
+func mySQLPrivileges(ctx context.Context, session db.Session, tableName string) (map[string]bool, error) {
+	row, err := session.SQL().QueryRowContext(ctx, "select current_user(), database()")
+	if err != nil {
+		return nil, err
+	}
+	var currentUser, schema string
+	if err := row.Scan(&currentUser, &schema); err != nil {
+		return nil, err
+	}
+	if prefix, name, ok := strings.Cut(tableName, "."); ok {
+		schema, tableName = prefix, name
+	}
+	// information_schema quotes the user and host of the grantee
+	user, host, _ := strings.Cut(currentUser, "@")
+	grantee := "'" + user + "'@'" + host + "'"
+	rows, err := session.SQL().QueryContext(ctx, `select privilege_type from information_schema.user_privileges where grantee = ?
+union select privilege_type from information_schema.schema_privileges where grantee = ? and table_schema = ?
+union select privilege_type from information_schema.table_privileges where grantee = ? and table_schema = ? and table_name = ?`,
+		grantee, grantee, schema, grantee, schema, tableName)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	granted := make(map[string]bool)
+	for rows.Next() {
+		var privilege string
+		if err := rows.Scan(&privilege); err != nil {
+			return nil, err
+		}
+		granted[strings.ToUpper(privilege)] = true
+	}
+	return granted, rows.Err()
+}
+
+// CheckPersistencePrivileges checks the connected user has the privileges it needs on the tables that the config
+// uses, returning an error that lists any that are missing
+func CheckPersistencePrivileges(ctx context.Context, session db.Session, persistConfig *config.PersistConfig) error {
+	tableName, err := GetTableName(persistConfig)
+	if err != nil {
+		return err
+	}
+	tables := []string{tableName}
+	if persistConfig.Archive {
+		tables = append(tables, archiveTableName, archiveLabelsTableName)
+	}
+	var gaps []string
+	for _, table := range tables {
+		missing, err := CheckPrivileges(ctx, session, table, dbTypeFor(session))
+		if err != nil {
+			return err
+		}
+		if len(missing) > 0 {
+			gaps = append(gaps, fmt.Sprintf("%s on %s", strings.Join(missing, ", "), table))
+		}
+	}
+	if len(gaps) > 0 {
+		return fmt.Errorf("the database user is missing privileges: %s", strings.Join(gaps, "; "))
+	}
+	return nil
+}
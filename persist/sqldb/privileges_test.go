@@ -0,0 +1,74 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+func TestCheckPrivileges(t *testing.T) {
+	ctx := context.Background()
+	postgres := func(granted ...string) *fakeConnector {
+		return &fakeConnector{dbType: Postgres, handler: func(query string, args []driver.NamedValue) (*fakeResult, error) {
+			if query != "select has_table_privilege($1, $2)" {
+				return &fakeResult{}, nil
+			}
+			ok := false
+			for _, privilege := range granted {
+				ok = ok || args[1].Value == privilege
+			}
+			return &fakeResult{columns: []string{"has_table_privilege"}, rows: [][]driver.Value{{ok}}}, nil
+		}}
+	}
+	var mySQLArgs []driver.NamedValue
+	mySQL := func(granted ...string) *fakeConnector {
+		return &fakeConnector{dbType: MySQL, handler: func(query string, args []driver.NamedValue) (*fakeResult, error) {
+			switch {
+			case query == "select current_user(), database()":
+				return &fakeResult{columns: []string{"current_user()", "database()"}, rows: [][]driver.Value{{"argo@%", "argo"}}}, nil
+			case strings.HasPrefix(query, "select privilege_type from information_schema.user_privileges"):
+				mySQLArgs = args
+				res := &fakeResult{columns: []string{"privilege_type"}}
+				for _, privilege := range granted {
+					res.rows = append(res.rows, []driver.Value{privilege})
+				}
+				return res, nil
+			}
+			return &fakeResult{}, nil
+		}}
+	}
+	t.Run("Postgres", func(t *testing.T) {
+		missing, err := CheckPrivileges(ctx, newFakeSession(t, postgres("SELECT", "INSERT", "UPDATE", "DELETE")), "argo_workflows", Postgres)
+		require.NoError(t, err)
+		assert.Empty(t, missing)
+		missing, err = CheckPrivileges(ctx, newFakeSession(t, postgres("SELECT", "INSERT", "UPDATE")), "argo_workflows", Postgres)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"DELETE"}, missing)
+	})
+	t.Run("MySQL", func(t *testing.T) {
+		missing, err := CheckPrivileges(ctx, newFakeSession(t, mySQL("SELECT", "INSERT", "UPDATE", "DELETE", "CREATE")), "argo_workflows", MySQL)
+		require.NoError(t, err)
+		assert.Empty(t, missing)
+		if assert.Len(t, mySQLArgs, 6) {
+			assert.Equal(t, "'argo'@'%'", mySQLArgs[0].Value)
+			assert.Equal(t, "argo", mySQLArgs[2].Value)
+			assert.Equal(t, "argo_workflows", mySQLArgs[5].Value)
+		}
+		missing, err = CheckPrivileges(ctx, newFakeSession(t, mySQL("Select", "Insert", "Update")), "other.argo_workflows", MySQL)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"DELETE"}, missing)
+		assert.Equal(t, "other", mySQLArgs[2].Value)
+	})
+	t.Run("CheckPersistencePrivileges", func(t *testing.T) {
+		persistConfig := &config.PersistConfig{Archive: true, PostgreSQL: &config.PostgreSQLConfig{DatabaseConfig: config.DatabaseConfig{TableName: "argo_workflows"}}}
+		require.NoError(t, CheckPersistencePrivileges(ctx, newFakeSession(t, postgres("SELECT", "INSERT", "UPDATE", "DELETE")), persistConfig))
+		err := CheckPersistencePrivileges(ctx, newFakeSession(t, postgres("SELECT", "INSERT")), persistConfig)
+		assert.EqualError(t, err, "the database user is missing privileges: UPDATE, DELETE on argo_workflows; UPDATE, DELETE on argo_archived_workflows; UPDATE, DELETE on argo_archived_workflows_labels")
+	})
+}
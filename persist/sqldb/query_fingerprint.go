@@ -0,0 +1,262 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/upper/db/v4"
+)
+
+// QueryFingerprint is how often queries of one shape, i.e. that only differ in their literals, were run. It never
+// includes the literals, so is safe to expose.
+type QueryFingerprint struct {
+	Fingerprint string `json:"fingerprint"`
+	// Query is the query with its literals and parameters replaced by ?
+	Query string `json:"query"`
+	// Count is approximate once more shapes have been seen than are kept, and may then be an over-estimate
+	Count int64 `json:"count"`
+}
+
+var (
+	// parameterListRegexp matches a list of parameters, e.g. of an IN, so that lists of different lengths share a
+	// fingerprint
+	parameterListRegexp = regexp.MustCompile(`\(\s*\?(\s*,\s*\?)+\s*\)`)
+	whitespaceRegexp    = regexp.MustCompile(`\s+`)
+)
+
+// normalizeQuery replaces the string and number literals and the parameters of the query with ?, and collapses its
+// whitespace. Quoted identifiers are kept.
+func normalizeQuery(query string) string {
+	var b strings.Builder
+	b.Grow(len(query))
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '\'':
+			// a quote is escaped by doubling it, and, for MySQL, by a backslash
+			for i++; i < len(query); i++ {
+				if query[i] == '\\' {
+					i++
+				} else if query[i] == '\'' {
+					if i+1 < len(query) && query[i+1] == '\'' {
+						i++
+					} else {
+						break
+					}
+				}
+			}
+			b.WriteByte('?')
+			i++
+		case c == '"' || c == '`':
+			end := strings.IndexByte(query[i+1:], c)
+			if end < 0 {
+				b.WriteString(query[i:])
+				i = len(query)
+			} else {
+				b.WriteString(query[i : i+end+2])
+				i += end + 2
+			}
+		case c == '$' && i+1 < len(query) && isDigit(query[i+1]):
+			for i++; i < len(query) && isDigit(query[i]); i++ {
+			}
+			b.WriteByte('?')
+		case isDigit(c) && (i == 0 || !isIdentifierChar(query[i-1])):
+			for ; i < len(query) && (isDigit(query[i]) || query[i] == '.'); i++ {
+			}
+			b.WriteByte('?')
+		case isIdentifierChar(c):
+			for start := i; ; i++ {
+				if i == len(query) || !isIdentifierChar(query[i]) {
+					b.WriteString(query[start:i])
+					break
+				}
+			}
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	normalized := strings.TrimSpace(whitespaceRegexp.ReplaceAllString(b.String(), " "))
+	return parameterListRegexp.ReplaceAllString(normalized, "(?)")
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+func isIdentifierChar(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || isDigit(c)
+}
+
+// fingerprintQuery returns the fingerprint of the query's shape, and the normalized query
+func fingerprintQuery(query string) (string, string) {
+	normalized := normalizeQuery(query)
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(normalized))
+	return fmt.Sprintf("%016x", h.Sum64()), normalized
+}
+
+// fingerprintCounter counts the most frequent fingerprints, keeping at most size of them. Once it is full, a new
+// fingerprint replaces the least frequent, and takes over its count (the "space-saving" algorithm), so that a frequent
+// fingerprint that is first seen late still makes it in.
+type fingerprintCounter struct {
+	size   int
+	mu     sync.Mutex
+	counts map[string]*QueryFingerprint
+}
+
+func newFingerprintCounter(size int) *fingerprintCounter {
+	return &fingerprintCounter{size: size, counts: make(map[string]*QueryFingerprint, size)}
+}
+
+func (c *fingerprintCounter) record(query string) {
+	fingerprint, normalized := fingerprintQuery(query)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f, ok := c.counts[fingerprint]; ok {
+		f.Count++
+		return
+	}
+	var count int64
+	if len(c.counts) >= c.size {
+		var least *QueryFingerprint
+		for _, f := range c.counts {
+			if least == nil || f.Count < least.Count {
+				least = f
+			}
+		}
+		delete(c.counts, least.Fingerprint)
+		count = least.Count
+	}
+	c.counts[fingerprint] = &QueryFingerprint{Fingerprint: fingerprint, Query: normalized, Count: count + 1}
+}
+
+// top returns the fingerprints, most frequent first
+func (c *fingerprintCounter) top() []QueryFingerprint {
+	c.mu.Lock()
+	fingerprints := make([]QueryFingerprint, 0, len(c.counts))
+	for _, f := range c.counts {
+		fingerprints = append(fingerprints, *f)
+	}
+	c.mu.Unlock()
+	sort.Slice(fingerprints, func(i, j int) bool {
+		if fingerprints[i].Count != fingerprints[j].Count {
+			return fingerprints[i].Count > fingerprints[j].Count
+		}
+		return fingerprints[i].Query < fingerprints[j].Query
+	})
+	return fingerprints
+}
+
+// fingerprintConnectors are the connectors of the open sessions, by their pool
+var fingerprintConnectors sync.Map
+
+// fingerprintConnector counts the fingerprints of its connections' queries. It has no counter until it is enabled,
+// so that sessions that do not record fingerprints only pay for checking that.
+type fingerprintConnector struct {
+	driver.Connector
+	counter atomic.Pointer[fingerprintCounter]
+	hooks   queryHooks
+	sqlDB   *sql.DB
+	closed  sync.Once
+}
+
+func newFingerprintConnector(c driver.Connector) *fingerprintConnector {
+	connector := &fingerprintConnector{Connector: c}
+	connector.hooks.after = func(_ context.Context, query string, err error) {
+		// the query is run again as a prepared statement
+		if err != driver.ErrSkip {
+			connector.record(query)
+		}
+	}
+	return connector
+}
+
+// register registers the connector for the pool until the pool is closed
+func (c *fingerprintConnector) register(sqlDB *sql.DB) {
+	c.sqlDB = sqlDB
+	fingerprintConnectors.Store(sqlDB, c)
+}
+
+func (c *fingerprintConnector) record(query string) {
+	if counter := c.counter.Load(); counter != nil {
+		counter.record(query)
+	}
+}
+
+func (c *fingerprintConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newHookedConn(conn, &c.hooks), nil
+}
+
+// Close is called when sql.DB is closed
+func (c *fingerprintConnector) Close() error {
+	c.closed.Do(func() { fingerprintConnectors.Delete(c.sqlDB) })
+	if closer, ok := c.Connector.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// recordQueryFingerprints starts counting the fingerprints of the session's queries, keeping the top most frequent,
+// doing nothing if top is not positive or the session was not opened by this package
+func recordQueryFingerprints(session db.Session, top int) {
+	if top <= 0 {
+		return
+	}
+	sqlDB, ok := session.Driver().(*sql.DB)
+	if !ok {
+		return
+	}
+	if c, ok := fingerprintConnectors.Load(sqlDB); ok {
+		c.(*fingerprintConnector).counter.Store(newFingerprintCounter(top))
+	}
+}
+
+// TopQueryFingerprints returns the most frequent fingerprints of the session's queries, most frequent first, or nil
+// if the session does not record them (topQueryFingerprints)
+func TopQueryFingerprints(session db.Session) []QueryFingerprint {
+	sqlDB, ok := session.Driver().(*sql.DB)
+	if !ok {
+		return nil
+	}
+	c, ok := fingerprintConnectors.Load(sqlDB)
+	if !ok {
+		return nil
+	}
+	counter := c.(*fingerprintConnector).counter.Load()
+	if counter == nil {
+		return nil
+	}
+	return counter.top()
+}
+
+// QueryFingerprintsHandler serves the query fingerprints as JSON, it is intended for the admin (pprof) server rather
+// than the API
+func QueryFingerprintsHandler(fingerprints func() []QueryFingerprint) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		f := fingerprints()
+		if f == nil {
+			f = []QueryFingerprint{}
+		}
+		data, err := json.MarshalIndent(f, "", "  ")
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(data)
+	}
+}
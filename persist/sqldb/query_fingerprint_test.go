@@ -0,0 +1,91 @@
+package sqldb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_normalizeQuery(t *testing.T) {
+	for _, tt := range []struct {
+		query string
+		want  string
+	}{
+		{"select * from argo_archived_workflows where uid = 'abc'", "select * from argo_archived_workflows where uid = ?"},
+		{"select * from argo_archived_workflows where uid = 'it''s'", "select * from argo_archived_workflows where uid = ?"},
+		{`select * from t where name = 'a\'b' and x = 1`, "select * from t where name = ? and x = ?"},
+		{"select * from t limit 10 offset 20", "select * from t limit ? offset ?"},
+		{"select * from t where x = 1.5", "select * from t where x = ?"},
+		{"select * from t where uid = $1 and name = $2", "select * from t where uid = ? and name = ?"},
+		{"select * from t where uid in ($1, $2, $3)", "select * from t where uid in (?)"},
+		{"select * from t where uid in ('a','b')", "select * from t where uid in (?)"},
+		{"select * from t2 where col1 = 1", "select * from t2 where col1 = ?"},
+		{`select "col1" from "t 2"`, `select "col1" from "t 2"`},
+		{"select `col1` from t", "select `col1` from t"},
+		{"select *\n\tfrom  t", "select * from t"},
+	} {
+		t.Run(tt.query, func(t *testing.T) {
+			assert.Equal(t, tt.want, normalizeQuery(tt.query))
+		})
+	}
+}
+
+func Test_fingerprintQuery(t *testing.T) {
+	a, _ := fingerprintQuery("select * from argo_archived_workflows where namespace = 'argo' and uid = 'abc' limit 10")
+	b, _ := fingerprintQuery("select * from argo_archived_workflows where namespace = 'default' and uid = 'def' limit 100")
+	c, _ := fingerprintQuery("select * from argo_archived_workflows where namespace = 'argo' limit 10")
+	assert.Equal(t, a, b, "queries of the same shape share a fingerprint")
+	assert.NotEqual(t, a, c)
+}
+
+func Test_fingerprintCounter(t *testing.T) {
+	counter := newFingerprintCounter(2)
+	for i := 0; i < 3; i++ {
+		counter.record(fmt.Sprintf("select * from a where x = %d", i))
+	}
+	counter.record("select * from b where x = 'y'")
+	counter.record("select * from c")
+	top := counter.top()
+	require.Len(t, top, 2, "the counter is bounded")
+	assert.Equal(t, "select * from a where x = ?", top[0].Query)
+	assert.Equal(t, int64(3), top[0].Count)
+	assert.Equal(t, "select * from c", top[1].Query, "the new fingerprint replaces the least frequent")
+	assert.Equal(t, int64(2), top[1].Count, "and takes over its count")
+}
+
+func TestTopQueryFingerprints(t *testing.T) {
+	session := newFakeSession(t, &fakeConnector{dbType: Postgres})
+	assert.Nil(t, TopQueryFingerprints(session), "not recorded unless enabled")
+
+	recordQueryFingerprints(session, 10)
+	for _, uid := range []string{"a", "b", "c"} {
+		_, err := session.SQL().Exec("delete from argo_archived_workflows where uid = '" + uid + "'")
+		require.NoError(t, err)
+	}
+	_, err := session.SQL().Query("select 1")
+	require.NoError(t, err)
+	top := TopQueryFingerprints(session)
+	require.Len(t, top, 2)
+	assert.Equal(t, "delete from argo_archived_workflows where uid = ?", top[0].Query)
+	assert.Equal(t, int64(3), top[0].Count)
+	assert.Equal(t, "select ?", top[1].Query)
+
+	t.Run("Handler", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		QueryFingerprintsHandler(func() []QueryFingerprint { return TopQueryFingerprints(session) })(w, httptest.NewRequest("GET", "/debug/persistence/queries", nil))
+		assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+		var got []QueryFingerprint
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &got))
+		assert.Equal(t, top, got)
+		assert.NotContains(t, w.Body.String(), "'a'", "the literals are not exposed")
+	})
+	t.Run("Disabled", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		QueryFingerprintsHandler(func() []QueryFingerprint { return nil })(w, httptest.NewRequest("GET", "/debug/persistence/queries", nil))
+		assert.Equal(t, "[]", w.Body.String())
+	})
+}
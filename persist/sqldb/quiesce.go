@@ -0,0 +1,122 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/upper/db/v4"
+
+	errorsutil "github.com/argoproj/argo-workflows/v3/util/errors"
+)
+
+// ErrMaintenanceInProgress is returned instead of running a write while the session is quiesced. It is transient,
+// so callers that retry transient errors retry the write.
+var ErrMaintenanceInProgress = errorsutil.NewErrTransient("database maintenance in progress, writes are paused")
+
+// quiesceGates are the gates of the open sessions, by their pool
+var quiesceGates sync.Map
+
+// quiesceGate fails writes while it is quiesced
+type quiesceGate struct {
+	quiesced atomic.Bool
+}
+
+// check fails the query if it writes while the gate is quiesced
+func (g *quiesceGate) check(_ context.Context, query string) (func(), error) {
+	if g.quiesced.Load() && isWriteStatement(query) {
+		return nil, ErrMaintenanceInProgress
+	}
+	return nil, nil
+}
+
+// Quiesce makes writes using the session fail with ErrMaintenanceInProgress, while reads continue, e.g. while a
+// migration runs on another session. Writes that are in flight are not interrupted.
+func Quiesce(session db.Session) error {
+	gate, err := quiesceGateFor(session)
+	if err != nil {
+		return err
+	}
+	gate.quiesced.Store(true)
+	logger().Info("Database writes quiesced")
+	return nil
+}
+
+// Resume allows writes using the session again
+func Resume(session db.Session) error {
+	gate, err := quiesceGateFor(session)
+	if err != nil {
+		return err
+	}
+	gate.quiesced.Store(false)
+	logger().Info("Database writes resumed")
+	return nil
+}
+
+func quiesceGateFor(session db.Session) (*quiesceGate, error) {
+	sqlDB, ok := session.Driver().(*sql.DB)
+	if ok {
+		if gate, ok := quiesceGates.Load(sqlDB); ok {
+			return gate.(*quiesceGate), nil
+		}
+	}
+	return nil, fmt.Errorf("cannot quiesce %T, it was not opened by this package or is in lightweight mode", session.Driver())
+}
+
+// writeStatementRegexp matches statements that write, or that may write, such as a CTE
+var writeStatementRegexp = regexp.MustCompile(`(?i)^\s*\(*\s*(insert|update|delete|replace|merge|upsert|create|alter|drop|truncate|rename|grant|revoke|with)\b`)
+
+// isWriteStatement returns whether the statement writes. A CTE is treated as a write if it contains a data-modifying
+// keyword, which may wrongly block a read that only mentions one, but never lets a write through.
+func isWriteStatement(query string) bool {
+	m := writeStatementRegexp.FindStringSubmatch(query)
+	if m == nil {
+		return false
+	}
+	if strings.EqualFold(m[1], "with") {
+		return dataModifyingRegexp.MatchString(query)
+	}
+	return true
+}
+
+var dataModifyingRegexp = regexp.MustCompile(`(?i)\b(insert|update|delete)\b`)
+
+// quiesceConnector fails writes on its connections while its gate is quiesced
+type quiesceConnector struct {
+	driver.Connector
+	hooks  queryHooks
+	sqlDB  *sql.DB
+	closed sync.Once
+}
+
+// openQuiescable opens the pool, registering its gate until the pool is closed
+func openQuiescable(c driver.Connector) *sql.DB {
+	gate := &quiesceGate{}
+	connector := &quiesceConnector{Connector: c, hooks: queryHooks{before: gate.check}}
+	connector.sqlDB = sql.OpenDB(connector)
+	quiesceGates.Store(connector.sqlDB, gate)
+	return connector.sqlDB
+}
+
+func (c *quiesceConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newHookedConn(conn, &c.hooks), nil
+}
+
+// Close is called when sql.DB is closed
+func (c *quiesceConnector) Close() error {
+	c.closed.Do(func() { quiesceGates.Delete(c.sqlDB) })
+	if closer, ok := c.Connector.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
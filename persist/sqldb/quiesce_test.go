@@ -0,0 +1,82 @@
+package sqldb
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/upper/db/v4"
+
+	errorsutil "github.com/argoproj/argo-workflows/v3/util/errors"
+)
+
+// otherPoolSession is a session whose pool was opened elsewhere
+type otherPoolSession struct {
+	db.Session
+	sqlDB *sql.DB
+}
+
+func (s *otherPoolSession) Driver() interface{} { return s.sqlDB }
+
+func TestQuiesce(t *testing.T) {
+	session := newFakeSession(t, &fakeConnector{dbType: Postgres})
+	write := func() error {
+		_, err := session.SQL().InsertInto("argo_workflows").Values(map[string]interface{}{"uid": "my-uid"}).Exec()
+		return err
+	}
+	read := func() error {
+		rows, err := session.SQL().Query("select uid from argo_workflows")
+		if err != nil {
+			return err
+		}
+		return rows.Close()
+	}
+	// the write is prepared and cached before the session is quiesced
+	require.NoError(t, write())
+
+	require.NoError(t, Quiesce(session))
+	err := write()
+	assert.ErrorIs(t, err, ErrMaintenanceInProgress)
+	assert.True(t, errorsutil.IsTransientErr(err), "the write can be retried")
+	_, err = session.SQL().Exec("delete from argo_workflows where uid = ?", "my-uid")
+	assert.ErrorIs(t, err, ErrMaintenanceInProgress)
+	assert.ErrorIs(t, session.Tx(func(tx db.Session) error {
+		_, err := tx.SQL().Update("argo_workflows").Set("phase", "Succeeded").Exec()
+		return err
+	}), ErrMaintenanceInProgress)
+	assert.NoError(t, read(), "reads pass")
+
+	require.NoError(t, Resume(session))
+	assert.NoError(t, write())
+	assert.NoError(t, read())
+
+	t.Run("NotOpenedByThisPackage", func(t *testing.T) {
+		other := &otherPoolSession{Session: session, sqlDB: sql.OpenDB(&fakeConnector{})}
+		defer func() { _ = other.sqlDB.Close() }()
+		assert.EqualError(t, Quiesce(other), "cannot quiesce *sql.DB, it was not opened by this package or is in lightweight mode")
+	})
+	t.Run("Closed", func(t *testing.T) {
+		closed := newFakeSession(t, &fakeConnector{dbType: Postgres})
+		sqlDB := closed.Driver().(*sql.DB)
+		require.NoError(t, closed.Close())
+		_, ok := quiesceGates.Load(sqlDB)
+		assert.False(t, ok, "the gate is unregistered")
+	})
+}
+
+func Test_isWriteStatement(t *testing.T) {
+	for query, write := range map[string]bool{
+		`INSERT INTO "argo_workflows" ("uid") VALUES ($1)`:                                 true,
+		"update argo_workflows set phase = $1":                                             true,
+		"  delete from argo_workflows":                                                     true,
+		"create index argo_workflows_i1 on argo_workflows (clustername)":                   true,
+		"with expired as (select uid from argo_workflows) delete from argo_workflows":      true,
+		"select uid from argo_workflows":                                                   false,
+		"with names as (select name from argo_workflows) select * from names":              false,
+		"(select uid from argo_workflows) union (select uid from argo_archived_workflows)": false,
+		"set statement_timeout = 1000":                                                     false,
+	} {
+		assert.Equal(t, write, isWriteStatement(query), query)
+	}
+}
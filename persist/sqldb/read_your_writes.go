@@ -0,0 +1,115 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/upper/db/v4"
+)
+
+// ConsistencyToken is the writer's position after a write, so that a later read can wait for the reader to have
+// replayed the write, i.e. read its own writes. It is the WAL LSN for Postgres, and the executed GTID set for MySQL.
+// The zero token means that the position is not known, e.g. MySQL without GTIDs, and reads after it use the writer.
+type ConsistencyToken string
+
+const (
+	defaultReadAfterWriteTimeout = time.Second
+	readAfterWritePollInterval   = 10 * time.Millisecond
+)
+
+// WriteWithToken is Write, that also returns the token of the writer's position once fn has written. Without a
+// separate reader every read sees the write, so the position is not got and the token is the zero token.
+func (s *SplitSession) WriteWithToken(ctx context.Context, fn func(session db.Session) error) (ConsistencyToken, error) {
+	var token ConsistencyToken
+	err := s.Write(func(session db.Session) error {
+		if err := fn(session); err != nil {
+			return err
+		}
+		if s.Reader() != session {
+			token = writerPosition(ctx, session)
+		}
+		return nil
+	})
+	return token, err
+}
+
+// ReadAfter runs fn against the reader once it has replayed the writes up to token, so that fn sees them. If the
+// reader has not caught up within readAfterWriteTimeout, or the token is the zero token, fn is run against the writer
+// instead.
+func (s *SplitSession) ReadAfter(ctx context.Context, token ConsistencyToken, fn func(session db.Session) error) error {
+	s.mu.RLock()
+	writer, reader := s.writer, s.reader
+	s.mu.RUnlock()
+	if reader == writer || token == "" {
+		return fn(writer)
+	}
+	caughtUp, err := s.waitForReader(ctx, reader, token)
+	if err != nil {
+		return err
+	}
+	if !caughtUp {
+		logger().WithField("token", token).Debug("Database reader has not caught up with the write, reading from the writer")
+		return fn(writer)
+	}
+	return fn(reader)
+}
+
+// writerPosition returns the token of the writer's current position, or the zero token if it is not known, e.g.
+// MySQL without GTIDs
+func writerPosition(ctx context.Context, session db.Session) ConsistencyToken {
+	query := "select pg_current_wal_lsn()::text"
+	if dbTypeFor(session) == MySQL {
+		query = "select @@global.gtid_executed"
+	}
+	var position sql.NullString
+	row, err := session.SQL().QueryRowContext(ctx, query)
+	if err == nil {
+		err = row.Scan(&position)
+	}
+	if err != nil {
+		logger().WithError(err).Debug("Failed to get the database writer's position, reads after the write will use the writer")
+		return ""
+	}
+	return ConsistencyToken(position.String)
+}
+
+// waitForReader polls the reader until it has replayed the writes up to token, returning false if it has not within
+// readAfterWriteTimeout, or its position cannot be got
+func (s *SplitSession) waitForReader(ctx context.Context, reader db.Session, token ConsistencyToken) (bool, error) {
+	query := "select pg_last_wal_replay_lsn() >= cast(? as pg_lsn)"
+	if dbTypeFor(reader) == MySQL {
+		query = "select gtid_subset(?, @@global.gtid_executed)"
+	}
+	timeout := s.readAfterWriteTimeout
+	if timeout <= 0 {
+		timeout = defaultReadAfterWriteTimeout
+	}
+	deadline := clock.Now().Add(timeout)
+	for {
+		var caughtUp sql.NullBool
+		row, err := reader.SQL().QueryRowContext(ctx, query, string(token))
+		if err == nil {
+			err = row.Scan(&caughtUp)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return false, ctx.Err()
+			}
+			logger().WithError(err).Debug("Failed to get the database reader's position")
+			return false, nil
+		}
+		// a reader that is not replaying is not a replica, so it has every write
+		if !caughtUp.Valid || caughtUp.Bool {
+			return true, nil
+		}
+		if !clock.Now().Before(deadline) {
+			return false, nil
+		}
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-clock.After(readAfterWritePollInterval):
+		}
+	}
+}
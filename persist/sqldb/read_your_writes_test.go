@@ -0,0 +1,131 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/upper/db/v4"
+)
+
+// laggingReader is a Postgres replica that replays the writer's WAL after it has been asked for its position lag times,
+// or never if lag is negative
+func laggingReader(lag int32) (*fakeConnector, *atomic.Int32) {
+	var polls atomic.Int32
+	return &fakeConnector{dbType: Postgres, handler: func(query string, args []driver.NamedValue) (*fakeResult, error) {
+		if strings.Contains(query, "pg_last_wal_replay_lsn") {
+			caughtUp := lag >= 0 && polls.Add(1) > lag
+			return &fakeResult{columns: []string{"caught_up"}, rows: [][]driver.Value{{caughtUp}}}, nil
+		}
+		return &fakeResult{}, nil
+	}}, &polls
+}
+
+func TestSplitSession_ReadAfter(t *testing.T) {
+	ctx := context.Background()
+	insert := func(session db.Session) error {
+		_, err := session.SQL().Exec("insert into argo_workflows values (1)")
+		return err
+	}
+	read := func(session db.Session) error {
+		_, err := session.SQL().Exec("select * from argo_workflows")
+		return err
+	}
+	newWriter := func() *fakeConnector {
+		return &fakeConnector{dbType: Postgres, handler: func(query string, _ []driver.NamedValue) (*fakeResult, error) {
+			if strings.Contains(query, "pg_current_wal_lsn") {
+				return &fakeResult{columns: []string{"lsn"}, rows: [][]driver.Value{{"0/16B3740"}}}, nil
+			}
+			return &fakeResult{}, nil
+		}}
+	}
+	t.Run("WriteWithToken", func(t *testing.T) {
+		writer := newWriter()
+		s := NewSplitSession(newFakeSession(t, writer), newFakeSession(t, &fakeConnector{dbType: Postgres}), nil)
+		token, err := s.WriteWithToken(ctx, insert)
+		require.NoError(t, err)
+		assert.Equal(t, ConsistencyToken("0/16B3740"), token)
+		assert.Contains(t, writer.Statements(), "insert into argo_workflows values (1)")
+	})
+	t.Run("WriteWithoutReader", func(t *testing.T) {
+		writer := newWriter()
+		session := newFakeSession(t, writer)
+		token, err := NewSplitSession(session, session, nil).WriteWithToken(ctx, insert)
+		require.NoError(t, err)
+		assert.Empty(t, token)
+		assert.NotContains(t, writer.Statements(), "select pg_current_wal_lsn()::text", "reads see the write without waiting")
+	})
+	t.Run("WriteFailed", func(t *testing.T) {
+		writer := newWriter()
+		s := NewSplitSession(newFakeSession(t, writer), newFakeSession(t, &fakeConnector{dbType: Postgres}), nil)
+		_, err := s.WriteWithToken(ctx, func(db.Session) error { return errors.New("failed") })
+		require.EqualError(t, err, "failed")
+		assert.NotContains(t, writer.Statements(), "select pg_current_wal_lsn()::text")
+	})
+	t.Run("ReaderCaughtUp", func(t *testing.T) {
+		clock := useFakeClock(t)
+		writer := newWriter()
+		reader, polls := laggingReader(0)
+		s := NewSplitSession(newFakeSession(t, writer), newFakeSession(t, reader), nil)
+		require.NoError(t, s.ReadAfter(ctx, "0/16B3740", read))
+		assert.Equal(t, int32(1), polls.Load())
+		assert.Empty(t, clock.Sleeps(), "the reader is not waited for")
+		assert.Contains(t, reader.Statements(), "select * from argo_workflows")
+	})
+	t.Run("ReaderLags", func(t *testing.T) {
+		clock := useFakeClock(t)
+		writer := newWriter()
+		reader, polls := laggingReader(3)
+		s := NewSplitSession(newFakeSession(t, writer), newFakeSession(t, reader), nil)
+		require.NoError(t, s.ReadAfter(ctx, "0/16B3740", read))
+		assert.Equal(t, int32(4), polls.Load())
+		assert.Equal(t, []time.Duration{readAfterWritePollInterval, readAfterWritePollInterval, readAfterWritePollInterval}, clock.Sleeps(), "the read waits for the reader")
+		assert.Contains(t, reader.Statements(), "select * from argo_workflows")
+		assert.NotContains(t, writer.Statements(), "select * from argo_workflows")
+	})
+	t.Run("ReaderTooFarBehind", func(t *testing.T) {
+		clock := useFakeClock(t)
+		writer := newWriter()
+		reader, _ := laggingReader(-1)
+		s := NewSplitSession(newFakeSession(t, writer), newFakeSession(t, reader), nil)
+		s.readAfterWriteTimeout = 100 * time.Millisecond
+		start := clock.Now()
+		require.NoError(t, s.ReadAfter(ctx, "0/16B3740", read))
+		assert.Equal(t, 100*time.Millisecond, clock.Now().Sub(start), "the read waits for the timeout")
+		assert.Contains(t, writer.Statements(), "select * from argo_workflows", "the read falls back to the writer")
+		assert.NotContains(t, reader.Statements(), "select * from argo_workflows")
+	})
+	t.Run("NotAReplica", func(t *testing.T) {
+		reader := &fakeConnector{dbType: Postgres, handler: func(query string, _ []driver.NamedValue) (*fakeResult, error) {
+			if strings.Contains(query, "pg_last_wal_replay_lsn") {
+				return &fakeResult{columns: []string{"caught_up"}, rows: [][]driver.Value{{nil}}}, nil
+			}
+			return &fakeResult{}, nil
+		}}
+		s := NewSplitSession(newFakeSession(t, newWriter()), newFakeSession(t, reader), nil)
+		require.NoError(t, s.ReadAfter(ctx, "0/16B3740", read))
+		assert.Contains(t, reader.Statements(), "select * from argo_workflows")
+	})
+	t.Run("NoToken", func(t *testing.T) {
+		writer := newWriter()
+		reader, polls := laggingReader(0)
+		s := NewSplitSession(newFakeSession(t, writer), newFakeSession(t, reader), nil)
+		require.NoError(t, s.ReadAfter(ctx, "", read))
+		assert.Zero(t, polls.Load())
+		assert.Contains(t, writer.Statements(), "select * from argo_workflows", "a position that is not known uses the writer")
+	})
+	t.Run("Cancelled", func(t *testing.T) {
+		useFakeClock(t)
+		reader, _ := laggingReader(-1)
+		s := NewSplitSession(newFakeSession(t, newWriter()), newFakeSession(t, reader), nil)
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		assert.ErrorIs(t, s.ReadAfter(ctx, "0/16B3740", read), context.Canceled)
+	})
+}
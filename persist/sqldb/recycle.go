@@ -0,0 +1,137 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+const (
+	defaultRecycleFraction = 0.1
+	// recycleJitter is how far either side of the interval each recycle may happen, so that controllers started
+	// together do not all reconnect at once
+	recycleJitter = 0.2
+)
+
+// recycleConnector wraps a connector so that, on a jittered interval, a fraction of the pool's connections are
+// discarded and replaced. This is independent of ConnMaxLifetime, and is to let a load balancer in front of the
+// database rebalance connections that were all opened at the same time, e.g. at startup or after a failover.
+//
+// database/sql does not allow a connection to be closed while it is in the pool, so a connection that is picked
+// for recycling is closed the next time it is taken from, or returned to, the pool.
+type recycleConnector struct {
+	driver.Connector
+	interval time.Duration
+	fraction float64
+	clock    Clock
+
+	mu    sync.Mutex
+	conns map[*recycleConn]bool
+	next  time.Time
+}
+
+func newRecycleConnector(c driver.Connector, persistPool *config.ConnectionPool) (driver.Connector, error) {
+	if persistPool == nil || persistPool.RecycleInterval <= 0 {
+		return c, nil
+	}
+	fraction := persistPool.RecycleFraction
+	if fraction == 0 {
+		fraction = defaultRecycleFraction
+	}
+	if fraction < 0 || fraction > 1 {
+		return nil, fmt.Errorf("recycleFraction must be between 0 and 1")
+	}
+	r := &recycleConnector{
+		Connector: c,
+		interval:  time.Duration(persistPool.RecycleInterval),
+		fraction:  fraction,
+		clock:     clock,
+		conns:     make(map[*recycleConn]bool),
+	}
+	r.next = r.clock.Now().Add(r.jitteredInterval())
+	return r, nil
+}
+
+func (r *recycleConnector) jitteredInterval() time.Duration {
+	return time.Duration(float64(r.interval) * (1 + recycleJitter*(2*rand.Float64()-1)))
+}
+
+func (r *recycleConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := r.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c := &recycleConn{wrappedConn: wrappedConn{Conn: conn}, connector: r}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.conns[c] = false
+	return c, nil
+}
+
+// maybeRecycle picks the connections to recycle if the interval has passed. It is called whenever the pool checks a
+// connection, rather than from a goroutine, so there is nothing to stop once the session is closed.
+func (r *recycleConnector) maybeRecycle() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.clock.Now()
+	if now.Before(r.next) {
+		return
+	}
+	r.next = now.Add(r.jitteredInterval())
+	var candidates []*recycleConn
+	for c, picked := range r.conns {
+		if !picked {
+			candidates = append(candidates, c)
+		}
+	}
+	// round at random, so that on average the fraction is recycled even when the pool is small
+	n := int(r.fraction*float64(len(candidates)) + rand.Float64())
+	rand.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	for _, c := range candidates[:n] {
+		r.conns[c] = true
+	}
+	if n > 0 {
+		logger().WithField("connections", n).Debug("Recycling DB connections")
+	}
+}
+
+func (r *recycleConnector) picked(c *recycleConn) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.conns[c]
+}
+
+type recycleConn struct {
+	wrappedConn
+	connector *recycleConnector
+}
+
+func (c *recycleConn) recycle() bool {
+	c.connector.maybeRecycle()
+	return c.connector.picked(c)
+}
+
+// ResetSession is called when the connection is taken from the pool
+func (c *recycleConn) ResetSession(ctx context.Context) error {
+	if c.recycle() {
+		return driver.ErrBadConn
+	}
+	return c.wrappedConn.ResetSession(ctx)
+}
+
+// IsValid is called when the connection is returned to the pool
+func (c *recycleConn) IsValid() bool {
+	return !c.recycle() && c.wrappedConn.IsValid()
+}
+
+func (c *recycleConn) Close() error {
+	c.connector.mu.Lock()
+	delete(c.connector.conns, c)
+	c.connector.mu.Unlock()
+	return c.Conn.Close()
+}
@@ -0,0 +1,75 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+func Test_newRecycleConnector(t *testing.T) {
+	fake := &fakeConnector{dbType: Postgres}
+	t.Run("Disabled", func(t *testing.T) {
+		c, err := newRecycleConnector(fake, &config.ConnectionPool{RecycleFraction: 0.5})
+		require.NoError(t, err)
+		assert.Same(t, fake, c)
+	})
+	t.Run("DefaultFraction", func(t *testing.T) {
+		c, err := newRecycleConnector(fake, &config.ConnectionPool{RecycleInterval: config.TTL(time.Minute)})
+		require.NoError(t, err)
+		assert.InDelta(t, defaultRecycleFraction, c.(*recycleConnector).fraction, 0)
+	})
+	t.Run("InvalidFraction", func(t *testing.T) {
+		_, err := newRecycleConnector(fake, &config.ConnectionPool{RecycleInterval: config.TTL(time.Minute), RecycleFraction: 1.5})
+		assert.EqualError(t, err, "recycleFraction must be between 0 and 1")
+	})
+}
+
+func TestRecycleConnector(t *testing.T) {
+	clock := useFakeClock(t)
+	fake := &fakeConnector{dbType: Postgres}
+	c, err := newRecycleConnector(fake, &config.ConnectionPool{RecycleInterval: config.TTL(time.Minute), RecycleFraction: 0.2})
+	require.NoError(t, err)
+	sqlDB := sql.OpenDB(c)
+	defer func() { _ = sqlDB.Close() }()
+	const poolSize = 10
+	sqlDB.SetMaxIdleConns(poolSize)
+	ctx := context.Background()
+	// use every connection in the pool, and then return them to it
+	usePool := func() {
+		conns := make([]*sql.Conn, poolSize)
+		for i := range conns {
+			conn, err := sqlDB.Conn(ctx)
+			require.NoError(t, err)
+			require.NoError(t, conn.PingContext(ctx))
+			conns[i] = conn
+		}
+		for _, conn := range conns {
+			require.NoError(t, conn.Close())
+		}
+	}
+
+	usePool()
+	require.Len(t, fake.Conns(), poolSize)
+	t.Run("BeforeInterval", func(t *testing.T) {
+		clock.Advance(30 * time.Second)
+		usePool()
+		assert.Len(t, fake.Conns(), poolSize)
+	})
+	t.Run("Recycled", func(t *testing.T) {
+		const intervals = 20
+		for i := 0; i < intervals; i++ {
+			// past the jitter
+			clock.Advance(2 * time.Minute)
+			usePool()
+		}
+		recycled := len(fake.Conns()) - poolSize
+		assert.InDelta(t, intervals*poolSize*0.2, recycled, 10)
+		assert.Equal(t, poolSize, sqlDB.Stats().Idle)
+	})
+}
@@ -0,0 +1,25 @@
+package sqldb
+
+// MaintenanceOption configures the migration and the other maintenance that this package does on the tables
+type MaintenanceOption func(*maintenanceOptions)
+
+type maintenanceOptions struct {
+	replicationSafe bool
+}
+
+// WithReplicationSafeMode avoids the maintenance that breaks logical replication from the database, as DDL is not
+// replicated: ResetTable deletes the rows rather than dropping and recreating the tables, and the migration gives
+// schema_history, which has no primary key, a replica identity so that it can be updated if it is published.
+func WithReplicationSafeMode(enabled bool) MaintenanceOption {
+	return func(o *maintenanceOptions) {
+		o.replicationSafe = enabled
+	}
+}
+
+func newMaintenanceOptions(opts []MaintenanceOption) maintenanceOptions {
+	var o maintenanceOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
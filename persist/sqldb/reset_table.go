@@ -0,0 +1,58 @@
+package sqldb
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/upper/db/v4"
+)
+
+// ResetConfirmation must be passed to ResetTable to acknowledge that every archived and offloaded workflow will be
+// deleted. It exists so that a reset cannot happen by accident, e.g. by passing `true` to the wrong parameter.
+const ResetConfirmation = "yes-delete-all-persisted-workflows"
+
+// ResetTable drops the offload table (tableName), the archive tables and the schema history if they exist, and
+// then recreates them by running the migration from scratch for the cluster, so the DDL is exactly the same as a fresh
+// install. This is intended for tests and CI, never for production.
+//
+// In replication-safe mode, the tables are migrated and then every row is deleted instead, as dropping the tables would
+// break the subscribers.
+func ResetTable(ctx context.Context, session db.Session, clusterName, tableName string, t dbType, confirmation string, opts ...MaintenanceOption) error {
+	if confirmation != ResetConfirmation {
+		return fmt.Errorf("refusing to reset %s: confirmation %q must be given", tableName, ResetConfirmation)
+	}
+	if tableName == "" {
+		return fmt.Errorf("refusing to reset: tableName is empty")
+	}
+	var cascade string
+	switch t {
+	case Postgres:
+		cascade = " cascade"
+	case MySQL:
+	default:
+		return fmt.Errorf("unsupported database type %q", t)
+	}
+	o := newMaintenanceOptions(opts)
+	logger().WithFields(log.Fields{"clusterName": clusterName, "tableName": tableName, "dbType": t, "replicationSafe": o.replicationSafe}).Warn("Resetting database tables")
+	if o.replicationSafe {
+		// migrate first, so that the tables exist to be deleted from
+		if err := NewMigrate(session, clusterName, tableName, opts...).Exec(ctx); err != nil {
+			return err
+		}
+		for _, name := range []string{archiveLabelsTableName, archiveTableName, tableName} {
+			if _, err := session.SQL().ExecContext(ctx, "delete from "+name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// drop in reverse dependency order, as the labels table has a foreign key on the archive table
+	for _, name := range []string{archiveLabelsTableName, archiveTableName, tableName, "schema_history"} {
+		_, err := session.SQL().ExecContext(ctx, "drop table if exists "+name+cascade)
+		if err != nil {
+			return err
+		}
+	}
+	return NewMigrate(session, clusterName, tableName, opts...).Exec(ctx)
+}
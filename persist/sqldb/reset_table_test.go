@@ -0,0 +1,195 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/upper/db/v4"
+)
+
+// fakeTables models which tables exist and how many rows each has, from the statements that create, rename, drop,
+// insert into and delete from them
+type fakeTables struct {
+	mu   sync.Mutex
+	rows map[string]int
+}
+
+var (
+	createTableRegexp = regexp.MustCompile(`^create table if not exists (\w+)`)
+	renameTableRegexp = regexp.MustCompile(`^alter table (\w+) rename to (\w+)`)
+	dropTableRegexp   = regexp.MustCompile(`^drop table if exists (\w+)`)
+	insertRegexp      = regexp.MustCompile(`^insert into (\w+)`)
+	deleteRegexp      = regexp.MustCompile(`^delete from (\w+)`)
+	countRegexp       = regexp.MustCompile(`^select count\(\*\) from (\w+)`)
+)
+
+func newFakeTables() *fakeTables {
+	return &fakeTables{rows: map[string]int{}}
+}
+
+func (f *fakeTables) handler(query string, _ []driver.NamedValue) (*fakeResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	exists := func(name string) error {
+		if _, ok := f.rows[name]; !ok {
+			return fmt.Errorf("table %s does not exist", name)
+		}
+		return nil
+	}
+	if m := createTableRegexp.FindStringSubmatch(query); m != nil {
+		if _, ok := f.rows[m[1]]; !ok {
+			f.rows[m[1]] = 0
+		}
+	} else if m := renameTableRegexp.FindStringSubmatch(query); m != nil {
+		if err := exists(m[1]); err != nil {
+			return nil, err
+		}
+		f.rows[m[2]] = f.rows[m[1]]
+		delete(f.rows, m[1])
+	} else if m := dropTableRegexp.FindStringSubmatch(query); m != nil {
+		delete(f.rows, m[1])
+	} else if m := insertRegexp.FindStringSubmatch(query); m != nil {
+		if err := exists(m[1]); err != nil {
+			return nil, err
+		}
+		f.rows[m[1]]++
+	} else if m := deleteRegexp.FindStringSubmatch(query); m != nil {
+		if err := exists(m[1]); err != nil {
+			return nil, err
+		}
+		f.rows[m[1]] = 0
+	} else if m := countRegexp.FindStringSubmatch(query); m != nil {
+		if err := exists(m[1]); err != nil {
+			return nil, err
+		}
+		return &fakeResult{columns: []string{"count"}, rows: [][]driver.Value{{int64(f.rows[m[1]])}}}, nil
+	}
+	return &fakeResult{}, nil
+}
+
+// populate migrates the tables for another cluster, and inserts a row into each
+func populate(t *testing.T, session db.Session) {
+	require.NoError(t, NewMigrate(session, "other-cluster", "argo_workflows").Exec(context.Background()))
+	for _, name := range []string{"argo_workflows", "argo_archived_workflows", "argo_archived_workflows_labels"} {
+		_, err := session.SQL().Exec("insert into " + name + " values (1)")
+		require.NoError(t, err)
+	}
+}
+
+// assertTablesReset asserts that each table exists, and is empty, and that the tables were migrated for the cluster
+func assertTablesReset(t *testing.T, session db.Session, statements []string) {
+	for _, name := range []string{"argo_workflows", "argo_archived_workflows", "argo_archived_workflows_labels"} {
+		var count int
+		row, err := session.SQL().QueryRow("select count(*) from " + name)
+		require.NoError(t, err)
+		require.NoError(t, row.Scan(&count), "%s exists", name)
+		assert.Zero(t, count, "%s is empty", name)
+	}
+	assert.Contains(t, statements, "update argo_workflows set clustername = 'my-cluster' where clustername is null")
+}
+
+func TestResetTable(t *testing.T) {
+	t.Run("NoConfirmation", func(t *testing.T) {
+		connector := &fakeConnector{}
+		session := newFakeSession(t, connector)
+		err := ResetTable(context.Background(), session, "my-cluster", "argo_workflows", Postgres, "yes")
+		assert.EqualError(t, err, `refusing to reset argo_workflows: confirmation "yes-delete-all-persisted-workflows" must be given`)
+		for _, s := range connector.Statements() {
+			assert.NotContains(t, s, "drop table")
+		}
+	})
+	for _, tt := range []struct {
+		dbType  dbType
+		cascade string
+	}{
+		{Postgres, " cascade"},
+		{MySQL, ""},
+	} {
+		t.Run(string(tt.dbType), func(t *testing.T) {
+			connector := &fakeConnector{dbType: tt.dbType, handler: newFakeTables().handler}
+			session := newFakeSession(t, connector)
+			populate(t, session)
+			start := len(connector.Statements())
+			require.NoError(t, ResetTable(context.Background(), session, "my-cluster", "argo_workflows", tt.dbType, ResetConfirmation))
+			statements := connector.Statements()[start:]
+			var drops []string
+			for _, s := range statements {
+				if strings.HasPrefix(s, "drop table") {
+					drops = append(drops, s)
+				}
+			}
+			assert.Equal(t, []string{
+				"drop table if exists argo_archived_workflows_labels" + tt.cascade,
+				"drop table if exists argo_archived_workflows" + tt.cascade,
+				"drop table if exists argo_workflows" + tt.cascade,
+				"drop table if exists schema_history" + tt.cascade,
+			}, drops)
+			dropped := indexOf(statements, "drop table if exists schema_history")
+			created := indexOf(statements, "create table if not exists argo_workflows (")
+			assert.Less(t, dropped, created, "tables must be dropped before they are recreated")
+			assertTablesReset(t, session, statements)
+		})
+	}
+}
+
+func indexOf(statements []string, prefix string) int {
+	for i, s := range statements {
+		if strings.HasPrefix(s, prefix) {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestResetTable_ReplicationSafeMode(t *testing.T) {
+	connector := &fakeConnector{dbType: Postgres, handler: newFakeTables().handler}
+	session := newFakeSession(t, connector)
+	populate(t, session)
+	start := len(connector.Statements())
+	require.NoError(t, ResetTable(context.Background(), session, "my-cluster", "argo_workflows", Postgres, ResetConfirmation, WithReplicationSafeMode(true)))
+	statements := connector.Statements()[start:]
+	var deletes []string
+	for _, s := range statements {
+		assert.NotContains(t, s, "drop table", "tables must not be dropped, as DDL is not replicated")
+		assert.NotContains(t, s, "truncate")
+		if strings.HasPrefix(s, "delete from") {
+			deletes = append(deletes, s)
+		}
+	}
+	assert.Equal(t, []string{
+		"delete from argo_archived_workflows_labels",
+		"delete from argo_archived_workflows",
+		"delete from argo_workflows",
+	}, deletes)
+	identity := indexOf(statements, "alter table schema_history replica identity full")
+	assert.NotEqual(t, -1, identity)
+	assert.Less(t, identity, indexOf(statements, "update schema_history"), "schema_history must have a replica identity before it is updated")
+	assert.Less(t, indexOf(statements, "create table if not exists argo_workflows ("), indexOf(statements, "delete from argo_workflows"), "tables must be migrated before they are deleted from")
+	assertTablesReset(t, session, statements)
+}
+
+func TestNewMigrate_ReplicationSafeMode(t *testing.T) {
+	for _, tt := range []struct {
+		dbType          dbType
+		replicationSafe bool
+		want            bool
+	}{
+		{Postgres, true, true},
+		{Postgres, false, false},
+		{MySQL, true, false},
+	} {
+		t.Run(fmt.Sprintf("%s/%v", tt.dbType, tt.replicationSafe), func(t *testing.T) {
+			connector := &fakeConnector{dbType: tt.dbType}
+			session := newFakeSession(t, connector)
+			require.NoError(t, NewMigrate(session, "", "argo_workflows", WithReplicationSafeMode(tt.replicationSafe)).Exec(context.Background()))
+			assert.Equal(t, tt.want, indexOf(connector.Statements(), "alter table schema_history replica identity") != -1)
+		})
+	}
+}
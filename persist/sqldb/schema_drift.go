@@ -0,0 +1,137 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+const schemaVersionQuery = "select schema_version from schema_history"
+
+// schemaDriftConnector wraps a connector so that, on an interval, the schema version is read from schema_history,
+// and if it has changed (e.g. another controller, or an operator, migrated the tables) every connection opened
+// before the change is discarded and replaced. A connection's prepared statements, and the driver's cached
+// descriptions of them, are then never used against a schema that they were not prepared for.
+//
+// As with recycleConnector, the check is made when a connection is taken from the pool, rather than from a
+// goroutine, and a stale connection is closed the next time it is taken from, or returned to, the pool.
+type schemaDriftConnector struct {
+	driver.Connector
+	interval time.Duration
+	clock    Clock
+
+	mu         sync.Mutex
+	next       time.Time
+	version    string
+	known      bool
+	generation int
+}
+
+func newSchemaDriftConnector(c driver.Connector, persistPool *config.ConnectionPool) driver.Connector {
+	if persistPool == nil || persistPool.SchemaDriftCheckInterval <= 0 {
+		return c
+	}
+	return &schemaDriftConnector{Connector: c, interval: time.Duration(persistPool.SchemaDriftCheckInterval), clock: clock}
+}
+
+func (s *schemaDriftConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := s.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &schemaDriftConn{wrappedConn: wrappedConn{Conn: conn}, connector: s, generation: s.generation}, nil
+}
+
+// due returns whether the check interval has passed, and if so starts the next one, so that only one connection
+// makes each check
+func (s *schemaDriftConnector) due() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.clock.Now()
+	if now.Before(s.next) {
+		return false
+	}
+	s.next = now.Add(s.interval)
+	return true
+}
+
+// observe records the schema version, starting a new generation of connections if it has changed since it was
+// last read
+func (s *schemaDriftConnector) observe(version string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.known && version != s.version {
+		s.generation++
+		logger().WithField("from", s.version).WithField("to", version).Info("Schema version changed, replacing DB connections")
+	}
+	s.version = version
+	s.known = true
+}
+
+func (s *schemaDriftConnector) stale(c *schemaDriftConn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return c.generation != s.generation
+}
+
+type schemaDriftConn struct {
+	wrappedConn
+	connector  *schemaDriftConnector
+	generation int
+}
+
+// check reads the schema version if it is due. A failure is only logged, as it does not mean that the connection
+// is unusable, e.g. the table has not been created yet.
+func (c *schemaDriftConn) check(ctx context.Context) {
+	if !c.connector.due() {
+		return
+	}
+	version, err := c.schemaVersion(ctx)
+	if err != nil {
+		logger().WithError(err).Warn("Failed to check the schema version")
+		return
+	}
+	c.connector.observe(version)
+}
+
+// schemaVersion returns the schema version as text, as it is only compared, and drivers return it as either text or
+// an integer
+func (c *schemaDriftConn) schemaVersion(ctx context.Context) (string, error) {
+	rows, err := c.QueryContext(ctx, schemaVersionQuery, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to get schema version: %w", err)
+	}
+	defer func() { _ = rows.Close() }()
+	dest := make([]driver.Value, 1)
+	if err := rows.Next(dest); err != nil {
+		return "", fmt.Errorf("failed to get schema version: %w", err)
+	}
+	switch v := dest[0].(type) {
+	case []byte:
+		return string(v), nil
+	case int64, uint64:
+		return fmt.Sprint(v), nil
+	default:
+		return "", fmt.Errorf("unexpected schema version %v", v)
+	}
+}
+
+// ResetSession is called when the connection is taken from the pool
+func (c *schemaDriftConn) ResetSession(ctx context.Context) error {
+	c.check(ctx)
+	if c.connector.stale(c) {
+		return driver.ErrBadConn
+	}
+	return c.wrappedConn.ResetSession(ctx)
+}
+
+// IsValid is called when the connection is returned to the pool
+func (c *schemaDriftConn) IsValid() bool {
+	return !c.connector.stale(c) && c.wrappedConn.IsValid()
+}
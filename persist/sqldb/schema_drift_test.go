@@ -0,0 +1,98 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+func Test_newSchemaDriftConnector(t *testing.T) {
+	fake := &fakeConnector{dbType: Postgres}
+	assert.Same(t, fake, newSchemaDriftConnector(fake, nil))
+	assert.Same(t, fake, newSchemaDriftConnector(fake, &config.ConnectionPool{}))
+	assert.IsType(t, &schemaDriftConnector{}, newSchemaDriftConnector(fake, &config.ConnectionPool{SchemaDriftCheckInterval: config.TTL(time.Minute)}))
+}
+
+func TestSchemaDriftConnector(t *testing.T) {
+	clock := useFakeClock(t)
+	var version atomic.Int64
+	var failing atomic.Bool
+	fake := &fakeConnector{dbType: Postgres, handler: func(query string, _ []driver.NamedValue) (*fakeResult, error) {
+		if query == schemaVersionQuery {
+			if failing.Load() {
+				return nil, fmt.Errorf(`relation "schema_history" does not exist`)
+			}
+			return &fakeResult{columns: []string{"schema_version"}, rows: [][]driver.Value{{version.Load()}}}, nil
+		}
+		return &fakeResult{}, nil
+	}}
+	version.Store(60)
+	sqlDB := sql.OpenDB(newSchemaDriftConnector(fake, &config.ConnectionPool{SchemaDriftCheckInterval: config.TTL(time.Minute)}))
+	defer func() { _ = sqlDB.Close() }()
+	const poolSize = 3
+	sqlDB.SetMaxIdleConns(poolSize)
+	ctx := context.Background()
+	// use every connection in the pool, and then return them to it
+	usePool := func() {
+		conns := make([]*sql.Conn, poolSize)
+		for i := range conns {
+			conn, err := sqlDB.Conn(ctx)
+			require.NoError(t, err)
+			require.NoError(t, conn.PingContext(ctx))
+			conns[i] = conn
+		}
+		for _, conn := range conns {
+			require.NoError(t, conn.Close())
+		}
+	}
+	checks := func() int {
+		n := 0
+		for _, s := range fake.Statements() {
+			if s == schemaVersionQuery {
+				n++
+			}
+		}
+		return n
+	}
+
+	usePool()
+	usePool()
+	require.Len(t, fake.Conns(), poolSize)
+	// the version is read the first time a pooled connection is reused, and then once per interval
+	assert.Equal(t, 1, checks())
+	t.Run("Unchanged", func(t *testing.T) {
+		clock.Advance(2 * time.Minute)
+		usePool()
+		assert.Equal(t, 2, checks())
+		assert.Len(t, fake.Conns(), poolSize)
+	})
+	t.Run("CheckFailed", func(t *testing.T) {
+		failing.Store(true)
+		defer failing.Store(false)
+		clock.Advance(2 * time.Minute)
+		usePool()
+		assert.Equal(t, 3, checks())
+		assert.Len(t, fake.Conns(), poolSize, "a failed check must not discard the connections")
+	})
+	t.Run("Changed", func(t *testing.T) {
+		version.Store(61)
+		usePool()
+		assert.Len(t, fake.Conns(), poolSize, "the change is not seen until the next check")
+		clock.Advance(2 * time.Minute)
+		usePool()
+		assert.Equal(t, 4, checks())
+		assert.Len(t, fake.Conns(), 2*poolSize, "every connection opened before the change must be replaced")
+		usePool()
+		assert.Len(t, fake.Conns(), 2*poolSize, "connections opened after the change must be kept")
+		assert.Equal(t, poolSize, sqlDB.Stats().Idle)
+	})
+}
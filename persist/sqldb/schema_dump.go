@@ -0,0 +1,172 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/upper/db/v4"
+)
+
+// tableSchema is a table's definition, as lines of DDL
+type tableSchema struct {
+	columns []string
+	// constraints are the table constraints, e.g. the primary key
+	constraints []string
+	// indexes are the statements that create the indexes that are not part of a constraint
+	indexes []string
+}
+
+// DumpSchema returns CREATE TABLE-style DDL for the table's columns, constraints and indexes, as introspected from
+// the database, e.g. to diff against the expected schema before an upgrade. Everything is sorted by name rather than
+// in creation order, so that the same schema always dumps the same, however it was migrated to.
+func DumpSchema(ctx context.Context, session db.Session, tableName string, t dbType) (string, error) {
+	var schema *tableSchema
+	var err error
+	switch t {
+	case Postgres:
+		schema, err = postgresSchema(ctx, session, tableName)
+	case MySQL:
+		schema, err = mySQLSchema(ctx, session, tableName)
+	default:
+		return "", fmt.Errorf("unsupported database type %q", t)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to dump schema of %s: %w", tableName, err)
+	}
+	if len(schema.columns) == 0 {
+		return "", fmt.Errorf("failed to dump schema of %s: the table does not exist", tableName)
+	}
+	sort.Strings(schema.columns)
+	sort.Strings(schema.constraints)
+	sort.Strings(schema.indexes)
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "CREATE TABLE %s (\n    %s\n);\n", tableName, strings.Join(append(schema.columns, schema.constraints...), ",\n    "))
+	for _, index := range schema.indexes {
+		fmt.Fprintf(b, "%s;\n", index)
+	}
+	return b.String(), nil
+}
+
+// columnDefinition returns the DDL for a column
+func columnDefinition(name, dataType, nullable string, defaultValue sql.NullString, extra ...string) string {
+	definition := []string{name, dataType}
+	if nullable == "NO" {
+		definition = append(definition, "NOT NULL")
+	}
+	if defaultValue.Valid {
+		definition = append(definition, "DEFAULT "+defaultValue.String)
+	}
+	return strings.Join(append(definition, extra...), " ")
+}
+
+// queryRows returns the rows of the query as strings, so that NULLs are distinguished from empty strings
+func queryRows(ctx context.Context, session db.Session, query string, args ...interface{}) ([][]sql.NullString, error) {
+	rows, err := session.SQL().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var values [][]sql.NullString
+	for rows.Next() {
+		row := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range row {
+			dest[i] = &row[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		values = append(values, row)
+	}
+	return values, rows.Err()
+}
+
+func postgresSchema(ctx context.Context, session db.Session, tableName string) (*tableSchema, error) {
+	schema := &tableSchema{}
+	// format_type includes the modifiers that information_schema splits into other columns, e.g. varchar(128)
+	columns, err := queryRows(ctx, session, `select a.attname, format_type(a.atttypid, a.atttypmod), case when a.attnotnull then 'NO' else 'YES' end, pg_get_expr(d.adbin, d.adrelid)
+from pg_attribute a left join pg_attrdef d on d.adrelid = a.attrelid and d.adnum = a.attnum
+where a.attrelid = to_regclass(?) and a.attnum > 0 and not a.attisdropped`, tableName)
+	if err != nil {
+		return nil, err
+	}
+	for _, column := range columns {
+		schema.columns = append(schema.columns, columnDefinition(column[0].String, column[1].String, column[2].String, column[3]))
+	}
+	constraints, err := queryRows(ctx, session, "select conname, pg_get_constraintdef(oid) from pg_constraint where conrelid = to_regclass(?)", tableName)
+	if err != nil {
+		return nil, err
+	}
+	for _, constraint := range constraints {
+		schema.constraints = append(schema.constraints, fmt.Sprintf("CONSTRAINT %s %s", constraint[0].String, constraint[1].String))
+	}
+	// the indexes that back a constraint, e.g. the primary key's, are created by the constraint
+	indexes, err := queryRows(ctx, session, `select pg_get_indexdef(i.indexrelid) from pg_index i
+where i.indrelid = to_regclass(?) and not exists (select 1 from pg_constraint c where c.conrelid = i.indrelid and c.conindid = i.indexrelid)`, tableName)
+	if err != nil {
+		return nil, err
+	}
+	for _, index := range indexes {
+		schema.indexes = append(schema.indexes, index[0].String)
+	}
+	return schema, nil
+}
+
+func mySQLSchema(ctx context.Context, session db.Session, tableName string) (*tableSchema, error) {
+	schema := &tableSchema{}
+	columns, err := queryRows(ctx, session, "select column_name, column_type, is_nullable, column_default, extra from information_schema.columns where table_schema = database() and table_name = ?", tableName)
+	if err != nil {
+		return nil, err
+	}
+	for _, column := range columns {
+		var extra []string
+		for _, e := range strings.Fields(column[4].String) {
+			// MySQL 8 marks a default that is an expression, which is already in the default
+			if e != "DEFAULT_GENERATED" {
+				extra = append(extra, e)
+			}
+		}
+		schema.columns = append(schema.columns, columnDefinition(column[0].String, column[1].String, column[2].String, column[3], extra...))
+	}
+	// the columns of each index are in order, so an index is complete once the next one starts
+	indexes, err := queryRows(ctx, session, "select index_name, non_unique, column_name from information_schema.statistics where table_schema = database() and table_name = ? order by index_name, seq_in_index", tableName)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(indexes); {
+		name, nonUnique := indexes[i][0].String, indexes[i][1].String
+		var indexColumns []string
+		for ; i < len(indexes) && indexes[i][0].String == name; i++ {
+			indexColumns = append(indexColumns, indexes[i][2].String)
+		}
+		switch {
+		case name == "PRIMARY":
+			schema.constraints = append(schema.constraints, fmt.Sprintf("PRIMARY KEY (%s)", strings.Join(indexColumns, ", ")))
+		case nonUnique == "0":
+			schema.indexes = append(schema.indexes, fmt.Sprintf("CREATE UNIQUE INDEX %s ON %s (%s)", name, tableName, strings.Join(indexColumns, ", ")))
+		default:
+			schema.indexes = append(schema.indexes, fmt.Sprintf("CREATE INDEX %s ON %s (%s)", name, tableName, strings.Join(indexColumns, ", ")))
+		}
+	}
+	foreignKeys, err := queryRows(ctx, session, "select constraint_name, column_name, referenced_table_name, referenced_column_name from information_schema.key_column_usage where table_schema = database() and table_name = ? and referenced_table_name is not null order by constraint_name, ordinal_position", tableName)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(foreignKeys); {
+		name, referencedTable := foreignKeys[i][0].String, foreignKeys[i][2].String
+		var fkColumns, referencedColumns []string
+		for ; i < len(foreignKeys) && foreignKeys[i][0].String == name; i++ {
+			fkColumns = append(fkColumns, foreignKeys[i][1].String)
+			referencedColumns = append(referencedColumns, foreignKeys[i][3].String)
+		}
+		schema.constraints = append(schema.constraints, fmt.Sprintf("CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s(%s)", name, strings.Join(fkColumns, ", "), referencedTable, strings.Join(referencedColumns, ", ")))
+	}
+	return schema, nil
+}
@@ -0,0 +1,107 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql/driver"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpSchema(t *testing.T) {
+	// results answers each introspection query, identified by a table it reads from
+	dump := func(t *testing.T, dbType dbType, results map[string]*fakeResult, reverse bool) string {
+		t.Helper()
+		connector := &fakeConnector{dbType: dbType, handler: func(query string, args []driver.NamedValue) (*fakeResult, error) {
+			for table, res := range results {
+				if strings.Contains(query, table) {
+					assert.Equal(t, "argo_archived_workflows", args[0].Value)
+					res := &fakeResult{columns: res.columns, rows: slices.Clone(res.rows)}
+					if reverse {
+						slices.Reverse(res.rows)
+					}
+					return res, nil
+				}
+			}
+			return &fakeResult{}, nil
+		}}
+		ddl, err := DumpSchema(context.Background(), newFakeSession(t, connector), "argo_archived_workflows", dbType)
+		require.NoError(t, err)
+		return ddl
+	}
+
+	t.Run("Postgres", func(t *testing.T) {
+		results := map[string]*fakeResult{
+			"pg_attribute": {columns: []string{"name", "type", "nullable", "default"}, rows: [][]driver.Value{
+				{"clustername", "character varying(64)", "NO", nil},
+				{"uid", "character varying(128)", "NO", nil},
+				{"phase", "character varying(25)", "NO", nil},
+				{"workflow", "text", "YES", nil},
+				{"startedat", "timestamp without time zone", "NO", "now()"},
+			}},
+			"pg_get_constraintdef": {columns: []string{"name", "definition"}, rows: [][]driver.Value{
+				{"argo_archived_workflows_pkey", "PRIMARY KEY (clustername, uid)"},
+			}},
+			"pg_get_indexdef": {columns: []string{"definition"}, rows: [][]driver.Value{
+				{"CREATE INDEX argo_archived_workflows_i1 ON public.argo_archived_workflows USING btree (clustername, namespace)"},
+				{"CREATE INDEX argo_archived_workflows_i2 ON public.argo_archived_workflows USING btree (clustername, startedat)"},
+			}},
+		}
+		want := `CREATE TABLE argo_archived_workflows (
+    clustername character varying(64) NOT NULL,
+    phase character varying(25) NOT NULL,
+    startedat timestamp without time zone NOT NULL DEFAULT now(),
+    uid character varying(128) NOT NULL,
+    workflow text,
+    CONSTRAINT argo_archived_workflows_pkey PRIMARY KEY (clustername, uid)
+);
+CREATE INDEX argo_archived_workflows_i1 ON public.argo_archived_workflows USING btree (clustername, namespace);
+CREATE INDEX argo_archived_workflows_i2 ON public.argo_archived_workflows USING btree (clustername, startedat);
+`
+		assert.Equal(t, want, dump(t, Postgres, results, false))
+		assert.Equal(t, want, dump(t, Postgres, results, true), "the dump does not depend on the order the database returns rows in")
+	})
+	t.Run("MySQL", func(t *testing.T) {
+		results := map[string]*fakeResult{
+			"information_schema.columns": {columns: []string{"name", "type", "nullable", "default", "extra"}, rows: [][]driver.Value{
+				{"clustername", "varchar(64)", "NO", nil, ""},
+				{"uid", "varchar(128)", "NO", nil, ""},
+				{"workflow", "json", "YES", nil, ""},
+				{"startedat", "timestamp", "NO", "CURRENT_TIMESTAMP", "DEFAULT_GENERATED"},
+			}},
+			// the columns of an index are in order, which the query sorts by
+			"information_schema.statistics": {columns: []string{"name", "nonUnique", "column"}, rows: [][]driver.Value{
+				{"PRIMARY", "0", "clustername"},
+				{"PRIMARY", "0", "uid"},
+				{"argo_archived_workflows_i1", "1", "clustername"},
+				{"argo_archived_workflows_i1", "1", "namespace"},
+				{"idx_uid", "0", "uid"},
+			}},
+			"information_schema.key_column_usage": {columns: []string{"name", "column", "referencedTable", "referencedColumn"}, rows: [][]driver.Value{
+				{"fk_cluster", "clustername", "argo_clusters", "name"},
+			}},
+		}
+		want := `CREATE TABLE argo_archived_workflows (
+    clustername varchar(64) NOT NULL,
+    startedat timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    uid varchar(128) NOT NULL,
+    workflow json,
+    CONSTRAINT fk_cluster FOREIGN KEY (clustername) REFERENCES argo_clusters(name),
+    PRIMARY KEY (clustername, uid)
+);
+CREATE INDEX argo_archived_workflows_i1 ON argo_archived_workflows (clustername, namespace);
+CREATE UNIQUE INDEX idx_uid ON argo_archived_workflows (uid);
+`
+		assert.Equal(t, want, dump(t, MySQL, results, false))
+		// the query keeps the columns of each index together and in order, but the indexes can come in any order
+		results["information_schema.statistics"].rows = append(results["information_schema.statistics"].rows[4:], results["information_schema.statistics"].rows[:4]...)
+		assert.Equal(t, want, dump(t, MySQL, results, false))
+	})
+	t.Run("NoSuchTable", func(t *testing.T) {
+		_, err := DumpSchema(context.Background(), newFakeSession(t, &fakeConnector{}), "argo_archived_workflows", Postgres)
+		assert.EqualError(t, err, "failed to dump schema of argo_archived_workflows: the table does not exist")
+	})
+}
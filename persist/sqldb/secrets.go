@@ -0,0 +1,80 @@
+package sqldb
+
+import (
+	"context"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	apiv1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/argoproj/argo-workflows/v3/errors"
+	"github.com/argoproj/argo-workflows/v3/util"
+	waitutil "github.com/argoproj/argo-workflows/v3/util/wait"
+)
+
+const defaultSecretFetchRetries = 3
+
+// secretFetchBackoff is the backoff between secret fetches, a variable so tests do not have to wait
+var secretFetchBackoff = wait.Backoff{Duration: time.Second, Factor: 2, Jitter: 0.1}
+
+// SecretProvider gets the value of the key of a secret that the persistence config refers to
+type SecretProvider interface {
+	GetSecret(ctx context.Context, namespace, name, key string) ([]byte, error)
+}
+
+// kubeSecretProvider gets the secrets from Kubernetes
+type kubeSecretProvider struct {
+	kubectlConfig kubernetes.Interface
+}
+
+func (p kubeSecretProvider) GetSecret(ctx context.Context, namespace, name, key string) ([]byte, error) {
+	return util.GetSecrets(ctx, p.kubectlConfig, namespace, name, key)
+}
+
+// StaticSecretProvider is a SecretProvider of secrets that are held in memory, keyed by namespace/name and then by
+// key, e.g. so that benchmarks of creating a session do not measure getting the secrets
+type StaticSecretProvider map[string]map[string][]byte
+
+func (p StaticSecretProvider) GetSecret(_ context.Context, namespace, name, key string) ([]byte, error) {
+	secret, ok := p[namespace+"/"+name]
+	if !ok {
+		return nil, apierr.NewNotFound(apiv1.Resource("secrets"), name)
+	}
+	value, ok := secret[key]
+	if !ok {
+		return nil, errors.Errorf(errors.CodeBadRequest, "secret '%s' does not have the key '%s'", name, key)
+	}
+	return value, nil
+}
+
+// newSecretProvider returns the provider of the secrets of a session that is created with the Kubernetes client. It
+// is a variable so that benchmarks and tests can use a StaticSecretProvider.
+var newSecretProvider = func(kubectlConfig kubernetes.Interface) SecretProvider {
+	return kubeSecretProvider{kubectlConfig: kubectlConfig}
+}
+
+// getSecret fetches the secret from the provider, retrying failures that util.GetSecrets does not consider transient but that happen
+// while the API server is unavailable, such as during a rollout. A missing secret or key is a misconfiguration,
+// so is not retried.
+func getSecret(ctx context.Context, kubectlConfig kubernetes.Interface, namespace string, selector apiv1.SecretKeySelector, retries int) ([]byte, error) {
+	if retries == 0 {
+		retries = defaultSecretFetchRetries
+	}
+	backoff := secretFetchBackoff
+	backoff.Steps = max(retries, 0) + 1
+	provider := newSecretProvider(kubectlConfig)
+	var value []byte
+	err := waitutil.Backoff(backoff, func() (bool, error) {
+		var err error
+		value, err = provider.GetSecret(ctx, namespace, selector.Name, selector.Key)
+		if err == nil || apierr.IsNotFound(errors.Cause(err)) || errors.IsCode(errors.CodeBadRequest, err) {
+			return true, err
+		}
+		logger().WithFields(log.Fields{"namespace": namespace, "name": selector.Name}).WithError(err).Warn("Failed to get persistence secret, retrying")
+		return false, err
+	})
+	return value, err
+}
@@ -0,0 +1,88 @@
+package sqldb
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	apierr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func Test_getSecret(t *testing.T) {
+	backoff := secretFetchBackoff
+	defer func() { secretFetchBackoff = backoff }()
+	secretFetchBackoff.Duration = 0
+
+	selector := apiv1.SecretKeySelector{LocalObjectReference: apiv1.LocalObjectReference{Name: "argo-postgres-config"}, Key: "username"}
+	newClient := func(failures int, err error) (*fake.Clientset, *int) {
+		kube := fake.NewSimpleClientset(&apiv1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "argo-postgres-config", Namespace: "argo"},
+			Data:       map[string][]byte{"username": []byte("postgres")},
+		})
+		calls := 0
+		kube.PrependReactor("get", "secrets", func(k8stesting.Action) (bool, runtime.Object, error) {
+			calls++
+			if calls <= failures {
+				return true, nil, err
+			}
+			return false, nil, nil
+		})
+		return kube, &calls
+	}
+	t.Run("Transient", func(t *testing.T) {
+		kube, calls := newClient(2, apierr.NewInternalError(assert.AnError))
+		value, err := getSecret(context.Background(), kube, "argo", selector, 0)
+		require.NoError(t, err)
+		assert.Equal(t, "postgres", string(value))
+		assert.Equal(t, 3, *calls)
+	})
+	t.Run("RetriesExhausted", func(t *testing.T) {
+		kube, calls := newClient(10, apierr.NewInternalError(assert.AnError))
+		_, err := getSecret(context.Background(), kube, "argo", selector, 1)
+		require.Error(t, err)
+		assert.Equal(t, 2, *calls)
+	})
+	t.Run("NotFound", func(t *testing.T) {
+		kube, calls := newClient(10, apierr.NewNotFound(apiv1.Resource("secrets"), "argo-postgres-config"))
+		_, err := getSecret(context.Background(), kube, "argo", selector, 0)
+		assert.EqualError(t, err, `secrets "argo-postgres-config" not found`)
+		assert.Equal(t, 1, *calls)
+	})
+}
+
+func TestStaticSecretProvider(t *testing.T) {
+	defer func(provider func(kubernetes.Interface) SecretProvider) { newSecretProvider = provider }(newSecretProvider)
+	// the backoff is not changed, so a retry would take seconds
+	newSecretProvider = func(kubernetes.Interface) SecretProvider {
+		return StaticSecretProvider{"argo/argo-postgres-config": {"username": []byte("postgres")}}
+	}
+	ctx := context.Background()
+	selector := func(name, key string) apiv1.SecretKeySelector {
+		return apiv1.SecretKeySelector{LocalObjectReference: apiv1.LocalObjectReference{Name: name}, Key: key}
+	}
+	t.Run("Found", func(t *testing.T) {
+		value, err := getSecret(ctx, nil, "argo", selector("argo-postgres-config", "username"), 0)
+		require.NoError(t, err)
+		assert.Equal(t, "postgres", string(value))
+	})
+	t.Run("NotFound", func(t *testing.T) {
+		start := time.Now()
+		_, err := getSecret(ctx, nil, "other", selector("argo-postgres-config", "username"), 0)
+		assert.EqualError(t, err, `secrets "argo-postgres-config" not found`)
+		assert.Less(t, time.Since(start), time.Second, "it is not retried")
+	})
+	t.Run("MissingKey", func(t *testing.T) {
+		start := time.Now()
+		_, err := getSecret(ctx, nil, "argo", selector("argo-postgres-config", "password"), 0)
+		assert.EqualError(t, err, "secret 'argo-postgres-config' does not have the key 'password'")
+		assert.Less(t, time.Since(start), time.Second, "it is not retried")
+	})
+}
@@ -0,0 +1,45 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/upper/db/v4"
+	"k8s.io/apimachinery/pkg/util/rand"
+)
+
+// selfTestClusterName is the cluster name of the sentinel row, which no real cluster can have, as a cluster name
+// must be a valid DNS name
+const selfTestClusterName = "argo_self_test"
+
+// SelfTest writes a sentinel row to the offload table, reads it back and deletes it. This detects databases where a
+// write is not immediately visible to the same client, e.g. because reads are routed to a lagging replica.
+func SelfTest(ctx context.Context, session db.Session, tableName string) (err error) {
+	uid := "self-test-" + rand.String(16)
+	// attempt to delete the row even if the insert failed, as it may have been committed anyway (e.g. on a timeout),
+	// use a context that is not cancelled so a cancelled self-test still cleans up
+	defer func() {
+		_, deleteErr := session.SQL().ExecContext(context.WithoutCancel(ctx), "delete from "+tableName+" where clustername = ? and uid = ?", selfTestClusterName, uid)
+		if deleteErr != nil && err == nil {
+			err = fmt.Errorf("self-test failed to delete its row from %s: %w", tableName, deleteErr)
+		}
+	}()
+	_, err = session.SQL().ExecContext(ctx, "insert into "+tableName+" (clustername, uid, version, namespace, nodes) values (?, ?, ?, ?, ?)", selfTestClusterName, uid, "self-test", selfTestClusterName, "{}")
+	if err != nil {
+		return fmt.Errorf("self-test failed to insert into %s: %w", tableName, err)
+	}
+	row, err := session.SQL().QueryRowContext(ctx, "select uid from "+tableName+" where clustername = ? and uid = ?", selfTestClusterName, uid)
+	if err != nil {
+		return fmt.Errorf("self-test failed to read from %s: %w", tableName, err)
+	}
+	var got string
+	if err := row.Scan(&got); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("self-test wrote a row to %s but could not read it back, reads may be served by a replica that is behind the writer", tableName)
+		}
+		return fmt.Errorf("self-test failed to read from %s: %w", tableName, err)
+	}
+	return nil
+}
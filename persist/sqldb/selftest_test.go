@@ -0,0 +1,66 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// selfTestBackend stores the rows inserted by the self-test, and can be made to lose them, like a lagging replica
+type selfTestBackend struct {
+	mu         sync.Mutex
+	rows       map[string]bool
+	consistent bool
+	insertErr  error
+}
+
+func (b *selfTestBackend) handler(query string, args []driver.NamedValue) (*fakeResult, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch {
+	case strings.HasPrefix(query, "insert into argo_workflows"):
+		b.rows[args[1].Value.(string)] = true
+		return &fakeResult{}, b.insertErr
+	case strings.HasPrefix(query, "select uid from argo_workflows"):
+		uid := args[1].Value.(string)
+		if !b.consistent || !b.rows[uid] {
+			return &fakeResult{columns: []string{"uid"}}, nil
+		}
+		return &fakeResult{columns: []string{"uid"}, rows: [][]driver.Value{{uid}}}, nil
+	case strings.HasPrefix(query, "delete from argo_workflows"):
+		delete(b.rows, args[1].Value.(string))
+	}
+	return &fakeResult{}, nil
+}
+
+func TestSelfTest(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		consistent bool
+		insertErr  error
+		err        string
+	}{
+		{"Consistent", true, nil, ""},
+		{"Inconsistent", false, nil, "self-test wrote a row to argo_workflows but could not read it back, reads may be served by a replica that is behind the writer"},
+		{"InsertFailed", true, errors.New("timeout"), "self-test failed to insert into argo_workflows: timeout"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &selfTestBackend{rows: map[string]bool{}, consistent: tt.consistent, insertErr: tt.insertErr}
+			connector := &fakeConnector{dbType: MySQL, handler: backend.handler}
+			err := SelfTest(context.Background(), newFakeSession(t, connector), "argo_workflows")
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.err)
+			}
+			// the sentinel row is always cleaned up
+			assert.Empty(t, backend.rows)
+			assert.Contains(t, connector.Statements(), "delete from argo_workflows where clustername = ? and uid = ?")
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package sqldb
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/upper/db/v4"
+)
+
+// the flavors of database server, MariaDB is a MySQL backend but is versioned separately
+const (
+	FlavorPostgres = "postgres"
+	FlavorMySQL    = "mysql"
+	FlavorMariaDB  = "mariadb"
+)
+
+// ServerVersionInfo is the parsed version of the database server
+type ServerVersionInfo struct {
+	Flavor string `json:"flavor"`
+	Major  int    `json:"major"`
+	Minor  int    `json:"minor"`
+	Patch  int    `json:"patch"`
+	// Raw is the version as reported by the server, e.g. "15.4 (Debian 15.4-1.pgdg120+1)"
+	Raw string `json:"raw"`
+}
+
+func (v ServerVersionInfo) String() string {
+	return fmt.Sprintf("%s %d.%d.%d", v.Flavor, v.Major, v.Minor, v.Patch)
+}
+
+// AtLeast returns whether the version is the same as, or newer than, major.minor.patch
+func (v ServerVersionInfo) AtLeast(major, minor, patch int) bool {
+	if v.Major != major {
+		return v.Major > major
+	}
+	if v.Minor != minor {
+		return v.Minor > minor
+	}
+	return v.Patch >= patch
+}
+
+// minServerVersions are the oldest supported versions of each flavor
+var minServerVersions = map[string]ServerVersionInfo{
+	FlavorPostgres: {Flavor: FlavorPostgres, Major: 9, Minor: 5},
+	FlavorMySQL:    {Flavor: FlavorMySQL, Major: 5, Minor: 7, Patch: 8},
+	FlavorMariaDB:  {Flavor: FlavorMariaDB, Major: 10, Minor: 3},
+}
+
+var serverVersionRegexp = regexp.MustCompile(`^(\d+)(?:\.(\d+))?(?:\.(\d+))?`)
+
+// parseServerVersion parses the version reported by the server
+func parseServerVersion(t dbType, raw string) (*ServerVersionInfo, error) {
+	v := &ServerVersionInfo{Raw: raw}
+	version := strings.TrimSpace(raw)
+	switch t {
+	case Postgres:
+		v.Flavor = FlavorPostgres
+	case MySQL:
+		v.Flavor = FlavorMySQL
+		if strings.Contains(strings.ToLower(version), "mariadb") {
+			v.Flavor = FlavorMariaDB
+			// MariaDB prefixes the version with "5.5.5-" for compatibility with old MySQL clients
+			version = strings.TrimPrefix(version, "5.5.5-")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported database type %q", t)
+	}
+	parts := serverVersionRegexp.FindStringSubmatch(version)
+	if parts == nil {
+		return nil, fmt.Errorf("failed to parse server version %q", raw)
+	}
+	for i, p := range []*int{&v.Major, &v.Minor, &v.Patch} {
+		if parts[i+1] != "" {
+			*p, _ = strconv.Atoi(parts[i+1])
+		}
+	}
+	return v, nil
+}
+
+// ServerVersion returns the version of the database server, so that features that depend on it can be gated
+func ServerVersion(ctx context.Context, session db.Session, t dbType) (*ServerVersionInfo, error) {
+	var query string
+	switch t {
+	case Postgres:
+		query = "show server_version"
+	case MySQL:
+		query = "select version()"
+	default:
+		return nil, fmt.Errorf("unsupported database type %q", t)
+	}
+	row, err := session.SQL().QueryRowContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	var raw string
+	if err := row.Scan(&raw); err != nil {
+		return nil, fmt.Errorf("failed to get server version: %w", err)
+	}
+	return parseServerVersion(t, raw)
+}
+
+// logServerVersion logs the server version, warning if it is older than we support. This is informational, so
+// failing to get the version is not an error.
+func logServerVersion(ctx context.Context, session db.Session, t dbType) {
+	v, err := ServerVersion(ctx, session, t)
+	if err != nil {
+		logger().WithError(err).Warn("Failed to get the database server version")
+		return
+	}
+	logCtx := logger().WithField("serverVersion", v.Raw)
+	if minVersion := minServerVersions[v.Flavor]; !v.AtLeast(minVersion.Major, minVersion.Minor, minVersion.Patch) {
+		logCtx.WithField("minVersion", fmt.Sprintf("%d.%d.%d", minVersion.Major, minVersion.Minor, minVersion.Patch)).Warnf("Database server %s is older than the minimum supported version", v)
+		return
+	}
+	logCtx.Infof("Database server is %s", v)
+}
@@ -0,0 +1,59 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerVersion(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		dbType dbType
+		raw    string
+		want   ServerVersionInfo
+	}{
+		{"Postgres", Postgres, "16.1", ServerVersionInfo{Flavor: FlavorPostgres, Major: 16, Minor: 1}},
+		{"PostgresDistribution", Postgres, "15.4 (Debian 15.4-1.pgdg120+1)", ServerVersionInfo{Flavor: FlavorPostgres, Major: 15, Minor: 4}},
+		{"PostgresBeta", Postgres, "17beta1", ServerVersionInfo{Flavor: FlavorPostgres, Major: 17}},
+		{"MySQL", MySQL, "8.0.35", ServerVersionInfo{Flavor: FlavorMySQL, Major: 8, Minor: 0, Patch: 35}},
+		{"MySQLSuffix", MySQL, "5.7.44-log", ServerVersionInfo{Flavor: FlavorMySQL, Major: 5, Minor: 7, Patch: 44}},
+		{"MariaDB", MySQL, "10.11.6-MariaDB-1:10.11.6+maria~ubu2204", ServerVersionInfo{Flavor: FlavorMariaDB, Major: 10, Minor: 11, Patch: 6}},
+		{"MariaDBCompatibilityPrefix", MySQL, "5.5.5-10.4.32-MariaDB", ServerVersionInfo{Flavor: FlavorMariaDB, Major: 10, Minor: 4, Patch: 32}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			session := newFakeSession(t, &fakeConnector{dbType: tt.dbType, handler: func(query string, _ []driver.NamedValue) (*fakeResult, error) {
+				if query == "show server_version" || query == "select version()" {
+					return &fakeResult{columns: []string{"version"}, rows: [][]driver.Value{{tt.raw}}}, nil
+				}
+				return &fakeResult{}, nil
+			}})
+			v, err := ServerVersion(context.Background(), session, tt.dbType)
+			require.NoError(t, err)
+			tt.want.Raw = tt.raw
+			assert.Equal(t, tt.want, *v)
+		})
+	}
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := parseServerVersion(Postgres, "unknown")
+		assert.EqualError(t, err, `failed to parse server version "unknown"`)
+	})
+}
+
+func Test_logServerVersion(t *testing.T) {
+	hook := test.NewGlobal()
+	defer log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+	session := newFakeSession(t, &fakeConnector{dbType: MySQL, handler: func(query string, _ []driver.NamedValue) (*fakeResult, error) {
+		return &fakeResult{columns: []string{"version"}, rows: [][]driver.Value{{"5.6.51"}}}, nil
+	}})
+	logServerVersion(context.Background(), session, MySQL)
+	require.NotNil(t, hook.LastEntry())
+	assert.Equal(t, log.WarnLevel, hook.LastEntry().Level)
+	assert.Equal(t, "Database server mysql 5.6.51 is older than the minimum supported version", hook.LastEntry().Message)
+	assert.Equal(t, "5.7.8", hook.LastEntry().Data["minVersion"])
+}
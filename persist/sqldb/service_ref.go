@@ -0,0 +1,84 @@
+package sqldb
+
+import (
+	"context"
+	"fmt"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+// resolveServiceRef returns the config with the host and port of its service, or the config unchanged if it does
+// not have one
+func resolveServiceRef(ctx context.Context, kubectlConfig kubernetes.Interface, namespace string, cfg config.DatabaseConfig) (config.DatabaseConfig, error) {
+	ref := cfg.ServiceRef
+	if ref == nil {
+		return cfg, nil
+	}
+	if cfg.AuroraWriterEndpoint != "" {
+		return cfg, fmt.Errorf("serviceRef cannot be used with auroraWriterEndpoint")
+	}
+	if ref.Namespace != "" {
+		namespace = ref.Namespace
+	}
+	svc, err := kubectlConfig.CoreV1().Services(namespace).Get(ctx, ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return cfg, fmt.Errorf("failed to get database service %s/%s: %w", namespace, ref.Name, err)
+	}
+	host, port, err := serviceEndpoint(svc, ref.PortName)
+	if err != nil {
+		return cfg, err
+	}
+	logger().WithField("service", namespace+"/"+ref.Name).WithField("host", host).WithField("port", port).Debug("Resolved the database service")
+	cfg.Host = host
+	cfg.Port = port
+	return cfg, nil
+}
+
+// serviceEndpoint returns the host and port of the service's port. The host is the service's DNS name, rather than its
+// cluster IP, so that it still resolves if the service is re-created. Clients connect to a headless service's pods
+// directly, so it is their (target) port, which must be a number as the pods' named ports cannot be looked up.
+func serviceEndpoint(svc *apiv1.Service, portName string) (string, int, error) {
+	var port *apiv1.ServicePort
+	for i, p := range svc.Spec.Ports {
+		if p.Name == portName || (portName == "" && len(svc.Spec.Ports) == 1) {
+			port = &svc.Spec.Ports[i]
+			break
+		}
+	}
+	if port == nil && svc.Spec.Type != apiv1.ServiceTypeExternalName {
+		if portName == "" {
+			return "", 0, fmt.Errorf("database service %s/%s has %d ports, so serviceRef.portName must be set", svc.Namespace, svc.Name, len(svc.Spec.Ports))
+		}
+		return "", 0, fmt.Errorf("database service %s/%s has no port named %q", svc.Namespace, svc.Name, portName)
+	}
+	switch {
+	case svc.Spec.Type == apiv1.ServiceTypeExternalName:
+		// an external name service may not have ports, in which case the default port of the database is used
+		if port == nil {
+			return svc.Spec.ExternalName, 0, nil
+		}
+		return svc.Spec.ExternalName, int(port.Port), nil
+	case svc.Spec.ClusterIP == apiv1.ClusterIPNone:
+		if port.TargetPort.Type == intstr.String {
+			return "", 0, fmt.Errorf("database service %s/%s is headless, so the targetPort of its port must be a number rather than the name %q", svc.Namespace, svc.Name, port.TargetPort.StrVal)
+		}
+		number := int(port.Port)
+		if port.TargetPort.IntValue() > 0 {
+			number = port.TargetPort.IntValue()
+		}
+		return serviceHost(svc), number, nil
+	case svc.Spec.ClusterIP != "":
+		return serviceHost(svc), int(port.Port), nil
+	}
+	return "", 0, fmt.Errorf("database service %s/%s has no cluster IP", svc.Namespace, svc.Name)
+}
+
+// serviceHost returns the DNS name of the service
+func serviceHost(svc *apiv1.Service) string {
+	return fmt.Sprintf("%s.%s.svc", svc.Name, svc.Namespace)
+}
@@ -0,0 +1,107 @@
+package sqldb
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+func Test_resolveServiceRef(t *testing.T) {
+	ctx := context.Background()
+	kube := fake.NewSimpleClientset(
+		&apiv1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "postgres", Namespace: "databases"},
+			Spec: apiv1.ServiceSpec{ClusterIP: "10.0.0.5", Ports: []apiv1.ServicePort{
+				{Name: "metrics", Port: 9187},
+				{Name: "postgresql", Port: 5432, TargetPort: intstr.FromInt(15432)},
+			}},
+		},
+		&apiv1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "mysql", Namespace: "argo"},
+			Spec:       apiv1.ServiceSpec{ClusterIP: "10.0.0.6", Ports: []apiv1.ServicePort{{Port: 3306}}},
+		},
+		&apiv1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "postgres-headless", Namespace: "databases"},
+			Spec:       apiv1.ServiceSpec{ClusterIP: apiv1.ClusterIPNone, Ports: []apiv1.ServicePort{{Port: 5432, TargetPort: intstr.FromInt(15432)}}},
+		},
+		&apiv1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "postgres-named-port", Namespace: "databases"},
+			Spec:       apiv1.ServiceSpec{ClusterIP: apiv1.ClusterIPNone, Ports: []apiv1.ServicePort{{Port: 5432, TargetPort: intstr.FromString("postgresql")}}},
+		},
+		&apiv1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "rds", Namespace: "argo"},
+			Spec:       apiv1.ServiceSpec{Type: apiv1.ServiceTypeExternalName, ExternalName: "argo.abc123.eu-west-1.rds.amazonaws.com"},
+		},
+	)
+	for _, tt := range []struct {
+		name     string
+		ref      *config.DatabaseServiceRef
+		wantHost string
+		wantPort int
+		err      string
+	}{
+		{"NoServiceRef", nil, "my-host", 1234, ""},
+		{"PortName", &config.DatabaseServiceRef{Namespace: "databases", Name: "postgres", PortName: "postgresql"}, "postgres.databases.svc", 5432, ""},
+		{"SinglePort", &config.DatabaseServiceRef{Name: "mysql"}, "mysql.argo.svc", 3306, ""},
+		{"Headless", &config.DatabaseServiceRef{Namespace: "databases", Name: "postgres-headless"}, "postgres-headless.databases.svc", 15432, ""},
+		{"HeadlessNamedTargetPort", &config.DatabaseServiceRef{Namespace: "databases", Name: "postgres-named-port"}, "", 0, `database service databases/postgres-named-port is headless, so the targetPort of its port must be a number rather than the name "postgresql"`},
+		{"ExternalName", &config.DatabaseServiceRef{Name: "rds"}, "argo.abc123.eu-west-1.rds.amazonaws.com", 0, ""},
+		{"PortNameRequired", &config.DatabaseServiceRef{Namespace: "databases", Name: "postgres"}, "", 0, "database service databases/postgres has 2 ports, so serviceRef.portName must be set"},
+		{"NoSuchPort", &config.DatabaseServiceRef{Namespace: "databases", Name: "postgres", PortName: "pg"}, "", 0, `database service databases/postgres has no port named "pg"`},
+		{"NotFound", &config.DatabaseServiceRef{Name: "postgres"}, "", 0, `failed to get database service argo/postgres: services "postgres" not found`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := resolveServiceRef(ctx, kube, "argo", config.DatabaseConfig{Host: "my-host", Port: 1234, ServiceRef: tt.ref})
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantHost, cfg.Host)
+			assert.Equal(t, tt.wantPort, cfg.Port)
+		})
+	}
+	t.Run("AuroraWriterEndpoint", func(t *testing.T) {
+		_, err := resolveServiceRef(ctx, kube, "argo", config.DatabaseConfig{AuroraWriterEndpoint: "writer", ServiceRef: &config.DatabaseServiceRef{Name: "mysql"}})
+		assert.EqualError(t, err, "serviceRef cannot be used with auroraWriterEndpoint")
+	})
+}
+
+func TestCreatePostGresDBSession_ServiceRef(t *testing.T) {
+	// the service's DNS name does not resolve here, so the connection is made via a proxy that resolves it
+	database, accepted := newFakeDatabase(t)
+	proxy, address := newFakeSOCKS5(t, database, "", "")
+	kube := fake.NewSimpleClientset(
+		&apiv1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "argo-postgres-config", Namespace: "argo"},
+			Data:       map[string][]byte{"username": []byte("argo"), "password": []byte("password")},
+		},
+		&apiv1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "postgres", Namespace: "argo"},
+			Spec:       apiv1.ServiceSpec{ClusterIP: "10.0.0.5", Ports: []apiv1.ServicePort{{Port: 5432}}},
+		},
+	)
+	cfg := &config.PostgreSQLConfig{DatabaseConfig: config.DatabaseConfig{
+		// the host is not used, as the service takes precedence
+		Host:           "postgres.invalid",
+		Database:       "argo",
+		TableName:      "argo_workflows",
+		ServiceRef:     &config.DatabaseServiceRef{Name: "postgres"},
+		Socks5Proxy:    &config.Socks5Proxy{Address: address},
+		UsernameSecret: apiv1.SecretKeySelector{LocalObjectReference: apiv1.LocalObjectReference{Name: "argo-postgres-config"}, Key: "username"},
+		PasswordSecret: apiv1.SecretKeySelector{LocalObjectReference: apiv1.LocalObjectReference{Name: "argo-postgres-config"}, Key: "password"},
+	}}
+	_, err := CreatePostGresDBSession(kube, "argo", cfg, nil)
+	require.Error(t, err, "the fake database is not a database")
+	assert.Contains(t, proxy.Connects(), "postgres.argo.svc:5432", "connects to the service's DNS name")
+	assert.Positive(t, accepted())
+	assert.Equal(t, "postgres.invalid", cfg.Host, "the config is not modified")
+}
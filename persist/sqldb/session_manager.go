@@ -0,0 +1,179 @@
+package sqldb
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/upper/db/v4"
+	"golang.org/x/sync/singleflight"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+// SessionManager shares sessions between callers that use the same config in the same namespace, rather than
+// creating (and connecting) a session per request. Sessions are reference counted, and closed once they have not
+// been used for the idle timeout. Sessions are created and closed without holding the lock, so that connecting to one
+// database does not hold up callers of the others.
+type SessionManager struct {
+	idleTimeout time.Duration
+	newSession  func(kubectlConfig kubernetes.Interface, namespace string, persistConfig *config.PersistConfig) (db.Session, error)
+	clock       Clock
+	// creating ensures that concurrent callers with the same key create a single session
+	creating singleflight.Group
+
+	mu       sync.Mutex
+	sessions map[string]*managedSession
+}
+
+type managedSession struct {
+	namespace     string
+	persistConfig *config.PersistConfig
+	session       db.Session
+	refs          int
+	idleSince     time.Time
+}
+
+func NewSessionManager(component Component, idleTimeout time.Duration) *SessionManager {
+	return &SessionManager{
+		idleTimeout: idleTimeout,
+		newSession: func(kubectlConfig kubernetes.Interface, namespace string, persistConfig *config.PersistConfig) (db.Session, error) {
+			return CreateDBSession(kubectlConfig, namespace, component, persistConfig)
+		},
+		clock:    clock,
+		sessions: make(map[string]*managedSession),
+	}
+}
+
+// GetSession returns the shared session for the namespace and config, creating it if needed. The caller must call
+// the returned func once it has finished with the session, and must not close the session itself.
+func (m *SessionManager) GetSession(ctx context.Context, kubectlConfig kubernetes.Interface, namespace string, persistConfig *config.PersistConfig) (db.Session, func(), error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	key, err := sessionKey(namespace, persistConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	for {
+		if s := m.acquire(key); s != nil {
+			var once sync.Once
+			return s.session, func() { once.Do(func() { m.release(s) }) }, nil
+		}
+		created := m.creating.DoChan(key, func() (interface{}, error) {
+			session, err := m.newSession(kubectlConfig, namespace, persistConfig)
+			if err != nil {
+				return nil, err
+			}
+			m.mu.Lock()
+			defer m.mu.Unlock()
+			m.sessions[key] = &managedSession{namespace: namespace, persistConfig: persistConfig, session: session, idleSince: m.clock.Now()}
+			return nil, nil
+		})
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case res := <-created:
+			if res.Err != nil {
+				return nil, nil, res.Err
+			}
+		}
+		// the session is acquired on the next iteration, unless it was evicted in the meantime
+	}
+}
+
+// acquire takes a reference to the session for the key, or returns nil if there is none
+func (m *SessionManager) acquire(key string) *managedSession {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[key]
+	if !ok {
+		return nil
+	}
+	s.refs++
+	return s
+}
+
+func (m *SessionManager) release(s *managedSession) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s.refs--
+	if s.refs == 0 {
+		s.idleSince = m.clock.Now()
+	}
+}
+
+// EvictIdle closes the sessions that nobody is using and have been idle for at least the idle timeout, returning
+// how many were closed
+func (m *SessionManager) EvictIdle() int {
+	m.mu.Lock()
+	var evicted []*managedSession
+	for key, s := range m.sessions {
+		if s.refs > 0 || m.clock.Now().Sub(s.idleSince) < m.idleTimeout {
+			continue
+		}
+		delete(m.sessions, key)
+		evicted = append(evicted, s)
+	}
+	m.mu.Unlock()
+	for _, s := range evicted {
+		if err := s.session.Close(); err != nil {
+			logger().WithError(err).Warn("Failed to close idle DB session")
+		}
+	}
+	return len(evicted)
+}
+
+// Run evicts idle sessions periodically until the context is done, and then closes all sessions
+func (m *SessionManager) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.idleTimeout)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			m.Close()
+			return
+		case <-ticker.C:
+			if n := m.EvictIdle(); n > 0 {
+				logger().WithField("evicted", n).Debug("Evicted idle DB sessions")
+			}
+		}
+	}
+}
+
+// Close closes every session, whether or not it is in use
+func (m *SessionManager) Close() {
+	m.mu.Lock()
+	sessions := m.sessions
+	m.sessions = make(map[string]*managedSession)
+	m.mu.Unlock()
+	for _, s := range sessions {
+		if err := s.session.Close(); err != nil {
+			logger().WithError(err).Warn("Failed to close DB session")
+		}
+	}
+}
+
+// PoolStats returns the pool stats of every session, sorted by namespace
+func (m *SessionManager) PoolStats() []PoolStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats := make([]PoolStats, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		stats = append(stats, SessionPoolStats(s.namespace, s.persistConfig, s.session))
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Namespace < stats[j].Namespace })
+	return stats
+}
+
+// sessionKey identifies the session for the namespace and config, any change to the config that affects the session
+// results in a new session
+func sessionKey(namespace string, persistConfig *config.PersistConfig) (string, error) {
+	fingerprint, err := FingerprintConfig(persistConfig)
+	if err != nil {
+		return "", err
+	}
+	return namespace + "/" + fingerprint, nil
+}
@@ -0,0 +1,116 @@
+package sqldb
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/upper/db/v4"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+func TestSessionManager(t *testing.T) {
+	clock := newFakeClock()
+	created := 0
+	m := NewSessionManager(ComponentServer, time.Minute)
+	m.clock = clock
+	m.newSession = func(kubernetes.Interface, string, *config.PersistConfig) (db.Session, error) {
+		created++
+		return newFakeSession(t, &fakeConnector{dbType: Postgres}), nil
+	}
+	defer m.Close()
+	ctx := context.Background()
+	newConfig := func(host string) *config.PersistConfig {
+		return &config.PersistConfig{PostgreSQL: &config.PostgreSQLConfig{DatabaseConfig: config.DatabaseConfig{Host: host, TableName: "argo_workflows"}}}
+	}
+
+	s1, release1, err := m.GetSession(ctx, nil, "argo", newConfig("postgres"))
+	require.NoError(t, err)
+	t.Run("SameConfig", func(t *testing.T) {
+		s2, release2, err := m.GetSession(ctx, nil, "argo", newConfig("postgres"))
+		require.NoError(t, err)
+		defer release2()
+		assert.Same(t, s1, s2)
+		assert.Equal(t, 1, created)
+	})
+	t.Run("DifferentConfig", func(t *testing.T) {
+		s2, release2, err := m.GetSession(ctx, nil, "argo", newConfig("other"))
+		require.NoError(t, err)
+		defer release2()
+		assert.NotSame(t, s1, s2)
+		s3, release3, err := m.GetSession(ctx, nil, "other-ns", newConfig("postgres"))
+		require.NoError(t, err)
+		defer release3()
+		assert.NotSame(t, s1, s3)
+		assert.Equal(t, 3, created)
+	})
+	t.Run("IdleEviction", func(t *testing.T) {
+		clock.Advance(2 * time.Minute)
+		// the first session is still in use
+		assert.Equal(t, 2, m.EvictIdle())
+		release1()
+		release1()
+		assert.Equal(t, 0, m.EvictIdle(), "not idle for long enough")
+		clock.Advance(time.Minute)
+		assert.Equal(t, 1, m.EvictIdle())
+		_, release, err := m.GetSession(ctx, nil, "argo", newConfig("postgres"))
+		require.NoError(t, err)
+		defer release()
+		assert.Equal(t, 4, created)
+	})
+}
+
+func TestSessionManager_creating(t *testing.T) {
+	ctx := context.Background()
+	// sessions for the "slow" host are not created until unblocked
+	unblock := make(chan struct{})
+	var created atomic.Int32
+	m := NewSessionManager(ComponentServer, time.Minute)
+	m.newSession = func(_ kubernetes.Interface, _ string, persistConfig *config.PersistConfig) (db.Session, error) {
+		if persistConfig.PostgreSQL.Host == "slow" {
+			<-unblock
+		}
+		created.Add(1)
+		return newFakeSession(t, &fakeConnector{dbType: Postgres}), nil
+	}
+	defer m.Close()
+	newConfig := func(host string) *config.PersistConfig {
+		return &config.PersistConfig{PostgreSQL: &config.PostgreSQLConfig{DatabaseConfig: config.DatabaseConfig{Host: host, TableName: "argo_workflows"}}}
+	}
+
+	var wg sync.WaitGroup
+	sessions := make([]db.Session, 3)
+	for i := range sessions {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			session, release, err := m.GetSession(ctx, nil, "argo", newConfig("slow"))
+			if assert.NoError(t, err) {
+				defer release()
+				sessions[i] = session
+			}
+		}(i)
+	}
+	t.Run("OtherSessionsAreNotHeldUp", func(t *testing.T) {
+		_, release, err := m.GetSession(ctx, nil, "argo", newConfig("fast"))
+		require.NoError(t, err)
+		release()
+	})
+	t.Run("Cancelled", func(t *testing.T) {
+		cancelled, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		_, _, err := m.GetSession(cancelled, nil, "argo", newConfig("slow"))
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+	close(unblock)
+	wg.Wait()
+	assert.Equal(t, int32(2), created.Load(), "the slow session is created once")
+	assert.Same(t, sessions[0], sessions[1])
+	assert.Same(t, sessions[0], sessions[2])
+}
@@ -0,0 +1,82 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+)
+
+// roleChangeRegexp matches statements that change the role of the connection, e.g. a pooler's DISCARD ALL
+var roleChangeRegexp = regexp.MustCompile(`(?i)^\s*(reset\s+(role|all)|discard\s+all|set\s+(session\s+)?role)\b`)
+
+// setRoleStatement returns the statement that makes the connection assume the role
+func setRoleStatement(role string) (string, error) {
+	if !identifierRegexp.MatchString(role) {
+		return "", fmt.Errorf("invalid setRole %q", role)
+	}
+	return "set role " + quoteLiteral(Postgres, role), nil
+}
+
+// roleConnector makes every connection assume a role when it connects. The role lasts as long as the connection,
+// as database/sql does not reset the server's session state when a connection is returned to the pool, but if a
+// statement changes the role, the role is set again before the connection is reused.
+type roleConnector struct {
+	driver.Connector
+	statement string
+}
+
+func (c *roleConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := execStatements(c.statement)(ctx, conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return &roleConn{wrappedConn: wrappedConn{Conn: conn}, statement: c.statement}, nil
+}
+
+type roleConn struct {
+	wrappedConn
+	statement string
+	// changed is whether a statement may have changed the role, a connection is only used by one goroutine at a time
+	changed bool
+}
+
+func (c *roleConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.changed = c.changed || roleChangeRegexp.MatchString(query)
+	return c.wrappedConn.ExecContext(ctx, query, args)
+}
+
+func (c *roleConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	c.changed = c.changed || roleChangeRegexp.MatchString(query)
+	return c.wrappedConn.QueryContext(ctx, query, args)
+}
+
+func (c *roleConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	c.changed = c.changed || roleChangeRegexp.MatchString(query)
+	return c.wrappedConn.PrepareContext(ctx, query)
+}
+
+func (c *roleConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// ResetSession is called when the connection is taken from the pool, so the role is set again if it was changed. If
+// it cannot be, the connection is discarded rather than used with the wrong role.
+func (c *roleConn) ResetSession(ctx context.Context) error {
+	if err := c.wrappedConn.ResetSession(ctx); err != nil {
+		return err
+	}
+	if !c.changed {
+		return nil
+	}
+	if err := execStatements(c.statement)(ctx, c.Conn); err != nil {
+		logger().WithError(err).Warn("Failed to set the role of a reused connection, discarding it")
+		return driver.ErrBadConn
+	}
+	c.changed = false
+	return nil
+}
@@ -0,0 +1,68 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	postgresqladp "github.com/upper/db/v4/adapter/postgresql"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+func TestRoleConnector(t *testing.T) {
+	ctx := context.Background()
+	statement, err := setRoleStatement("argo_writers")
+	require.NoError(t, err)
+	assert.Equal(t, "set role 'argo_writers'", statement)
+	fake := &fakeConnector{dbType: Postgres}
+	sqlDB := sql.OpenDB(&roleConnector{Connector: fake, statement: statement})
+	defer func() { _ = sqlDB.Close() }()
+
+	t.Run("NewConnections", func(t *testing.T) {
+		conn1, err := sqlDB.Conn(ctx)
+		require.NoError(t, err)
+		defer func() { _ = conn1.Close() }()
+		conn2, err := sqlDB.Conn(ctx)
+		require.NoError(t, err)
+		defer func() { _ = conn2.Close() }()
+		require.Len(t, fake.Conns(), 2)
+		for _, conn := range fake.Conns() {
+			assert.Equal(t, []string{"set role 'argo_writers'"}, conn.Statements())
+		}
+	})
+	t.Run("Reused", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			_, err := sqlDB.ExecContext(ctx, "insert into argo_workflows values (1)")
+			require.NoError(t, err)
+		}
+		for _, conn := range fake.Conns() {
+			assert.NotContains(t, conn.Statements()[1:], "set role 'argo_writers'", "an unchanged role is not set again")
+		}
+	})
+	t.Run("Reset", func(t *testing.T) {
+		sqlDB.SetMaxOpenConns(1)
+		_, err := sqlDB.ExecContext(ctx, "RESET ROLE")
+		require.NoError(t, err)
+		_, err = sqlDB.ExecContext(ctx, "insert into argo_workflows values (2)")
+		require.NoError(t, err)
+		var conn *fakeConn
+		for _, c := range fake.Conns() {
+			if slices.Contains(c.Statements(), "RESET ROLE") {
+				conn = c
+			}
+		}
+		require.NotNil(t, conn)
+		statements := conn.Statements()
+		assert.Equal(t, []string{"RESET ROLE", "set role 'argo_writers'", "insert into argo_workflows values (2)"}, statements[len(statements)-3:])
+	})
+	t.Run("Invalid", func(t *testing.T) {
+		for _, role := range []string{"argo writers", "argo'; drop table argo_workflows; --", "1argo"} {
+			_, err := openPostgres(postgresqladp.ConnectionURL{Host: "postgres"}, &config.PostgreSQLConfig{SetRole: role}, nil, sessionFeatures{})
+			assert.EqualError(t, err, `invalid setRole "`+role+`"`)
+		}
+	})
+}
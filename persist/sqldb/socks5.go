@@ -0,0 +1,48 @@
+package sqldb
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/net/proxy"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+// newSOCKS5Dialer returns a dialer that connects to the proxy using forward, and the name that identifies the proxy
+// and user
+func newSOCKS5Dialer(ctx context.Context, kubectlConfig kubernetes.Interface, namespace string, cfg config.DatabaseConfig, forward proxy.Dialer) (proxy.ContextDialer, string, error) {
+	p := cfg.Socks5Proxy
+	if p.Address == "" {
+		return nil, "", fmt.Errorf("socks5Proxy.address is required")
+	}
+	var auth *proxy.Auth
+	if p.UsernameSecret != nil {
+		username, err := getSecret(ctx, kubectlConfig, namespace, *p.UsernameSecret, cfg.SecretFetchRetries)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to get the SOCKS5 proxy username: %w", err)
+		}
+		auth = &proxy.Auth{User: string(username)}
+		if p.PasswordSecret != nil {
+			password, err := getSecret(ctx, kubectlConfig, namespace, *p.PasswordSecret, cfg.SecretFetchRetries)
+			if err != nil {
+				return nil, "", fmt.Errorf("failed to get the SOCKS5 proxy password: %w", err)
+			}
+			auth.Password = string(password)
+		}
+	}
+	dialer, err := proxy.SOCKS5("tcp", p.Address, auth, forward)
+	if err != nil {
+		return nil, "", err
+	}
+	contextDialer, ok := dialer.(proxy.ContextDialer)
+	if !ok {
+		return nil, "", fmt.Errorf("SOCKS5 dialer %T does not support contexts", dialer)
+	}
+	name := "socks5:" + p.Address
+	if auth != nil {
+		name = "socks5:" + auth.User + "@" + p.Address
+	}
+	return contextDialer, name, nil
+}
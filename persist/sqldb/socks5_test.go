@@ -0,0 +1,200 @@
+package sqldb
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	postgresqladp "github.com/upper/db/v4/adapter/postgresql"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+// fakeSOCKS5 is a minimal SOCKS5 server that records the address of every CONNECT, and forwards it to target, so
+// that the address does not need to resolve
+type fakeSOCKS5 struct {
+	target         string
+	user, password string
+
+	mu       sync.Mutex
+	connects []string
+}
+
+func newFakeSOCKS5(t *testing.T, target, user, password string) (*fakeSOCKS5, string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+	s := &fakeSOCKS5{target: target, user: user, password: password}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s, listener.Addr().String()
+}
+
+func (s *fakeSOCKS5) Connects() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.connects...)
+}
+
+func (s *fakeSOCKS5) serve(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	readBytes := func(n int) []byte {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return nil
+		}
+		return b
+	}
+	greeting := readBytes(2)
+	if greeting == nil || readBytes(int(greeting[1])) == nil {
+		return
+	}
+	if s.user == "" {
+		_, _ = conn.Write([]byte{5, 0})
+	} else {
+		_, _ = conn.Write([]byte{5, 2})
+		header := readBytes(2)
+		if header == nil {
+			return
+		}
+		user := string(readBytes(int(header[1])))
+		password := string(readBytes(int(readBytes(1)[0])))
+		if user != s.user || password != s.password {
+			_, _ = conn.Write([]byte{1, 1})
+			return
+		}
+		_, _ = conn.Write([]byte{1, 0})
+	}
+	request := readBytes(4)
+	if request == nil {
+		return
+	}
+	var host string
+	switch request[3] {
+	case 1:
+		host = net.IP(readBytes(4)).String()
+	case 3:
+		host = string(readBytes(int(readBytes(1)[0])))
+	case 4:
+		host = net.IP(readBytes(16)).String()
+	}
+	port := binary.BigEndian.Uint16(readBytes(2))
+	s.mu.Lock()
+	s.connects = append(s.connects, net.JoinHostPort(host, strconv.Itoa(int(port))))
+	s.mu.Unlock()
+	target, err := net.Dial("tcp", s.target)
+	if err != nil {
+		_, _ = conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer func() { _ = target.Close() }()
+	_, _ = conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go func() { _, _ = io.Copy(target, conn) }()
+	_, _ = io.Copy(conn, target)
+}
+
+// newFakeDatabase returns the address of a server that counts its connections and closes them straight away
+func newFakeDatabase(t *testing.T) (string, func() int) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+	var mu sync.Mutex
+	accepted := 0
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			accepted++
+			mu.Unlock()
+			_ = conn.Close()
+		}
+	}()
+	return listener.Addr().String(), func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return accepted
+	}
+}
+
+func TestSOCKS5Proxy(t *testing.T) {
+	ctx := context.Background()
+	kube := fake.NewSimpleClientset(&apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "argo-socks5-proxy", Namespace: "argo"},
+		Data:       map[string][]byte{"username": []byte("proxy-user"), "password": []byte("proxy-password")},
+	})
+	newConfig := func(address string) config.DatabaseConfig {
+		return config.DatabaseConfig{Socks5Proxy: &config.Socks5Proxy{
+			Address:        address,
+			UsernameSecret: &apiv1.SecretKeySelector{LocalObjectReference: apiv1.LocalObjectReference{Name: "argo-socks5-proxy"}, Key: "username"},
+			PasswordSecret: &apiv1.SecretKeySelector{LocalObjectReference: apiv1.LocalObjectReference{Name: "argo-socks5-proxy"}, Key: "password"},
+		}}
+	}
+
+	t.Run("NoProxy", func(t *testing.T) {
+		dialer, err := newDBDialer(ctx, kube, "argo", config.DatabaseConfig{})
+		require.NoError(t, err)
+		assert.Nil(t, dialer)
+	})
+	t.Run("AddressRequired", func(t *testing.T) {
+		_, err := newDBDialer(ctx, kube, "argo", newConfig(""))
+		assert.EqualError(t, err, "socks5Proxy.address is required")
+	})
+	t.Run("Postgres", func(t *testing.T) {
+		database, accepted := newFakeDatabase(t)
+		proxy, address := newFakeSOCKS5(t, database, "proxy-user", "proxy-password")
+		dialer, err := newDBDialer(ctx, kube, "argo", newConfig(address))
+		require.NoError(t, err)
+		connConfig, err := pgxConnConfig(postgresqladp.ConnectionURL{User: "argo", Host: "db.internal:5432", Options: map[string]string{"sslmode": "disable"}}, &config.PostgreSQLConfig{}, withDialer(dialer))
+		require.NoError(t, err)
+		_, err = pgconn.ConnectConfig(ctx, &connConfig.Config)
+		assert.Error(t, err, "the fake database closes the connection")
+		assert.Equal(t, []string{"db.internal:5432"}, proxy.Connects(), "the host is resolved by the proxy")
+		assert.Equal(t, 1, accepted())
+	})
+	t.Run("MySQL", func(t *testing.T) {
+		database, accepted := newFakeDatabase(t)
+		proxy, address := newFakeSOCKS5(t, database, "proxy-user", "proxy-password")
+		dialer, err := newDBDialer(ctx, kube, "argo", newConfig(address))
+		require.NoError(t, err)
+		assert.Equal(t, "socks5:proxy-user@"+address, dialer.name)
+		mysqlConfig := mysql.NewConfig()
+		mysqlConfig.Addr = "db.internal:3306"
+		mysqlConfig.Net = registerMySQLDialer(dialer)
+		connector, err := mysql.NewConnector(mysqlConfig)
+		require.NoError(t, err)
+		_, err = connector.Connect(ctx)
+		assert.Error(t, err, "the fake database closes the connection")
+		assert.Equal(t, []string{"db.internal:3306"}, proxy.Connects())
+		assert.Equal(t, 1, accepted())
+	})
+	t.Run("WrongPassword", func(t *testing.T) {
+		database, accepted := newFakeDatabase(t)
+		_, address := newFakeSOCKS5(t, database, "proxy-user", "other-password")
+		dialer, err := newDBDialer(ctx, kube, "argo", newConfig(address))
+		require.NoError(t, err)
+		_, err = dialer.DialContext(ctx, "tcp", "db.internal:5432")
+		assert.Error(t, err)
+		assert.Equal(t, 0, accepted())
+	})
+}
@@ -0,0 +1,223 @@
+package sqldb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgconn"
+	"github.com/upper/db/v4"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+// SplitSession sends writes to a writer session and reads to a reader session, e.g. the cluster and reader
+// endpoints of Amazon Aurora. When the writer turns out to be read-only, because a failover promoted another
+// instance, the writer session is reconnected.
+type SplitSession struct {
+	mu        sync.RWMutex
+	writer    db.Session
+	reader    db.Session
+	reconnect func() (db.Session, error)
+	// readAfterWriteTimeout is how long ReadAfter waits for the reader to catch up
+	readAfterWriteTimeout time.Duration
+}
+
+// NewSplitSession creates a split session. reader may be the same session as writer. reconnect opens a new writer
+// session, if it is nil the writer is never reconnected.
+func NewSplitSession(writer, reader db.Session, reconnect func() (db.Session, error)) *SplitSession {
+	return &SplitSession{writer: writer, reader: reader, reconnect: reconnect}
+}
+
+// CreateSplitDBSession creates a split session for the component from the persistence config. Reads are sent to the Aurora reader
+// endpoint if one is configured, otherwise both reads and writes use the same session. The middleware is applied to
+// each session.
+func CreateSplitDBSession(kubectlConfig kubernetes.Interface, namespace string, component Component, persistConfig *config.PersistConfig, middleware ...SessionMiddleware) (*SplitSession, error) {
+	persistConfig, err := ResolveBackend(persistConfig)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := CreateDBSession(kubectlConfig, namespace, component, persistConfig, middleware...)
+	if err != nil {
+		return nil, err
+	}
+	reconnect := func() (db.Session, error) {
+		return CreateDBSession(kubectlConfig, namespace, component, persistConfig, middleware...)
+	}
+	readerConfig := readerPersistConfig(persistConfig)
+	if readerConfig == nil {
+		return NewSplitSession(writer, writer, reconnect), nil
+	}
+	reader, err := CreateDBSession(kubectlConfig, namespace, component, readerConfig, middleware...)
+	if err != nil {
+		_ = writer.Close()
+		return nil, err
+	}
+	s := NewSplitSession(writer, reader, reconnect)
+	s.readAfterWriteTimeout = time.Duration(databaseConfig(persistConfig).ReadAfterWriteTimeout)
+	return s, nil
+}
+
+// readerPersistConfig returns a copy of the config that connects to the reader endpoint, using the reader's pool and
+// statement timeout, or nil if there is no reader endpoint
+func readerPersistConfig(persistConfig *config.PersistConfig) *config.PersistConfig {
+	readerConfig := *persistConfig
+	if persistConfig.ReaderConnectionPool != nil {
+		readerConfig.ConnectionPool = persistConfig.ReaderConnectionPool
+	}
+	withReader := func(cfg config.DatabaseConfig) config.DatabaseConfig {
+		cfg.Host, cfg.AuroraWriterEndpoint = cfg.AuroraReaderEndpoint, ""
+		if cfg.ReaderStatementTimeout > 0 {
+			cfg.StatementTimeout = cfg.ReaderStatementTimeout
+		}
+		return cfg
+	}
+	if cfg := persistConfig.PostgreSQL; cfg != nil && cfg.AuroraReaderEndpoint != "" {
+		postgreSQL := *cfg
+		postgreSQL.DatabaseConfig = withReader(cfg.DatabaseConfig)
+		readerConfig.PostgreSQL = &postgreSQL
+		return &readerConfig
+	}
+	if cfg := persistConfig.MySQL; cfg != nil && cfg.AuroraReaderEndpoint != "" {
+		mySQL := *cfg
+		mySQL.DatabaseConfig = withReader(cfg.DatabaseConfig)
+		readerConfig.MySQL = &mySQL
+		return &readerConfig
+	}
+	return nil
+}
+
+// Reconfigure configures the writer's pool with the reloaded connection pool, and the reader's with the reader
+// connection pool, if the reader is a separate session. See ReconfigureDBSession.
+func (s *SplitSession) Reconfigure(persistConfig *config.PersistConfig) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ReconfigureDBSession(s.writer, persistConfig.ConnectionPool)
+	if s.reader != s.writer {
+		readerPool := persistConfig.ReaderConnectionPool
+		if readerPool == nil {
+			readerPool = persistConfig.ConnectionPool
+		}
+		ReconfigureDBSession(s.reader, readerPool)
+	}
+}
+
+// Writer returns the current writer session
+func (s *SplitSession) Writer() db.Session {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.writer
+}
+
+// Reader returns the reader session
+func (s *SplitSession) Reader() db.Session {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.reader
+}
+
+// Write runs fn against the writer. If the writer rejects the write because it is read-only, the writer is
+// reconnected and fn is run once more against the new writer.
+func (s *SplitSession) Write(fn func(session db.Session) error) error {
+	writer := s.Writer()
+	err := fn(writer)
+	if !isReadOnlyError(err) || s.reconnect == nil {
+		return err
+	}
+	logger().WithError(err).Warn("Database writer is read-only, assuming failover and reconnecting")
+	writer, err = s.reconnectWriter(writer)
+	if err != nil {
+		return err
+	}
+	return fn(writer)
+}
+
+// Tx runs fn in a transaction against the writer, even if it only reads, so the transaction never lands on a replica.
+// database/sql holds a single connection for the whole transaction, so every statement uses the same connection, and
+// the pool cannot recycle or rebalance it until the transaction ends. If the writer turns out to be read-only, the
+// whole transaction is run once more against the new writer, as with Write.
+func (s *SplitSession) Tx(ctx context.Context, fn func(tx db.Session) error) error {
+	return s.Write(func(session db.Session) error {
+		return session.TxContext(ctx, fn, nil)
+	})
+}
+
+// Read runs fn against the reader
+func (s *SplitSession) Read(fn func(session db.Session) error) error {
+	return fn(s.Reader())
+}
+
+func (s *SplitSession) reconnectWriter(stale db.Session) (db.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.writer != stale {
+		// another write already reconnected
+		return s.writer, nil
+	}
+	writer, err := s.reconnect()
+	if err != nil {
+		return nil, fmt.Errorf("failed to reconnect database writer: %w", err)
+	}
+	if s.reader == s.writer {
+		s.reader = writer
+	}
+	s.writer = writer
+	if err := stale.Close(); err != nil {
+		logger().WithError(err).Warn("Failed to close stale database writer")
+	}
+	return writer, nil
+}
+
+// Close closes both sessions
+func (s *SplitSession) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var errs []error
+	if s.reader != s.writer {
+		errs = append(errs, s.reader.Close())
+	}
+	errs = append(errs, s.writer.Close())
+	return errors.Join(errs...)
+}
+
+// Drain drains the reader and then the writer with DrainPool, so that reads stop before the writes that they may be
+// waiting for, e.g. on shutdown. ctx is the deadline of both. It returns the sum of the connections that were closed
+// at the deadline, and the errors of both.
+func (s *SplitSession) Drain(ctx context.Context) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var closed int
+	var errs []error
+	if s.reader != s.writer {
+		n, err := DrainPool(ctx, s.reader)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to drain the reader: %w", err))
+		}
+		closed += n
+	}
+	n, err := DrainPool(ctx, s.writer)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to drain the writer: %w", err))
+	}
+	return closed + n, errors.Join(errs...)
+}
+
+// isReadOnlyError returns true if the error is the database refusing a write because it is read-only, e.g. because
+// it is a replica
+func isReadOnlyError(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		// ER_OPTION_PREVENTS_STATEMENT (with --read-only) and ER_READ_ONLY_MODE
+		return mysqlErr.Number == 1290 || mysqlErr.Number == 1836
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		// read_only_sql_transaction
+		return pgErr.Code == "25006"
+	}
+	return false
+}
@@ -0,0 +1,286 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/upper/db/v4"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+func TestSplitSession(t *testing.T) {
+	insert := func(session db.Session) error {
+		_, err := session.SQL().Exec("insert into argo_workflows values (1)")
+		return err
+	}
+	readOnly := &fakeConnector{dbType: MySQL, handler: func(query string, _ []driver.NamedValue) (*fakeResult, error) {
+		if strings.HasPrefix(query, "insert") {
+			return nil, &mysql.MySQLError{Number: 1290, Message: "The MySQL server is running with the --read-only option"}
+		}
+		return &fakeResult{}, nil
+	}}
+	t.Run("Write", func(t *testing.T) {
+		writer := &fakeConnector{dbType: MySQL}
+		reader := &fakeConnector{dbType: MySQL}
+		s := NewSplitSession(newFakeSession(t, writer), newFakeSession(t, reader), nil)
+		require.NoError(t, s.Write(insert))
+		require.NoError(t, s.Read(func(session db.Session) error { return session.Ping() }))
+		assert.Contains(t, writer.Statements(), "insert into argo_workflows values (1)")
+		assert.NotContains(t, reader.Statements(), "insert into argo_workflows values (1)")
+	})
+	t.Run("Failover", func(t *testing.T) {
+		oldWriter := newFakeSession(t, readOnly)
+		newWriter := &fakeConnector{dbType: MySQL}
+		reconnects := 0
+		s := NewSplitSession(oldWriter, oldWriter, func() (db.Session, error) {
+			reconnects++
+			return newFakeSession(t, newWriter), nil
+		})
+		require.NoError(t, s.Write(insert))
+		assert.Equal(t, 1, reconnects)
+		assert.Contains(t, newWriter.Statements(), "insert into argo_workflows values (1)")
+		assert.Error(t, oldWriter.Ping(), "stale writer is closed")
+		assert.Equal(t, s.Writer(), s.Reader(), "a shared reader follows the writer")
+		require.NoError(t, s.Write(insert))
+		assert.Equal(t, 1, reconnects, "healthy writer is not reconnected")
+	})
+	t.Run("OtherErrorsAreNotRetried", func(t *testing.T) {
+		s := NewSplitSession(newFakeSession(t, &fakeConnector{dbType: MySQL, handler: func(string, []driver.NamedValue) (*fakeResult, error) {
+			return nil, &mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}
+		}}), nil, func() (db.Session, error) {
+			t.Fatal("unexpected reconnect")
+			return nil, nil
+		})
+		assert.Error(t, s.Write(insert))
+	})
+	t.Run("NoReconnect", func(t *testing.T) {
+		writer := newFakeSession(t, readOnly)
+		s := NewSplitSession(writer, writer, nil)
+		assert.Error(t, s.Write(insert), "the read-only error is returned")
+		assert.Same(t, writer, s.Writer())
+	})
+}
+
+func TestSplitSession_Tx(t *testing.T) {
+	ctx := context.Background()
+	statements := []string{
+		"select id from argo_workflows where name = 'my-wf' for update",
+		"update argo_workflows set phase = 'Running' where name = 'my-wf'",
+		"insert into argo_workflow_history values (1)",
+	}
+	// assertOneConn asserts that a single connection ran every statement of the transaction
+	assertOneConn := func(t *testing.T, connector *fakeConnector) {
+		t.Helper()
+		var used []*fakeConn
+		for _, conn := range connector.Conns() {
+			if slices.ContainsFunc(statements, func(statement string) bool { return slices.Contains(conn.Statements(), statement) }) {
+				used = append(used, conn)
+			}
+		}
+		if assert.Len(t, used, 1, "the transaction used one connection") {
+			assert.Subset(t, used[0].Statements(), statements)
+		}
+	}
+
+	t.Run("SameConnection", func(t *testing.T) {
+		writer := &fakeConnector{dbType: Postgres}
+		reader := &fakeConnector{dbType: Postgres}
+		s := NewSplitSession(newFakeSession(t, writer), newFakeSession(t, reader), nil)
+		err := s.Tx(ctx, func(tx db.Session) error {
+			for _, statement := range statements {
+				if _, err := tx.SQL().Exec(statement); err != nil {
+					return err
+				}
+				// the pool has another connection to choose from while the transaction runs
+				if err := s.Writer().Ping(); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Greater(t, len(writer.Conns()), 1)
+		assertOneConn(t, writer)
+		for _, statement := range statements {
+			assert.NotContains(t, reader.Statements(), statement, "a transaction never lands on the reader")
+		}
+	})
+	t.Run("ReadOnly", func(t *testing.T) {
+		writer := &fakeConnector{dbType: Postgres}
+		reader := &fakeConnector{dbType: Postgres}
+		s := NewSplitSession(newFakeSession(t, writer), newFakeSession(t, reader), nil)
+		require.NoError(t, s.Tx(ctx, func(tx db.Session) error {
+			_, err := tx.SQL().Exec(statements[0])
+			return err
+		}))
+		assert.Contains(t, writer.Statements(), statements[0])
+		assert.NotContains(t, reader.Statements(), statements[0], "a transaction that only reads uses the writer")
+	})
+	t.Run("Failover", func(t *testing.T) {
+		oldWriter := &fakeConnector{dbType: MySQL, handler: func(query string, _ []driver.NamedValue) (*fakeResult, error) {
+			if strings.HasPrefix(query, "insert") {
+				return nil, &mysql.MySQLError{Number: 1290, Message: "The MySQL server is running with the --read-only option"}
+			}
+			return &fakeResult{}, nil
+		}}
+		newWriter := &fakeConnector{dbType: MySQL}
+		session := newFakeSession(t, oldWriter)
+		s := NewSplitSession(session, session, func() (db.Session, error) {
+			return newFakeSession(t, newWriter), nil
+		})
+		err := s.Tx(ctx, func(tx db.Session) error {
+			for _, statement := range statements {
+				if _, err := tx.SQL().Exec(statement); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		// the whole transaction is run again, rather than continuing on another node
+		assertOneConn(t, newWriter)
+	})
+}
+
+func Test_readerPersistConfig(t *testing.T) {
+	assert.Nil(t, readerPersistConfig(&config.PersistConfig{PostgreSQL: &config.PostgreSQLConfig{}}))
+	persistConfig := &config.PersistConfig{PostgreSQL: &config.PostgreSQLConfig{DatabaseConfig: config.DatabaseConfig{
+		Port:                 5432,
+		AuroraWriterEndpoint: "my-cluster",
+		AuroraReaderEndpoint: "my-cluster-ro",
+	}}}
+	readerConfig := readerPersistConfig(persistConfig)
+	if assert.NotNil(t, readerConfig) {
+		assert.Equal(t, "my-cluster-ro:5432", readerConfig.PostgreSQL.GetHostname())
+		assert.Equal(t, "my-cluster:5432", persistConfig.PostgreSQL.GetHostname(), "original config is unchanged")
+	}
+}
+
+func Test_readerPersistConfigPoolAndTimeout(t *testing.T) {
+	persistConfig := &config.PersistConfig{
+		ConnectionPool:       &config.ConnectionPool{MaxOpenConns: 10, MaxIdleConns: 5},
+		ReaderConnectionPool: &config.ConnectionPool{MaxOpenConns: 40, MaxIdleConns: 20},
+		MySQL: &config.MySQLConfig{DatabaseConfig: config.DatabaseConfig{
+			AuroraReaderEndpoint:   "my-cluster-ro",
+			StatementTimeout:       config.TTL(time.Minute),
+			ReaderStatementTimeout: config.TTL(10 * time.Second),
+		}},
+	}
+	readerConfig := readerPersistConfig(persistConfig)
+	require.NotNil(t, readerConfig)
+	assert.Equal(t, config.TTL(10*time.Second), readerConfig.MySQL.StatementTimeout)
+	assert.Equal(t, config.TTL(time.Minute), persistConfig.MySQL.StatementTimeout, "writer keeps its timeout")
+
+	writer := ConfigureDBSession(newFakeSession(t, &fakeConnector{dbType: MySQL}), persistConfig.ConnectionPool)
+	reader := ConfigureDBSession(newFakeSession(t, &fakeConnector{dbType: MySQL}), readerConfig.ConnectionPool)
+	assert.Equal(t, 10, writer.Driver().(*sql.DB).Stats().MaxOpenConnections)
+	assert.Equal(t, 40, reader.Driver().(*sql.DB).Stats().MaxOpenConnections)
+
+	t.Run("Defaults", func(t *testing.T) {
+		persistConfig.ReaderConnectionPool = nil
+		persistConfig.MySQL.ReaderStatementTimeout = 0
+		readerConfig := readerPersistConfig(persistConfig)
+		require.NotNil(t, readerConfig)
+		assert.Same(t, persistConfig.ConnectionPool, readerConfig.ConnectionPool)
+		assert.Equal(t, config.TTL(time.Minute), readerConfig.MySQL.StatementTimeout)
+	})
+}
+
+// closeRecorder records the order that the pools are closed in
+type closeRecorder struct {
+	mu     sync.Mutex
+	closed []string
+}
+
+func (r *closeRecorder) Closed() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.closed...)
+}
+
+type closeRecordingConnector struct {
+	*fakeConnector
+	name     string
+	recorder *closeRecorder
+}
+
+func (c closeRecordingConnector) Close() error {
+	c.recorder.mu.Lock()
+	defer c.recorder.mu.Unlock()
+	c.recorder.closed = append(c.recorder.closed, c.name)
+	return nil
+}
+
+func TestSplitSession_Drain(t *testing.T) {
+	ctx := context.Background()
+	newSession := func(t *testing.T, name string, recorder *closeRecorder) db.Session {
+		connector := &fakeConnector{dbType: Postgres, handler: func(query string, _ []driver.NamedValue) (*fakeResult, error) {
+			if res := lookupNameResult(query); res != nil {
+				return res, nil
+			}
+			return &fakeResult{}, nil
+		}}
+		session, err := openSession(Postgres, closeRecordingConnector{fakeConnector: connector, name: name, recorder: recorder}, allSessionFeatures)
+		require.NoError(t, err)
+		return session
+	}
+	t.Run("ReaderThenWriter", func(t *testing.T) {
+		recorder := &closeRecorder{}
+		writer, reader := newSession(t, "writer", recorder), newSession(t, "reader", recorder)
+		// a read in flight delays the writer being drained until it finishes
+		conn, err := reader.Driver().(*sql.DB).Conn(ctx)
+		require.NoError(t, err)
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			assert.Equal(t, []string{"reader"}, recorder.Closed(), "the writer is not closed while reads are in flight")
+			_ = conn.Close()
+		}()
+		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		pools := []*sql.DB{reader.Driver().(*sql.DB), writer.Driver().(*sql.DB)}
+		inUse, err := NewSplitSession(writer, reader, nil).Drain(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, inUse)
+		assert.Equal(t, []string{"reader", "writer"}, recorder.Closed())
+		for _, pool := range pools {
+			_, err := pool.Exec("select 1")
+			assert.EqualError(t, err, "sql: database is closed")
+		}
+	})
+	t.Run("Deadline", func(t *testing.T) {
+		recorder := &closeRecorder{}
+		writer, reader := newSession(t, "writer", recorder), newSession(t, "reader", recorder)
+		readerConn, err := reader.Driver().(*sql.DB).Conn(ctx)
+		require.NoError(t, err)
+		defer func() { _ = readerConn.Close() }()
+		writerConn, err := writer.Driver().(*sql.DB).Conn(ctx)
+		require.NoError(t, err)
+		defer func() { _ = writerConn.Close() }()
+		ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		writerPool := writer.Driver().(*sql.DB)
+		closed, err := NewSplitSession(writer, reader, nil).Drain(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 2, closed, "the connections of both pools are closed")
+		_, err = writerPool.Exec("select 1")
+		assert.EqualError(t, err, "sql: database is closed", "the writer is closed even though the reader did not drain")
+	})
+	t.Run("SameSession", func(t *testing.T) {
+		recorder := &closeRecorder{}
+		session := newSession(t, "writer", recorder)
+		inUse, err := NewSplitSession(session, session, nil).Drain(ctx)
+		require.NoError(t, err)
+		assert.Zero(t, inUse)
+		assert.Equal(t, []string{"writer"}, recorder.Closed(), "the session is drained once")
+	})
+}
@@ -3,8 +3,10 @@ package sqldb
 import (
 	"context"
 	"fmt"
+	"regexp"
 	"time"
 
+	log "github.com/sirupsen/logrus"
 	"github.com/upper/db/v4"
 	mysqladp "github.com/upper/db/v4/adapter/mysql"
 	postgresqladp "github.com/upper/db/v4/adapter/postgresql"
@@ -12,49 +14,290 @@ import (
 
 	"github.com/argoproj/argo-workflows/v3/config"
 	"github.com/argoproj/argo-workflows/v3/errors"
-	"github.com/argoproj/argo-workflows/v3/util"
 )
 
+// ResolveBackend returns a copy of the config with only the backend that is used, as both may be configured, e.g.
+// while migrating from one to the other
+func ResolveBackend(persistConfig *config.PersistConfig) (*config.PersistConfig, error) {
+	c := *persistConfig
+	switch c.PreferredBackend {
+	case "":
+		if c.PostgreSQL != nil && c.MySQL != nil {
+			return nil, errors.InternalError("both postgresql and mysql are configured, set preferredBackend to choose which to use")
+		}
+	case "postgresql":
+		if c.PostgreSQL == nil {
+			return nil, errors.InternalError("preferredBackend is postgresql, but postgresql is not configured")
+		}
+		c.MySQL = nil
+	case "mysql":
+		if c.MySQL == nil {
+			return nil, errors.InternalError("preferredBackend is mysql, but mysql is not configured")
+		}
+		c.PostgreSQL = nil
+	default:
+		return nil, errors.InternalError("preferredBackend must be one of: postgresql, mysql")
+	}
+	return &c, nil
+}
+
 func GetTableName(persistConfig *config.PersistConfig) (string, error) {
-	var tableName string
+	persistConfig, err := ResolveBackend(persistConfig)
+	if err != nil {
+		return "", err
+	}
+	var tableName, backend string
 	if persistConfig.PostgreSQL != nil {
-		tableName = persistConfig.PostgreSQL.TableName
-
+		tableName, backend = persistConfig.PostgreSQL.TableName, "postgresql"
 	} else if persistConfig.MySQL != nil {
-		tableName = persistConfig.MySQL.TableName
+		tableName, backend = persistConfig.MySQL.TableName, "mysql"
 	}
 	if tableName == "" {
-		return "", errors.InternalError("TableName is empty")
-	} else {
+		return "", missingTableNameError(backend)
+	}
+	return tableName, nil
+}
+
+// ErrMissingTableName is the error when the backend's tableName is not set, it is a configuration error rather than
+// an internal one, so it is returned wrapped with the field that must be set
+var ErrMissingTableName = errors.New(errors.CodeBadRequest, "tableName is empty")
+
+// missingTableNameError returns ErrMissingTableName for the backend, i.e. postgresql or mysql
+func missingTableNameError(backend string) error {
+	if backend == "" {
+		return fmt.Errorf("%w: configure persistence.postgresql or persistence.mysql, and set its tableName, e.g. to argo_workflows", ErrMissingTableName)
+	}
+	return fmt.Errorf("%w: set persistence.%s.tableName in the workflow controller config map, e.g. to argo_workflows", ErrMissingTableName, backend)
+}
+
+var identifierRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,62}$`)
+
+// GetQualifiedTableName returns the table name prefixed with the Postgres schema, or the MySQL database, so that
+// queries do not depend on the search path or the connection's default database
+func GetQualifiedTableName(persistConfig *config.PersistConfig) (string, error) {
+	persistConfig, err := ResolveBackend(persistConfig)
+	if err != nil {
+		return "", err
+	}
+	tableName, err := GetTableName(persistConfig)
+	if err != nil {
+		return "", err
+	}
+	var prefix string
+	if persistConfig.PostgreSQL != nil {
+		prefix = persistConfig.PostgreSQL.Schema
+	} else if persistConfig.MySQL != nil {
+		prefix = persistConfig.MySQL.Database
+	}
+	if !identifierRegexp.MatchString(tableName) {
+		return "", errors.InternalErrorf("invalid table name %q", tableName)
+	}
+	if prefix == "" {
 		return tableName, nil
 	}
+	if !identifierRegexp.MatchString(prefix) {
+		return "", errors.InternalErrorf("invalid schema or database name %q", prefix)
+	}
+	return prefix + "." + tableName, nil
 }
 
-// CreateDBSession creates the dB session
-func CreateDBSession(kubectlConfig kubernetes.Interface, namespace string, persistConfig *config.PersistConfig) (db.Session, error) {
+// CreateDBSession creates the dB session for the component, which is wrapped by the middleware in order once it is
+// configured, unless in lightweight mode. If it cannot connect, it connects using the fallback config instead, if
+// there is one.
+func CreateDBSession(kubectlConfig kubernetes.Interface, namespace string, component Component, persistConfig *config.PersistConfig, middleware ...SessionMiddleware) (db.Session, error) {
 	if persistConfig == nil {
 		return nil, errors.InternalError("Persistence config is not found")
 	}
+	return createDBSession(kubectlConfig, namespace, component, WithEnvOverrides(persistConfig), 0, middleware...)
+}
 
-	if persistConfig.PostgreSQL != nil {
-		return CreatePostGresDBSession(kubectlConfig, namespace, persistConfig.PostgreSQL, persistConfig.ConnectionPool)
+// fallbackPersistConfig returns a copy of the fallback config that uses the table name of the config, as the
+// controller and the server find the tables by the name in the config that they were given, whichever database they
+// are connected to
+func fallbackPersistConfig(persistConfig *config.PersistConfig) *config.PersistConfig {
+	fallback := *persistConfig.Fallback
+	tableName := databaseConfig(persistConfig).TableName
+	if fallback.PostgreSQL != nil {
+		postgreSQL := *fallback.PostgreSQL
+		postgreSQL.TableName = tableName
+		fallback.PostgreSQL = &postgreSQL
+	}
+	if fallback.MySQL != nil {
+		mySQL := *fallback.MySQL
+		mySQL.TableName = tableName
+		fallback.MySQL = &mySQL
+	}
+	return &fallback
+}
+
+// connectDB can be replaced in tests
+var connectDB = newDBSession
+
+// createDBSession creates the session, falling back to the fallback config, and then to its fallback, until one
+// connects. fallbacks is how many configs have already failed.
+func createDBSession(kubectlConfig kubernetes.Interface, namespace string, component Component, persistConfig *config.PersistConfig, fallbacks int, middleware ...SessionMiddleware) (db.Session, error) {
+	persistConfig, err := ResolveBackend(persistConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := retryConnect(persistConfig.ConnectionRetry, func() (db.Session, error) {
+		session, err := connectDB(kubectlConfig, namespace, component, persistConfig)
+		if err != nil {
+			return nil, err
+		}
+		// a connection that is routed to the wrong database is as good as no connection, so the fallback is used
+		if err := validateConnection(context.Background(), session, databaseConfig(persistConfig)); err != nil {
+			_ = session.Close()
+			return nil, err
+		}
+		return session, nil
+	})
+	if err != nil {
+		if persistConfig.Fallback == nil {
+			return nil, err
+		}
+		logger().WithFields(persistenceSummary(persistConfig)).WithError(err).Warn("Failed to connect to the database, connecting to the fallback")
+		return createDBSession(kubectlConfig, namespace, component, fallbackPersistConfig(persistConfig), fallbacks+1, middleware...)
+	}
+	if err := checkMaxConnections(session, dbTypeFor(session), persistConfig.ConnectionPool); err != nil {
+		_ = session.Close()
+		return nil, err
+	}
+	fields := persistenceSummary(persistConfig)
+	if fallbacks > 0 {
+		fields["fallback"] = fallbacks
+	}
+	if component != "" {
+		fields["component"] = component
+	}
+	logger().WithFields(fields).Info("Persistence configured")
+	return instrumentSession(session, component, persistConfig, middleware...), nil
+}
+
+// instrumentSession logs the server version, reports when the component's queries last succeeded, records the
+// fingerprints of its queries, and applies the middleware. In lightweight mode it does none of these, and returns the
+// bare session.
+func instrumentSession(session db.Session, component Component, persistConfig *config.PersistConfig, middleware ...SessionMiddleware) db.Session {
+	if persistConfig.LightweightMode {
+		return session
+	}
+	logServerVersion(context.Background(), session, dbTypeFor(session))
+	trackLastSuccess(session, component)
+	recordQueryFingerprints(session, databaseConfig(persistConfig).TopQueryFingerprints)
+	return applyMiddleware(session, middleware...)
+}
+
+// newDBSession creates the session for whichever database is configured
+func newDBSession(kubectlConfig kubernetes.Interface, namespace string, component Component, persistConfig *config.PersistConfig) (db.Session, error) {
+	var session db.Session
+	var err error
+	var t dbType
+	features := sessionFeaturesFor(databaseConfig(persistConfig), persistConfig.LightweightMode)
+	if cfg := persistConfig.PostgreSQL; cfg != nil {
+		t = Postgres
+		var opts []PostgresOption
+		if component != "" {
+			opts = append(opts, withApplicationName(applicationName(component, cfg.PerPodAppName)))
+		}
+		session, err = createPostgresDBSession(kubectlConfig, namespace, cfg, persistConfig.ConnectionPool, features, opts...)
 	} else if persistConfig.MySQL != nil {
-		return CreateMySQLDBSession(kubectlConfig, namespace, persistConfig.MySQL, persistConfig.ConnectionPool)
+		t = MySQL
+		session, err = createMySQLDBSession(kubectlConfig, namespace, persistConfig.MySQL, persistConfig.ConnectionPool, features)
+	} else {
+		return nil, fmt.Errorf("no databases are configured")
+	}
+	if err != nil && !persistConfig.LightweightMode {
+		recordConnectionError(t, component, err)
+	}
+	return session, err
+}
+
+// databaseConfig returns the config of whichever database is configured
+func databaseConfig(persistConfig *config.PersistConfig) config.DatabaseConfig {
+	if persistConfig.PostgreSQL != nil {
+		return persistConfig.PostgreSQL.DatabaseConfig
+	}
+	if persistConfig.MySQL != nil {
+		return persistConfig.MySQL.DatabaseConfig
 	}
-	return nil, fmt.Errorf("no databases are configured")
+	return config.DatabaseConfig{}
+}
+
+// persistenceSummary summarizes the effective persistence settings for support triage. It must never include
+// credentials, so only settings that are not secret are listed.
+func persistenceSummary(persistConfig *config.PersistConfig) log.Fields {
+	fields := log.Fields{}
+	if cfg := persistConfig.PostgreSQL; cfg != nil {
+		fields["backend"] = Postgres
+		fields["host"] = cfg.GetHostname()
+		fields["database"] = cfg.Database
+		fields["tableName"] = cfg.TableName
+		fields["tls"] = cfg.SSL
+		fields["sslMode"] = cfg.SSLMode
+	} else if cfg := persistConfig.MySQL; cfg != nil {
+		fields["backend"] = MySQL
+		fields["host"] = cfg.GetHostname()
+		fields["database"] = cfg.Database
+		fields["tableName"] = cfg.TableName
+		fields["tls"] = cfg.Options["tls"] != "" && cfg.Options["tls"] != "false"
+	}
+	if pool := persistConfig.ConnectionPool; pool != nil {
+		fields["maxOpenConns"] = pool.MaxOpenConns
+		fields["maxIdleConns"] = pool.MaxIdleConns
+		fields["connMaxLifetime"] = time.Duration(pool.ConnMaxLifetime).String()
+	}
+	return fields
 }
 
 // CreatePostGresDBSession creates postgresDB session
-func CreatePostGresDBSession(kubectlConfig kubernetes.Interface, namespace string, cfg *config.PostgreSQLConfig, persistPool *config.ConnectionPool) (db.Session, error) {
+func CreatePostGresDBSession(kubectlConfig kubernetes.Interface, namespace string, cfg *config.PostgreSQLConfig, persistPool *config.ConnectionPool, opts ...PostgresOption) (db.Session, error) {
+	return createPostgresDBSession(kubectlConfig, namespace, cfg, persistPool, sessionFeaturesFor(cfg.DatabaseConfig, false), opts...)
+}
+
+func createPostgresDBSession(kubectlConfig kubernetes.Interface, namespace string, cfg *config.PostgreSQLConfig, persistPool *config.ConnectionPool, features sessionFeatures, opts ...PostgresOption) (db.Session, error) {
+	if cfg.TableName == "" {
+		return nil, missingTableNameError("postgresql")
+	}
 	ctx := context.Background()
-	userNameByte, err := util.GetSecrets(ctx, kubectlConfig, namespace, cfg.UsernameSecret.Name, cfg.UsernameSecret.Key)
+	// the host is resolved first, as e.g. IAM auth and the pgpass file depend on it
+	databaseConfig, err := resolveServiceRef(ctx, kubectlConfig, namespace, cfg.DatabaseConfig)
 	if err != nil {
 		return nil, err
 	}
-	passwordByte, err := util.GetSecrets(ctx, kubectlConfig, namespace, cfg.PasswordSecret.Name, cfg.PasswordSecret.Key)
+	resolved := *cfg
+	resolved.DatabaseConfig = databaseConfig
+	cfg = &resolved
+	userNameByte, err := getSecret(ctx, kubectlConfig, namespace, cfg.UsernameSecret, cfg.SecretFetchRetries)
 	if err != nil {
 		return nil, err
 	}
+	switch cfg.AuthMode {
+	case "", AuthModePassword:
+	case AuthModeGSSAPI:
+		if err := setupGSSAPI(ctx, kubectlConfig, namespace, cfg, string(userNameByte)); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("authMode must be one of: password, gssapi")
+	}
+	// with IAM auth, the password is a token that is generated for each connection, with an exec credential it is
+	// output by the command, and with GSSAPI there is none
+	var passwordByte []byte
+	if cfg.IAMAuth == "" && cfg.ExecCredential == nil && cfg.AuthMode != AuthModeGSSAPI {
+		if cfg.PasswordSecret.Name == "" && cfg.PgPassFile != "" {
+			password, err := pgPassFilePassword(cfg, string(userNameByte))
+			if err != nil {
+				return nil, err
+			}
+			passwordByte = []byte(password)
+		} else {
+			passwordByte, err = getSecret(ctx, kubectlConfig, namespace, cfg.PasswordSecret, cfg.SecretFetchRetries)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
 
 	settings := postgresqladp.ConnectionURL{
 		User:     string(userNameByte),
@@ -63,68 +306,177 @@ func CreatePostGresDBSession(kubectlConfig kubernetes.Interface, namespace strin
 		Database: cfg.Database,
 	}
 
-	if cfg.SSL {
-		if cfg.SSLMode != "" {
-			options := map[string]string{
-				"sslmode": cfg.SSLMode,
-			}
-			settings.Options = options
-		}
+	options, err := postgresOptions(cfg)
+	if err != nil {
+		return nil, err
 	}
+	settings.Options = options
 
-	session, err := postgresqladp.Open(settings)
+	dialer, err := newDBDialer(ctx, kubectlConfig, namespace, cfg.DatabaseConfig)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := rootCAs(ctx, kubectlConfig, namespace, cfg.DatabaseConfig)
+	if err != nil {
+		return nil, err
+	}
+	crls, err := revocationLists(ctx, kubectlConfig, namespace, cfg.DatabaseConfig)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := clientCertificate(ctx, kubectlConfig, namespace, cfg.DatabaseConfig)
+	if err != nil {
+		return nil, err
+	}
+	// prepended so that the caller's options take precedence
+	if cert != nil {
+		opts = append([]PostgresOption{withClientCertificate(cert)}, opts...)
+	}
+	if crls != nil {
+		opts = append([]PostgresOption{withCRL(crls)}, opts...)
+	}
+	if pool != nil {
+		opts = append([]PostgresOption{WithRootCAs(pool)}, opts...)
+	}
+	if dialer != nil {
+		opts = append([]PostgresOption{withDialer(dialer)}, opts...)
+	}
+	session, err := openPostgres(settings, cfg, persistPool, features, opts...)
 	if err != nil {
 		return nil, err
 	}
+	if cfg.RequireTLS {
+		if err := verifyTLS(session, Postgres); err != nil {
+			_ = session.Close()
+			return nil, err
+		}
+	} else if cfg.SSL && cfg.SSLMode != "disable" {
+		warnIfNotEncrypted(session, Postgres)
+	}
 	session = ConfigureDBSession(session, persistPool)
 	return session, nil
 }
 
 // CreateMySQLDBSession creates Mysql DB session
 func CreateMySQLDBSession(kubectlConfig kubernetes.Interface, namespace string, cfg *config.MySQLConfig, persistPool *config.ConnectionPool) (db.Session, error) {
+	return createMySQLDBSession(kubectlConfig, namespace, cfg, persistPool, sessionFeaturesFor(cfg.DatabaseConfig, false))
+}
+
+func createMySQLDBSession(kubectlConfig kubernetes.Interface, namespace string, cfg *config.MySQLConfig, persistPool *config.ConnectionPool, features sessionFeatures) (db.Session, error) {
 	if cfg.TableName == "" {
-		return nil, errors.InternalError("tableName is empty")
+		return nil, missingTableNameError("mysql")
+	}
+	if err := validateCharset(cfg.Charset); err != nil {
+		return nil, err
+	}
+	if err := validateCollation(mySQLCharset(cfg), cfg.CollationConnection); err != nil {
+		return nil, err
+	}
+	if err := validateCharsetInitMode(cfg.CharsetInitMode); err != nil {
+		return nil, err
 	}
 
 	ctx := context.Background()
-	userNameByte, err := util.GetSecrets(ctx, kubectlConfig, namespace, cfg.UsernameSecret.Name, cfg.UsernameSecret.Key)
+	databaseConfig, err := resolveServiceRef(ctx, kubectlConfig, namespace, cfg.DatabaseConfig)
 	if err != nil {
 		return nil, err
 	}
-	passwordByte, err := util.GetSecrets(ctx, kubectlConfig, namespace, cfg.PasswordSecret.Name, cfg.PasswordSecret.Key)
+	resolved := *cfg
+	resolved.DatabaseConfig = databaseConfig
+	cfg = &resolved
+	userNameByte, err := getSecret(ctx, kubectlConfig, namespace, cfg.UsernameSecret, cfg.SecretFetchRetries)
 	if err != nil {
 		return nil, err
 	}
+	// with IAM auth, the password is a token that is generated for each connection, and with an exec credential it
+	// is output by the command
+	var passwordByte []byte
+	if cfg.IAMAuth == "" && cfg.ExecCredential == nil {
+		passwordByte, err = getSecret(ctx, kubectlConfig, namespace, cfg.PasswordSecret, cfg.SecretFetchRetries)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	session, err := mysqladp.Open(mysqladp.ConnectionURL{
+	var opts []mySQLOption
+	dialer, err := newDBDialer(ctx, kubectlConfig, namespace, cfg.DatabaseConfig)
+	if err != nil {
+		return nil, err
+	}
+	if dialer != nil {
+		opts = append(opts, withMySQLDialer(dialer))
+	}
+	pool, err := rootCAs(ctx, kubectlConfig, namespace, cfg.DatabaseConfig)
+	if err != nil {
+		return nil, err
+	}
+	if pool != nil {
+		opts = append(opts, withMySQLRootCAs(pool))
+	}
+	crls, err := revocationLists(ctx, kubectlConfig, namespace, cfg.DatabaseConfig)
+	if err != nil {
+		return nil, err
+	}
+	if crls != nil {
+		opts = append(opts, withMySQLCRL(crls))
+	}
+	cert, err := clientCertificate(ctx, kubectlConfig, namespace, cfg.DatabaseConfig)
+	if err != nil {
+		return nil, err
+	}
+	if cert != nil {
+		opts = append(opts, withMySQLClientCertificate(cert))
+	}
+	session, err := openMySQL(mysqladp.ConnectionURL{
 		User:     string(userNameByte),
 		Password: string(passwordByte),
 		Host:     cfg.GetHostname(),
 		Database: cfg.Database,
 		Options:  cfg.Options,
-	})
+	}, cfg, persistPool, features, opts...)
 	if err != nil {
 		return nil, err
 	}
-	session = ConfigureDBSession(session, persistPool)
-	// this is needed to make MySQL run in a Golang-compatible UTF-8 character set.
-	_, err = session.SQL().Exec("SET NAMES 'utf8mb4'")
-	if err != nil {
-		return nil, err
+	if cfg.RequireTLS {
+		if err := verifyTLS(session, MySQL); err != nil {
+			_ = session.Close()
+			return nil, err
+		}
+	} else if cfg.Options["tls"] != "" && cfg.Options["tls"] != "false" {
+		warnIfNotEncrypted(session, MySQL)
 	}
-	_, err = session.SQL().Exec("SET CHARACTER SET utf8mb4")
-	if err != nil {
+	session = ConfigureDBSession(session, persistPool)
+	if err := setMySQLCharset(session, cfg); err != nil {
+		_ = session.Close()
 		return nil, err
 	}
 	return session, nil
 }
 
-// ConfigureDBSession configures the DB session
+// ConfigureDBSession configures the DB session. If the pool is auto-tuned, its size is set to the initial size, which
+// AutoTunePool then adjusts.
 func ConfigureDBSession(session db.Session, persistPool *config.ConnectionPool) db.Session {
 	if persistPool != nil {
-		session.SetMaxOpenConns(persistPool.MaxOpenConns)
+		maxOpenConns := persistPool.MaxOpenConns
+		if persistPool.AutoTune != nil {
+			maxOpenConns = autoTuneInitialSize(persistPool)
+		}
+		session.SetMaxOpenConns(maxOpenConns)
 		session.SetMaxIdleConns(persistPool.MaxIdleConns)
 		session.SetConnMaxLifetime(time.Duration(persistPool.ConnMaxLifetime))
 	}
 	return session
 }
+
+// ReconfigureDBSession configures the DB session once the config is reloaded. The size of an auto-tuned pool is left
+// as AutoTunePool has tuned it, rather than being reset to the initial size.
+func ReconfigureDBSession(session db.Session, persistPool *config.ConnectionPool) {
+	if persistPool == nil {
+		return
+	}
+	if persistPool.AutoTune == nil {
+		session.SetMaxOpenConns(persistPool.MaxOpenConns)
+	}
+	session.SetMaxIdleConns(persistPool.MaxIdleConns)
+	session.SetConnMaxLifetime(time.Duration(persistPool.ConnMaxLifetime))
+}
@@ -0,0 +1,394 @@
+package sqldb
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	pgxv4 "github.com/jackc/pgx/v4"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/upper/db/v4"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+	"github.com/argoproj/argo-workflows/v3/errors"
+	"github.com/argoproj/argo-workflows/v3/workflow/metrics"
+)
+
+func Test_persistenceSummary(t *testing.T) {
+	databaseConfig := config.DatabaseConfig{
+		Host:           "my-host",
+		Port:           1234,
+		Database:       "my-db",
+		TableName:      "argo_workflows",
+		UsernameSecret: apiv1.SecretKeySelector{LocalObjectReference: apiv1.LocalObjectReference{Name: "my-secret"}, Key: "username"},
+		PasswordSecret: apiv1.SecretKeySelector{LocalObjectReference: apiv1.LocalObjectReference{Name: "my-secret"}, Key: "password"},
+	}
+	pool := &config.ConnectionPool{MaxOpenConns: 10, MaxIdleConns: 5, ConnMaxLifetime: config.TTL(time.Minute)}
+	t.Run("Postgres", func(t *testing.T) {
+		fields := persistenceSummary(&config.PersistConfig{
+			ConnectionPool: pool,
+			PostgreSQL:     &config.PostgreSQLConfig{DatabaseConfig: databaseConfig, SSL: true, SSLMode: "require"},
+		})
+		assert.Equal(t, Postgres, fields["backend"])
+		assert.Equal(t, "my-host:1234", fields["host"])
+		assert.Equal(t, true, fields["tls"])
+		assert.Equal(t, 10, fields["maxOpenConns"])
+		assert.Equal(t, 5, fields["maxIdleConns"])
+		assert.Equal(t, "1m0s", fields["connMaxLifetime"])
+		assert.NotContains(t, fmt.Sprint(fields), "my-secret")
+	})
+	t.Run("MySQL", func(t *testing.T) {
+		fields := persistenceSummary(&config.PersistConfig{
+			MySQL: &config.MySQLConfig{DatabaseConfig: databaseConfig},
+		})
+		assert.Equal(t, MySQL, fields["backend"])
+		assert.Equal(t, false, fields["tls"])
+		assert.NotContains(t, fields, "maxOpenConns")
+		assert.NotContains(t, fmt.Sprint(fields), "my-secret")
+	})
+}
+
+func TestGetQualifiedTableName(t *testing.T) {
+	postgres := func(schema, tableName string) *config.PersistConfig {
+		return &config.PersistConfig{PostgreSQL: &config.PostgreSQLConfig{DatabaseConfig: config.DatabaseConfig{Database: "postgres", TableName: tableName}, Schema: schema}}
+	}
+	mySQL := func(database, tableName string) *config.PersistConfig {
+		return &config.PersistConfig{MySQL: &config.MySQLConfig{DatabaseConfig: config.DatabaseConfig{Database: database, TableName: tableName}}}
+	}
+	for _, tt := range []struct {
+		name          string
+		persistConfig *config.PersistConfig
+		want          string
+		err           string
+	}{
+		{"PostgresSchema", postgres("argo", "argo_workflows"), "argo.argo_workflows", ""},
+		{"PostgresNoSchema", postgres("", "argo_workflows"), "argo_workflows", ""},
+		{"MySQLDatabase", mySQL("argo", "argo_workflows"), "argo.argo_workflows", ""},
+		{"MySQLNoDatabase", mySQL("", "argo_workflows"), "argo_workflows", ""},
+		{"NoTableName", postgres("argo", ""), "", "tableName is empty: set persistence.postgresql.tableName in the workflow controller config map, e.g. to argo_workflows"},
+		{"MaliciousSchema", postgres("argo; drop table argo_workflows", "argo_workflows"), "", `invalid schema or database name "argo; drop table argo_workflows"`},
+		{"MaliciousDatabase", mySQL("argo`.x", "argo_workflows"), "", "invalid schema or database name \"argo`.x\""},
+		{"MaliciousTableName", postgres("argo", "argo_workflows where 1=1"), "", `invalid table name "argo_workflows where 1=1"`},
+		{"QualifiedTableName", postgres("argo", "other.argo_workflows"), "", `invalid table name "other.argo_workflows"`},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := GetQualifiedTableName(tt.persistConfig)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+			} else if assert.NoError(t, err) {
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+	// GetTableName is unchanged
+	tableName, err := GetTableName(postgres("argo", "argo_workflows"))
+	assert.NoError(t, err)
+	assert.Equal(t, "argo_workflows", tableName)
+}
+
+func TestResolveBackend(t *testing.T) {
+	postgreSQL := &config.PostgreSQLConfig{DatabaseConfig: config.DatabaseConfig{TableName: "postgres_workflows"}}
+	mySQL := &config.MySQLConfig{DatabaseConfig: config.DatabaseConfig{TableName: "mysql_workflows"}}
+	for _, tt := range []struct {
+		name          string
+		persistConfig config.PersistConfig
+		want          dbType
+		err           string
+	}{
+		{"PostgresOnly", config.PersistConfig{PostgreSQL: postgreSQL}, Postgres, ""},
+		{"MySQLOnly", config.PersistConfig{MySQL: mySQL}, MySQL, ""},
+		{"BothPreferPostgres", config.PersistConfig{PostgreSQL: postgreSQL, MySQL: mySQL, PreferredBackend: "postgresql"}, Postgres, ""},
+		{"BothPreferMySQL", config.PersistConfig{PostgreSQL: postgreSQL, MySQL: mySQL, PreferredBackend: "mysql"}, MySQL, ""},
+		{"BothWithoutPreference", config.PersistConfig{PostgreSQL: postgreSQL, MySQL: mySQL}, "", "both postgresql and mysql are configured, set preferredBackend to choose which to use"},
+		{"PreferredNotConfigured", config.PersistConfig{PostgreSQL: postgreSQL, PreferredBackend: "mysql"}, "", "preferredBackend is mysql, but mysql is not configured"},
+		{"InvalidPreference", config.PersistConfig{PostgreSQL: postgreSQL, PreferredBackend: "sqlite"}, "", "preferredBackend must be one of: postgresql, mysql"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ResolveBackend(&tt.persistConfig)
+			tableName, tableNameErr := GetTableName(&tt.persistConfig)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				assert.EqualError(t, tableNameErr, tt.err)
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.NoError(t, tableNameErr)
+			switch tt.want {
+			case Postgres:
+				assert.Same(t, postgreSQL, c.PostgreSQL)
+				assert.Nil(t, c.MySQL)
+				assert.Equal(t, "postgres_workflows", tableName)
+			case MySQL:
+				assert.Same(t, mySQL, c.MySQL)
+				assert.Nil(t, c.PostgreSQL)
+				assert.Equal(t, "mysql_workflows", tableName)
+			}
+		})
+	}
+}
+
+func Test_instrumentSession(t *testing.T) {
+	wrapped := 0
+	middleware := func(session db.Session) db.Session {
+		wrapped++
+		return session
+	}
+	t.Run("Lightweight", func(t *testing.T) {
+		connector := &fakeConnector{dbType: Postgres}
+		session := newFakeSession(t, connector)
+		statements := len(connector.Statements())
+		persistConfig := &config.PersistConfig{LightweightMode: true}
+		goroutines := runtime.NumGoroutine()
+		var got db.Session
+		allocs := testing.AllocsPerRun(100, func() {
+			got = instrumentSession(session, ComponentController, persistConfig, middleware)
+		})
+		assert.Same(t, session, got)
+		assert.Zero(t, allocs)
+		assert.Equal(t, goroutines, runtime.NumGoroutine(), "no background goroutines are started")
+		assert.Zero(t, wrapped, "no middleware is applied")
+		assert.Len(t, connector.Statements(), statements, "the server version is not queried")
+	})
+	t.Run("Instrumented", func(t *testing.T) {
+		connector := &fakeConnector{dbType: Postgres}
+		session := newFakeSession(t, connector)
+		statements := len(connector.Statements())
+		persistConfig := &config.PersistConfig{PostgreSQL: &config.PostgreSQLConfig{DatabaseConfig: config.DatabaseConfig{TopQueryFingerprints: 10}}}
+		instrumentSession(session, ComponentController, persistConfig, middleware)
+		assert.Equal(t, 1, wrapped)
+		assert.Greater(t, len(connector.Statements()), statements, "the server version is queried")
+		_, err := session.SQL().Exec("select 1")
+		require.NoError(t, err)
+		assert.NotEmpty(t, TopQueryFingerprints(session), "the query fingerprints are recorded")
+	})
+}
+
+func TestCreateDBSession_Fallback(t *testing.T) {
+	defer func(connect func(kubernetes.Interface, string, Component, *config.PersistConfig) (db.Session, error)) {
+		connectDB = connect
+	}(connectDB)
+	// the fallback cannot be a real database, so it is faked
+	fallbackSession := newFakeSession(t, &fakeConnector{dbType: Postgres})
+	var connected []string
+	var fallbackTableName string
+	connectDB = func(kubectlConfig kubernetes.Interface, namespace string, component Component, persistConfig *config.PersistConfig) (db.Session, error) {
+		connected = append(connected, persistConfig.PostgreSQL.Host)
+		if persistConfig.PostgreSQL.Host == "fallback.db.internal" {
+			fallbackTableName = persistConfig.PostgreSQL.TableName
+			return fallbackSession, nil
+		}
+		return newDBSession(kubectlConfig, namespace, component, persistConfig)
+	}
+	// nothing is listening on the port once the listener is closed, so the primary refuses connections
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	refusing := listener.Addr().(*net.TCPAddr)
+	require.NoError(t, listener.Close())
+	kube := fake.NewSimpleClientset(&apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "argo-postgres-config", Namespace: "argo"},
+		Data:       map[string][]byte{"username": []byte("argo"), "password": []byte("password")},
+	})
+	newConfig := func(host string, port int) *config.PostgreSQLConfig {
+		return &config.PostgreSQLConfig{DatabaseConfig: config.DatabaseConfig{
+			Host:           host,
+			Port:           port,
+			Database:       "argo",
+			TableName:      "argo_workflows",
+			UsernameSecret: apiv1.SecretKeySelector{LocalObjectReference: apiv1.LocalObjectReference{Name: "argo-postgres-config"}, Key: "username"},
+			PasswordSecret: apiv1.SecretKeySelector{LocalObjectReference: apiv1.LocalObjectReference{Name: "argo-postgres-config"}, Key: "password"},
+		}}
+	}
+	fallback := newConfig("fallback.db.internal", 5432)
+	fallback.TableName = "other_workflows"
+	primary := &config.PersistConfig{
+		PostgreSQL:      newConfig(refusing.IP.String(), refusing.Port),
+		LightweightMode: true,
+		Fallback:        &config.PersistConfig{PostgreSQL: fallback, LightweightMode: true},
+	}
+
+	session, err := CreateDBSession(kube, "argo", ComponentController, primary)
+	require.NoError(t, err)
+	assert.Same(t, fallbackSession, session)
+	assert.Equal(t, []string{"127.0.0.1", "fallback.db.internal"}, connected)
+	assert.Equal(t, "argo_workflows", fallbackTableName, "the table name is taken from the primary")
+	assert.Equal(t, "other_workflows", fallback.TableName, "the config is not modified")
+
+	t.Run("NoFallback", func(t *testing.T) {
+		connected = nil
+		noFallback := *primary
+		noFallback.Fallback = nil
+		_, err := CreateDBSession(kube, "argo", ComponentController, &noFallback)
+		assert.Error(t, err)
+		assert.Equal(t, []string{"127.0.0.1"}, connected)
+	})
+}
+
+func TestCreateDBSession_Component(t *testing.T) {
+	hook := test.NewGlobal()
+	defer log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+	defer ResetLogLevel()
+	SetLogLevel(log.DebugLevel)
+	t.Setenv("POD_NAME", "")
+	// nothing is listening on the port once the listener is closed, so the connection is refused after the log
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	refusing := listener.Addr().(*net.TCPAddr)
+	require.NoError(t, listener.Close())
+	kube := fake.NewSimpleClientset(&apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "argo-postgres-config", Namespace: "argo"},
+		Data:       map[string][]byte{"username": []byte("argo"), "password": []byte("password")},
+	})
+	persistConfig := &config.PersistConfig{PostgreSQL: &config.PostgreSQLConfig{DatabaseConfig: config.DatabaseConfig{
+		Host:           refusing.IP.String(),
+		Port:           refusing.Port,
+		Database:       "argo",
+		TableName:      "argo_workflows",
+		UsernameSecret: apiv1.SecretKeySelector{LocalObjectReference: apiv1.LocalObjectReference{Name: "argo-postgres-config"}, Key: "username"},
+		PasswordSecret: apiv1.SecretKeySelector{LocalObjectReference: apiv1.LocalObjectReference{Name: "argo-postgres-config"}, Key: "password"},
+	}}}
+	for _, component := range []Component{ComponentController, ComponentServer} {
+		t.Run(string(component), func(t *testing.T) {
+			hook.Reset()
+			errors := metrics.DBConnectionErrorsMetric.WithLabelValues("postgres", string(component), "network")
+			before := testutil.ToFloat64(errors)
+			_, err := CreateDBSession(kube, "argo", component, persistConfig)
+			assert.Error(t, err)
+			assert.Equal(t, before+1, testutil.ToFloat64(errors))
+			var runtimeParams interface{}
+			for _, entry := range hook.AllEntries() {
+				if entry.Message == "Resolved database connection parameters" {
+					runtimeParams = entry.Data["runtimeParams"]
+				}
+			}
+			assert.Equal(t, "argo-workflows/"+string(component), runtimeParams.(map[string]string)["application_name"])
+		})
+	}
+}
+
+func TestCreateDBSession_EmptyTableName(t *testing.T) {
+	// the secrets do not exist, so this fails before fetching them
+	kube := fake.NewSimpleClientset()
+	for backend, persistConfig := range map[string]*config.PersistConfig{
+		"postgresql": {PostgreSQL: &config.PostgreSQLConfig{DatabaseConfig: config.DatabaseConfig{Host: "db.internal", Database: "argo"}}},
+		"mysql":      {MySQL: &config.MySQLConfig{DatabaseConfig: config.DatabaseConfig{Host: "db.internal", Database: "argo"}}},
+	} {
+		t.Run(backend, func(t *testing.T) {
+			message := "tableName is empty: set persistence." + backend + ".tableName in the workflow controller config map, e.g. to argo_workflows"
+			_, err := CreateDBSession(kube, "argo", ComponentController, persistConfig)
+			require.ErrorIs(t, err, ErrMissingTableName)
+			assert.EqualError(t, err, message)
+			_, err = GetTableName(persistConfig)
+			require.ErrorIs(t, err, ErrMissingTableName)
+			assert.EqualError(t, err, message)
+		})
+	}
+	t.Run("NoBackend", func(t *testing.T) {
+		_, err := GetTableName(&config.PersistConfig{})
+		require.ErrorIs(t, err, ErrMissingTableName)
+		assert.EqualError(t, err, "tableName is empty: configure persistence.postgresql or persistence.mysql, and set its tableName, e.g. to argo_workflows")
+	})
+	assert.True(t, errors.IsCode(errors.CodeBadRequest, ErrMissingTableName), "it is a configuration error rather than an internal one")
+}
+
+func TestCreateDBSession_ValidationQuery(t *testing.T) {
+	defer func(connect func(kubernetes.Interface, string, Component, *config.PersistConfig) (db.Session, error)) {
+		connectDB = connect
+	}(connectDB)
+	newSession := func(sentinel string) db.Session {
+		return newFakeSession(t, &fakeConnector{dbType: Postgres, handler: func(query string, args []driver.NamedValue) (*fakeResult, error) {
+			return &fakeResult{columns: []string{"sentinel"}, rows: [][]driver.Value{{sentinel}}}, nil
+		}})
+	}
+	sentinels := map[string]string{"wrong.db.internal": "replica", "right.db.internal": "primary"}
+	var connected db.Session
+	connectDB = func(kubectlConfig kubernetes.Interface, namespace string, component Component, persistConfig *config.PersistConfig) (db.Session, error) {
+		connected = newSession(sentinels[persistConfig.PostgreSQL.Host])
+		return connected, nil
+	}
+	newConfig := func(host string) *config.PostgreSQLConfig {
+		return &config.PostgreSQLConfig{DatabaseConfig: config.DatabaseConfig{
+			Host:               host,
+			TableName:          "argo_workflows",
+			ValidateConnection: true,
+			ValidationQuery:    "select sentinel",
+			ExpectedResult:     "primary",
+		}}
+	}
+	t.Run("Mismatch", func(t *testing.T) {
+		_, err := CreateDBSession(nil, "argo", ComponentController, &config.PersistConfig{PostgreSQL: newConfig("wrong.db.internal"), LightweightMode: true})
+		assert.EqualError(t, err, `validation query "select sentinel" returned "replica", expected "primary"`)
+	})
+	t.Run("Fallback", func(t *testing.T) {
+		session, err := CreateDBSession(nil, "argo", ComponentController, &config.PersistConfig{
+			PostgreSQL:      newConfig("wrong.db.internal"),
+			LightweightMode: true,
+			Fallback:        &config.PersistConfig{PostgreSQL: newConfig("right.db.internal"), LightweightMode: true},
+		})
+		require.NoError(t, err)
+		assert.Same(t, connected, session)
+	})
+}
+
+// fakeSessionDatabase fakes the database and the secrets, so that benchmarks of creating a session only measure
+// assembling it
+func fakeSessionDatabase(b *testing.B) config.DatabaseConfig {
+	pgx, mySQL, secrets := pgxConnector, mySQLConnector, newSecretProvider
+	b.Cleanup(func() { pgxConnector, mySQLConnector, newSecretProvider = pgx, mySQL, secrets })
+	pgxConnector = func(pgxv4.ConnConfig) driver.Connector { return &fakeConnector{dbType: Postgres} }
+	mySQLConnector = func(*mysql.Config) (driver.Connector, error) {
+		return &fakeConnector{dbType: MySQL, handler: func(query string, _ []driver.NamedValue) (*fakeResult, error) {
+			if query == "select connection_id()" {
+				return &fakeResult{columns: []string{"id"}, rows: [][]driver.Value{{[]byte("1")}}}, nil
+			}
+			return &fakeResult{}, nil
+		}}, nil
+	}
+	newSecretProvider = func(kubernetes.Interface) SecretProvider {
+		return StaticSecretProvider{"argo/argo-db-config": {"username": []byte("argo"), "password": []byte("password")}}
+	}
+	return config.DatabaseConfig{
+		Host:           "db.internal",
+		Database:       "argo",
+		TableName:      "argo_workflows",
+		UsernameSecret: apiv1.SecretKeySelector{LocalObjectReference: apiv1.LocalObjectReference{Name: "argo-db-config"}, Key: "username"},
+		PasswordSecret: apiv1.SecretKeySelector{LocalObjectReference: apiv1.LocalObjectReference{Name: "argo-db-config"}, Key: "password"},
+	}
+}
+
+func BenchmarkCreatePostGresDBSession(b *testing.B) {
+	cfg := &config.PostgreSQLConfig{DatabaseConfig: fakeSessionDatabase(b)}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		session, err := CreatePostGresDBSession(nil, "argo", cfg, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		_ = session.Close()
+	}
+}
+
+func BenchmarkCreateMySQLDBSession(b *testing.B) {
+	cfg := &config.MySQLConfig{DatabaseConfig: fakeSessionDatabase(b)}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		session, err := CreateMySQLDBSession(nil, "argo", cfg, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		_ = session.Close()
+	}
+}
@@ -0,0 +1,46 @@
+// Package sqldbtest provides helpers for tests that create database sessions, e.g.
+//
+//	session, err := sqldb.CreateDBSession(kube, namespace, persistConfig)
+//	require.NoError(t, err)
+//	sqldbtest.MustCloseOnCleanup(t, session)
+package sqldbtest
+
+import (
+	"context"
+	"time"
+
+	"github.com/upper/db/v4"
+
+	"github.com/argoproj/argo-workflows/v3/persist/sqldb"
+)
+
+// CloseTimeout is how long MustCloseOnCleanup waits for connections that are still in use to be returned
+var CloseTimeout = 10 * time.Second
+
+// TB is the subset of testing.TB that the helpers use
+type TB interface {
+	Helper()
+	Cleanup(func())
+	Errorf(format string, args ...interface{})
+}
+
+// MustCloseOnCleanup closes the session when the test and its subtests have completed, and fails the test if it
+// cannot be closed, or if connections are still in use after CloseTimeout, e.g. because rows were not closed. The
+// leaked connections are closed if the session tracks them, as the sessions that this module opens do, so that a
+// suite that leaks does not exhaust the server's connections.
+func MustCloseOnCleanup(t TB, session db.Session) {
+	t.Helper()
+	t.Cleanup(func() {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), CloseTimeout)
+		defer cancel()
+		leaked, err := sqldb.DrainPool(ctx, session)
+		if err != nil {
+			t.Errorf("failed to close the session: %v", err)
+			return
+		}
+		if leaked > 0 {
+			t.Errorf("%d connection(s) were still in use %v after the session was closed, they were leaked, e.g. rows that were not closed", leaked, CloseTimeout)
+		}
+	})
+}
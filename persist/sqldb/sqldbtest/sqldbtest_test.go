@@ -0,0 +1,105 @@
+package sqldbtest
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/upper/db/v4"
+	postgresqladp "github.com/upper/db/v4/adapter/postgresql"
+)
+
+// fakeConnector is a database whose queries all return the database name
+type fakeConnector struct{}
+
+func (fakeConnector) Connect(context.Context) (driver.Conn, error) { return fakeConn{}, nil }
+func (fakeConnector) Driver() driver.Driver                        { return fakeDriver{} }
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return fakeConn{}, nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(string) (driver.Stmt, error) { return nil, driver.ErrSkip }
+func (fakeConn) Close() error                        { return nil }
+func (fakeConn) Begin() (driver.Tx, error)           { return nil, driver.ErrSkip }
+
+func (fakeConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	return &fakeRows{}, nil
+}
+
+type fakeRows struct{ done bool }
+
+func (*fakeRows) Columns() []string { return []string{"name"} }
+func (*fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = "argo"
+	return nil
+}
+
+func newSession(t *testing.T) db.Session {
+	session, err := postgresqladp.New(sql.OpenDB(fakeConnector{}))
+	require.NoError(t, err)
+	return session
+}
+
+// recordingT records the cleanups and errors, so that the test can run them and check for them
+type recordingT struct {
+	cleanups []func()
+	errors   []string
+}
+
+func (t *recordingT) Helper()          {}
+func (t *recordingT) Cleanup(f func()) { t.cleanups = append(t.cleanups, f) }
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func (t *recordingT) cleanup() {
+	for i := len(t.cleanups) - 1; i >= 0; i-- {
+		t.cleanups[i]()
+	}
+}
+
+func TestMustCloseOnCleanup(t *testing.T) {
+	defer func(timeout time.Duration) { CloseTimeout = timeout }(CloseTimeout)
+	CloseTimeout = 100 * time.Millisecond
+	t.Run("Closed", func(t *testing.T) {
+		rt := &recordingT{}
+		session := newSession(t)
+		MustCloseOnCleanup(rt, session)
+		rows, err := session.SQL().Query("select name")
+		require.NoError(t, err)
+		require.NoError(t, rows.Close())
+		require.Len(t, rt.cleanups, 1, "the session is not closed until the test has completed")
+		rt.cleanup()
+		assert.Empty(t, rt.errors)
+		assert.Error(t, session.Ping(), "the session is closed")
+	})
+	t.Run("Leaked", func(t *testing.T) {
+		rt := &recordingT{}
+		session := newSession(t)
+		MustCloseOnCleanup(rt, session)
+		rows, err := session.SQL().Query("select name")
+		require.NoError(t, err)
+		defer func() { _ = rows.Close() }()
+		rt.cleanup()
+		assert.Equal(t, []string{"1 connection(s) were still in use 100ms after the session was closed, they were leaked, e.g. rows that were not closed"}, rt.errors)
+	})
+	t.Run("Testing", func(t *testing.T) {
+		// *testing.T can be passed
+		MustCloseOnCleanup(t, newSession(t))
+	})
+}
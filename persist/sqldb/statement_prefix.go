@@ -0,0 +1,211 @@
+package sqldb
+
+import (
+	"container/list"
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"sync/atomic"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgconn/stmtcache"
+)
+
+// defaultStatementCacheCapacity is the capacity of the statement cache when only the statementNamePrefix enables it,
+// which is the driver's own default
+const defaultStatementCacheCapacity = 512
+
+// statementNamePrefixRegexp limits the prefix so that the statement names, which have a suffix appended, are
+// identifiers that Postgres does not truncate to 63 characters
+var statementNamePrefixRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,31}$`)
+
+func validateStatementNamePrefix(prefix string) error {
+	if !statementNamePrefixRegexp.MatchString(prefix) {
+		return fmt.Errorf("statementNamePrefix must be at most 32 letters, digits or underscores, starting with a letter or underscore")
+	}
+	return nil
+}
+
+// statementConn is what the cache needs of the connection, so that it can be tested without a server
+type statementConn interface {
+	Prepare(ctx context.Context, name, sql string, paramOIDs []uint32) (*pgconn.StatementDescription, error)
+	Deallocate(ctx context.Context, name string) error
+	TxStatus() byte
+}
+
+type pgStatementConn struct {
+	*pgconn.PgConn
+}
+
+func (c pgStatementConn) Deallocate(ctx context.Context, name string) error {
+	return c.Exec(ctx, "deallocate "+name).Close()
+}
+
+// prefixedCaches counts the caches, so that the connections of a process, which may share a server session behind
+// a pooler, do not name their statements the same either
+var prefixedCaches atomic.Uint64
+
+// prefixedStatementCache is the driver's LRU statement cache, but its statements are named with the prefix rather
+// than "lrupsc", so that the statements of different instances that share a server session behind a pooler cannot
+// collide
+type prefixedStatementCache struct {
+	conn         statementConn
+	mode         int
+	cap          int
+	namePrefix   string
+	prepareCount int
+	m            map[string]*list.Element
+	l            *list.List
+	stmtsToClear []string
+}
+
+func newPrefixedStatementCache(conn statementConn, mode, capacity int, prefix string) *prefixedStatementCache {
+	return &prefixedStatementCache{
+		conn:       conn,
+		mode:       mode,
+		cap:        capacity,
+		namePrefix: fmt.Sprintf("%s_%d", prefix, prefixedCaches.Add(1)),
+		m:          make(map[string]*list.Element),
+		l:          list.New(),
+	}
+}
+
+func (c *prefixedStatementCache) Get(ctx context.Context, sql string) (*pgconn.StatementDescription, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	// statements that errored can only be deallocated outside of a failed transaction
+	if txStatus := c.conn.TxStatus(); (txStatus == 'I' || txStatus == 'T') && len(c.stmtsToClear) > 0 {
+		for _, stmt := range c.stmtsToClear {
+			if err := c.clearStmt(ctx, stmt); err != nil {
+				return nil, err
+			}
+		}
+		c.stmtsToClear = nil
+	}
+	if el, ok := c.m[sql]; ok {
+		c.l.MoveToFront(el)
+		return el.Value.(*pgconn.StatementDescription), nil
+	}
+	if c.l.Len() == c.cap {
+		if err := c.remove(ctx, c.l.Back()); err != nil {
+			return nil, err
+		}
+	}
+	var name string
+	if c.mode == stmtcache.ModePrepare {
+		name = fmt.Sprintf("%s_%d", c.namePrefix, c.prepareCount)
+		c.prepareCount++
+	}
+	psd, err := c.conn.Prepare(ctx, name, sql, nil)
+	if err != nil {
+		return nil, err
+	}
+	c.m[sql] = c.l.PushFront(psd)
+	return psd, nil
+}
+
+func (c *prefixedStatementCache) Clear(ctx context.Context) error {
+	for c.l.Len() > 0 {
+		if err := c.remove(ctx, c.l.Back()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StatementErrored marks the statement to be deallocated if its cached plan may be invalid, e.g. after a migration,
+// which Postgres reports as "feature not supported"
+func (c *prefixedStatementCache) StatementErrored(sql string, err error) {
+	if pgErr, ok := err.(*pgconn.PgError); ok && pgErr.Code == "0A000" {
+		c.stmtsToClear = append(c.stmtsToClear, sql)
+	}
+}
+
+func (c *prefixedStatementCache) clearStmt(ctx context.Context, sql string) error {
+	el, ok := c.m[sql]
+	if !ok {
+		return nil
+	}
+	return c.remove(ctx, el)
+}
+
+func (c *prefixedStatementCache) remove(ctx context.Context, el *list.Element) error {
+	c.l.Remove(el)
+	psd := el.Value.(*pgconn.StatementDescription)
+	delete(c.m, psd.SQL)
+	if c.mode == stmtcache.ModePrepare {
+		return c.conn.Deallocate(ctx, psd.Name)
+	}
+	return nil
+}
+
+func (c *prefixedStatementCache) Len() int  { return c.l.Len() }
+func (c *prefixedStatementCache) Cap() int  { return c.cap }
+func (c *prefixedStatementCache) Mode() int { return c.mode }
+
+// unpreparedConnector runs the statements that are prepared using database/sql as plain queries, as the driver names
+// them "pgx_<n>" whatever the prefix. The queries are still prepared, by the cache, using the prefix.
+type unpreparedConnector struct {
+	driver.Connector
+}
+
+func (c *unpreparedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &unpreparedConn{wrappedConn: wrappedConn{Conn: conn}}, nil
+}
+
+type unpreparedConn struct {
+	wrappedConn
+}
+
+func (c *unpreparedConn) PrepareContext(_ context.Context, query string) (driver.Stmt, error) {
+	return &unpreparedStmt{conn: c, query: query}, nil
+}
+
+func (c *unpreparedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+type unpreparedStmt struct {
+	conn  *unpreparedConn
+	query string
+}
+
+func (s *unpreparedStmt) Close() error { return nil }
+
+// NumInput is unknown, as the statement is not prepared
+func (s *unpreparedStmt) NumInput() int { return -1 }
+
+func (s *unpreparedStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *unpreparedStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+func (s *unpreparedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.conn.ExecContext(ctx, s.query, args)
+}
+
+func (s *unpreparedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.conn.QueryContext(ctx, s.query, args)
+}
+
+func (s *unpreparedStmt) CheckNamedValue(nv *driver.NamedValue) error {
+	return s.conn.CheckNamedValue(nv)
+}
+
+// namedValues converts the arguments of a statement that is run without a context
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return named
+}
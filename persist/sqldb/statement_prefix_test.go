@@ -0,0 +1,99 @@
+package sqldb
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgconn/stmtcache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeStatementConn records the statements that are prepared and deallocated
+type fakeStatementConn struct {
+	txStatus    byte
+	prepared    []string
+	deallocated []string
+}
+
+func (c *fakeStatementConn) Prepare(_ context.Context, name, sql string, _ []uint32) (*pgconn.StatementDescription, error) {
+	c.prepared = append(c.prepared, name)
+	return &pgconn.StatementDescription{Name: name, SQL: sql}, nil
+}
+
+func (c *fakeStatementConn) Deallocate(_ context.Context, name string) error {
+	c.deallocated = append(c.deallocated, name)
+	return nil
+}
+
+func (c *fakeStatementConn) TxStatus() byte { return c.txStatus }
+
+func Test_prefixedStatementCache(t *testing.T) {
+	ctx := context.Background()
+	t.Run("Prefix", func(t *testing.T) {
+		conn := &fakeStatementConn{txStatus: 'I'}
+		cache := newPrefixedStatementCache(conn, stmtcache.ModePrepare, 2, "argo_prod")
+		other := newPrefixedStatementCache(&fakeStatementConn{}, stmtcache.ModePrepare, 2, "argo_prod")
+		assert.NotEqual(t, cache.namePrefix, other.namePrefix, "the caches of a process are told apart")
+
+		a, err := cache.Get(ctx, "select 1")
+		require.NoError(t, err)
+		b, err := cache.Get(ctx, "select 2")
+		require.NoError(t, err)
+		for _, psd := range []*pgconn.StatementDescription{a, b} {
+			assert.True(t, strings.HasPrefix(psd.Name, "argo_prod_"), psd.Name)
+			assert.NotContains(t, psd.Name, "lrupsc")
+		}
+		assert.NotEqual(t, a.Name, b.Name)
+
+		again, err := cache.Get(ctx, "select 1")
+		require.NoError(t, err)
+		assert.Same(t, a, again, "the statement is cached")
+		assert.Len(t, conn.prepared, 2)
+	})
+	t.Run("Evict", func(t *testing.T) {
+		conn := &fakeStatementConn{txStatus: 'I'}
+		cache := newPrefixedStatementCache(conn, stmtcache.ModePrepare, 2, "argo_prod")
+		a, _ := cache.Get(ctx, "select 1")
+		_, _ = cache.Get(ctx, "select 2")
+		_, _ = cache.Get(ctx, "select 3")
+		assert.Equal(t, []string{a.Name}, conn.deallocated, "the least recently used statement is deallocated")
+		assert.Equal(t, 2, cache.Len())
+		require.NoError(t, cache.Clear(ctx))
+		assert.Len(t, conn.deallocated, 3)
+		assert.Zero(t, cache.Len())
+	})
+	t.Run("StatementErrored", func(t *testing.T) {
+		conn := &fakeStatementConn{txStatus: 'E'}
+		cache := newPrefixedStatementCache(conn, stmtcache.ModePrepare, 2, "argo_prod")
+		a, _ := cache.Get(ctx, "select 1")
+		cache.StatementErrored("select 1", &pgconn.PgError{Code: "0A000"})
+		_, _ = cache.Get(ctx, "select 2")
+		assert.Empty(t, conn.deallocated, "not in a failed transaction")
+		conn.txStatus = 'I'
+		_, _ = cache.Get(ctx, "select 2")
+		assert.Equal(t, []string{a.Name}, conn.deallocated)
+	})
+	t.Run("Describe", func(t *testing.T) {
+		conn := &fakeStatementConn{}
+		cache := newPrefixedStatementCache(conn, stmtcache.ModeDescribe, 2, "argo_prod")
+		psd, err := cache.Get(ctx, "select 1")
+		require.NoError(t, err)
+		assert.Empty(t, psd.Name, "described statements are unnamed")
+	})
+}
+
+func Test_unpreparedConnector(t *testing.T) {
+	connector := &fakeConnector{dbType: Postgres}
+	unprepared := &unpreparedConnector{Connector: connector}
+	conn, err := unprepared.Connect(context.Background())
+	require.NoError(t, err)
+	stmt, err := conn.Prepare("select 1")
+	require.NoError(t, err)
+	assert.IsType(t, &unpreparedStmt{}, stmt, "the statement is not prepared by the driver")
+	_, err = stmt.Exec(nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"select 1"}, connector.Statements(), "the statement runs as a query")
+}
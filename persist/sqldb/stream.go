@@ -0,0 +1,67 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/upper/db/v4"
+)
+
+// streamBatchSize is how many rows are fetched from a Postgres cursor at a time
+var streamBatchSize = 100
+
+// StreamRows runs the query and calls fn for each row, without loading the whole result into memory, e.g. to page
+// through a very large archive. fn must only scan the current row. Iteration stops when ctx is done, or fn returns an
+// error, which is returned.
+//
+// Postgres sends the whole result to the client unless a cursor is used, so for Postgres the query runs in a
+// read-only transaction, and the rows are fetched from a cursor in batches. MySQL streams the rows as they are read.
+// The rows are not limited by maxRows.
+func StreamRows(ctx context.Context, session db.Session, query string, fn func(rows *sql.Rows) error, args ...interface{}) error {
+	ctx = withUnlimitedRows(ctx)
+	if dbTypeFor(session) == MySQL {
+		rows, err := session.SQL().QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = rows.Close() }()
+		_, err = streamRows(ctx, rows, fn)
+		return err
+	}
+	return session.TxContext(ctx, func(tx db.Session) error {
+		if _, err := tx.SQL().ExecContext(ctx, "declare argo_stream no scroll cursor for "+query, args...); err != nil {
+			return fmt.Errorf("failed to declare cursor: %w", err)
+		}
+		fetch := fmt.Sprintf("fetch %d from argo_stream", streamBatchSize)
+		for {
+			rows, err := tx.SQL().QueryContext(ctx, fetch)
+			if err != nil {
+				return err
+			}
+			n, err := streamRows(ctx, rows, fn)
+			_ = rows.Close()
+			if err != nil {
+				return err
+			}
+			if n < streamBatchSize {
+				return nil
+			}
+		}
+	}, &sql.TxOptions{ReadOnly: true})
+}
+
+// streamRows calls fn for each of the rows, returning how many there were
+func streamRows(ctx context.Context, rows *sql.Rows, fn func(rows *sql.Rows) error) (int, error) {
+	n := 0
+	for rows.Next() {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		n++
+		if err := fn(rows); err != nil {
+			return n, err
+		}
+	}
+	return n, rows.Err()
+}
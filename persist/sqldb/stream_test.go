@@ -0,0 +1,125 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeCursor generates the rows of a Postgres cursor as they are fetched, so that the test can tell how many rows
+// have been sent to the client but not yet consumed
+type fakeCursor struct {
+	total int
+
+	mu        sync.Mutex
+	declared  []driver.NamedValue
+	generated int
+	fetches   int
+}
+
+func (c *fakeCursor) handler(query string, args []driver.NamedValue) (*fakeResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch query {
+	case "declare argo_stream no scroll cursor for select id from argo_archived_workflows where clustername = $1":
+		c.declared = args
+	case "fetch 10 from argo_stream":
+		c.fetches++
+		res := &fakeResult{columns: []string{"id"}}
+		for i := 0; i < 10 && c.generated < c.total; i++ {
+			res.rows = append(res.rows, []driver.Value{int64(c.generated)})
+			c.generated++
+		}
+		return res, nil
+	}
+	return &fakeResult{}, nil
+}
+
+func (c *fakeCursor) Generated() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generated
+}
+
+func TestStreamRows(t *testing.T) {
+	defer func(size int) { streamBatchSize = size }(streamBatchSize)
+	streamBatchSize = 10
+	ctx := context.Background()
+	const query = "select id from argo_archived_workflows where clustername = ?"
+
+	t.Run("Postgres", func(t *testing.T) {
+		cursor := &fakeCursor{total: 1000}
+		session := newFakeSession(t, &fakeConnector{dbType: Postgres, handler: cursor.handler})
+		var ids []int64
+		err := StreamRows(ctx, session, query, func(rows *sql.Rows) error {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			ids = append(ids, id)
+			assert.LessOrEqual(t, cursor.Generated()-len(ids), streamBatchSize, "at most a batch is buffered")
+			return nil
+		}, "default")
+		require.NoError(t, err)
+		assert.Len(t, ids, 1000)
+		assert.Equal(t, int64(999), ids[999])
+		assert.Equal(t, "default", cursor.declared[0].Value)
+		assert.Equal(t, 101, cursor.fetches, "the last fetch is empty")
+	})
+	t.Run("MySQL", func(t *testing.T) {
+		var got []driver.NamedValue
+		connector := &fakeConnector{dbType: MySQL, handler: func(q string, args []driver.NamedValue) (*fakeResult, error) {
+			if q != query {
+				return &fakeResult{}, nil
+			}
+			got = args
+			return &fakeResult{columns: []string{"id"}, rows: [][]driver.Value{{int64(1)}, {int64(2)}, {int64(3)}}}, nil
+		}}
+		var ids []int64
+		err := StreamRows(ctx, newFakeSession(t, connector), query, func(rows *sql.Rows) error {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			ids = append(ids, id)
+			return nil
+		}, "default")
+		require.NoError(t, err)
+		assert.Equal(t, []int64{1, 2, 3}, ids)
+		assert.Equal(t, "default", got[0].Value)
+		for _, statement := range connector.Statements() {
+			assert.NotContains(t, statement, "declare", "MySQL does not use a cursor")
+		}
+	})
+	t.Run("Cancelled", func(t *testing.T) {
+		cursor := &fakeCursor{total: 1000}
+		session := newFakeSession(t, &fakeConnector{dbType: Postgres, handler: cursor.handler})
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		n := 0
+		err := StreamRows(ctx, session, query, func(*sql.Rows) error {
+			n++
+			if n == 25 {
+				cancel()
+			}
+			return nil
+		}, "default")
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, 25, n)
+		assert.LessOrEqual(t, cursor.Generated(), 30, "no more rows are fetched")
+	})
+	t.Run("Error", func(t *testing.T) {
+		cursor := &fakeCursor{total: 1000}
+		session := newFakeSession(t, &fakeConnector{dbType: Postgres, handler: cursor.handler})
+		failed := errors.New("failed")
+		err := StreamRows(ctx, session, query, func(*sql.Rows) error { return failed }, "default")
+		assert.ErrorIs(t, err, failed)
+		assert.Equal(t, 1, cursor.fetches)
+	})
+}
@@ -0,0 +1,50 @@
+package sqldb
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/upper/db/v4"
+)
+
+// TableStatistics is the approximate size of a table
+type TableStatistics struct {
+	// Rows is the (estimated) number of rows
+	Rows int64 `json:"rows"`
+	// SizeBytes is the approximate on-disk size, including indexes
+	SizeBytes int64 `json:"sizeBytes"`
+}
+
+// TableStats returns the approximate size of the table for capacity planning. Row counts come from the
+// statistics the database keeps, as an exact count requires a full table scan.
+func TableStats(ctx context.Context, session db.Session, tableName string, t dbType) (*TableStatistics, error) {
+	var query string
+	switch t {
+	case Postgres:
+		// reltuples is -1 (or 0 before Postgres 14) when the table has never been vacuumed or analyzed
+		query = "select cast(c.reltuples as bigint), pg_total_relation_size(c.oid) from pg_class c where c.oid = to_regclass(?)"
+	case MySQL:
+		query = "select coalesce(table_rows, 0), coalesce(data_length, 0) + coalesce(index_length, 0) from information_schema.tables where table_schema = database() and table_name = ?"
+	default:
+		return nil, fmt.Errorf("unsupported database type %q", t)
+	}
+	stats := &TableStatistics{}
+	row, err := session.SQL().QueryRowContext(ctx, query, tableName)
+	if err != nil {
+		return nil, err
+	}
+	if err := row.Scan(&stats.Rows, &stats.SizeBytes); err != nil {
+		return nil, fmt.Errorf("failed to get statistics for table %s: %w", tableName, err)
+	}
+	if stats.Rows < 0 {
+		// no statistics yet, which only happens for new (and therefore small) tables, so counting is cheap
+		row, err := session.SQL().QueryRowContext(ctx, "select count(*) from "+tableName)
+		if err != nil {
+			return nil, err
+		}
+		if err := row.Scan(&stats.Rows); err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,44 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTableStats(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		dbType dbType
+		rows   [][]driver.Value
+		want   TableStatistics
+	}{
+		{"Postgres", Postgres, [][]driver.Value{{int64(1000), int64(65536)}}, TableStatistics{Rows: 1000, SizeBytes: 65536}},
+		{"PostgresNeverAnalyzed", Postgres, [][]driver.Value{{int64(-1), int64(8192)}}, TableStatistics{Rows: 3, SizeBytes: 8192}},
+		{"MySQL", MySQL, [][]driver.Value{{int64(1000), int64(49152)}}, TableStatistics{Rows: 1000, SizeBytes: 49152}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			connector := &fakeConnector{dbType: tt.dbType, handler: func(query string, args []driver.NamedValue) (*fakeResult, error) {
+				if strings.HasPrefix(query, "select count(*) from argo_archived_workflows") {
+					return &fakeResult{columns: []string{"count"}, rows: [][]driver.Value{{int64(3)}}}, nil
+				}
+				if strings.Contains(query, "pg_total_relation_size") || strings.Contains(query, "information_schema.tables") {
+					assert.Equal(t, "argo_archived_workflows", args[0].Value)
+					return &fakeResult{columns: []string{"rows", "size"}, rows: tt.rows}, nil
+				}
+				return &fakeResult{}, nil
+			}}
+			stats, err := TableStats(context.Background(), newFakeSession(t, connector), "argo_archived_workflows", tt.dbType)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, *stats)
+		})
+	}
+	t.Run("NoSuchTable", func(t *testing.T) {
+		_, err := TableStats(context.Background(), newFakeSession(t, &fakeConnector{}), "argo_archived_workflows", Postgres)
+		assert.EqualError(t, err, "failed to get statistics for table argo_archived_workflows: sql: no rows in result set")
+	})
+}
@@ -0,0 +1,62 @@
+package sqldb
+
+import (
+	"fmt"
+
+	"github.com/upper/db/v4"
+
+	"github.com/argoproj/argo-workflows/v3/workflow/metrics"
+)
+
+// isEncrypted returns whether the session's connection is encrypted using TLS
+func isEncrypted(session db.Session, t dbType) (bool, error) {
+	switch t {
+	case Postgres:
+		row, err := session.SQL().QueryRow("select ssl from pg_stat_ssl where pid = pg_backend_pid()")
+		if err != nil {
+			return false, err
+		}
+		var ssl bool
+		if err := row.Scan(&ssl); err != nil {
+			return false, err
+		}
+		return ssl, nil
+	case MySQL:
+		row, err := session.SQL().QueryRow("show session status like 'Ssl_cipher'")
+		if err != nil {
+			return false, err
+		}
+		var name, cipher string
+		if err := row.Scan(&name, &cipher); err != nil {
+			return false, err
+		}
+		return cipher != "", nil
+	}
+	return false, fmt.Errorf("unsupported database type %q", t)
+}
+
+// verifyTLS returns an error unless the session's connection is encrypted
+func verifyTLS(session db.Session, t dbType) error {
+	encrypted, err := isEncrypted(session, t)
+	if err != nil {
+		return fmt.Errorf("failed to verify the database connection uses TLS: %w", err)
+	}
+	if !encrypted {
+		return fmt.Errorf("TLS is required but the database connection is not encrypted")
+	}
+	return nil
+}
+
+// warnIfNotEncrypted logs a warning, and counts the downgrade, if the session's connection is not encrypted although
+// TLS was requested, e.g. because sslMode prefer fell back to plaintext. Unlike verifyTLS, the session is still used.
+func warnIfNotEncrypted(session db.Session, t dbType) {
+	encrypted, err := isEncrypted(session, t)
+	if err != nil {
+		logger().WithField("backend", t).WithError(err).Warn("Failed to check whether the database connection uses TLS")
+		return
+	}
+	if !encrypted {
+		metrics.DBTLSDowngradesMetric.WithLabelValues(string(t)).Inc()
+		logger().WithField("backend", t).Warn("TLS was requested but the database connection is not encrypted, set requireTLS to fail instead")
+	}
+}
@@ -0,0 +1,143 @@
+package sqldb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// TLS record content types
+const (
+	tlsRecordHandshake       = 0x16
+	tlsRecordApplicationData = 0x17
+)
+
+// tlsHandshakeTimeoutDialer bounds the TLS handshake of its connections. Both drivers start TLS within their own
+// protocol (STARTTLS), rather than on a connection that is already TLS, and neither has a handshake timeout, so the
+// connection watches what the driver writes: the handshake starts when it writes the ClientHello, and ends when it
+// writes its first application data (in TLS 1.3, its Finished message is sent as application data).
+type tlsHandshakeTimeoutDialer struct {
+	proxy.ContextDialer
+	timeout time.Duration
+}
+
+func (d *tlsHandshakeTimeoutDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	conn, err := d.ContextDialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	return &tlsHandshakeTimeoutConn{Conn: conn, timeout: d.timeout}, nil
+}
+
+// tlsHandshakeTimeoutConn sets a deadline for the handshake. The driver may set deadlines of its own, e.g. pgx when
+// the context is cancelled, so while the handshake is in progress the earliest one applies, and the driver's
+// deadlines are restored once it is complete.
+type tlsHandshakeTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+
+	mu                          sync.Mutex
+	handshaking, handshaken     bool
+	handshakeDeadline           time.Time
+	readDeadline, writeDeadline time.Time
+}
+
+func (c *tlsHandshakeTimeoutConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	switch {
+	case c.handshaken:
+	case !c.handshaking && isClientHello(b):
+		c.handshaking = true
+		c.handshakeDeadline = time.Now().Add(c.timeout)
+		_ = c.applyDeadlines()
+	case c.handshaking && hasApplicationData(b):
+		c.handshaking, c.handshaken = false, true
+		_ = c.applyDeadlines()
+	}
+	c.mu.Unlock()
+	n, err := c.Conn.Write(b)
+	return n, c.handshakeError(err)
+}
+
+func (c *tlsHandshakeTimeoutConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	return n, c.handshakeError(err)
+}
+
+// handshakeError explains the error if it is the handshake timing out
+func (c *tlsHandshakeTimeoutConn) handshakeError(err error) error {
+	if err == nil || !errors.Is(err, os.ErrDeadlineExceeded) {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.handshaking || time.Now().Before(c.handshakeDeadline) {
+		return err
+	}
+	return fmt.Errorf("TLS handshake did not complete within tlsHandshakeTimeout (%v): %w", c.timeout, err)
+}
+
+func (c *tlsHandshakeTimeoutConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline, c.writeDeadline = t, t
+	return c.applyDeadlines()
+}
+
+func (c *tlsHandshakeTimeoutConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return c.applyDeadlines()
+}
+
+func (c *tlsHandshakeTimeoutConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeDeadline = t
+	return c.applyDeadlines()
+}
+
+// applyDeadlines sets the driver's deadlines, or the handshake's if it is in progress and earlier
+func (c *tlsHandshakeTimeoutConn) applyDeadlines() error {
+	read, write := c.readDeadline, c.writeDeadline
+	if c.handshaking {
+		read, write = earliestDeadline(read, c.handshakeDeadline), earliestDeadline(write, c.handshakeDeadline)
+	}
+	return errors.Join(c.Conn.SetReadDeadline(read), c.Conn.SetWriteDeadline(write))
+}
+
+// earliestDeadline returns the earliest of the deadlines, where the zero time is no deadline
+func earliestDeadline(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+	return a
+}
+
+// isClientHello returns true if b starts with a TLS handshake record that holds a ClientHello
+func isClientHello(b []byte) bool {
+	return len(b) >= 6 && b[0] == tlsRecordHandshake && b[1] == 3 && b[5] == 1
+}
+
+// hasApplicationData returns true if any of the TLS records in b is application data. crypto/tls only writes whole
+// records.
+func hasApplicationData(b []byte) bool {
+	for len(b) >= 5 {
+		if b[0] == tlsRecordApplicationData {
+			return true
+		}
+		n := 5 + (int(b[3])<<8 | int(b[4]))
+		if n >= len(b) {
+			break
+		}
+		b = b[n:]
+	}
+	return false
+}
@@ -0,0 +1,170 @@
+package sqldb
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	postgresqladp "github.com/upper/db/v4/adapter/postgresql"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+// newStallingTLSServer returns the address of a server that runs the protocol's exchange before TLS, using greet, and
+// then reads the client's TLS handshake without ever replying
+func newStallingTLSServer(t *testing.T, greet func(conn net.Conn) error) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	var mu sync.Mutex
+	var conns []net.Conn
+	t.Cleanup(func() {
+		_ = listener.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+	})
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+			go func() {
+				if greet(conn) == nil {
+					_, _ = io.Copy(io.Discard, conn)
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// postgresGreeting accepts the client's SSLRequest
+func postgresGreeting(conn net.Conn) error {
+	request := make([]byte, 8)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return err
+	}
+	_, err := conn.Write([]byte{'S'})
+	return err
+}
+
+// mySQLGreeting sends the initial handshake of a server that supports TLS
+func mySQLGreeting(conn net.Conn) error {
+	data := []byte{10}
+	data = append(data, "8.0.36\x00"...)
+	data = append(data, 1, 0, 0, 0)                              // connection id
+	data = append(data, "abcdefgh"...)                           // auth-plugin-data-part-1
+	data = append(data, 0)                                       // filler
+	data = binary.LittleEndian.AppendUint16(data, 0x0200|0x0800) // CLIENT_PROTOCOL_41 | CLIENT_SSL
+	data = append(data, 0x21, 0x02, 0, 0, 0, 21)                 // charset, status flags, upper capability flags, auth-plugin-data length
+	data = append(data, make([]byte, 10)...)                     // reserved
+	data = append(data, "ijklmnopqrst\x00"...)                   // auth-plugin-data-part-2
+	data = append(data, "mysql_native_password\x00"...)
+	header := []byte{byte(len(data)), byte(len(data) >> 8), byte(len(data) >> 16), 0}
+	_, err := conn.Write(append(header, data...))
+	return err
+}
+
+func TestTLSHandshakeTimeout(t *testing.T) {
+	ctx := context.Background()
+	t.Run("NotSet", func(t *testing.T) {
+		dialer, err := newDBDialer(ctx, nil, "argo", config.DatabaseConfig{})
+		require.NoError(t, err)
+		assert.Nil(t, dialer)
+	})
+	t.Run("Name", func(t *testing.T) {
+		dialer, err := newDBDialer(ctx, nil, "argo", config.DatabaseConfig{TLSHandshakeTimeout: config.TTL(5 * time.Second)})
+		require.NoError(t, err)
+		assert.Equal(t, "tlsHandshakeTimeout:5s", dialer.name)
+		dialer, err = newDBDialer(ctx, nil, "argo", config.DatabaseConfig{LocalAddr: loopbackAlias, TLSHandshakeTimeout: config.TTL(5 * time.Second)})
+		require.NoError(t, err)
+		assert.Equal(t, "local:"+loopbackAlias+"/tlsHandshakeTimeout:5s", dialer.name)
+	})
+	t.Run("Postgres", func(t *testing.T) {
+		database := newStallingTLSServer(t, postgresGreeting)
+		dialer, err := newDBDialer(ctx, nil, "argo", config.DatabaseConfig{TLSHandshakeTimeout: config.TTL(100 * time.Millisecond)})
+		require.NoError(t, err)
+		connConfig, err := pgxConnConfig(postgresqladp.ConnectionURL{User: "argo", Host: database, Options: map[string]string{"sslmode": "require"}}, &config.PostgreSQLConfig{}, withDialer(dialer))
+		require.NoError(t, err)
+		start := time.Now()
+		_, err = pgconn.ConnectConfig(ctx, &connConfig.Config)
+		assert.ErrorContains(t, err, "TLS handshake did not complete within tlsHandshakeTimeout (100ms)")
+		assert.Less(t, time.Since(start), 5*time.Second)
+	})
+	t.Run("MySQL", func(t *testing.T) {
+		database := newStallingTLSServer(t, mySQLGreeting)
+		dialer, err := newDBDialer(ctx, nil, "argo", config.DatabaseConfig{TLSHandshakeTimeout: config.TTL(100 * time.Millisecond)})
+		require.NoError(t, err)
+		mysqlConfig := mysql.NewConfig()
+		mysqlConfig.User = "argo"
+		mysqlConfig.Addr = database
+		mysqlConfig.TLS = &tls.Config{InsecureSkipVerify: true}
+		mysqlConfig.Net = registerMySQLDialer(dialer)
+		connector, err := mysql.NewConnector(mysqlConfig)
+		require.NoError(t, err)
+		start := time.Now()
+		_, err = connector.Connect(ctx)
+		assert.ErrorContains(t, err, "TLS handshake did not complete within tlsHandshakeTimeout (100ms)")
+		assert.Less(t, time.Since(start), 5*time.Second)
+	})
+	t.Run("Completed", func(t *testing.T) {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+		t.Cleanup(server.Close)
+		dialer, err := newDBDialer(ctx, nil, "argo", config.DatabaseConfig{TLSHandshakeTimeout: config.TTL(100 * time.Millisecond)})
+		require.NoError(t, err)
+		raw, err := dialer.DialContext(ctx, "tcp", server.Listener.Addr().String())
+		require.NoError(t, err)
+		conn := tls.Client(raw, &tls.Config{InsecureSkipVerify: true})
+		t.Cleanup(func() { _ = conn.Close() })
+		require.NoError(t, conn.Handshake())
+		time.Sleep(200 * time.Millisecond)
+		request, err := http.NewRequest("GET", server.URL, nil)
+		require.NoError(t, err)
+		require.NoError(t, request.Write(conn), "the handshake's deadline does not apply once it is complete")
+		response, err := http.ReadResponse(bufio.NewReader(conn), request)
+		require.NoError(t, err)
+		_ = response.Body.Close()
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+	})
+}
+
+func Test_tlsHandshakeTimeoutConn_driverDeadline(t *testing.T) {
+	client, server := net.Pipe()
+	t.Cleanup(func() { _ = client.Close(); _ = server.Close() })
+	conn := &tlsHandshakeTimeoutConn{Conn: client, timeout: time.Hour}
+	// the handshake starts
+	go func() { _, _ = io.Copy(io.Discard, server) }()
+	_, err := conn.Write([]byte{tlsRecordHandshake, 3, 1, 0, 1, 1})
+	require.NoError(t, err)
+	// the driver's earlier deadline applies during the handshake
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(10*time.Millisecond)))
+	_, err = conn.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	assert.NotContains(t, err.Error(), "tlsHandshakeTimeout", "it is not the handshake timing out")
+}
+
+func Test_hasApplicationData(t *testing.T) {
+	assert.False(t, hasApplicationData([]byte{tlsRecordHandshake, 3, 3, 0, 1, 0}))
+	// a change cipher spec record, followed by application data
+	assert.True(t, hasApplicationData([]byte{0x14, 3, 3, 0, 1, 1, tlsRecordApplicationData, 3, 3, 0, 1, 0}))
+	assert.False(t, hasApplicationData(nil))
+}
@@ -0,0 +1,124 @@
+package sqldb
+
+import (
+	"database/sql/driver"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v4"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	log "github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+	"github.com/argoproj/argo-workflows/v3/workflow/metrics"
+)
+
+func Test_verifyTLS(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		dbType dbType
+		result *fakeResult
+		err    string
+	}{
+		{"PostgresEncrypted", Postgres, &fakeResult{columns: []string{"ssl"}, rows: [][]driver.Value{{true}}}, ""},
+		{"PostgresPlaintext", Postgres, &fakeResult{columns: []string{"ssl"}, rows: [][]driver.Value{{false}}}, "TLS is required but the database connection is not encrypted"},
+		{"MySQLEncrypted", MySQL, &fakeResult{columns: []string{"Variable_name", "Value"}, rows: [][]driver.Value{{"Ssl_cipher", "TLS_AES_256_GCM_SHA384"}}}, ""},
+		{"MySQLPlaintext", MySQL, &fakeResult{columns: []string{"Variable_name", "Value"}, rows: [][]driver.Value{{"Ssl_cipher", ""}}}, "TLS is required but the database connection is not encrypted"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			session := newFakeSession(t, &fakeConnector{dbType: tt.dbType, handler: func(query string, _ []driver.NamedValue) (*fakeResult, error) {
+				if strings.Contains(query, "pg_stat_ssl") || strings.Contains(query, "Ssl_cipher") {
+					return tt.result, nil
+				}
+				return &fakeResult{}, nil
+			}})
+			err := verifyTLS(session, tt.dbType)
+			if tt.err == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func Test_warnIfNotEncrypted(t *testing.T) {
+	hook := test.NewGlobal()
+	defer log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+	for _, tt := range []struct {
+		name   string
+		dbType dbType
+		result *fakeResult
+		warn   bool
+	}{
+		{"PostgresEncrypted", Postgres, &fakeResult{columns: []string{"ssl"}, rows: [][]driver.Value{{true}}}, false},
+		{"PostgresPlaintext", Postgres, &fakeResult{columns: []string{"ssl"}, rows: [][]driver.Value{{false}}}, true},
+		{"MySQLEncrypted", MySQL, &fakeResult{columns: []string{"Variable_name", "Value"}, rows: [][]driver.Value{{"Ssl_cipher", "TLS_AES_256_GCM_SHA384"}}}, false},
+		{"MySQLPlaintext", MySQL, &fakeResult{columns: []string{"Variable_name", "Value"}, rows: [][]driver.Value{{"Ssl_cipher", ""}}}, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			hook.Reset()
+			session := newFakeSession(t, &fakeConnector{dbType: tt.dbType, handler: func(query string, _ []driver.NamedValue) (*fakeResult, error) {
+				if strings.Contains(query, "pg_stat_ssl") || strings.Contains(query, "Ssl_cipher") {
+					return tt.result, nil
+				}
+				return &fakeResult{}, nil
+			}})
+			downgrades := metrics.DBTLSDowngradesMetric.WithLabelValues(string(tt.dbType))
+			before := testutil.ToFloat64(downgrades)
+			warnIfNotEncrypted(session, tt.dbType)
+			if tt.warn {
+				assert.Equal(t, before+1, testutil.ToFloat64(downgrades))
+				entry := hook.LastEntry()
+				require.NotNil(t, entry)
+				assert.Equal(t, log.WarnLevel, entry.Level)
+				assert.Equal(t, "TLS was requested but the database connection is not encrypted, set requireTLS to fail instead", entry.Message)
+			} else {
+				assert.Equal(t, before, testutil.ToFloat64(downgrades))
+				assert.Empty(t, hook.AllEntries())
+			}
+		})
+	}
+}
+
+func TestCreateDBSession_RequireTLS(t *testing.T) {
+	defer func(connector func(pgx.ConnConfig) driver.Connector) { pgxConnector = connector }(pgxConnector)
+	// the database accepts the connection without TLS, which sslMode prefer falls back to
+	connector := &fakeConnector{dbType: Postgres, handler: func(query string, _ []driver.NamedValue) (*fakeResult, error) {
+		if strings.Contains(query, "pg_stat_ssl") {
+			return &fakeResult{columns: []string{"ssl"}, rows: [][]driver.Value{{false}}}, nil
+		}
+		return &fakeResult{}, nil
+	}}
+	pgxConnector = func(pgx.ConnConfig) driver.Connector { return connector }
+	kube := fake.NewSimpleClientset(&apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "argo-postgres-config", Namespace: "argo"},
+		Data:       map[string][]byte{"username": []byte("argo"), "password": []byte("password")},
+	})
+	newConfig := func(requireTLS bool) *config.PersistConfig {
+		return &config.PersistConfig{LightweightMode: true, PostgreSQL: &config.PostgreSQLConfig{SSL: true, SSLMode: "prefer", DatabaseConfig: config.DatabaseConfig{
+			Host:           "db.internal",
+			Port:           5432,
+			Database:       "argo",
+			TableName:      "argo_workflows",
+			UsernameSecret: apiv1.SecretKeySelector{LocalObjectReference: apiv1.LocalObjectReference{Name: "argo-postgres-config"}, Key: "username"},
+			PasswordSecret: apiv1.SecretKeySelector{LocalObjectReference: apiv1.LocalObjectReference{Name: "argo-postgres-config"}, Key: "password"},
+			RequireTLS:     requireTLS,
+		}}}
+	}
+	t.Run("Required", func(t *testing.T) {
+		_, err := CreateDBSession(kube, "argo", ComponentController, newConfig(true))
+		assert.EqualError(t, err, "TLS is required but the database connection is not encrypted")
+	})
+	t.Run("NotRequired", func(t *testing.T) {
+		session, err := CreateDBSession(kube, "argo", ComponentController, newConfig(false))
+		require.NoError(t, err)
+		_ = session.Close()
+	})
+}
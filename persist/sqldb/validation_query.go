@@ -0,0 +1,44 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/upper/db/v4"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+// defaultValidationQuery is run when the connection is validated without a query
+const defaultValidationQuery = "SELECT 1"
+
+// validateConnection runs the validation query if configured to, and returns an error unless it succeeds and, if
+// there is an expected result, the first column of the first row is it. e.g. a proxy may answer a sentinel query, to
+// confirm that it routes to the right database.
+func validateConnection(ctx context.Context, session db.Session, cfg config.DatabaseConfig) error {
+	if !cfg.ValidateConnection {
+		return nil
+	}
+	query := cfg.ValidationQuery
+	if query == "" {
+		query = defaultValidationQuery
+	}
+	row, err := session.SQL().QueryRowContext(ctx, query)
+	if err != nil {
+		return fmt.Errorf("validation query %q failed: %w", query, err)
+	}
+	var got sql.NullString
+	if err := row.Scan(&got); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("validation query %q returned no rows", query)
+		}
+		return fmt.Errorf("validation query %q failed: %w", query, err)
+	}
+	if cfg.ExpectedResult != "" && got.String != cfg.ExpectedResult {
+		return fmt.Errorf("validation query %q returned %q, expected %q", query, got.String, cfg.ExpectedResult)
+	}
+	logger().WithField("query", query).Debug("Connection validated")
+	return nil
+}
@@ -0,0 +1,62 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+func Test_validateConnection(t *testing.T) {
+	ctx := context.Background()
+	newSession := func(t *testing.T, result []driver.Value) (*fakeConnector, func(config.DatabaseConfig) error) {
+		connector := &fakeConnector{dbType: Postgres, handler: func(query string, args []driver.NamedValue) (*fakeResult, error) {
+			res := &fakeResult{columns: []string{"result"}}
+			if result != nil {
+				res.rows = [][]driver.Value{result}
+			}
+			return res, nil
+		}}
+		session := newFakeSession(t, connector)
+		return connector, func(cfg config.DatabaseConfig) error { return validateConnection(ctx, session, cfg) }
+	}
+	t.Run("Disabled", func(t *testing.T) {
+		connector, validate := newSession(t, nil)
+		assert.NoError(t, validate(config.DatabaseConfig{ValidationQuery: "SELECT 1"}))
+		assert.NotContains(t, connector.Statements(), "SELECT 1")
+	})
+	t.Run("Default", func(t *testing.T) {
+		connector, validate := newSession(t, []driver.Value{int64(1)})
+		assert.NoError(t, validate(config.DatabaseConfig{ValidateConnection: true}))
+		assert.Contains(t, connector.Statements(), "SELECT 1")
+	})
+	t.Run("Match", func(t *testing.T) {
+		connector, validate := newSession(t, []driver.Value{"argo-primary"})
+		assert.NoError(t, validate(config.DatabaseConfig{ValidateConnection: true, ValidationQuery: "SELECT sentinel FROM routing", ExpectedResult: "argo-primary"}))
+		assert.Contains(t, connector.Statements(), "SELECT sentinel FROM routing")
+	})
+	t.Run("MatchInteger", func(t *testing.T) {
+		_, validate := newSession(t, []driver.Value{int64(42)})
+		assert.NoError(t, validate(config.DatabaseConfig{ValidateConnection: true, ExpectedResult: "42"}))
+	})
+	t.Run("Mismatch", func(t *testing.T) {
+		_, validate := newSession(t, []driver.Value{"argo-replica"})
+		err := validate(config.DatabaseConfig{ValidateConnection: true, ValidationQuery: "SELECT sentinel FROM routing", ExpectedResult: "argo-primary"})
+		assert.EqualError(t, err, `validation query "SELECT sentinel FROM routing" returned "argo-replica", expected "argo-primary"`)
+	})
+	t.Run("NoRows", func(t *testing.T) {
+		_, validate := newSession(t, nil)
+		err := validate(config.DatabaseConfig{ValidateConnection: true})
+		assert.EqualError(t, err, `validation query "SELECT 1" returned no rows`)
+	})
+	t.Run("Error", func(t *testing.T) {
+		connector := &fakeConnector{dbType: Postgres, handler: func(string, []driver.NamedValue) (*fakeResult, error) {
+			return nil, assert.AnError
+		}}
+		err := validateConnection(ctx, newFakeSession(t, connector), config.DatabaseConfig{ValidateConnection: true})
+		assert.ErrorIs(t, err, assert.AnError)
+	})
+}
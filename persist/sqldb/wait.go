@@ -0,0 +1,96 @@
+package sqldb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/upper/db/v4"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+	argoerrors "github.com/argoproj/argo-workflows/v3/errors"
+)
+
+// WaitForDatabase waits until the database is ready, e.g. in an init container, by connecting and pinging it every
+// pollInterval until it succeeds or ctx is done. Errors that retrying cannot fix, e.g. wrong credentials or an
+// untrusted certificate, are returned straight away rather than waiting for ctx, so that a misconfiguration fails fast.
+// The errors that are retried are the same as connectionRetry's. The fallback is not connected to.
+func WaitForDatabase(ctx context.Context, kubectlConfig kubernetes.Interface, namespace string, component Component, persistConfig *config.PersistConfig, pollInterval time.Duration) error {
+	if persistConfig == nil {
+		return argoerrors.InternalError("Persistence config is not found")
+	}
+	persistConfig, err := ResolveBackend(WithEnvOverrides(persistConfig))
+	if err != nil {
+		return err
+	}
+	var retryableErrors []string
+	if persistConfig.ConnectionRetry != nil {
+		retryableErrors = persistConfig.ConnectionRetry.RetryableErrors
+	}
+	var lastErr error
+	for attempt := 1; ; attempt++ {
+		err := pingDatabase(ctx, kubectlConfig, namespace, component, persistConfig)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			// an attempt that was abandoned as ctx is done does not say why the database is not ready, the last one does
+			if errors.Is(err, ctx.Err()) {
+				if lastErr == nil {
+					return fmt.Errorf("the database is not ready: %w", ctx.Err())
+				}
+				err = lastErr
+			}
+			return fmt.Errorf("the database is not ready: %w: %w", ctx.Err(), err)
+		}
+		if !isTransientConnectionError(err, retryableErrors) {
+			return err
+		}
+		lastErr = err
+		logger().WithError(err).WithFields(log.Fields{"attempt": attempt, "pollInterval": pollInterval}).Info("Waiting for the database to be ready")
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("the database is not ready: %w: %w", ctx.Err(), err)
+		case <-clock.After(pollInterval):
+		}
+	}
+}
+
+// pingDatabase makes a single attempt to connect to the database and ping it, abandoning it once ctx is done. The
+// session is not retried by connectionRetry, as WaitForDatabase polls, nor instrumented, as it is closed straight away.
+func pingDatabase(ctx context.Context, kubectlConfig kubernetes.Interface, namespace string, component Component, persistConfig *config.PersistConfig) error {
+	lightweight := *persistConfig
+	lightweight.LightweightMode = true
+	type result struct {
+		session db.Session
+		err     error
+	}
+	connected := make(chan result, 1)
+	go func() {
+		session, err := connectDB(kubectlConfig, namespace, component, &lightweight)
+		connected <- result{session: session, err: err}
+	}()
+	select {
+	case <-ctx.Done():
+		// the session is closed once the abandoned attempt connects
+		go func() {
+			if r := <-connected; r.session != nil {
+				_ = r.session.Close()
+			}
+		}()
+		return ctx.Err()
+	case r := <-connected:
+		if r.err != nil {
+			return r.err
+		}
+		defer func() { _ = r.session.Close() }()
+		if sqlDB, ok := r.session.Driver().(*sql.DB); ok {
+			return sqlDB.PingContext(ctx)
+		}
+		return r.session.Ping()
+	}
+}
@@ -0,0 +1,113 @@
+package sqldb
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/upper/db/v4"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/argoproj/argo-workflows/v3/config"
+)
+
+func TestWaitForDatabase(t *testing.T) {
+	defer func(connect func(kubernetes.Interface, string, Component, *config.PersistConfig) (db.Session, error)) {
+		connectDB = connect
+	}(connectDB)
+	ctx := context.Background()
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	persistConfig := &config.PersistConfig{PostgreSQL: &config.PostgreSQLConfig{DatabaseConfig: config.DatabaseConfig{Host: "postgres", TableName: "argo_workflows"}}}
+	// connect connects once it has failed the given number of times
+	connect := func(failures int, err error) *int {
+		calls := 0
+		connectDB = func(_ kubernetes.Interface, _ string, _ Component, persistConfig *config.PersistConfig) (db.Session, error) {
+			calls++
+			assert.True(t, persistConfig.LightweightMode, "the session is not instrumented")
+			if calls <= failures {
+				return nil, err
+			}
+			return newFakeSession(t, &fakeConnector{dbType: Postgres}), nil
+		}
+		return &calls
+	}
+
+	t.Run("Ready", func(t *testing.T) {
+		c := useFakeClock(t)
+		calls := connect(0, nil)
+		assert.NoError(t, WaitForDatabase(ctx, nil, "argo", ComponentController, persistConfig, 5*time.Second))
+		assert.Equal(t, 1, *calls)
+		assert.Empty(t, c.Sleeps())
+	})
+	t.Run("BecomesReady", func(t *testing.T) {
+		c := useFakeClock(t)
+		calls := connect(3, refused)
+		assert.NoError(t, WaitForDatabase(ctx, nil, "argo", ComponentController, persistConfig, 5*time.Second))
+		assert.Equal(t, 4, *calls)
+		assert.Equal(t, []time.Duration{5 * time.Second, 5 * time.Second, 5 * time.Second}, c.Sleeps())
+	})
+	t.Run("Misconfigured", func(t *testing.T) {
+		c := useFakeClock(t)
+		authErr := &pgconn.PgError{Code: "28P01", Message: "password authentication failed"}
+		calls := connect(100, authErr)
+		assert.Equal(t, authErr, WaitForDatabase(ctx, nil, "argo", ComponentController, persistConfig, 5*time.Second))
+		assert.Equal(t, 1, *calls)
+		assert.Empty(t, c.Sleeps())
+	})
+	t.Run("RetryableErrors", func(t *testing.T) {
+		useFakeClock(t)
+		calls := connect(1, &pgconn.PgError{Code: "53300", Message: "sorry, too many clients already"})
+		retrying := *persistConfig
+		retrying.ConnectionRetry = &config.ConnectionRetry{RetryableErrors: []string{"53300"}}
+		assert.NoError(t, WaitForDatabase(ctx, nil, "argo", ComponentController, &retrying, 5*time.Second))
+		assert.Equal(t, 2, *calls)
+	})
+	t.Run("SingleAttempt", func(t *testing.T) {
+		c := useFakeClock(t)
+		calls := connect(1, refused)
+		retrying := *persistConfig
+		retrying.ConnectionRetry = &config.ConnectionRetry{MaxRetries: 5}
+		assert.NoError(t, WaitForDatabase(ctx, nil, "argo", ComponentController, &retrying, 5*time.Second))
+		assert.Equal(t, 2, *calls)
+		assert.Equal(t, []time.Duration{5 * time.Second}, c.Sleeps(), "connectionRetry does not retry each attempt")
+	})
+	t.Run("AttemptAbandoned", func(t *testing.T) {
+		useFakeClock(t)
+		ctx, cancel := context.WithCancel(ctx)
+		connecting := make(chan struct{})
+		release := make(chan struct{})
+		defer close(release)
+		connectDB = func(kubernetes.Interface, string, Component, *config.PersistConfig) (db.Session, error) {
+			close(connecting)
+			<-release
+			return nil, refused
+		}
+		go func() {
+			<-connecting
+			cancel()
+		}()
+		err := WaitForDatabase(ctx, nil, "argo", ComponentController, persistConfig, 5*time.Second)
+		assert.EqualError(t, err, "the database is not ready: context canceled")
+	})
+	t.Run("ContextDone", func(t *testing.T) {
+		useFakeClock(t)
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		calls := 0
+		connectDB = func(kubernetes.Interface, string, Component, *config.PersistConfig) (db.Session, error) {
+			calls++
+			if calls == 2 {
+				cancel()
+			}
+			return nil, refused
+		}
+		err := WaitForDatabase(ctx, nil, "argo", ComponentController, persistConfig, 5*time.Second)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.ErrorIs(t, err, refused)
+		assert.Equal(t, 2, calls)
+	})
+}
@@ -1,6 +1,7 @@
 package sqldb
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"time"
@@ -69,11 +70,26 @@ type WorkflowArchive interface {
 }
 
 type workflowArchive struct {
-	session           db.Session
+	sessions          *SplitSession
 	clusterName       string
 	managedNamespace  string
 	instanceIDService instanceid.Service
 	dbType            dbType
+	encryptor         *ArchiveEncryptor
+}
+
+// WorkflowArchiveOption configures the workflowArchive
+type WorkflowArchiveOption func(*workflowArchive)
+
+// WithArchiveEncryption encrypts the workflows that are archived, and decrypts them when they are read. Workflows
+// that were archived before encryption was enabled are read as they are. A nil encryptor does not encrypt.
+//
+// The list of archived workflows decrypts each workflow that does not keep its labels, annotations and progress
+// unencrypted (unencryptedFields), to show them.
+func WithArchiveEncryption(encryptor *ArchiveEncryptor) WorkflowArchiveOption {
+	return func(r *workflowArchive) {
+		r.encryptor = encryptor
+	}
 }
 
 func (r *workflowArchive) IsEnabled() bool {
@@ -81,19 +97,36 @@ func (r *workflowArchive) IsEnabled() bool {
 }
 
 // NewWorkflowArchive returns a new workflowArchive
-func NewWorkflowArchive(session db.Session, clusterName, managedNamespace string, instanceIDService instanceid.Service) WorkflowArchive {
-	return &workflowArchive{session: session, clusterName: clusterName, managedNamespace: managedNamespace, instanceIDService: instanceIDService, dbType: dbTypeFor(session)}
+func NewWorkflowArchive(session db.Session, clusterName, managedNamespace string, instanceIDService instanceid.Service, opts ...WorkflowArchiveOption) WorkflowArchive {
+	return NewSplitWorkflowArchive(NewSplitSession(session, session, nil), clusterName, managedNamespace, instanceIDService, opts...)
+}
+
+// NewSplitWorkflowArchive returns a new workflowArchive, that archives and deletes workflows using the writer, and
+// lists and gets them using the reader
+func NewSplitWorkflowArchive(sessions *SplitSession, clusterName, managedNamespace string, instanceIDService instanceid.Service, opts ...WorkflowArchiveOption) WorkflowArchive {
+	r := &workflowArchive{sessions: sessions, clusterName: clusterName, managedNamespace: managedNamespace, instanceIDService: instanceIDService, dbType: dbTypeFor(sessions.Writer())}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
 }
 
 func (r *workflowArchive) ArchiveWorkflow(wf *wfv1.Workflow) error {
-	logCtx := log.WithFields(log.Fields{"uid": wf.UID, "labels": wf.GetLabels()})
+	logCtx := logger().WithFields(log.Fields{"uid": wf.UID, "labels": wf.GetLabels()})
 	logCtx.Debug("Archiving workflow")
 	wf.ObjectMeta.Labels[common.LabelKeyWorkflowArchivingStatus] = "Persisted"
 	workflow, err := json.Marshal(wf)
 	if err != nil {
 		return err
 	}
-	return r.session.Tx(func(sess db.Session) error {
+	column := string(workflow)
+	if r.encryptor != nil {
+		column, err = r.encryptor.encrypt(wf, workflow)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt workflow: %w", err)
+		}
+	}
+	return r.sessions.Tx(context.Background(), func(sess db.Session) error {
 		_, err := sess.SQL().
 			DeleteFrom(archiveTableName).
 			Where(r.clusterManagedNamespaceAndInstanceID()).
@@ -114,7 +147,7 @@ func (r *workflowArchive) ArchiveWorkflow(wf *wfv1.Workflow) error {
 					StartedAt:   wf.Status.StartedAt.Time,
 					FinishedAt:  wf.Status.FinishedAt.Time,
 				},
-				Workflow: string(workflow),
+				Workflow: column,
 			})
 		if err != nil {
 			return err
@@ -146,15 +179,20 @@ func (r *workflowArchive) ArchiveWorkflow(wf *wfv1.Workflow) error {
 }
 
 func (r *workflowArchive) ListWorkflows(options sutils.ListOptions) (wfv1.Workflows, error) {
-	var archivedWfs []archivedWorkflowMetadata
+	var archivedWfs []archivedWorkflowRecord
 
 	selectQuery, err := selectArchivedWorkflowQuery(r.dbType)
 	if err != nil {
 		return nil, err
 	}
+	columns := []interface{}{selectQuery}
+	// encrypted workflows may need decrypting to get the fields that are listed
+	if r.encryptor != nil {
+		columns = append(columns, "workflow")
+	}
 
-	selector := r.session.SQL().
-		Select(selectQuery).
+	selector := r.sessions.Reader().SQL().
+		Select(columns...).
 		From(archiveTableName).
 		Where(r.clusterManagedNamespaceAndInstanceID())
 
@@ -170,6 +208,11 @@ func (r *workflowArchive) ListWorkflows(options sutils.ListOptions) (wfv1.Workfl
 
 	wfs := make(wfv1.Workflows, len(archivedWfs))
 	for i, md := range archivedWfs {
+		if r.encryptor != nil {
+			if err := decryptListFields(r.encryptor, &md); err != nil {
+				return nil, err
+			}
+		}
 		labels := make(map[string]string)
 		if err := json.Unmarshal([]byte(md.Labels), &labels); err != nil {
 			return nil, err
@@ -205,7 +248,7 @@ func (r *workflowArchive) ListWorkflows(options sutils.ListOptions) (wfv1.Workfl
 func (r *workflowArchive) CountWorkflows(options sutils.ListOptions) (int64, error) {
 	total := &archivedWorkflowCount{}
 
-	selector := r.session.SQL().
+	selector := r.sessions.Reader().SQL().
 		Select(db.Raw("count(*) as total")).
 		From(archiveTableName).
 		Where(r.clusterManagedNamespaceAndInstanceID())
@@ -268,8 +311,9 @@ func namePrefixClause(namePrefix string) db.Cond {
 func (r *workflowArchive) GetWorkflow(uid string, namespace string, name string) (*wfv1.Workflow, error) {
 	var err error
 	archivedWf := &archivedWorkflowRecord{}
+	session := r.sessions.Reader()
 	if uid != "" {
-		err = r.session.SQL().
+		err = session.SQL().
 			Select("workflow").
 			From(archiveTableName).
 			Where(r.clusterManagedNamespaceAndInstanceID()).
@@ -278,7 +322,7 @@ func (r *workflowArchive) GetWorkflow(uid string, namespace string, name string)
 	} else {
 		if name != "" && namespace != "" {
 			total := &archivedWorkflowCount{}
-			err = r.session.SQL().
+			err = session.SQL().
 				Select(db.Raw("count(*) as total")).
 				From(archiveTableName).
 				Where(r.clusterManagedNamespaceAndInstanceID()).
@@ -292,7 +336,7 @@ func (r *workflowArchive) GetWorkflow(uid string, namespace string, name string)
 			if num > 1 {
 				return nil, fmt.Errorf("found %d archived workflows with namespace/name: %s/%s", num, namespace, name)
 			}
-			err = r.session.SQL().
+			err = session.SQL().
 				Select("workflow").
 				From(archiveTableName).
 				Where(r.clusterManagedNamespaceAndInstanceID()).
@@ -309,8 +353,12 @@ func (r *workflowArchive) GetWorkflow(uid string, namespace string, name string)
 		}
 		return nil, err
 	}
+	workflow, err := decryptArchivedWorkflow(r.encryptor, archivedWf.Workflow)
+	if err != nil {
+		return nil, err
+	}
 	var wf *wfv1.Workflow
-	err = json.Unmarshal([]byte(archivedWf.Workflow), &wf)
+	err = json.Unmarshal(workflow, &wf)
 	if err != nil {
 		return nil, err
 	}
@@ -320,37 +368,41 @@ func (r *workflowArchive) GetWorkflow(uid string, namespace string, name string)
 }
 
 func (r *workflowArchive) DeleteWorkflow(uid string) error {
-	rs, err := r.session.SQL().
-		DeleteFrom(archiveTableName).
-		Where(r.clusterManagedNamespaceAndInstanceID()).
-		And(db.Cond{"uid": uid}).
-		Exec()
-	if err != nil {
-		return err
-	}
-	rowsAffected, err := rs.RowsAffected()
-	if err != nil {
-		return err
-	}
-	log.WithFields(log.Fields{"uid": uid, "rowsAffected": rowsAffected}).Debug("Deleted archived workflow")
-	return nil
+	return r.sessions.Write(func(session db.Session) error {
+		rs, err := session.SQL().
+			DeleteFrom(archiveTableName).
+			Where(r.clusterManagedNamespaceAndInstanceID()).
+			And(db.Cond{"uid": uid}).
+			Exec()
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := rs.RowsAffected()
+		if err != nil {
+			return err
+		}
+		logger().WithFields(log.Fields{"uid": uid, "rowsAffected": rowsAffected}).Debug("Deleted archived workflow")
+		return nil
+	})
 }
 
 func (r *workflowArchive) DeleteExpiredWorkflows(ttl time.Duration) error {
-	rs, err := r.session.SQL().
-		DeleteFrom(archiveTableName).
-		Where(r.clusterManagedNamespaceAndInstanceID()).
-		And(fmt.Sprintf("finishedat < current_timestamp - interval '%d' second", int(ttl.Seconds()))).
-		Exec()
-	if err != nil {
-		return err
-	}
-	rowsAffected, err := rs.RowsAffected()
-	if err != nil {
-		return err
-	}
-	log.WithFields(log.Fields{"rowsAffected": rowsAffected}).Info("Deleted archived workflows")
-	return nil
+	return r.sessions.Write(func(session db.Session) error {
+		rs, err := session.SQL().
+			DeleteFrom(archiveTableName).
+			Where(r.clusterManagedNamespaceAndInstanceID()).
+			And(fmt.Sprintf("finishedat < current_timestamp - interval '%d' second", int(ttl.Seconds()))).
+			Exec()
+		if err != nil {
+			return err
+		}
+		rowsAffected, err := rs.RowsAffected()
+		if err != nil {
+			return err
+		}
+		logger().WithFields(log.Fields{"rowsAffected": rowsAffected}).Info("Deleted archived workflows")
+		return nil
+	})
 }
 
 func selectArchivedWorkflowQuery(t dbType) (*db.RawExpr, error) {
@@ -58,6 +58,7 @@ import (
 	"github.com/argoproj/argo-workflows/v3/server/workflow/store"
 	"github.com/argoproj/argo-workflows/v3/server/workflowarchive"
 	"github.com/argoproj/argo-workflows/v3/server/workflowtemplate"
+	envutil "github.com/argoproj/argo-workflows/v3/util/env"
 	grpcutil "github.com/argoproj/argo-workflows/v3/util/grpc"
 	"github.com/argoproj/argo-workflows/v3/util/instanceid"
 	"github.com/argoproj/argo-workflows/v3/util/json"
@@ -72,6 +73,9 @@ import (
 
 var MaxGRPCMessageSize int
 
+// dbDrainTimeout is how long to wait on shutdown for the database connections in use to be returned
+var dbDrainTimeout = envutil.LookupEnvDurationOr("DB_DRAIN_TIMEOUT", 10*time.Second)
+
 type argoServer struct {
 	baseHRef string
 	// https://itnext.io/practical-guide-to-securing-grpc-connections-with-go-and-tls-part-1-f63058e9d6d1
@@ -208,8 +212,9 @@ func (as *argoServer) Run(ctx context.Context, port int, browserOpenFunc func(st
 	offloadRepo := sqldb.ExplosiveOffloadNodeStatusRepo
 	wfArchive := sqldb.NullWorkflowArchive
 	persistence := config.Persistence
+	var session *sqldb.SplitSession
 	if persistence != nil {
-		session, err := sqldb.CreateDBSession(as.clients.Kubernetes, as.namespace, persistence)
+		session, err = sqldb.CreateSplitDBSession(as.clients.Kubernetes, as.namespace, sqldb.ComponentServer, persistence)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -219,13 +224,17 @@ func (as *argoServer) Run(ctx context.Context, port int, browserOpenFunc func(st
 		}
 		// we always enable node offload, as this is read-only for the Argo Server, i.e. you can turn it off if you
 		// like and the controller won't offload newly created workflows, but you can still read them
-		offloadRepo, err = sqldb.NewOffloadNodeStatusRepo(session, persistence.GetClusterName(), tableName)
+		offloadRepo, err = sqldb.NewSplitOffloadNodeStatusRepo(session, persistence.GetClusterName(), tableName)
 		if err != nil {
 			log.WithError(err).Fatal(err.Error())
 		}
 		// we always enable the archive for the Argo Server, as the Argo Server does not write records, so you can
 		// disable the archiving - and still read old records
-		wfArchive = sqldb.NewWorkflowArchive(session, persistence.GetClusterName(), as.managedNamespace, instanceIDService)
+		encryptor, err := sqldb.NewArchiveEncryptor(ctx, as.clients.Kubernetes, as.namespace, persistence.ArchiveEncryption)
+		if err != nil {
+			log.Fatal(err)
+		}
+		wfArchive = sqldb.NewSplitWorkflowArchive(session, persistence.GetClusterName(), as.managedNamespace, instanceIDService, sqldb.WithArchiveEncryption(encryptor))
 	}
 	eventRecorderManager := events.NewEventRecorderManager(as.clients.Kubernetes)
 	artifactRepositories := artifactrepositories.New(as.clients.Kubernetes, as.managedNamespace, &config.ArtifactRepository)
@@ -282,6 +291,13 @@ func (as *argoServer) Run(ctx context.Context, port int, browserOpenFunc func(st
 	browserOpenFunc(url)
 
 	<-as.stopCh
+	if session != nil {
+		drainCtx, cancel := context.WithTimeout(context.Background(), dbDrainTimeout)
+		defer cancel()
+		if closed, err := session.Drain(drainCtx); err != nil {
+			log.WithError(err).WithField("closed", closed).Warn("Failed to drain the database connections")
+		}
+	}
 }
 
 func (as *argoServer) newGRPCServer(instanceIDService instanceid.Service, workflowServer workflowpkg.WorkflowServiceServer, wfArchiveServer workflowarchivepkg.ArchivedWorkflowServiceServer, eventServer *event.Controller, links []*v1alpha1.Link, columns []*v1alpha1.Column, navColor string) *grpc.Server {
@@ -24,7 +24,7 @@ func newPersistence(kubeClient kubernetes.Interface, wcConfig *config.Config) *P
 		if persistence.MySQL != nil {
 			persistence.MySQL.Host = "localhost"
 		}
-		session, err := sqldb.CreateDBSession(kubeClient, Namespace, persistence)
+		session, err := sqldb.CreateDBSession(kubeClient, Namespace, sqldb.ComponentController, persistence)
 		if err != nil {
 			panic(err)
 		}
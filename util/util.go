@@ -48,6 +48,27 @@ func GetSecrets(ctx context.Context, clientSet kubernetes.Interface, namespace,
 	return val, nil
 }
 
+// GetConfigMaps retrieves a config map value, from either its data or its binary data
+func GetConfigMaps(ctx context.Context, clientSet kubernetes.Interface, namespace, name, key string) ([]byte, error) {
+	configMapsIf := clientSet.CoreV1().ConfigMaps(namespace)
+	var configMap *apiv1.ConfigMap
+	err := waitutil.Backoff(retry.DefaultRetry, func() (bool, error) {
+		var err error
+		configMap, err = configMapsIf.Get(ctx, name, metav1.GetOptions{})
+		return !errorsutil.IsTransientErr(err), err
+	})
+	if err != nil {
+		return []byte{}, errors.InternalWrapError(err)
+	}
+	if val, ok := configMap.Data[key]; ok {
+		return []byte(val), nil
+	}
+	if val, ok := configMap.BinaryData[key]; ok {
+		return val, nil
+	}
+	return []byte{}, errors.Errorf(errors.CodeBadRequest, "config map '%s' does not have the key '%s'", name, key)
+}
+
 // Write the Terminate message in pod spec
 func WriteTerminateMessage(message string) {
 	err := os.WriteFile("/dev/termination-log", []byte(message), 0o600)
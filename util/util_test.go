@@ -1,10 +1,14 @@
 package util
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 
 	wfv1 "github.com/argoproj/argo-workflows/v3/pkg/apis/workflow/v1alpha1"
 )
@@ -240,3 +244,22 @@ func TestMergeParameters(t *testing.T) {
 		})
 	}
 }
+
+func TestGetConfigMaps(t *testing.T) {
+	kube := fake.NewSimpleClientset(&apiv1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "my-cm", Namespace: "argo"},
+		Data:       map[string]string{"text": "my-text"},
+		BinaryData: map[string][]byte{"binary": []byte("my-binary")},
+	})
+	ctx := context.Background()
+	val, err := GetConfigMaps(ctx, kube, "argo", "my-cm", "text")
+	require.NoError(t, err)
+	assert.Equal(t, "my-text", string(val))
+	val, err = GetConfigMaps(ctx, kube, "argo", "my-cm", "binary")
+	require.NoError(t, err)
+	assert.Equal(t, "my-binary", string(val))
+	_, err = GetConfigMaps(ctx, kube, "argo", "my-cm", "missing")
+	assert.EqualError(t, err, "config map 'my-cm' does not have the key 'missing'")
+	_, err = GetConfigMaps(ctx, kube, "argo", "missing", "text")
+	assert.Error(t, err)
+}
@@ -31,21 +31,23 @@ func (wfc *WorkflowController) updateConfig() error {
 	persistence := wfc.Config.Persistence
 	if persistence != nil {
 		log.Info("Persistence configuration enabled")
+		persistence = sqldb.WithEnvOverrides(persistence)
 		tableName, err := sqldb.GetTableName(persistence)
 		if err != nil {
 			return err
 		}
 		if wfc.session == nil {
-			session, err := sqldb.CreateDBSession(wfc.kubeclientset, wfc.namespace, persistence)
+			session, err := sqldb.CreateSplitDBSession(wfc.kubeclientset, wfc.namespace, sqldb.ComponentController, persistence)
 			if err != nil {
 				return err
 			}
 			log.Info("Persistence Session created successfully")
 			wfc.session = session
+		} else {
+			wfc.session.Reconfigure(persistence)
 		}
-		sqldb.ConfigureDBSession(wfc.session, persistence.ConnectionPool)
 		if persistence.NodeStatusOffload {
-			wfc.offloadNodeStatusRepo, err = sqldb.NewOffloadNodeStatusRepo(wfc.session, persistence.GetClusterName(), tableName)
+			wfc.offloadNodeStatusRepo, err = sqldb.NewSplitOffloadNodeStatusRepo(wfc.session, persistence.GetClusterName(), tableName)
 			if err != nil {
 				return err
 			}
@@ -60,7 +62,11 @@ func (wfc *WorkflowController) updateConfig() error {
 			if err != nil {
 				return err
 			}
-			wfc.wfArchive = sqldb.NewWorkflowArchive(wfc.session, persistence.GetClusterName(), wfc.managedNamespace, instanceIDService)
+			encryptor, err := sqldb.NewArchiveEncryptor(context.Background(), wfc.kubeclientset, wfc.namespace, persistence.ArchiveEncryption)
+			if err != nil {
+				return err
+			}
+			wfc.wfArchive = sqldb.NewSplitWorkflowArchive(wfc.session, persistence.GetClusterName(), wfc.managedNamespace, instanceIDService, sqldb.WithArchiveEncryption(encryptor))
 			log.Info("Workflow archiving is enabled")
 		} else {
 			log.Info("Workflow archiving is disabled")
@@ -86,14 +92,32 @@ func (wfc *WorkflowController) initDB() error {
 	persistence := wfc.Config.Persistence
 	if persistence == nil || persistence.SkipMigration {
 		log.Info("DB migration is disabled")
-		return nil
+		return wfc.checkDBPrivileges()
 	}
 	tableName, err := sqldb.GetTableName(persistence)
 	if err != nil {
 		return err
 	}
 
-	return sqldb.NewMigrate(wfc.session, persistence.GetClusterName(), tableName).Exec(context.Background())
+	session, closeSession, err := sqldb.MigrationSession(wfc.kubeclientset, wfc.namespace, persistence, wfc.session.Writer())
+	if err != nil {
+		return err
+	}
+	defer closeSession()
+	replicationSafe := persistence.PostgreSQL != nil && persistence.PostgreSQL.ReplicationSafeMode
+	if err := sqldb.NewMigrate(session, persistence.GetClusterName(), tableName, sqldb.WithReplicationSafeMode(replicationSafe)).Exec(context.Background()); err != nil {
+		return err
+	}
+	return wfc.checkDBPrivileges()
+}
+
+// checkDBPrivileges checks the runtime user's privileges if configured to, once the tables have been migrated
+func (wfc *WorkflowController) checkDBPrivileges() error {
+	persistence := wfc.Config.Persistence
+	if persistence == nil || !persistence.CheckPrivileges || wfc.session == nil {
+		return nil
+	}
+	return sqldb.CheckPersistencePrivileges(context.Background(), wfc.session.Writer(), sqldb.WithEnvOverrides(persistence))
 }
 
 func (wfc *WorkflowController) newRateLimiter() *rate.Limiter {
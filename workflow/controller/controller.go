@@ -10,8 +10,6 @@ import (
 	"syscall"
 	"time"
 
-	"github.com/upper/db/v4"
-
 	"github.com/argoproj/pkg/errors"
 	syncpkg "github.com/argoproj/pkg/sync"
 	log "github.com/sirupsen/logrus"
@@ -128,7 +126,7 @@ type WorkflowController struct {
 	podCleanupQueue       workqueue.RateLimitingInterface // pods to be deleted or labelled depend on GC strategy
 	throttler             sync.Throttler
 	workflowKeyLock       syncpkg.KeyLock // used to lock workflows for exclusive modification or access
-	session               db.Session
+	session               *sqldb.SplitSession
 	offloadNodeStatusRepo sqldb.OffloadNodeStatusRepo
 	hydrator              hydrator.Interface
 	wfArchive             sqldb.WorkflowArchive
@@ -179,6 +177,9 @@ var (
 	// believe it cannot run. By delaying for 1s, we would have finished the semaphore counter
 	// updates, and the next workflow will see the updated availability.
 	semaphoreNotifyDelay = env.LookupEnvDurationOr("SEMAPHORE_NOTIFY_DELAY", time.Second)
+
+	// dbDrainTimeout is how long to wait on shutdown for the database connections in use to be returned
+	dbDrainTimeout = env.LookupEnvDurationOr("DB_DRAIN_TIMEOUT", 10*time.Second)
 )
 
 func init() {
@@ -343,6 +344,9 @@ func (wfc *WorkflowController) Run(ctx context.Context, wfWorkers, workflowTTLWo
 	}
 	go wfc.workflowGarbageCollector(ctx.Done())
 	go wfc.archivedWorkflowGarbageCollector(ctx.Done())
+	go wfc.dbPrimaryMonitor(ctx)
+	go wfc.dbPoolAutoTuner(ctx)
+	go wfc.dbMaintainer(ctx)
 
 	go wfc.runGCcontroller(ctx, workflowTTLWorkers)
 	go wfc.runCronController(ctx, cronWorkflowWorkers)
@@ -358,6 +362,20 @@ func (wfc *WorkflowController) Run(ctx context.Context, wfWorkers, workflowTTLWo
 		go wait.JitterUntilWithContext(ctx, wfc.syncAllCacheForGC, cacheGCPeriod, 0.0, true)
 	}
 	<-ctx.Done()
+	wfc.drainDB()
+}
+
+// drainDB waits for the database connections in use to be returned, and closes the sessions
+func (wfc *WorkflowController) drainDB() {
+	if wfc.session == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dbDrainTimeout)
+	defer cancel()
+	closed, err := wfc.session.Drain(ctx)
+	if err != nil {
+		log.WithError(err).WithField("closed", closed).Warn("Failed to drain the database connections")
+	}
 }
 
 func (wfc *WorkflowController) RunMetricsServer(ctx context.Context, isDummy bool) {
@@ -781,6 +799,43 @@ func (wfc *WorkflowController) archivedWorkflowGarbageCollector(stopCh <-chan st
 	}
 }
 
+// dbPrimaryMonitor reports whether the database is a writable primary, so that a connection left pointing at a
+// read-only replica after a failover can be alerted on
+func (wfc *WorkflowController) dbPrimaryMonitor(ctx context.Context) {
+	defer runtimeutil.HandleCrash(runtimeutil.PanicHandlers...)
+
+	persistence := wfc.Config.Persistence
+	if persistence == nil || persistence.PrimaryCheckInterval <= 0 || wfc.session == nil {
+		return
+	}
+	sqldb.MonitorPrimary(ctx, wfc.session.Writer, sqldb.ComponentController, time.Duration(persistence.PrimaryCheckInterval))
+}
+
+// dbPoolAutoTuner resizes the database connection pool from its stats, if it is auto-tuned. The config is read once,
+// when the controller starts, so changes to autoTune take effect on restart.
+func (wfc *WorkflowController) dbPoolAutoTuner(ctx context.Context) {
+	defer runtimeutil.HandleCrash(runtimeutil.PanicHandlers...)
+
+	persistence := wfc.Config.Persistence
+	if persistence == nil || persistence.ConnectionPool == nil || persistence.ConnectionPool.AutoTune == nil || wfc.session == nil {
+		return
+	}
+	if err := sqldb.AutoTunePool(ctx, wfc.session.Writer, persistence.ConnectionPool); err != nil {
+		log.WithError(err).Error("Failed to auto-tune the database connection pool")
+	}
+}
+
+// dbMaintainer periodically maintains the archive tables, so that they do not bloat
+func (wfc *WorkflowController) dbMaintainer(ctx context.Context) {
+	defer runtimeutil.HandleCrash(runtimeutil.PanicHandlers...)
+
+	persistence := wfc.Config.Persistence
+	if persistence == nil || persistence.MaintenanceInterval <= 0 || wfc.session == nil {
+		return
+	}
+	sqldb.MaintainPeriodically(ctx, wfc.session.Writer, time.Duration(persistence.MaintenanceInterval))
+}
+
 func (wfc *WorkflowController) runWorker() {
 	defer runtimeutil.HandleCrash(runtimeutil.PanicHandlers...)
 
@@ -0,0 +1,28 @@
+package controller
+
+import (
+	"net/http"
+
+	"github.com/argoproj/argo-workflows/v3/persist/sqldb"
+)
+
+// PoolStats serves the state of the persistence connection pool as JSON, for debugging
+func (wfc *WorkflowController) PoolStats(w http.ResponseWriter, r *http.Request) {
+	sqldb.PoolStatsHandler(func() []sqldb.PoolStats {
+		persistence := wfc.Config.Persistence
+		if persistence == nil || wfc.session == nil {
+			return []sqldb.PoolStats{}
+		}
+		return []sqldb.PoolStats{sqldb.SessionPoolStats("", sqldb.WithEnvOverrides(persistence), wfc.session.Writer())}
+	})(w, r)
+}
+
+// QueryFingerprints serves the most frequent fingerprints of the persistence queries as JSON, for debugging
+func (wfc *WorkflowController) QueryFingerprints(w http.ResponseWriter, r *http.Request) {
+	sqldb.QueryFingerprintsHandler(func() []sqldb.QueryFingerprint {
+		if wfc.session == nil {
+			return nil
+		}
+		return sqldb.TopQueryFingerprints(wfc.session.Writer())
+	})(w, r)
+}
@@ -0,0 +1,13 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var DBConnectionErrorsMetric = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: argoNamespace,
+		Subsystem: workflowsSubsystem,
+		Name:      "db_connection_errors_total",
+		Help:      "Number of failures to connect to the persistence database. https://argo-workflows.readthedocs.io/en/latest/metrics/#argo_workflows_db_connection_errors_total",
+	},
+	[]string{"backend", "component", "reason"},
+)
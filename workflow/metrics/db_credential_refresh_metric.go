@@ -0,0 +1,23 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var DBCredentialRefreshesMetric = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: argoNamespace,
+		Subsystem: workflowsSubsystem,
+		Name:      "db_credential_refreshes_total",
+		Help:      "Number of times short-lived credentials were obtained for a new connection to the persistence database. https://argo-workflows.readthedocs.io/en/latest/metrics/#argo_workflows_db_credential_refreshes_total",
+	},
+	[]string{"auth_mode"},
+)
+
+var DBCredentialRefreshFailuresMetric = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: argoNamespace,
+		Subsystem: workflowsSubsystem,
+		Name:      "db_credential_refresh_failures_total",
+		Help:      "Number of failures to get short-lived credentials for a new connection to the persistence database. https://argo-workflows.readthedocs.io/en/latest/metrics/#argo_workflows_db_credential_refresh_failures_total",
+	},
+	[]string{"auth_mode"},
+)
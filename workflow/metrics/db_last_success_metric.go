@@ -0,0 +1,13 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var DBLastSuccessMetric = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: argoNamespace,
+		Subsystem: workflowsSubsystem,
+		Name:      "db_last_success_timestamp_seconds",
+		Help:      "The Unix time that a query or ping to the persistence database last succeeded. https://argo-workflows.readthedocs.io/en/latest/metrics/#argo_workflows_db_last_success_timestamp_seconds",
+	},
+	[]string{"backend", "component"},
+)
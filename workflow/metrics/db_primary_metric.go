@@ -0,0 +1,13 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var DBPrimaryMetric = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: argoNamespace,
+		Subsystem: workflowsSubsystem,
+		Name:      "db_primary",
+		Help:      "Whether the persistence database connection is to a writable primary (1) or a read-only replica (0). https://argo-workflows.readthedocs.io/en/latest/metrics/#argo_workflows_db_primary",
+	},
+	[]string{"backend", "component"},
+)
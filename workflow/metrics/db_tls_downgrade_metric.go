@@ -0,0 +1,13 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var DBTLSDowngradesMetric = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: argoNamespace,
+		Subsystem: workflowsSubsystem,
+		Name:      "db_tls_downgrades_total",
+		Help:      "Number of persistence database sessions that requested TLS but connected without it. https://argo-workflows.readthedocs.io/en/latest/metrics/#argo_workflows_db_tls_downgrades_total",
+	},
+	[]string{"backend"},
+)
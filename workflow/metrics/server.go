@@ -111,6 +111,12 @@ func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
 	}
 	m.logMetric.Describe(ch)
 	K8sRequestTotalMetric.Describe(ch)
+	DBConnectionErrorsMetric.Describe(ch)
+	DBCredentialRefreshesMetric.Describe(ch)
+	DBCredentialRefreshFailuresMetric.Describe(ch)
+	DBPrimaryMetric.Describe(ch)
+	DBLastSuccessMetric.Describe(ch)
+	DBTLSDowngradesMetric.Describe(ch)
 	PodMissingMetric.Describe(ch)
 	WorkflowConditionMetric.Describe(ch)
 }
@@ -121,6 +127,12 @@ func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
 	}
 	m.logMetric.Collect(ch)
 	K8sRequestTotalMetric.Collect(ch)
+	DBConnectionErrorsMetric.Collect(ch)
+	DBCredentialRefreshesMetric.Collect(ch)
+	DBCredentialRefreshFailuresMetric.Collect(ch)
+	DBPrimaryMetric.Collect(ch)
+	DBLastSuccessMetric.Collect(ch)
+	DBTLSDowngradesMetric.Collect(ch)
 	PodMissingMetric.Collect(ch)
 	WorkflowConditionMetric.Collect(ch)
 }